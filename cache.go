@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/httpcache"
+	"github.com/urfave/cli/v3"
+)
+
+// httpCache returns the cache enabled by --cache, --cache-dir or --offline, or nil. The cache is kept in the user
+// cache directory unless --cache-dir is set
+func httpCache(cmd *cli.Command) (*httpcache.Cache, error) {
+	if !cmd.Bool("cache") && cmd.String("cache-dir") == "" && !cmd.Bool("offline") {
+		return nil, nil
+	}
+	dir := cmd.String("cache-dir")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("finding the cache directory, use --cache-dir: %w", err)
+		}
+		dir = filepath.Join(userDir, "godown", "http")
+	}
+	maxSize, err := download.ParseSize(cmd.String("cache-max-size"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cache-max-size: %w", err)
+	}
+	cache, err := httpcache.New(dir, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	cache.Offline = cmd.Bool("offline")
+	return cache, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/rpc"
+	"github.com/ananthvk/godown/internal/web"
+	"github.com/urfave/cli/v3"
+)
+
+// daemonCommand returns the command that runs godown as a background service controlled over JSON-RPC
+func daemonCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "run as a service that accepts downloads over a JSON-RPC API",
+		Description: "Downloads are added and controlled with JSON-RPC 2.0 requests posted to " + rpc.Path + ",\n" +
+			"authenticated with an \"Authorization: Bearer <token>\" header. The global options apply to the downloads.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Value: "127.0.0.1:6800",
+				Usage: "address to listen on, either a loopback host:port or unix:<path> for a unix socket",
+			},
+			&cli.StringFlag{
+				Name:  "web",
+				Usage: "also serve a web dashboard on this host:port, addresses without a host such as :8080 are bound to localhost",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Sources: cli.EnvVars("GODOWN_RPC_TOKEN"),
+				Usage:   "secret that clients must send, a random token is generated and printed if empty",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			if !cmd.Bool("log") {
+				slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			}
+
+			ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			downloader, err := newDownloader(cmd, reporter.NopProgressBarFactory{})
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			if downloader.Spider != nil {
+				return cli.Exit("--spider cannot be used in daemon mode", 1)
+			}
+			// Progress bars are not shown by the daemon, but the other --progress reporters are
+			stopProgress, err := watchProgress(cmd, downloader)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			stopTracing, err := startTracing(cmd, downloader)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			stopHooks := startHooks(cmd, downloader, true)
+			metricsURL, stopMetrics, err := serveMetrics(ctx, cmd, downloader)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			if metricsURL != "" {
+				fmt.Fprintln(os.Stderr, "metrics on", metricsURL)
+			}
+
+			token := cmd.String("token")
+			if token == "" {
+				b := make([]byte, 16)
+				rand.Read(b)
+				token = hex.EncodeToString(b)
+				fmt.Fprintln(os.Stderr, "rpc token:", token)
+			}
+
+			sess, err := openSession(ctx, cmd, downloader, false)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+
+			l, err := rpc.Listen(cmd.String("listen"))
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			fmt.Fprintln(os.Stderr, "listening on", cmd.String("listen"))
+
+			webDone := make(chan error, 1)
+			if addr := cmd.String("web"); addr != "" {
+				wl, err := web.Listen(addr)
+				if err != nil {
+					l.Close()
+					return cli.Exit(err.Error(), 1)
+				}
+				if ip := wl.Addr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+					fmt.Fprintln(os.Stderr, "warning: the web dashboard is reachable from other hosts and the token is sent unencrypted")
+				}
+				fmt.Fprintf(os.Stderr, "web dashboard on http://%s/\n", wl.Addr())
+				webServer := &web.Server{Downloader: downloader, Token: token}
+				go func() {
+					err := webServer.Serve(ctx, wl)
+					if err != nil {
+						cancel()
+					}
+					webDone <- err
+				}()
+			} else {
+				webDone <- nil
+			}
+
+			server := &rpc.Server{Downloader: downloader, Token: token}
+			err = server.Serve(ctx, l)
+			if err != nil {
+				cancel()
+			}
+			if webErr := <-webDone; err == nil {
+				err = webErr
+			}
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			slog.Info("waiting for downloads to stop")
+			downloader.Wait()
+			closeErr := downloader.Close()
+			stopHooks()
+			stopProgress()
+			stopMetrics()
+			stopTracing()
+			if closeErr != nil {
+				return cli.Exit("closing storage: "+closeErr.Error(), 1)
+			}
+			if sess != nil {
+				if err := sess.Close(); err != nil {
+					return cli.Exit("saving session: "+err.Error(), 1)
+				}
+			}
+			return nil
+		},
+	}
+}
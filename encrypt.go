@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/urfave/cli/v3"
+)
+
+// encryptionKey returns the key of --encrypt-key-file, --encrypt-key or --encrypt-passphrase, or nil if none is set
+func encryptionKey(cmd *cli.Command) (*storage.EncryptionKey, error) {
+	var selected []string
+	for _, flag := range []string{"encrypt-key-file", "encrypt-key", "encrypt-passphrase"} {
+		if cmd.String(flag) != "" {
+			selected = append(selected, "--"+flag)
+		}
+	}
+	switch {
+	case len(selected) == 0:
+		return nil, nil
+	case len(selected) > 1:
+		return nil, fmt.Errorf("%s cannot be combined with %s", selected[0], selected[1])
+	case cmd.String("encrypt-passphrase") != "":
+		return &storage.EncryptionKey{Passphrase: cmd.String("encrypt-passphrase")}, nil
+	}
+	data := []byte(cmd.String("encrypt-key"))
+	if path := cmd.String("encrypt-key-file"); path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	key, err := storage.ParseEncryptionKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", selected[0], err)
+	}
+	return &storage.EncryptionKey{Key: key}, nil
+}
+
+// encryptFiles returns the decorator encrypting the saved files, or nil if no key is set. Encrypted files cannot be
+// extracted or have their links converted once saved, and are not deduplicated by --store
+func encryptFiles(cmd *cli.Command) (storage.Decorator, error) {
+	key, err := encryptionKey(cmd)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	switch {
+	case (cmd.Bool("extract") || cmd.Bool("remove-archive")) && !cmd.Bool("extract-stream"):
+		return nil, errors.New("archives can only be extracted with --extract-stream when encrypting files")
+	case cmd.Bool("convert-links"):
+		return nil, errors.New("--convert-links cannot be combined with encryption")
+	case cmd.String("store") != "":
+		return nil, errors.New("--store cannot be combined with encryption")
+	}
+	return storage.Encrypt(*key)
+}
+
+// decryptCommand returns the command that restores the plaintext of encrypted downloads
+func decryptCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "decrypt",
+		Usage:     "decrypt files saved with --encrypt-key-file, --encrypt-key or --encrypt-passphrase",
+		ArgsUsage: "<file.enc>...",
+		Description: "Every file is written without its .enc extension, or to --output when a single file is given,\n" +
+			"- writing it to stdout. Files that were modified or truncated are not written, and the command fails.",
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			if !cmd.Bool("log") {
+				slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			}
+			key, err := encryptionKey(cmd)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			if key == nil {
+				return cli.Exit("decrypt requires --encrypt-key-file, --encrypt-key or --encrypt-passphrase", 1)
+			}
+			if cmd.Args().Len() == 0 {
+				return cli.Exit("decrypt takes the files to decrypt", 1)
+			}
+			output := cmd.String("output")
+			if output != "" && cmd.Args().Len() > 1 {
+				return cli.Exit("--output takes a single file", 1)
+			}
+			failed := 0
+			for _, name := range cmd.Args().Slice() {
+				dest := output
+				if dest == "" {
+					var ok bool
+					if dest, ok = strings.CutSuffix(name, storage.EncryptedExtension); !ok || dest == "" {
+						fmt.Fprintf(os.Stderr, "%s: does not end with %s, use --output\n", name, storage.EncryptedExtension)
+						failed++
+						continue
+					}
+				}
+				if err := decryptFile(name, dest, *key); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return cli.Exit(fmt.Sprintf("%d file(s) could not be decrypted", failed), 1)
+			}
+			return nil
+		},
+	}
+}
+
+// decryptFile decrypts name into dest, which must not exist. The plaintext is written to a temporary file renamed
+// to dest once the whole file was authenticated, except on stdout where it is written as it is read
+func decryptFile(name, dest string, key storage.EncryptionKey) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	r, err := storage.NewDecryptReader(src, key)
+	if err != nil {
+		return err
+	}
+	if dest == "-" {
+		_, err := io.Copy(os.Stdout, r)
+		return err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".godown-decrypt-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
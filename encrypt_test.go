@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+var testKey = storage.EncryptionKey{Passphrase: "test passphrase"}
+
+// saveEncrypted saves plain to dir as name.enc, aborting the stream once cut bytes were written and resuming it if
+// cut is positive
+func saveEncrypted(t *testing.T, dir, name string, plain []byte, cut int) string {
+	t.Helper()
+	f := &storage.EncryptWriterFactory{Factory: &storage.FSWriterFactory{BasePath: dir}, Key: testKey}
+	fileName, w, err := f.CreateStream(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(0)
+	if cut > 0 {
+		if _, err := w.Write(plain[:cut]); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Abort(w); err != nil {
+			t.Fatal(err)
+		}
+		if offset, err = f.StreamSize(fileName); err != nil {
+			t.Fatal(err)
+		}
+		if w, err = f.ResumeStream(fileName, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Write(plain[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, fileName)
+}
+
+func TestDecryptFile(t *testing.T) {
+	dir := t.TempDir()
+	plain := make([]byte, 300*1024)
+	rand.Read(plain)
+	for _, cut := range []int{0, 1000, 200 * 1024} {
+		name := saveEncrypted(t, dir, "file", plain, cut)
+		dest := filepath.Join(dir, "plain")
+		if err := decryptFile(name, dest, testKey); err != nil {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+		got, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("cut at %d: decrypted file differs", cut)
+		}
+		if err := decryptFile(name, dest, testKey); err == nil {
+			t.Errorf("cut at %d: existing destination was replaced", cut)
+		}
+		os.Remove(name)
+		os.Remove(dest)
+	}
+}
+
+func TestDecryptFileTampered(t *testing.T) {
+	dir := t.TempDir()
+	plain := make([]byte, 100*1024)
+	rand.Read(plain)
+	name := saveEncrypted(t, dir, "file", plain, 0)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-20] ^= 1
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "plain")
+	if err := decryptFile(name, dest, testKey); !errors.Is(err, storage.ErrDecrypt) {
+		t.Fatalf("got %v, expected ErrDecrypt", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("the directory holds %d files after a failed decryption, expected only the encrypted one", len(entries))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/urfave/cli/v3"
+)
+
+// gcCommand returns the command that removes the objects of a --store that no file links to
+func gcCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "remove the objects of the --store that no downloaded file links to any more",
+		Description: "Objects are kept while a file saved from the store is still a link to them or has the same\n" +
+			"content, or while they have other hard links. Temporary files of downloads that did not finish are\n" +
+			"removed as well.",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "min-age",
+				Value: time.Hour,
+				Usage: "keep objects and temporary files modified more recently, as running downloads may still link them",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only print what would be removed",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			if !cmd.Bool("log") {
+				slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			}
+			if cmd.String("store") == "" {
+				return cli.Exit("gc requires --store", 1)
+			}
+			store := &storage.StoreWriterFactory{Dir: cmd.String("store")}
+			stats, err := store.GC(cmd.Duration("min-age"), cmd.Bool("dry-run"))
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			verb := "removed"
+			if cmd.Bool("dry-run") {
+				verb = "would remove"
+			}
+			fmt.Printf("%s %d files, %d bytes, %d objects kept\n", verb, stats.Removed, stats.Freed, stats.Kept)
+			return nil
+		},
+	}
+}
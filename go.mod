@@ -3,8 +3,12 @@ module github.com/ananthvk/godown
 go 1.24.5
 
 require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
 	github.com/urfave/cli/v3 v3.4.1
 	github.com/vbauerster/mpb/v8 v8.10.2
+	golang.org/x/crypto v0.39.0
 )
 
 require (
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/hook"
+	"github.com/urfave/cli/v3"
+)
+
+// startHooks runs the hooks given by --on-complete, --on-error and --on-batch-complete for the downloads. In the
+// daemon a batch ends whenever the queue is empty, otherwise once every url is downloaded. The returned function
+// waits for the hooks once the downloads are done
+func startHooks(cmd *cli.Command, downloader *download.Downloader, daemon bool) func() {
+	// Hooks may run in another directory, so they are given absolute paths
+	dir, err := filepath.Abs(cmd.String("output-dir"))
+	if err != nil {
+		dir = cmd.String("output-dir")
+	}
+	if bucket := cmd.String("s3-bucket"); bucket != "" {
+		dir = strings.TrimSuffix("s3://"+path.Join(bucket, cmd.String("s3-prefix")), "/")
+	}
+	runner := &hook.Runner{
+		Downloader:  downloader,
+		Dir:         dir,
+		BatchOnIdle: daemon,
+		Concurrency: cmd.Int("hook-concurrency"),
+		Timeout:     cmd.Duration("hook-timeout"),
+	}
+	if s := cmd.String("on-complete"); s != "" {
+		runner.OnComplete = hook.Parse(s)
+	}
+	if s := cmd.String("on-error"); s != "" {
+		runner.OnError = hook.Parse(s)
+	}
+	if s := cmd.String("on-batch-complete"); s != "" {
+		runner.OnBatchComplete = hook.Parse(s)
+	}
+	if runner.OnComplete == nil && runner.OnError == nil && runner.OnBatchComplete == nil {
+		return func() {}
+	}
+	runner.Start()
+	return runner.Close
+}
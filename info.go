@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/urfave/cli/v3"
+)
+
+// infoCommand returns the command that shows the metadata saved with downloaded files
+func infoCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "info",
+		Usage:     "show where files saved with --metadata were downloaded from",
+		ArgsUsage: "<file>...",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the metadata of every file as a json line",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			if !cmd.Bool("log") {
+				slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			}
+			if cmd.Args().Len() == 0 {
+				return cli.Exit("info takes the files to show", 1)
+			}
+			failed := 0
+			for i, name := range cmd.Args().Slice() {
+				m, err := storage.ReadMetadata(name)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					failed++
+					continue
+				}
+				if cmd.Bool("json") {
+					data, err := json.Marshal(struct {
+						File string `json:"file"`
+						*storage.Metadata
+					}{name, m})
+					if err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					fmt.Println(string(data))
+					continue
+				}
+				if i > 0 {
+					fmt.Println()
+				}
+				printMetadata(name, m)
+			}
+			if failed > 0 {
+				return cli.Exit(fmt.Sprintf("%d file(s) have no metadata", failed), 1)
+			}
+			return nil
+		},
+	}
+}
+
+// printMetadata prints the fields of m that are set
+func printMetadata(name string, m *storage.Metadata) {
+	downloaded := "incomplete"
+	if m.Complete() {
+		downloaded = m.Downloaded.Local().Format(time.RFC3339)
+	}
+	fields := []struct{ name, value string }{
+		{"file", name},
+		{"url", m.URL},
+		{"final url", m.FinalURL},
+		{"etag", m.ETag},
+		{"last modified", m.LastModified},
+		{"content type", m.ContentType},
+		{"downloaded", downloaded},
+		{"digest", m.Digest},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Printf("%-14s %s\n", field.name+":", field.value)
+		}
+	}
+}
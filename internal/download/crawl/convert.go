@@ -0,0 +1,97 @@
+package crawl
+
+import (
+	"html"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ConvertLinks rewrites the links of every saved HTML and CSS document for offline browsing.
+// Links to files that were downloaded are replaced by relative paths to the local copies, and relative links
+// to files that were not downloaded are made absolute so that they still point to the original site.
+// basePath is the directory that the files were saved in, so this only works for files on the local file system
+func (c *Crawler) ConvertLinks(basePath string) error {
+	c.mu.Lock()
+	pages := append([]savedPage(nil), c.pages...)
+	files := make(map[string]string, len(c.files))
+	for k, v := range c.files {
+		files[k] = v
+	}
+	c.mu.Unlock()
+
+	for _, page := range pages {
+		filePath := filepath.Join(basePath, filepath.FromSlash(page.fileName))
+		doc, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		contentType := "text/html"
+		if page.css {
+			contentType = "text/css"
+		}
+		converted, n := convertDocument(doc, page, contentType, files)
+		if n == 0 {
+			continue
+		}
+		if err := os.WriteFile(filePath, converted, 0644); err != nil {
+			return err
+		}
+		slog.Info("converted links", "file", page.fileName, "links", n)
+	}
+	return nil
+}
+
+// convertDocument returns the document with its links rewritten and the number of links changed
+func convertDocument(doc []byte, page savedPage, contentType string, files map[string]string) ([]byte, int) {
+	var out strings.Builder
+	last, changed := 0, 0
+	for _, l := range documentLinks(page.url, contentType, doc) {
+		if l.resolved == nil || l.Start < last {
+			continue
+		}
+		var replacement string
+		if local, ok := files[Normalize(l.resolved)]; ok {
+			replacement = relativeLink(page.fileName, local)
+			if l.resolved.Fragment != "" {
+				replacement += "#" + l.resolved.EscapedFragment()
+			}
+		} else if u, err := url.Parse(l.URL); err == nil && !u.IsAbs() {
+			replacement = l.resolved.String()
+		} else {
+			continue
+		}
+		if !page.css {
+			replacement = html.EscapeString(replacement)
+		}
+		out.Write(doc[last:l.Start])
+		out.WriteString(replacement)
+		last = l.End
+		changed++
+	}
+	out.Write(doc[last:])
+	return []byte(out.String()), changed
+}
+
+// relativeLink returns a URL reference from the file from to the file to, both relative to the download directory
+func relativeLink(from, to string) string {
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(from)), filepath.FromSlash(to))
+	if err != nil {
+		rel = to
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, p := range parts {
+		if p != ".." {
+			parts[i] = url.PathEscape(p)
+		}
+	}
+	link := path.Join(parts...)
+	// A file name containing a colon would otherwise be mistaken for a URL scheme
+	if strings.Contains(parts[0], ":") {
+		link = "./" + link
+	}
+	return link
+}
@@ -0,0 +1,91 @@
+package crawl
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertDocument(t *testing.T) {
+	page, _ := url.Parse("http://example.com/docs/index.html")
+	files := map[string]string{
+		"http://example.com/docs/a.html":       "example.com/docs/a.html",
+		"http://example.com/style.css":         "example.com/style.css",
+		"http://example.com/img/b%20c.png":     "example.com/img/b c.png",
+		"http://cdn.example.com/lib.js":        "cdn.example.com/lib.js",
+		"http://example.com/docs/x:y.html":     "example.com/docs/x:y.html",
+		"http://example.com/docs/q?page=2&x=1": "example.com/docs/q?page=2&x=1.html",
+	}
+	tests := []struct {
+		name string
+		doc  string
+		want string
+		n    int
+	}{
+		{"relative", `<a href="a.html">`, `<a href="a.html">`, 1},
+		{"absolute", `<a href="http://example.com/docs/a.html">`, `<a href="a.html">`, 1},
+		{"parent directory", `<link href="/style.css">`, `<link href="../style.css">`, 1},
+		{"other host", `<script src="http://cdn.example.com/lib.js"></script>`, `<script src="../../cdn.example.com/lib.js"></script>`, 1},
+		{"fragment", `<a href="a.html#part">`, `<a href="a.html#part">`, 1},
+		{"escaped name", `<img src="/img/b%20c.png">`, `<img src="../img/b%20c.png">`, 1},
+		{"colon in name", `<a href="http://example.com/docs/x:y.html">`, `<a href="./x:y.html">`, 1},
+		{"query", `<a href="q?page=2&amp;x=1">`, `<a href="q%3Fpage=2&amp;x=1.html">`, 1},
+		{"not downloaded relative", `<a href="missing.html">`, `<a href="http://example.com/docs/missing.html">`, 1},
+		{"not downloaded absolute", `<a href="http://other.com/">`, `<a href="http://other.com/">`, 0},
+		{"mailto", `<a href="mailto:a@example.com">`, `<a href="mailto:a@example.com">`, 0},
+		{"comment", `<!-- <a href="a.html"> -->`, `<!-- <a href="a.html"> -->`, 0},
+		{"style", `<div style="background: url('/style.css')">`, `<div style="background: url('../style.css')">`, 1},
+		{"srcset", `<img srcset="/img/b%20c.png 1x, missing.png 2x">`,
+			`<img srcset="../img/b%20c.png 1x, http://example.com/docs/missing.png 2x">`, 2},
+		{"base", `<base href="/"><a href="style.css">`, `<base href="/"><a href="../style.css">`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, n := convertDocument([]byte(tt.doc), savedPage{url: page, fileName: "example.com/docs/index.html"}, "text/html", files)
+			if string(got) != tt.want || n != tt.n {
+				t.Errorf("converted to %s (%d links), expected %s (%d links)", got, n, tt.want, tt.n)
+			}
+		})
+	}
+}
+
+func TestConvertLinks(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("example.com/index.html", `<link rel="stylesheet" href="/css/site.css"><a href="about">`)
+	write("example.com/css/site.css", `@import "base.css"; body { background: url(/img/bg.png) }`)
+
+	c := NewCrawler(Rules{})
+	c.Saved(mustParse(t, "http://example.com/"), "example.com/index.html", "text/html; charset=utf-8")
+	c.Saved(mustParse(t, "http://example.com/css/site.css"), "example.com/css/site.css", "text/css")
+	c.Saved(mustParse(t, "http://example.com/img/bg.png"), "example.com/img/bg.png", "image/png")
+	if err := c.ConvertLinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.com/index.html", `<link rel="stylesheet" href="css/site.css"><a href="http://example.com/about">`},
+		{"example.com/css/site.css", `@import "http://example.com/css/base.css"; body { background: url(../img/bg.png) }`},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(tt.name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s holds %s, expected %s", tt.name, data, tt.want)
+		}
+	}
+}
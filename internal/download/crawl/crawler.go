@@ -0,0 +1,192 @@
+package crawl
+
+import (
+	"context"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Crawler keeps the state of a recursive download: the queue of URLs already seen (normalized so that
+// each page is fetched once), the hosts of the starting URLs and the local files that pages were saved to.
+// It is safe for concurrent use by multiple tasks
+type Crawler struct {
+	Rules Rules
+
+	robots    *robotsCache
+	mu        sync.Mutex
+	seen      map[string]bool
+	seedHosts map[string]bool
+	files     map[string]string
+	pages     []savedPage
+}
+
+// savedPage is an HTML or CSS document that was saved, it is revisited when converting links
+type savedPage struct {
+	url      *url.URL
+	fileName string
+	css      bool
+}
+
+// NewCrawler creates a Crawler that follows links according to rules
+func NewCrawler(rules Rules) *Crawler {
+	return &Crawler{
+		Rules:     rules,
+		robots:    &robotsCache{client: &http.Client{}, hosts: map[string]*robots{}},
+		seen:      map[string]bool{},
+		seedHosts: map[string]bool{},
+		files:     map[string]string{},
+	}
+}
+
+// SetClient replaces the client robots.txt is fetched with, it must be called before the first Visit
+func (c *Crawler) SetClient(client *http.Client) {
+	c.robots.client = client
+}
+
+// Seed registers a starting URL. It returns false if the URL has already been queued
+func (c *Crawler) Seed(u *url.URL) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seedHosts[strings.ToLower(u.Hostname())] = true
+	key := Normalize(u)
+	if c.seen[key] {
+		return false
+	}
+	c.seen[key] = true
+	return true
+}
+
+// Visit decides whether a link found at depth should be downloaded. It returns true at most once for every
+// normalized URL, and only if the URL passes the rules and robots.txt
+func (c *Crawler) Visit(ctx context.Context, u *url.URL, depth int) bool {
+	c.mu.Lock()
+	key := Normalize(u)
+	if c.seen[key] || !c.Rules.allowed(u, depth, c.seedHosts) {
+		c.mu.Unlock()
+		return false
+	}
+	c.seen[key] = true
+	c.mu.Unlock()
+
+	if !c.Rules.IgnoreRobots && !c.robots.allowed(ctx, u) {
+		slog.Info("disallowed by robots.txt", "url", u.String())
+		return false
+	}
+	return true
+}
+
+// CanRecurse reports whether links found in a page at depth can still be followed
+func (c *Crawler) CanRecurse(depth int) bool {
+	return c.Rules.MaxDepth <= 0 || depth < c.Rules.MaxDepth
+}
+
+// IsDocument reports whether the content type is HTML or CSS, the documents that contain links
+func IsDocument(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "text/css"
+}
+
+// FileName returns the local path for u, mirroring the host and path of the URL (like wget -r).
+// Directory URLs are saved as index.html, queries are kept in the name and HTML or CSS documents
+// without a matching extension get one so that they can be opened locally
+func (c *Crawler) FileName(u *url.URL, contentType string) string {
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" {
+		host += "+" + port
+	}
+	parts := []string{sanitizeSegment(host)}
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg = sanitizeSegment(seg); seg != "" {
+			parts = append(parts, seg)
+		}
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		parts = append(parts, "index.html")
+	}
+	name := path.Join(parts...)
+	if u.RawQuery != "" {
+		name += "?" + strings.ReplaceAll(u.RawQuery, "/", "%2F")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case (mediaType == "text/html" || mediaType == "application/xhtml+xml") && ext != ".html" && ext != ".htm":
+		name += ".html"
+	case mediaType == "text/css" && ext != ".css":
+		name += ".css"
+	}
+	return name
+}
+
+// sanitizeSegment makes a path segment safe to use as a file name
+func sanitizeSegment(seg string) string {
+	if unescaped, err := url.PathUnescape(seg); err == nil {
+		seg = unescaped
+	}
+	seg = strings.NewReplacer("/", "%2F", "\\", "%5C", "\x00", "").Replace(seg)
+	if seg == "." || seg == ".." {
+		return ""
+	}
+	return seg
+}
+
+// Saved records that u was saved as fileName. HTML and CSS documents are remembered for link conversion
+func (c *Crawler) Saved(u *url.URL, fileName string, contentType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[Normalize(u)] = fileName
+	if IsDocument(contentType) {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		c.pages = append(c.pages, savedPage{url: u, fileName: fileName, css: mediaType == "text/css"})
+	}
+}
+
+// Links extracts the links of an HTML or CSS document downloaded from pageURL and resolves them
+func (c *Crawler) Links(pageURL *url.URL, contentType string, body []byte) []*url.URL {
+	var resolved []*url.URL
+	for _, l := range documentLinks(pageURL, contentType, body) {
+		if l.resolved != nil {
+			resolved = append(resolved, l.resolved)
+		}
+	}
+	return resolved
+}
+
+// resolvedLink is a link along with its absolute URL, resolved is nil if the link is not a valid URL
+type resolvedLink struct {
+	Link
+	resolved *url.URL
+}
+
+// documentLinks extracts the links of a document and resolves them against the page URL or its <base> element
+func documentLinks(pageURL *url.URL, contentType string, body []byte) []resolvedLink {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var links []Link
+	base := pageURL
+	if mediaType == "text/css" {
+		links = ExtractCSSLinks(body)
+	} else {
+		var baseHref string
+		links, baseHref = ExtractHTMLLinks(body)
+		if baseHref != "" {
+			if b, err := pageURL.Parse(baseHref); err == nil {
+				base = b
+			}
+		}
+	}
+	out := make([]resolvedLink, 0, len(links))
+	for _, l := range links {
+		rl := resolvedLink{Link: l}
+		if u, err := base.Parse(l.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			rl.resolved = u
+		}
+		out = append(out, rl)
+	}
+	return out
+}
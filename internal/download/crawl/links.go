@@ -0,0 +1,160 @@
+package crawl
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Link is a reference found in a document. Start and End are the byte offsets of the raw (still escaped)
+// reference in the document, so that it can be rewritten later; URL is the unescaped reference
+type Link struct {
+	URL   string
+	Start int
+	End   int
+}
+
+// linkAttributes lists the attributes of each element that contain URLs.
+// srcset attributes contain a list of candidates and are handled separately
+var linkAttributes = map[string][]string{
+	"a":      {"href"},
+	"area":   {"href"},
+	"link":   {"href"},
+	"img":    {"src", "srcset"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+	"embed":  {"src"},
+	"source": {"src", "srcset"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"track":  {"src"},
+	"input":  {"src"},
+	"object": {"data"},
+	"body":   {"background"},
+	"table":  {"background"},
+	"td":     {"background"},
+}
+
+var (
+	htmlComment  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlTag      = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)(\s[^>]*)?>`)
+	htmlAttr     = regexp.MustCompile(`([^\s=/>"']+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+	htmlStyle    = regexp.MustCompile(`(?is)<style[^>]*>(.*?)</style>`)
+	cssURL       = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	cssImport    = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+	srcsetSplit  = regexp.MustCompile(`[^\s,][^\s]*`)
+	ignoredLinks = []string{"data:", "javascript:", "mailto:", "tel:", "#"}
+)
+
+// ExtractHTMLLinks returns the links of an HTML document along with the href of its <base> element, if any.
+// Links inside comments are ignored; links in <style> elements and style attributes are extracted as CSS
+func ExtractHTMLLinks(doc []byte) ([]Link, string) {
+	s := string(doc)
+	comments := htmlComment.FindAllStringIndex(s, -1)
+	inComment := func(pos int) bool {
+		for _, c := range comments {
+			if pos >= c[0] && pos < c[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var links []Link
+	base := ""
+	for _, tag := range htmlTag.FindAllStringSubmatchIndex(s, -1) {
+		if inComment(tag[0]) || tag[4] < 0 {
+			continue
+		}
+		name := strings.ToLower(s[tag[2]:tag[3]])
+		attrs := linkAttributes[name]
+		attrOffset := tag[4]
+		for _, attr := range htmlAttr.FindAllStringSubmatchIndex(s[tag[4]:tag[5]], -1) {
+			attrName := strings.ToLower(s[attrOffset+attr[2] : attrOffset+attr[3]])
+			start, end := -1, -1
+			for g := 4; g <= 8; g += 2 {
+				if attr[g] >= 0 {
+					start, end = attrOffset+attr[g], attrOffset+attr[g+1]
+					break
+				}
+			}
+			if start < 0 {
+				continue
+			}
+			switch {
+			case name == "base" && attrName == "href":
+				base = html.UnescapeString(s[start:end])
+			case attrName == "style":
+				links = append(links, extractCSS(s[start:end], start, true)...)
+			case attrName == "srcset":
+				for _, m := range srcsetSplit.FindAllStringIndex(s[start:end], -1) {
+					candidate := s[start+m[0] : start+m[1]]
+					// The first token of every comma separated candidate is the URL, descriptors follow it
+					if m[0] == 0 || strings.HasSuffix(strings.TrimSpace(s[start:start+m[0]]), ",") {
+						candidate = strings.TrimSuffix(candidate, ",")
+						links = appendLink(links, html.UnescapeString(candidate), start+m[0], start+m[0]+len(candidate))
+					}
+				}
+			default:
+				for _, a := range attrs {
+					if a == attrName {
+						links = appendLink(links, html.UnescapeString(s[start:end]), start, end)
+					}
+				}
+			}
+		}
+	}
+	for _, style := range htmlStyle.FindAllStringSubmatchIndex(s, -1) {
+		if !inComment(style[0]) {
+			links = append(links, extractCSS(s[style[2]:style[3]], style[2], false)...)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Start < links[j].Start })
+	return links, base
+}
+
+// ExtractCSSLinks returns the url() and @import references of a stylesheet
+func ExtractCSSLinks(doc []byte) []Link {
+	return extractCSS(string(doc), 0, false)
+}
+
+// extractCSS finds references in CSS, offset is added to the positions of the links.
+// When escaped is true the CSS is inside an HTML attribute and references are unescaped
+func extractCSS(s string, offset int, escaped bool) []Link {
+	var links []Link
+	for _, re := range []*regexp.Regexp{cssURL, cssImport} {
+		for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+			for g := 2; g < len(m); g += 2 {
+				if m[g] < 0 {
+					continue
+				}
+				value := s[m[g]:m[g+1]]
+				if escaped {
+					value = html.UnescapeString(value)
+				}
+				links = appendLink(links, value, offset+m[g], offset+m[g+1])
+				break
+			}
+		}
+	}
+	// Links are rewritten in document order
+	sort.Slice(links, func(i, j int) bool { return links[i].Start < links[j].Start })
+	return links
+}
+
+// appendLink appends a link unless it is empty or refers to something that cannot be downloaded
+func appendLink(links []Link, value string, start, end int) []Link {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return links
+	}
+	lower := strings.ToLower(value)
+	for _, prefix := range ignoredLinks {
+		if strings.HasPrefix(lower, prefix) {
+			return links
+		}
+	}
+	return append(links, Link{URL: value, Start: start, End: end})
+}
@@ -0,0 +1,173 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// userAgent is the product token matched against User-agent lines of robots.txt
+const userAgent = "godown"
+
+// maxRobotsSize is the maximum size of robots.txt that is parsed, as recommended by RFC 9309
+const maxRobotsSize = 500 * 1024
+
+// robotsRule is a single Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robots holds the rules of a robots.txt file that apply to us
+type robots struct {
+	rules       []robotsRule
+	disallowAll bool
+}
+
+// parseRobots parses robots.txt and keeps the rules of the group for our user agent, or of the "*" group
+// when there is no specific group
+func parseRobots(data []byte) *robots {
+	var specific, wildcard []robotsRule
+	var current *[]robotsRule
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				// A User-agent line after rules starts a new group
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+			current = nil
+			for _, a := range agents {
+				if a == "*" {
+					current = &wildcard
+				}
+			}
+			for _, a := range agents {
+				if a != "*" && strings.Contains(userAgent, a) {
+					current = &specific
+				}
+			}
+		case "allow", "disallow":
+			inRules = true
+			if current == nil || value == "" {
+				continue
+			}
+			*current = append(*current, robotsRule{allow: key == "allow", pattern: value, re: robotsPattern(value)})
+		}
+	}
+	if specific != nil {
+		return &robots{rules: specific}
+	}
+	return &robots{rules: wildcard}
+}
+
+// robotsPattern compiles a path pattern where '*' matches any sequence of characters and a trailing '$'
+// anchors the pattern at the end of the path
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowed reports whether the path (including the query) may be fetched.
+// The longest matching rule wins, and Allow wins when an Allow and a Disallow rule have the same length
+func (r *robots) allowed(p string) bool {
+	if r.disallowAll {
+		return false
+	}
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(p) {
+			continue
+		}
+		if len(rule.pattern) > best || (len(rule.pattern) == best && rule.allow) {
+			best, allow = len(rule.pattern), rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsCache fetches and caches robots.txt for every scheme and host
+type robotsCache struct {
+	client *http.Client
+	mu     sync.Mutex
+	hosts  map[string]*robots
+}
+
+// allowed reports whether robots.txt of u's host permits fetching u
+func (c *robotsCache) allowed(ctx context.Context, u *url.URL) bool {
+	key := u.Scheme + "://" + u.Host
+	c.mu.Lock()
+	r, ok := c.hosts[key]
+	c.mu.Unlock()
+	if !ok {
+		r = c.fetch(ctx, key)
+		c.mu.Lock()
+		c.hosts[key] = r
+		c.mu.Unlock()
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return r.allowed(p)
+}
+
+// fetch downloads robots.txt. Following RFC 9309, a missing file allows everything while a server error
+// or an unreachable server disallows everything
+func (c *robotsCache) fetch(ctx context.Context, origin string) *robots {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return &robots{disallowAll: true}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		slog.Warn("fetching robots.txt", "origin", origin, "err", err)
+		return &robots{disallowAll: true}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+		if err != nil {
+			return &robots{disallowAll: true}
+		}
+		return parseRobots(data)
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		return &robots{}
+	default:
+		slog.Warn("fetching robots.txt", "origin", origin, "status", resp.Status)
+		return &robots{disallowAll: true}
+	}
+}
@@ -0,0 +1,82 @@
+package crawl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRobotsAllowed(t *testing.T) {
+	const generic = "User-agent: *\nDisallow: /private\nAllow: /private/public\n"
+	tests := []struct {
+		name   string
+		robots string
+		path   string
+		want   bool
+	}{
+		{"no rules", "", "/a", true},
+		{"disallowed", generic, "/private/a", false},
+		{"longer allow", generic, "/private/public/a", true},
+		{"prefix", generic, "/privateer", false},
+		{"other path", generic, "/a", true},
+		{"comment", "User-agent: * # everyone\nDisallow: /a # no\n", "/a/b", false},
+		{"empty disallow", "User-agent: *\nDisallow:\n", "/a", true},
+		{"wildcard", "User-agent: *\nDisallow: /*.pdf\n", "/docs/a.pdf", false},
+		{"anchored", "User-agent: *\nDisallow: /*.pdf$\n", "/docs/a.pdf?download=1", true},
+		{"query", "User-agent: *\nDisallow: /*?session=\n", "/a?session=1", false},
+		{"allow wins a tie", "User-agent: *\nDisallow: /a\nAllow: /a\n", "/a", true},
+		{"specific group wins", generic + "\nUser-agent: GoDown\nDisallow: /b\n", "/private/a", true},
+		{"specific group rules", generic + "\nUser-agent: GoDown\nDisallow: /b\n", "/b", false},
+		{"other agent", "User-agent: otherbot\nDisallow: /\n", "/a", true},
+		{"grouped agents", "User-agent: otherbot\nUser-agent: godown\nDisallow: /\n", "/a", false},
+		{"new group after rules", "User-agent: godown\nDisallow: /a\nUser-agent: otherbot\nDisallow: /b\n", "/b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRobots([]byte(tt.robots)).allowed(tt.path); got != tt.want {
+				t.Errorf("allowed(%q) = %v, expected %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotsCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{"found", http.StatusOK, "User-agent: *\nDisallow: /a\n", false},
+		{"missing", http.StatusNotFound, "", true},
+		{"server error", http.StatusInternalServerError, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/robots.txt" {
+					http.NotFound(w, r)
+					return
+				}
+				fetched.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			c := NewCrawler(Rules{})
+			c.SetClient(server.Client())
+			c.Seed(mustParse(t, server.URL+"/"))
+			for _, p := range []string{"/a", "/a/b"} {
+				if got := c.Visit(context.Background(), mustParse(t, server.URL+p), 1); got != tt.want {
+					t.Errorf("Visit(%q) = %v, expected %v", p, got, tt.want)
+				}
+			}
+			if fetched.Load() != 1 {
+				t.Errorf("robots.txt fetched %d times, expected once", fetched.Load())
+			}
+		})
+	}
+}
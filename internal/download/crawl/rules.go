@@ -0,0 +1,113 @@
+package crawl
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rules decides which links found during a recursive download are followed.
+// MaxDepth is the maximum number of links followed from a starting URL, 0 means no limit.
+// Links to other hosts are only followed when SpanHosts is set, and then only to hosts inside Domains if it is not empty.
+// Include and Exclude are glob patterns (as in path.Match) matched against the URL path and each of its parent
+// directories, so "/docs" or "/docs/*" matches everything below /docs. IncludeRegex and ExcludeRegex are matched
+// against the full URL. A link must match at least one include rule (if any are given) and no exclude rule
+type Rules struct {
+	MaxDepth     int
+	SpanHosts    bool
+	Domains      []string
+	Include      []string
+	Exclude      []string
+	IncludeRegex []*regexp.Regexp
+	ExcludeRegex []*regexp.Regexp
+	IgnoreRobots bool
+}
+
+// allowed reports whether u, found at the given depth, should be downloaded.
+// seedHosts is the set of hosts of the starting URLs
+func (r *Rules) allowed(u *url.URL, depth int, seedHosts map[string]bool) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if r.MaxDepth > 0 && depth > r.MaxDepth {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if !seedHosts[host] {
+		if !r.SpanHosts || (len(r.Domains) > 0 && !matchesDomain(host, r.Domains)) {
+			return false
+		}
+	}
+
+	if len(r.Include) > 0 || len(r.IncludeRegex) > 0 {
+		included := matchesGlob(u.Path, r.Include)
+		for _, re := range r.IncludeRegex {
+			included = included || re.MatchString(u.String())
+		}
+		if !included {
+			return false
+		}
+	}
+	if matchesGlob(u.Path, r.Exclude) {
+		return false
+	}
+	for _, re := range r.ExcludeRegex {
+		if re.MatchString(u.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of one of them
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "."))
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesGlob reports whether p or any of its parent directories matches one of the patterns
+func matchesGlob(p string, patterns []string) bool {
+	if p == "" {
+		p = "/"
+	}
+	for _, pattern := range patterns {
+		for dir := p; ; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+			if ok, _ := path.Match(strings.TrimSuffix(pattern, "/*"), dir); ok && strings.HasSuffix(pattern, "/*") {
+				return true
+			}
+			if dir == "/" || dir == "." {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// Normalize returns the canonical form of u used for de-duplication: the scheme and host are lower cased,
+// default ports and the fragment are removed and an empty path becomes "/"
+func Normalize(u *url.URL) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	c.Host = strings.ToLower(c.Host)
+	if _, port, err := net.SplitHostPort(c.Host); err == nil {
+		if (c.Scheme == "http" && port == "80") || (c.Scheme == "https" && port == "443") {
+			// Keep the brackets of IPv6 addresses
+			c.Host = strings.TrimSuffix(c.Host, ":"+port)
+		}
+	}
+	c.Fragment, c.RawFragment = "", ""
+	if c.Path == "" {
+		c.Path, c.RawPath = "/", ""
+	}
+	return c.String()
+}
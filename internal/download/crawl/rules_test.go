@@ -0,0 +1,107 @@
+package crawl
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"HTTP://Example.COM", "http://example.com/"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:443/a", "http://example.com:443/a"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"http://example.com/a#section", "http://example.com/a"},
+		{"http://example.com/a?q=1#x", "http://example.com/a?q=1"},
+		{"http://example.com/A/b%20c", "http://example.com/A/b%20c"},
+		{"http://[::1]:80/", "http://[::1]/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := Normalize(mustParse(t, tt.url)); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, expected %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulesAllowed(t *testing.T) {
+	seedHosts := map[string]bool{"example.com": true}
+	tests := []struct {
+		name  string
+		rules Rules
+		url   string
+		depth int
+		want  bool
+	}{
+		{"same host", Rules{}, "http://example.com/a", 1, true},
+		{"host case", Rules{}, "http://EXAMPLE.com/a", 1, true},
+		{"not http", Rules{}, "ftp://example.com/a", 1, false},
+		{"within depth", Rules{MaxDepth: 2}, "http://example.com/a", 2, true},
+		{"beyond depth", Rules{MaxDepth: 2}, "http://example.com/a", 3, false},
+		{"other host", Rules{}, "http://other.com/a", 1, false},
+		{"span hosts", Rules{SpanHosts: true}, "http://other.com/a", 1, true},
+		{"domain", Rules{SpanHosts: true, Domains: []string{"other.com"}}, "http://other.com/a", 1, true},
+		{"subdomain", Rules{SpanHosts: true, Domains: []string{".Other.com"}}, "http://cdn.other.com/a", 1, true},
+		{"domain suffix only", Rules{SpanHosts: true, Domains: []string{"other.com"}}, "http://another.com/a", 1, false},
+		{"domain outside", Rules{SpanHosts: true, Domains: []string{"other.com"}}, "http://third.com/a", 1, false},
+		{"domains without span hosts", Rules{Domains: []string{"other.com"}}, "http://other.com/a", 1, false},
+		{"include directory", Rules{Include: []string{"/docs"}}, "http://example.com/docs/a/b.html", 1, true},
+		{"include directory glob", Rules{Include: []string{"/docs/*"}}, "http://example.com/docs", 1, true},
+		{"include file glob", Rules{Include: []string{"/*.pdf"}}, "http://example.com/a.pdf", 1, true},
+		{"not included", Rules{Include: []string{"/docs"}}, "http://example.com/blog/a", 1, false},
+		{"include regex", Rules{Include: []string{"/docs"}, IncludeRegex: []*regexp.Regexp{regexp.MustCompile(`\?page=\d+$`)}},
+			"http://example.com/blog?page=2", 1, true},
+		{"exclude", Rules{Exclude: []string{"/private"}}, "http://example.com/private/a", 1, false},
+		{"exclude wins over include", Rules{Include: []string{"/docs"}, Exclude: []string{"/docs/old"}}, "http://example.com/docs/old/a", 1, false},
+		{"exclude regex", Rules{ExcludeRegex: []*regexp.Regexp{regexp.MustCompile(`\.zip$`)}}, "http://example.com/a.zip", 1, false},
+		{"exclude regex no match", Rules{ExcludeRegex: []*regexp.Regexp{regexp.MustCompile(`\.zip$`)}}, "http://example.com/a.zip.html", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.allowed(mustParse(t, tt.url), tt.depth, seedHosts); got != tt.want {
+				t.Errorf("allowed(%q) = %v, expected %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisitOnce(t *testing.T) {
+	c := NewCrawler(Rules{IgnoreRobots: true})
+	if !c.Seed(mustParse(t, "http://example.com/")) {
+		t.Fatal("seed was not queued")
+	}
+	if c.Seed(mustParse(t, "HTTP://example.com:80")) {
+		t.Error("the same seed was queued twice")
+	}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://example.com/#top", false},
+		{"http://example.com/a", true},
+		{"http://Example.com:80/a#b", false},
+		{"http://example.com/a?x=1", true},
+		{"http://other.com/a", false},
+	}
+	for _, tt := range tests {
+		if got := c.Visit(context.Background(), mustParse(t, tt.url), 1); got != tt.want {
+			t.Errorf("Visit(%q) = %v, expected %v", tt.url, got, tt.want)
+		}
+	}
+}
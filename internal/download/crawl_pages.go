@@ -0,0 +1,46 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/ananthvk/godown/internal/download/crawl"
+)
+
+// crawlPages implements task.PageHandler for recursive downloads. Files are named after their URL, and the links
+// of saved HTML and CSS documents are queued back into the Downloader one level deeper
+type crawlPages struct {
+	downloader *Downloader
+	ctx        context.Context
+	job        string
+	source     *url.URL
+	depth      int
+}
+
+func (c *crawlPages) FileName(resp *http.Response) string {
+	return c.downloader.Recursive.FileName(resp.Request.URL, resp.Header.Get("Content-Type"))
+}
+
+func (c *crawlPages) WantsBody(resp *http.Response) bool {
+	return crawl.IsDocument(resp.Header.Get("Content-Type")) && c.downloader.Recursive.CanRecurse(c.depth)
+}
+
+func (c *crawlPages) Saved(resp *http.Response, fileName string, body []byte) {
+	crawler := c.downloader.Recursive
+	contentType := resp.Header.Get("Content-Type")
+	crawler.Saved(resp.Request.URL, fileName, contentType)
+	if resp.Request.URL.String() != c.source.String() {
+		// Pages may link to the url before the redirect, so record it as well
+		crawler.Saved(c.source, fileName, "")
+	}
+	if body == nil {
+		return
+	}
+	for _, link := range crawler.Links(resp.Request.URL, contentType, body) {
+		if !crawler.Visit(c.ctx, link, c.depth+1) {
+			continue
+		}
+		c.downloader.addLink(c.ctx, link.String(), c.depth+1, c.job)
+	}
+}
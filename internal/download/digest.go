@@ -0,0 +1,87 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/task"
+)
+
+// ErrDigestMismatch is returned when a download does not have the digest given in its options
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// digestOptions moves a digest given as a "#sha256=<hex>" fragment of the url, as pip does, to the options and
+// checks the digest of the options
+func digestOptions(urlString string, opts JobOptions) (string, JobOptions, error) {
+	if base, fragment, ok := strings.Cut(urlString, "#"); ok {
+		if sum, ok := strings.CutPrefix(fragment, "sha256="); ok {
+			urlString = base
+			if opts.Digest == "" {
+				opts.Digest = "sha256:" + sum
+			}
+		}
+	}
+	if opts.Digest != "" {
+		sum, err := storage.ParseDigest(opts.Digest)
+		if err != nil {
+			return urlString, opts, err
+		}
+		opts.Digest = "sha256:" + sum
+	}
+	return urlString, opts, nil
+}
+
+// checkDigest returns an error if the digest of a completed job is not the one given in its options, the mutex
+// must be held
+func checkDigest(j *job) error {
+	want := j.info.Options.Digest
+	if want == "" || j.info.Digest == "" || j.info.Digest == want {
+		return nil
+	}
+	return fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, want, j.info.Digest)
+}
+
+// fromStore saves the file of an HTTP(S) job from the storage.ContentStore instead of downloading it, when the
+// digest of the job is given and already stored. It reports whether it did
+func (d *Downloader) fromStore(j *job) (bool, error) {
+	d.mu.Lock()
+	store, ok := d.writerFactory.(storage.ContentStore)
+	opts, urlString := j.info.Options, j.info.URL
+	_, isHTTP := j.task.(*task.HTTPDownloadTask)
+	d.mu.Unlock()
+	if !ok || !isHTTP || opts.Digest == "" {
+		return false, nil
+	}
+	fileName := opts.FileName
+	if fileName == "" {
+		fileName = fileNameFromURL(urlString)
+	}
+	name, ok, err := store.Link(opts.Digest, fileName)
+	if err != nil || !ok {
+		return false, err
+	}
+	d.mu.Lock()
+	j.info.FileName = name
+	j.info.Digest = opts.Digest
+	d.mu.Unlock()
+	slog.Info("linked stored file", "url", urlString, "filename", name, "digest", opts.Digest)
+	return true, nil
+}
+
+// fileNameFromURL returns the name a download is saved as when the response does not name it
+func fileNameFromURL(urlString string) string {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return "download"
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "download"
+	}
+	return name
+}
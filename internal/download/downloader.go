@@ -2,24 +2,148 @@ package download
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/ananthvk/godown/internal/download/crawl"
+	"github.com/ananthvk/godown/internal/download/extract"
+	"github.com/ananthvk/godown/internal/download/httpcache"
 	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/spider"
 	"github.com/ananthvk/godown/internal/download/storage"
 	"github.com/ananthvk/godown/internal/download/task"
+	"github.com/ananthvk/godown/internal/download/trace"
 )
 
-// Downloader manages downloading files concurrently from URLs.
-// It uses a WriterFactory to allow the tasks to create writers for saving files,
-// and a WaitGroup to wait until all downloads are complete. The ignoreInvalidURL flag controls
-// whether invalid URLs are skipped or does not allow any download
+// ErrJobNotFound is returned by the job control methods when there is no job with the given ID
+var ErrJobNotFound = errors.New("job not found")
+
+const (
+	// retryDelay is the time waited before retrying a failed download, it doubles with every attempt
+	retryDelay = time.Second
+	// maxRetryDelay limits the time waited before retrying a failed download
+	maxRetryDelay = 30 * time.Second
+)
+
+// Downloader manages downloading files concurrently from URLs, every download is a job with a stable ID that can be
+// listed, paused, resumed and removed while it runs. Use Reconfigure to change the exported fields once it started
 type Downloader struct {
-	writerFactory    storage.WriterFactory
-	wg               sync.WaitGroup
+	// HTTP holds the settings of HTTP(S) downloads
+	HTTP HTTPOptions
+	// Torrent holds the settings used for magnet links and .torrent URLs
+	Torrent TorrentOptions
+	// Stream holds the settings used for HLS and DASH
+	Stream StreamOptions
+	// When Recursive is not nil, pages downloaded from HTTP(S) URLs are searched for links which are downloaded
+	// as well
+	Recursive *crawl.Crawler
+	// When Spider is not nil, URLs are only checked and the results are collected in the report instead of saving
+	// files
+	Spider *SpiderOptions
+	// MaxConcurrent limits the number of tasks that run at the same time, 0 means no limit
+	MaxConcurrent int
+	// MaxStoppedJobs is the number of stopped jobs remembered, 0 means DefaultMaxStoppedJobs
+	MaxStoppedJobs int
+	// Journal is optional and is told about every change to a job so that the jobs can be restored after a restart
+	Journal Journal
+	// Retries is the number of times a download that failed with a temporary error, such as a timeout or a 5xx
+	// response, is tried again, waiting longer before every attempt. HTTP(S) downloads continue where they stopped
+	Retries int
+	// Tracer is optional, every job is traced as a "download" span with an "attempt" span for every time it runs,
+	// which holds the spans of the task
+	Tracer *trace.Tracer
+	// When Extract is not nil, downloaded archives are extracted and the job fails if they cannot be
+	Extract *ExtractOptions
+	// When HTTPCache is not nil, HTTP(S) downloads and robots.txt go through it, but torrents and streams do not
+	HTTPCache *httpcache.Cache
+	// writerFactory allows the tasks to create writers for saving files
+	writerFactory storage.WriterFactory
+	basePath      string
+	// wg is used to wait until all downloads are complete
+	wg sync.WaitGroup
+	// ignoreInvalidURL controls whether invalid URLs are skipped or does not allow any download
 	ignoreInvalidURL bool
 	progressBar      reporter.ProgressBarFactory
+
+	rateLimiter RateLimiter
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	queue   []*job
+	stopped []*job
+	active  int
+	seq     uint64
+	events  eventLog
+}
+
+// HTTPOptions configures HTTP(S) downloads
+type HTTPOptions struct {
+	// When Compressed is true, responses are requested with a gzip, br, zstd or deflate content coding and decoded
+	// while they are saved
+	Compressed bool
+	// KeepEncoding saves the encoded bodies instead, with the extension of the coding added to their names
+	KeepEncoding bool
+	// Continue resumes a file saved by an earlier run for the same url if it is incomplete. The file is found by
+	// its storage.Metadata, under the name given, or else under the name in the url or by the url recorded in it, if
+	// the WriterFactory is a storage.MetadataReader and storage.MetadataFinder
+	Continue bool
+	// Update does so as well and downloads a complete file again, replacing it, only if it changed
+	Update bool
+}
+
+// TorrentOptions configures BitTorrent downloads.
+// When FollowTorrent is true, HTTP(S) URLs whose path ends with .torrent are downloaded as torrents
+// instead of being saved as files. ListenAddr is the address for incoming peer connections and
+// SeedRatio is the upload ratio to reach before a torrent task finishes
+type TorrentOptions struct {
+	FollowTorrent bool
+	ListenAddr    string
+	SeedRatio     float64
+}
+
+// StreamOptions configures HLS and DASH downloads.
+// When FollowStreams is true, HTTP(S) URLs whose path ends with .m3u8 or .mpd are downloaded as streams,
+// concatenating the segments of one variant, instead of saving the playlist itself
+type StreamOptions struct {
+	FollowStreams bool
+	task.StreamOptions
+}
+
+// Journal persists jobs so that they survive restarts, see Downloader.Restore.
+// The methods are called with the Downloader's mutex held, so they must not call back into the Downloader
+type Journal interface {
+	// Update records the current state of a job
+	Update(job JobInfo)
+	// Forget records that a stopped job is no longer remembered
+	Forget(id string)
+}
+
+// ExtractOptions configures the extraction of downloaded archives, see the extract package.
+// Archives are extracted into Dir, or the output directory if it is empty, once they are complete, and removed
+// afterwards if RemoveArchive is set. When Stream is set, archives other than zip are extracted while they are
+// downloaded and never saved
+type ExtractOptions struct {
+	Dir           string
+	Limits        extract.Limits
+	RemoveArchive bool
+	Stream        bool
+}
+
+// SpiderOptions configures link checking. Results are added to Report, checks that failed with a temporary error
+// are retried like downloads, see Downloader.Retries
+type SpiderOptions struct {
+	Report *spider.Report
 }
 
 // NewDownloader creates and returns a pointer to a Downloader object.
@@ -28,45 +152,784 @@ type Downloader struct {
 func NewDownloader(basePath string, ignoreInvalidURL bool, progressBarFactory reporter.ProgressBarFactory) *Downloader {
 	downloader := Downloader{}
 	downloader.writerFactory = &storage.FSWriterFactory{BasePath: basePath}
+	downloader.basePath = basePath
 	downloader.ignoreInvalidURL = ignoreInvalidURL
 	downloader.progressBar = progressBarFactory
+	downloader.jobs = map[string]*job{}
 	return &downloader
 }
 
+// SetWriterFactory replaces the FSWriterFactory the files are saved with, it must be called before the first
+// download. If f is a storage.JobWriterFactory, the files of every job are created with f.ForJob. Archives can
+// then only be extracted while they are downloaded
+func (d *Downloader) SetWriterFactory(f storage.WriterFactory) {
+	d.writerFactory = f
+}
+
+// DecorateWriterFactory wraps the WriterFactory the files are saved with in decorators, such as one compressing
+// them, see storage.Decorate. It must be called before the first download and after SetWriterFactory
+func (d *Downloader) DecorateWriterFactory(decorators ...storage.Decorator) {
+	d.writerFactory = storage.Decorate(d.writerFactory, decorators...)
+}
+
 // Download downloads the file at urlString, it creates the appropriate DownloadTask
-// depending upon the scheme in the url. Currently only HTTP(S) URLs are supported.
+// depending upon the scheme and extension of the url. HTTP(S) URLs and magnet links are supported.
 // If ignoreInvalidURL is true and the url lacks a scheme, "http://" is prepended.
 // The task is executed in a separate goroutine and increments the value of the WaitGroup.
+// If MaxConcurrent is set, Download blocks until the task can start, so that callers
+// feeding a large number of URLs do not queue them all in memory.
 // Clients must call Wait() to ensure all downloads complete
 func (d *Downloader) Download(ctx context.Context, urlString string) {
-	if !d.ignoreInvalidURL && !IsUrl(urlString) {
-		slog.Error("invalid url", "url", urlString)
+	d.DownloadAs(ctx, urlString, "")
+}
+
+// DownloadAs is like Download, but HTTP(S) responses are saved as fileName instead of a name detected
+// from the response. An empty fileName behaves like Download. fileName is ignored for torrents, streams,
+// recursive downloads and link checks
+func (d *Downloader) DownloadAs(ctx context.Context, urlString string, fileName string) {
+	id, err := d.Add(ctx, urlString, JobOptions{FileName: fileName})
+	if err != nil {
+		slog.Error("adding download", "url", urlString, "err", err)
+		return
+	}
+	if id == "" {
+		return
+	}
+	d.mu.Lock()
+	j := d.jobs[id]
+	d.mu.Unlock()
+	if j == nil {
 		return
 	}
-	url, _ := url.Parse(urlString)
-	var t task.Task
+	select {
+	case <-j.started:
+	case <-ctx.Done():
+	}
+}
+
+// Add queues a download of urlString and returns the ID of its job without waiting for it to start.
+// The job is cancelled when ctx is done. An error is returned if the url is invalid or not supported,
+// and an empty ID is returned if nothing needs to be downloaded, such as a page that was already queued
+// by a recursive download
+func (d *Downloader) Add(ctx context.Context, urlString string, opts JobOptions) (string, error) {
+	urlString, opts, err := digestOptions(urlString, opts)
+	if err != nil {
+		return "", err
+	}
+	j := d.newJob(ctx, urlString, opts, "")
+	t, err := d.newTask(ctx, j, urlString)
+	if err != nil || t == nil {
+		return "", err
+	}
+	d.enqueue(j, t)
+	return j.info.ID, nil
+}
+
+// newJob creates a job that is not queued yet
+func (d *Downloader) newJob(ctx context.Context, urlString string, opts JobOptions, parent string) *job {
+	j := &job{
+		info:    JobInfo{ID: newJobID(), URL: urlString, Options: opts, State: JobWaiting, Parent: parent, Created: time.Now()},
+		ctx:     ctx,
+		started: make(chan struct{}),
+	}
+	j.progress = d.newJobProgress(j)
+	return j
+}
+
+// newJobProgress creates the progress bar factory passed to the tasks of the job
+func (d *Downloader) newJobProgress(j *job) *jobProgress {
+	return &jobProgress{job: j, factory: d.progressBar, limiter: &d.rateLimiter}
+}
+
+// SetRateLimit limits the combined speed of HTTP(S) downloads to bytesPerSecond, 0 removes the limit.
+// Torrents and streams are not limited
+func (d *Downloader) SetRateLimit(bytesPerSecond int64) {
+	d.rateLimiter.SetRate(bytesPerSecond)
+}
+
+// RateLimit returns the limit set by SetRateLimit
+func (d *Downloader) RateLimit() int64 {
+	return d.rateLimiter.Rate()
+}
+
+// RateLimitWait returns the total time that downloads have waited for the rate limit
+func (d *Downloader) RateLimitWait() time.Duration {
+	return d.rateLimiter.Waited()
+}
+
+// newTask creates the task for the job depending upon the scheme and extension of urlString.
+// A nil task is returned if there is nothing to download
+func (d *Downloader) newTask(ctx context.Context, j *job, urlString string) (task.Task, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	progress := j.progress
+	if d.Spider != nil {
+		return d.newLinkCheckTask(urlString), nil
+	}
+	if !d.ignoreInvalidURL && !IsUrl(urlString) {
+		return nil, fmt.Errorf("invalid url %q", urlString)
+	}
+	url, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+	fileName := j.info.Options.FileName
 
 	switch url.Scheme {
+	case "magnet":
+		return d.newTorrentTask(j, urlString, progress), nil
 	case "http", "https":
-		t = &task.HTTPDownloadTask{Url: urlString, WriterFactory: d.writerFactory, ProgressBarFactory: d.progressBar}
+		ext := strings.ToLower(path.Ext(url.Path))
+		if d.Torrent.FollowTorrent && ext == ".torrent" {
+			return d.newTorrentTask(j, urlString, progress), nil
+		}
+		if d.Stream.FollowStreams && (ext == ".m3u8" || ext == ".mpd") {
+			return d.newStreamTask(j, urlString, ext, progress), nil
+		}
+		return d.newHTTPTask(ctx, j, urlString, 0, fileName, progress), nil
 	default:
 		if d.ignoreInvalidURL && url.Scheme == "" {
 			// If the url is invalid because it lacks a URL scheme, try adding a default http:// scheme
-			t = &task.HTTPDownloadTask{Url: "http://" + urlString, WriterFactory: d.writerFactory, ProgressBarFactory: d.progressBar}
-		} else {
-			slog.Error("unsupported url scheme", "scheme", url.Scheme)
-			return
+			return d.newHTTPTask(ctx, j, "http://"+urlString, 0, fileName, progress), nil
 		}
+		return nil, fmt.Errorf("unsupported url scheme %q", url.Scheme)
 	}
+}
+
+// enqueue adds the job to the queue and starts it if there is a free slot
+func (d *Downloader) enqueue(j *job, t task.Task) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j.task = t
+	d.seq++
+	j.seq = d.seq
+	d.jobs[j.info.ID] = j
+	d.push(j)
+	d.watch(j)
+	d.emit(j, EventQueued)
+	d.schedule()
+}
 
+// watch counts the job in the WaitGroup until it stops. Jobs that are not running when the context is
+// cancelled are stopped here, active jobs stop on their own. The mutex must be held
+func (d *Downloader) watch(j *job) {
 	d.wg.Add(1)
+	j.stopWatch = context.AfterFunc(j.ctx, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if !j.running && !j.info.State.Stopped() {
+			d.stop(j, JobError, j.ctx.Err())
+		}
+	})
+}
+
+// push inserts the job into the queue after the jobs with a higher or the same priority, the mutex must be held
+func (d *Downloader) push(j *job) {
+	i, _ := slices.BinarySearchFunc(d.queue, j, func(q, j *job) int {
+		if q.info.Options.Priority != j.info.Options.Priority {
+			return j.info.Options.Priority - q.info.Options.Priority
+		}
+		if q.seq < j.seq {
+			return -1
+		}
+		return 1
+	})
+	d.queue = slices.Insert(d.queue, i, j)
+}
+
+// schedule starts waiting jobs while there are free slots, the mutex must be held
+func (d *Downloader) schedule() {
+	for d.MaxConcurrent <= 0 || d.active < d.MaxConcurrent {
+		if len(d.queue) == 0 {
+			return
+		}
+		j := d.queue[0]
+		d.queue = d.queue[1:]
+		if j.info.State != JobWaiting || j.running {
+			continue
+		}
+		d.run(j)
+	}
+}
+
+// run executes the task of the job in a separate goroutine, the mutex must be held
+func (d *Downloader) run(j *job) {
+	ctx, cancel := context.WithCancel(j.ctx)
+	j.cancel = cancel
+	j.running = true
+	j.pausing.Store(false)
+	j.info.State = JobActive
+	j.info.Error = ""
+	j.info.ErrorClass = ""
+	d.active++
+	closeStarted(j)
+	if j.span == nil {
+		_, j.span = d.Tracer.Start(ctx, "download")
+		j.span.SetAttr("job.id", j.info.ID)
+		j.span.SetAttr("job.url", j.info.URL)
+	}
+	ctx, attempt := trace.Start(trace.ContextWithSpan(ctx, j.span), "attempt")
+	attempt.SetAttr("job.retries", j.info.Retries)
+	d.emit(j, EventStarted)
 	go func() {
-		t.Execute(ctx)
-		defer d.wg.Done()
+		stored, err := d.fromStore(j)
+		if !stored && err == nil {
+			err = j.task.Execute(ctx)
+		}
+		if err == nil {
+			err = d.extract(ctx, j)
+		}
+		attempt.End(err)
+		d.finished(j, err)
 	}()
 }
 
-// Wait blocks until all downloads started by Download() are complete
+// extract extracts the file saved by a job if it is an archive, unless it was extracted while it was downloaded
+func (d *Downloader) extract(ctx context.Context, j *job) error {
+	d.mu.Lock()
+	opts, name := d.Extract, j.info.FileName
+	d.mu.Unlock()
+	if opts == nil || name == "" || !extract.Supported(name) || opts.Stream && extract.Streamable(name) {
+		return nil
+	}
+	archive := filepath.Join(d.basePath, name)
+	dir := d.extractDir(opts)
+	_, span := trace.Start(ctx, "extract")
+	span.SetAttr("extract.dir", dir)
+	err := extract.File(archive, dir, opts.Limits)
+	span.End(err)
+	if err != nil {
+		slog.Error("extracting archive", "path", archive, "err", err)
+		return err
+	}
+	slog.Info("extracted archive", "path", archive, "dir", dir)
+	if opts.RemoveArchive {
+		if err := os.Remove(archive); err != nil {
+			slog.Warn("removing archive", "path", archive, "err", err)
+		}
+	}
+	return nil
+}
+
+// extractDir returns the directory archives are extracted into
+func (d *Downloader) extractDir(opts *ExtractOptions) string {
+	if opts.Dir != "" {
+		return opts.Dir
+	}
+	return d.basePath
+}
+
+// closeStarted releases the callers of DownloadAs waiting for the job to start
+func closeStarted(j *job) {
+	select {
+	case <-j.started:
+	default:
+		close(j.started)
+	}
+}
+
+// finished is called when the task of an active job returns
+func (d *Downloader) finished(j *job, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j.cancel()
+	j.running = false
+	d.active--
+	switch {
+	case j.info.State == JobPaused:
+		d.emit(j, EventPaused)
+	case j.info.State == JobWaiting:
+		// Resumed before the paused task returned
+		d.push(j)
+	case j.info.State == JobRemoved:
+		d.stop(j, JobRemoved, nil)
+	case err != nil && j.ctx.Err() == nil && j.info.Retries < d.Retries && temporary(err):
+		d.retryLater(j, err)
+	case err != nil:
+		d.stop(j, JobError, err)
+	default:
+		if digester, ok := j.task.(task.Digester); ok && digester.Digest() != "" {
+			j.info.Digest = digester.Digest()
+		}
+		if err := checkDigest(j); err != nil {
+			d.stop(j, JobError, err)
+		} else {
+			d.stop(j, JobComplete, nil)
+		}
+	}
+	d.schedule()
+}
+
+// retryLater queues the job again after a delay that doubles with every retry, the mutex must be held
+func (d *Downloader) retryLater(j *job, err error) {
+	j.info.Retries++
+	j.info.State = JobWaiting
+	j.info.Error = err.Error()
+	j.info.ErrorClass = ErrorClass(err)
+	delay := min(retryDelay<<(j.info.Retries-1), maxRetryDelay)
+	slog.Info("retrying download", "url", j.info.URL, "attempt", j.info.Retries, "delay", delay, "err", err)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if j.retryTimer != timer {
+			return
+		}
+		j.retryTimer = nil
+		if j.info.State == JobWaiting && !j.running {
+			d.push(j)
+			d.schedule()
+		}
+	})
+	j.retryTimer = timer
+	d.emit(j, EventRetry)
+}
+
+// stopRetry cancels a pending retry of the job, the mutex must be held
+func stopRetry(j *job) {
+	if j.retryTimer != nil {
+		j.retryTimer.Stop()
+		j.retryTimer = nil
+	}
+}
+
+// stop moves the job to a stopped state and forgets the oldest stopped jobs, the mutex must be held
+func (d *Downloader) stop(j *job, state JobState, err error) {
+	j.info.State = state
+	if err != nil {
+		j.info.Error = err.Error()
+		j.info.ErrorClass = ErrorClass(err)
+	}
+	j.info.Finished = time.Now()
+	j.stopWatch()
+	stopRetry(j)
+	j.span.SetAttr("job.state", string(state))
+	j.span.SetAttr("job.completed", j.completed.Load())
+	j.span.End(err)
+	j.span = nil
+	if state != JobComplete {
+		j.progress.abort()
+	}
+	closeStarted(j)
+	switch state {
+	case JobComplete:
+		d.emit(j, EventCompleted)
+	case JobRemoved:
+		d.emit(j, EventRemoved)
+	default:
+		d.emit(j, EventFailed)
+	}
+	d.stopped = append(d.stopped, j)
+	d.pruneStopped()
+	d.wg.Done()
+}
+
+// pruneStopped forgets the oldest stopped jobs beyond MaxStoppedJobs, the mutex must be held
+func (d *Downloader) pruneStopped() {
+	limit := d.MaxStoppedJobs
+	if limit <= 0 {
+		limit = DefaultMaxStoppedJobs
+	}
+	for len(d.stopped) > limit {
+		d.forget(d.stopped[0])
+	}
+}
+
+// forget drops a stopped job, the mutex must be held
+func (d *Downloader) forget(j *job) {
+	delete(d.jobs, j.info.ID)
+	d.stopped = slices.DeleteFunc(d.stopped, func(s *job) bool { return s == j })
+	if d.Journal != nil {
+		d.Journal.Forget(j.info.ID)
+	}
+}
+
+// persist passes the state of the job to the Journal, the mutex must be held.
+// Jobs interrupted because their context was cancelled, such as when the program is stopped, are not recorded
+// so that the journal still has them as unfinished
+func (d *Downloader) persist(j *job) {
+	if d.Journal != nil && j.ctx.Err() == nil {
+		d.Journal.Update(j.snapshot())
+	}
+}
+
+// Pause stops a waiting or active job until it is resumed. An active download is cancelled, HTTP(S)
+// downloads continue where they stopped when resumed while other downloads start again
+func (d *Downloader) Pause(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	switch j.info.State {
+	case JobPaused:
+		return nil
+	case JobWaiting, JobActive:
+		j.info.State = JobPaused
+		stopRetry(j)
+		if j.running {
+			// The event is emitted once the task has returned
+			j.pausing.Store(true)
+			j.cancel()
+		} else {
+			d.emit(j, EventPaused)
+		}
+		return nil
+	default:
+		return fmt.Errorf("cannot pause a job that is %s", j.info.State)
+	}
+}
+
+// Resume queues a paused job again
+func (d *Downloader) Resume(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.info.State != JobPaused {
+		return fmt.Errorf("cannot resume a job that is %s", j.info.State)
+	}
+	j.info.State = JobWaiting
+	if !j.running {
+		d.push(j)
+	}
+	d.emit(j, EventResumed)
+	d.schedule()
+	return nil
+}
+
+// Cancel stops a job that has not stopped yet, its connections are closed and it is kept as a removed job
+func (d *Downloader) Cancel(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.info.State.Stopped() {
+		return fmt.Errorf("cannot cancel a job that is %s", j.info.State)
+	}
+	d.cancel(j)
+	return nil
+}
+
+// Remove cancels a job that has not stopped yet. A stopped job is forgotten instead
+func (d *Downloader) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.info.State.Stopped() {
+		d.forget(j)
+	} else {
+		d.cancel(j)
+	}
+	return nil
+}
+
+// cancel stops the job as removed, the mutex must be held
+func (d *Downloader) cancel(j *job) {
+	if j.running {
+		// The job is stopped once the task has returned
+		j.info.State = JobRemoved
+		j.pausing.Store(false)
+		j.cancel()
+		return
+	}
+	d.stop(j, JobRemoved, nil)
+}
+
+// Retry queues a failed or removed job again with the same ID. HTTP(S) downloads continue where they stopped
+func (d *Downloader) Retry(id string) error {
+	d.mu.Lock()
+	j, ok := d.jobs[id]
+	if !ok {
+		d.mu.Unlock()
+		return ErrJobNotFound
+	}
+	if j.info.State != JobError && j.info.State != JobRemoved {
+		d.mu.Unlock()
+		return fmt.Errorf("cannot retry a job that is %s", j.info.State)
+	}
+	if j.ctx.Err() != nil {
+		d.mu.Unlock()
+		return j.ctx.Err()
+	}
+	t := j.task
+	d.mu.Unlock()
+
+	if t == nil {
+		// Jobs restored from a journal have no task yet
+		var err error
+		if t, err = d.newTask(j.ctx, j, j.info.URL); err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("nothing to download for %s", j.info.URL)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if j.info.State != JobError && j.info.State != JobRemoved || d.jobs[id] != j {
+		return fmt.Errorf("cannot retry a job that is %s", j.info.State)
+	}
+	d.stopped = slices.DeleteFunc(d.stopped, func(s *job) bool { return s == j })
+	j.task = t
+	j.info.State = JobWaiting
+	j.info.Error = ""
+	j.info.ErrorClass = ""
+	j.info.Retries = 0
+	j.info.HookError = ""
+	j.info.Finished = time.Time{}
+	d.push(j)
+	d.watch(j)
+	d.emit(j, EventRetry)
+	d.schedule()
+	return nil
+}
+
+// ReportHook records the result of the hooks run for a stopped job in its HookError, and emits EventHook
+func (d *Downloader) ReportHook(id string, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if !j.info.State.Stopped() {
+		return fmt.Errorf("cannot report hooks of a job that is %s", j.info.State)
+	}
+	j.info.HookError = ""
+	if err != nil {
+		j.info.HookError = err.Error()
+	}
+	d.emit(j, EventHook)
+	return nil
+}
+
+// SetPriority changes the priority of a job, which decides the order in which waiting jobs are started
+func (d *Downloader) SetPriority(id string, priority int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.info.State.Stopped() {
+		return fmt.Errorf("cannot change the priority of a job that is %s", j.info.State)
+	}
+	j.info.Options.Priority = priority
+	if i := slices.Index(d.queue, j); i >= 0 {
+		d.queue = slices.Delete(d.queue, i, i+1)
+		d.push(j)
+	}
+	d.emit(j, EventPriority)
+	return nil
+}
+
+// Restore adds a job saved by a Journal, keeping its ID. Unfinished jobs are queued again and HTTP(S) downloads
+// continue where they stopped, paused jobs stay paused and stopped jobs are only remembered.
+// Links found by restored pages of a recursive download are treated as links of a starting page
+func (d *Downloader) Restore(ctx context.Context, info JobInfo) error {
+	j := &job{info: info, ctx: ctx, started: make(chan struct{})}
+	j.progress = d.newJobProgress(j)
+	j.completed.Store(info.Completed)
+	j.total.Store(info.Total)
+	if info.State.Stopped() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.jobs[info.ID]; ok {
+			return fmt.Errorf("job %s already exists", info.ID)
+		}
+		d.seq++
+		j.seq = d.seq
+		closeStarted(j)
+		d.jobs[info.ID] = j
+		d.stopped = append(d.stopped, j)
+		d.pruneStopped()
+		return nil
+	}
+
+	if info.State != JobPaused {
+		j.info.State = JobWaiting
+	}
+	j.info.Error = ""
+	t, err := d.newTask(ctx, j, info.URL)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing to download for %s", info.URL)
+	}
+	d.mu.Lock()
+	_, exists := d.jobs[info.ID]
+	d.mu.Unlock()
+	if exists {
+		return fmt.Errorf("job %s already exists", info.ID)
+	}
+	d.enqueue(j, t)
+	return nil
+}
+
+// Job returns the current state of a job
+func (d *Downloader) Job(id string) (JobInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return JobInfo{}, ErrJobNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// Jobs returns the jobs in the given states in the order they were added, all jobs are returned if no state is given
+func (d *Downloader) Jobs(states ...JobState) []JobInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	jobs := make([]*job, 0, len(d.jobs))
+	for _, j := range d.jobs {
+		if len(states) == 0 || slices.Contains(states, j.info.State) {
+			jobs = append(jobs, j)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b *job) int {
+		if a.seq < b.seq {
+			return -1
+		}
+		return 1
+	})
+	infos := make([]JobInfo, len(jobs))
+	for i, j := range jobs {
+		infos[i] = j.snapshot()
+	}
+	return infos
+}
+
+// Reconfigure calls fn with the mutex held so that the exported fields can be changed while downloads are
+// running. The new settings apply to downloads added afterwards, except MaxConcurrent which applies immediately
+func (d *Downloader) Reconfigure(fn func(d *Downloader)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(d)
+	d.schedule()
+}
+
+// newLinkCheckTask creates a LinkCheckTask for urlString, invalid URLs are reported as broken links
+// and nil is returned
+func (d *Downloader) newLinkCheckTask(urlString string) task.Task {
+	record := d.Spider.Report.Add(urlString)
+	if !IsUrl(urlString) {
+		u, err := url.Parse(urlString)
+		if !d.ignoreInvalidURL || err != nil || u.Scheme != "" {
+			record(spider.Result{ContentLength: -1, Error: "invalid url", Broken: true})
+			return nil
+		}
+		urlString = "http://" + urlString
+	}
+	return &task.LinkCheckTask{Url: urlString, Record: record}
+}
+
+// newHTTPTask creates an HTTPDownloadTask for the job. In recursive mode the url is registered with the crawler and
+// nil is returned if it has already been queued; depth is the number of links followed to reach the url.
+// fileName overrides the name of the saved file unless the download is recursive
+func (d *Downloader) newHTTPTask(ctx context.Context, j *job, urlString string, depth int, fileName string,
+	progress reporter.ProgressBarFactory) task.Task {
+	t := &task.HTTPDownloadTask{Url: urlString, WriterFactory: d.factoryFor(j), ProgressBarFactory: progress, FileName: fileName,
+		Compressed: d.HTTP.Compressed, KeepEncoding: d.HTTP.KeepEncoding}
+	if d.HTTPCache != nil {
+		t.Client = &http.Client{Transport: d.HTTPCache}
+	}
+	if d.Extract != nil && d.Extract.Stream {
+		t.WriterFactory = &extract.WriterFactory{Factory: t.WriterFactory, Dir: d.extractDir(d.Extract), Limits: d.Extract.Limits}
+	}
+	t.Resume = j.info.Resume
+	if t.Resume == nil && d.Recursive == nil && (d.HTTP.Continue || d.HTTP.Update) {
+		d.previousDownload(t, j, urlString, fileName)
+	}
+	t.OnCheckpoint = func(state task.ResumeState) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		j.info.Resume = &state
+		if j.info.FileName != state.FileName {
+			j.info.FileName = state.FileName
+			d.emit(j, EventFileName)
+		} else {
+			d.persist(j)
+		}
+	}
+	t.OnResponse = func(latency time.Duration) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.publish(Event{Type: EventResponse, Job: j.snapshot(), Latency: latency})
+	}
+	if d.Recursive != nil {
+		u, err := url.Parse(urlString)
+		if err != nil {
+			slog.Error("invalid url", "url", urlString, "err", err)
+			return nil
+		}
+		if depth == 0 && !d.Recursive.Seed(u) {
+			return nil
+		}
+		t.Pages = &crawlPages{downloader: d, ctx: ctx, job: j.info.ID, source: u, depth: depth}
+	}
+	return t
+}
+
+// addLink queues a link found at depth by a recursive download, parent is the ID of the job that found it
+func (d *Downloader) addLink(ctx context.Context, link string, depth int, parent string) {
+	j := d.newJob(ctx, link, JobOptions{}, parent)
+	d.mu.Lock()
+	t := d.newHTTPTask(ctx, j, link, depth, "", j.progress)
+	d.mu.Unlock()
+	if t != nil {
+		d.enqueue(j, t)
+	}
+}
+
+// newTorrentTask creates a TorrentDownloadTask for a magnet link or a .torrent URL
+func (d *Downloader) newTorrentTask(j *job, source string, progress reporter.ProgressBarFactory) task.Task {
+	return &task.TorrentDownloadTask{
+		Source:             source,
+		WriterFactory:      d.factoryFor(j),
+		ProgressBarFactory: progress,
+		ListenAddr:         d.Torrent.ListenAddr,
+		SeedRatio:          d.Torrent.SeedRatio,
+	}
+}
+
+// newStreamTask creates an HLSDownloadTask or a DASHDownloadTask depending on the extension of the manifest
+func (d *Downloader) newStreamTask(j *job, manifestURL string, ext string, progress reporter.ProgressBarFactory) task.Task {
+	if ext == ".mpd" {
+		return &task.DASHDownloadTask{Url: manifestURL, WriterFactory: d.factoryFor(j), ProgressBarFactory: progress, Options: d.Stream.StreamOptions}
+	}
+	return &task.HLSDownloadTask{Url: manifestURL, WriterFactory: d.factoryFor(j), ProgressBarFactory: progress, Options: d.Stream.StreamOptions}
+}
+
+// factoryFor returns the WriterFactory that the files of the job are created with
+func (d *Downloader) factoryFor(j *job) storage.WriterFactory {
+	if f, ok := d.writerFactory.(storage.JobWriterFactory); ok {
+		return f.ForJob(j.info.ID)
+	}
+	return d.writerFactory
+}
+
+// Wait blocks until all downloads are complete, failed or removed. Paused jobs are waited for as well
 func (d *Downloader) Wait() {
 	d.wg.Wait()
 }
+
+// Close closes the WriterFactory if it is an io.Closer, such as a bundle which is completed when closed. It must be
+// called once the downloads are done
+func (d *Downloader) Close() error {
+	if c, ok := d.writerFactory.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
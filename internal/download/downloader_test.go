@@ -0,0 +1,81 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+)
+
+func TestRetryStreamJob(t *testing.T) {
+	segments := []string{"first segment ", "second segment ", "third segment"}
+	// The fetcher tries a segment 4 times before the task fails, the job is then retried
+	var failures atomic.Int32
+	failures.Store(4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/video.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:10\n")
+			for i := range segments {
+				fmt.Fprintf(w, "#EXTINF:10,\nseg%d.ts\n", i)
+			}
+			fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+		case "/seg0.ts":
+			fmt.Fprint(w, segments[0])
+		case "/seg1.ts":
+			if failures.Add(-1) >= 0 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, segments[1])
+		case "/seg2.ts":
+			fmt.Fprint(w, segments[2])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(dir, false, reporter.NopProgressBarFactory{})
+	d.Retries = 1
+	d.Stream.FollowStreams = true
+	d.Stream.Concurrency = 1
+	id, err := d.Add(context.Background(), server.URL+"/video.m3u8", JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	info, err := d.Job(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != JobComplete || info.Retries != 1 {
+		t.Fatalf("job is %s after %d retries (%s), expected complete after 1", info.State, info.Retries, info.Error)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 1 || names[0] != "video.ts" {
+		t.Fatalf("saved %v, expected only video.ts", names)
+	}
+	data, err := os.ReadFile(dir + "/video.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(segments, ""); string(data) != want {
+		t.Errorf("saved %q, expected %q", data, want)
+	}
+}
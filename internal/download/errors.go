@@ -0,0 +1,97 @@
+package download
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/ananthvk/godown/internal/download/extract"
+	"github.com/ananthvk/godown/internal/download/httpcache"
+	"github.com/ananthvk/godown/internal/download/httperr"
+)
+
+// Error classes returned by ErrorClass
+const (
+	ErrorCanceled   = "canceled"
+	ErrorTimeout    = "timeout"
+	ErrorDNS        = "dns"
+	ErrorConnection = "connection"
+	ErrorTLS        = "tls"
+	ErrorHTTP4xx    = "http_4xx"
+	ErrorHTTP5xx    = "http_5xx"
+	ErrorStorage    = "storage"
+	ErrorArchive    = "archive"
+	ErrorDigest     = "digest"
+	ErrorOffline    = "offline"
+	ErrorOther      = "other"
+)
+
+// ErrorClass groups the error of a failed download into a small set of classes, so that tools can decide what
+// to do about it without parsing the message. An empty string is returned for a nil error
+func ErrorClass(err error) string {
+	var archiveErr *extract.Error
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var pathErr *fs.PathError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.As(err, &archiveErr):
+		return ErrorArchive
+	case errors.Is(err, ErrDigestMismatch):
+		return ErrorDigest
+	case errors.Is(err, httpcache.ErrNotCached):
+		return ErrorOffline
+	case statusCode(err) >= 500:
+		return ErrorHTTP5xx
+	case statusCode(err) > 0:
+		return ErrorHTTP4xx
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr):
+		return ErrorTLS
+	case errors.As(err, &pathErr), errors.Is(err, syscall.ENOSPC):
+		return ErrorStorage
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return ErrorConnection
+	default:
+		return ErrorOther
+	}
+}
+
+// statusCode returns the unexpected HTTP status that err reports, or 0
+func statusCode(err error) int {
+	var statusErr *httperr.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+// temporary reports whether a download that failed with err may succeed when tried again
+func temporary(err error) bool {
+	if code := statusCode(err); code > 0 {
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	switch ErrorClass(err) {
+	case ErrorTimeout, ErrorDNS, ErrorConnection:
+		return true
+	}
+	return false
+}
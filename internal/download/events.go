@@ -0,0 +1,171 @@
+package download
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxEvents is the number of events kept for clients polling with WaitEvents
+const maxEvents = 1024
+
+// EventType tells what happened to the job of an Event
+type EventType string
+
+const (
+	EventQueued    EventType = "queued"
+	EventStarted   EventType = "started"
+	EventFileName  EventType = "filename"
+	EventResponse  EventType = "response"
+	EventPaused    EventType = "paused"
+	EventResumed   EventType = "resumed"
+	EventPriority  EventType = "priority"
+	EventRetry     EventType = "retry"
+	EventCompleted EventType = "completed"
+	EventFailed    EventType = "failed"
+	EventRemoved   EventType = "removed"
+	EventHook      EventType = "hook"
+)
+
+// Event is emitted whenever a job is added, changes state or settings, or its file name is resolved, when
+// the server responds to a request of an HTTP(S) download, with the time it took in Latency, and when the hooks
+// run for a stopped job are done, see ReportHook.
+// Seq increases by one for every event
+type Event struct {
+	Seq     uint64        `json:"seq"`
+	Time    time.Time     `json:"time"`
+	Type    EventType     `json:"type"`
+	Job     JobInfo       `json:"job"`
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+// eventLog keeps the most recent events, it is protected by the Downloader's mutex
+type eventLog struct {
+	events      []Event
+	seq         uint64
+	notify      chan struct{}
+	subscribers []*subscriber
+}
+
+// emit records an event for j and wakes up the waiting clients, the Downloader's mutex must be held
+func (d *Downloader) emit(j *job, typ EventType) {
+	d.persist(j)
+	d.publish(Event{Type: typ, Job: j.snapshot()})
+}
+
+// publish numbers the event and delivers it, the Downloader's mutex must be held
+func (d *Downloader) publish(event Event) {
+	l := &d.events
+	l.seq++
+	event.Seq = l.seq
+	event.Time = time.Now()
+	l.events = append(l.events, event)
+	if len(l.events) > maxEvents {
+		l.events = append(l.events[:0], l.events[len(l.events)-maxEvents:]...)
+	}
+	if l.notify != nil {
+		close(l.notify)
+		l.notify = nil
+	}
+	for _, s := range l.subscribers {
+		s.push(event)
+	}
+}
+
+// subscriber delivers events to a function on its own goroutine. Events are queued without a limit so that
+// emitting never blocks and no event is lost
+type subscriber struct {
+	fn     func(Event)
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []Event
+	closed bool
+	done   chan struct{}
+}
+
+// Subscribe calls fn with every event emitted from now on, in order, on a separate goroutine so that a slow
+// subscriber does not hold up the downloads. Unlike WaitEvents, no event is dropped. The returned function
+// ends the subscription once the events emitted before it was called have been delivered
+func (d *Downloader) Subscribe(fn func(Event)) func() {
+	s := &subscriber{fn: fn, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	d.mu.Lock()
+	d.events.subscribers = append(d.events.subscribers, s)
+	d.mu.Unlock()
+	go s.run()
+	return func() {
+		d.mu.Lock()
+		d.events.subscribers = slices.DeleteFunc(d.events.subscribers, func(o *subscriber) bool { return o == s })
+		d.mu.Unlock()
+		s.mu.Lock()
+		s.closed = true
+		s.cond.Signal()
+		s.mu.Unlock()
+		<-s.done
+	}
+}
+
+func (s *subscriber) push(event Event) {
+	s.mu.Lock()
+	s.queue = append(s.queue, event)
+	s.cond.Signal()
+	s.mu.Unlock()
+}
+
+func (s *subscriber) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		queue := s.queue
+		s.queue = nil
+		closed := s.closed
+		s.mu.Unlock()
+		for _, event := range queue {
+			s.fn(event)
+		}
+		if closed && len(queue) == 0 {
+			return
+		}
+	}
+}
+
+// WaitEvents returns the events after since. If there are none, it blocks until an event is emitted or ctx is done.
+// Events older than the last 1024 are dropped, clients that fall behind should list the jobs again
+func (d *Downloader) WaitEvents(ctx context.Context, since uint64) []Event {
+	for {
+		d.mu.Lock()
+		l := &d.events
+		var events []Event
+		for _, e := range l.events {
+			if e.Seq > since {
+				events = append(events, e)
+			}
+		}
+		if len(events) > 0 {
+			d.mu.Unlock()
+			return events
+		}
+		if l.notify == nil {
+			l.notify = make(chan struct{})
+		}
+		notify := l.notify
+		d.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// LastEvent returns the sequence number of the most recent event
+func (d *Downloader) LastEvent() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.events.seq
+}
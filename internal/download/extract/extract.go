@@ -0,0 +1,455 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ErrUnsafePath is returned for entries that would be written outside of the target directory
+var ErrUnsafePath = errors.New("unsafe path")
+
+// Error is returned when an archive cannot be extracted
+type Error struct {
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return "extracting " + e.Name + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Limits guard against archive bombs, a zero field means no limit
+type Limits struct {
+	// MaxSize is the total size of the extracted files
+	MaxSize int64
+	// MaxEntries is the number of entries in the archive, including directories and links
+	MaxEntries int
+}
+
+type kind int
+
+const (
+	kindTar kind = iota
+	kindZip
+	// kindFile is a single compressed file
+	kindFile
+)
+
+type format struct {
+	suffix     string
+	kind       kind
+	decompress func(io.Reader) (io.ReadCloser, error)
+}
+
+// formats are matched in order, so that .tar.gz is found before .gz
+var formats = []format{
+	{".tar.gz", kindTar, gunzip},
+	{".tgz", kindTar, gunzip},
+	{".tar.bz2", kindTar, bunzip2},
+	{".tbz2", kindTar, bunzip2},
+	{".tar.xz", kindTar, unxz},
+	{".txz", kindTar, unxz},
+	{".tar.zst", kindTar, unzstd},
+	{".tzst", kindTar, unzstd},
+	{".tar", kindTar, nil},
+	{".zip", kindZip, nil},
+	{".gz", kindFile, gunzip},
+	{".bz2", kindFile, bunzip2},
+	{".xz", kindFile, unxz},
+	{".zst", kindFile, unzstd},
+}
+
+func detect(name string) (format, bool) {
+	lower := strings.ToLower(name)
+	for _, f := range formats {
+		if strings.HasSuffix(lower, f.suffix) && len(lower) > len(f.suffix) {
+			return f, true
+		}
+	}
+	return format{}, false
+}
+
+// Supported reports whether the file name is that of an archive or a compressed file that can be extracted
+func Supported(name string) bool {
+	_, ok := detect(name)
+	return ok
+}
+
+// Streamable reports whether the file name can be extracted while it is read, which is the case for everything
+// but zip archives
+func Streamable(name string) bool {
+	f, ok := detect(name)
+	return ok && f.kind != kindZip
+}
+
+func gunzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func bunzip2(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func unxz(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
+
+func unzstd(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// File extracts the archive at path into dir, which is created if needed. A compressed file that is not an
+// archive is decompressed into dir under its name without the extension
+func File(path, dir string, limits Limits) error {
+	name := filepath.Base(path)
+	f, ok := detect(name)
+	if !ok {
+		return &Error{Name: name, Err: errors.New("unsupported format")}
+	}
+	if f.kind == kindZip {
+		if err := extractZip(path, dir, limits); err != nil {
+			return &Error{Name: name, Err: err}
+		}
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return &Error{Name: name, Err: err}
+	}
+	defer file.Close()
+	return Stream(file, name, dir, limits)
+}
+
+// Stream extracts the archive called name read from r into dir, like File. Zip archives cannot be streamed
+func Stream(r io.Reader, name, dir string, limits Limits) error {
+	if err := stream(r, name, dir, limits); err != nil {
+		return &Error{Name: name, Err: err}
+	}
+	return nil
+}
+
+func stream(r io.Reader, name, dir string, limits Limits) error {
+	f, ok := detect(name)
+	if !ok || f.kind == kindZip {
+		return errors.New("cannot be extracted as a stream")
+	}
+	if f.decompress != nil {
+		dr, err := f.decompress(r)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		r = dr
+	}
+	x, err := newExtractor(dir, limits)
+	if err != nil {
+		return err
+	}
+	defer x.root.Close()
+	if f.kind == kindFile {
+		// The suffix matched case insensitively, so it has the same length in name
+		rel, err := x.entry(name[:len(name)-len(f.suffix)])
+		if err != nil {
+			return err
+		}
+		return x.writeFile(rel, r, 0644)
+	}
+	if err := x.tar(r); err != nil {
+		return err
+	}
+	return x.symlinks()
+}
+
+func extractZip(path, dir string, limits Limits) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	x, err := newExtractor(dir, limits)
+	if err != nil {
+		return err
+	}
+	defer x.root.Close()
+	for _, f := range zr.File {
+		rel, err := x.entry(f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdirAll(rel)
+		case mode&fs.ModeSymlink != 0:
+			var target string
+			target, err = readZipLink(f)
+			x.links = append(x.links, link{name: rel, target: target})
+		case mode.IsRegular():
+			if f.UncompressedSize64 > 1<<62 {
+				return x.sizeError()
+			}
+			if err := x.reserve(int64(f.UncompressedSize64)); err != nil {
+				return err
+			}
+			err = x.zipFile(rel, f)
+		default:
+			slog.Info("skipping archive entry", "name", f.Name, "mode", mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return x.symlinks()
+}
+
+func (x *extractor) zipFile(rel string, f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return x.writeFile(rel, r, f.Mode())
+}
+
+// readZipLink returns the target of a symlink stored in a zip archive, which is the content of the entry
+func readZipLink(f *zip.File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	target, err := io.ReadAll(io.LimitReader(r, 4096))
+	return string(target), err
+}
+
+// extractor writes the entries of an archive inside a directory. Symlinks are created last, once the directories
+// they point through exist, so that no entry is written through one of them
+type extractor struct {
+	dir     string
+	root    *os.Root
+	limits  Limits
+	size    int64
+	entries int
+	links   []link
+}
+
+type link struct {
+	name, target string
+}
+
+func newExtractor(dir string, limits Limits) (*extractor, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{dir: dir, root: root, limits: limits}, nil
+}
+
+// entry counts an entry and returns its path relative to the directory, absolute paths and paths leaving it
+// are rejected
+func (x *extractor) entry(name string) (string, error) {
+	x.entries++
+	if x.limits.MaxEntries > 0 && x.entries > x.limits.MaxEntries {
+		return "", fmt.Errorf("archive has more than %d entries", x.limits.MaxEntries)
+	}
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if strings.HasPrefix(name, "/") || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w %q", ErrUnsafePath, name)
+	}
+	return rel, nil
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := x.entry(hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdirAll(rel)
+		case tar.TypeReg:
+			if err := x.reserve(hdr.Size); err != nil {
+				return err
+			}
+			err = x.writeFile(rel, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			x.links = append(x.links, link{name: rel, target: hdr.Linkname})
+		case tar.TypeLink:
+			err = x.hardLink(rel, hdr.Linkname, hdr.FileInfo().Mode())
+		default:
+			slog.Info("skipping archive entry", "name", hdr.Name, "type", string(hdr.Typeflag))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// mkdirAll creates the directory rel and its parents
+func (x *extractor) mkdirAll(rel string) error {
+	if rel == "." {
+		return nil
+	}
+	var dir string
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		if err := x.root.Mkdir(dir, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile saves r as the file rel, replacing it if it exists
+func (x *extractor) writeFile(rel string, r io.Reader, mode fs.FileMode) error {
+	if err := x.mkdirAll(filepath.Dir(rel)); err != nil {
+		return err
+	}
+	f, err := x.root.OpenFile(rel, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	err = x.copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// hardLink saves a copy of the entry target as rel
+func (x *extractor) hardLink(rel, target string, mode fs.FileMode) error {
+	source := filepath.Clean(filepath.FromSlash(target))
+	if strings.HasPrefix(target, "/") || !filepath.IsLocal(source) || source == rel {
+		return fmt.Errorf("%w: %q links to %q", ErrUnsafePath, rel, target)
+	}
+	f, err := x.root.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return x.writeFile(rel, f, mode)
+}
+
+// copy copies r to w, failing once the extracted files are larger than the limit
+func (x *extractor) copy(w io.Writer, r io.Reader) error {
+	if x.limits.MaxSize <= 0 {
+		n, err := io.Copy(w, r)
+		x.size += n
+		return err
+	}
+	n, err := io.Copy(w, io.LimitReader(r, x.limits.MaxSize-x.size+1))
+	x.size += n
+	if x.size > x.limits.MaxSize {
+		return x.sizeError()
+	}
+	return err
+}
+
+// reserve fails early if an entry of the given size would not fit within the limit
+func (x *extractor) reserve(size int64) error {
+	if x.limits.MaxSize > 0 && size > x.limits.MaxSize-x.size {
+		return x.sizeError()
+	}
+	return nil
+}
+
+func (x *extractor) sizeError() error {
+	return fmt.Errorf("archive is larger than %d bytes once extracted", x.limits.MaxSize)
+}
+
+// symlinks creates the symlinks of the archive
+func (x *extractor) symlinks() error {
+	for _, l := range x.links {
+		if err := x.mkdirAll(filepath.Dir(l.name)); err != nil {
+			return err
+		}
+		if err := x.checkLink(l.name, l.target); err != nil {
+			return err
+		}
+		// Replace the link or file left by an earlier extraction, like tar does
+		if fi, err := x.root.Lstat(l.name); err == nil && !fi.IsDir() {
+			if err := x.root.Remove(l.name); err != nil {
+				return err
+			}
+		}
+		if err := os.Symlink(l.target, filepath.Join(x.dir, l.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLink rejects symlinks that are absolute or point outside of the directory. The parents of the link must be
+// directories and the target is resolved through directories only: ".." after a symlink or a missing directory
+// is rejected as it could lead anywhere
+func (x *extractor) checkLink(name, target string) error {
+	unsafe := fmt.Errorf("%w: %q links to %q", ErrUnsafePath, name, target)
+	if target == "" || strings.HasPrefix(target, "/") || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return unsafe
+	}
+	var dirs []string
+	if parent := filepath.Dir(name); parent != "." {
+		dirs = strings.Split(parent, string(filepath.Separator))
+	}
+	for i := range dirs {
+		if !x.isDir(filepath.Join(dirs[:i+1]...)) {
+			return unsafe
+		}
+	}
+	for _, element := range strings.Split(filepath.ToSlash(target), "/") {
+		switch element {
+		case "", ".":
+		case "..":
+			if len(dirs) == 0 || !x.isDir(filepath.Join(dirs...)) {
+				return unsafe
+			}
+			dirs = dirs[:len(dirs)-1]
+		default:
+			dirs = append(dirs, element)
+		}
+	}
+	return nil
+}
+
+// isDir reports whether rel is a directory and not a symlink
+func (x *extractor) isDir(rel string) bool {
+	fi, err := x.root.Lstat(rel)
+	return err == nil && fi.IsDir()
+}
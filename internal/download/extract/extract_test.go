@@ -0,0 +1,291 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is a file, directory, symlink or hardlink of a test archive
+type entry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+}
+
+func file(name, body string) entry { return entry{name: name, body: body, typeflag: tar.TypeReg} }
+
+func symlink(name, target string) entry {
+	return entry{name: name, typeflag: tar.TypeSymlink, linkname: target}
+}
+
+func makeTar(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeZip saves a zip archive of entries in a temporary directory and returns its path. Hardlinks cannot be stored
+// in zip archives
+func makeZip(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		fh := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch e.typeflag {
+		case tar.TypeDir:
+			fh.SetMode(fs.ModeDir | 0755)
+		case tar.TypeSymlink:
+			fh.SetMode(fs.ModeSymlink | 0777)
+			body = e.linkname
+		default:
+			fh.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// extractors extract the same entries as a tar and as a zip archive
+var extractors = []struct {
+	name    string
+	extract func(t *testing.T, entries []entry, dir string, limits Limits) error
+}{
+	{"tar", func(t *testing.T, entries []entry, dir string, limits Limits) error {
+		return Stream(bytes.NewReader(makeTar(t, entries)), "test.tar", dir, limits)
+	}},
+	{"zip", func(t *testing.T, entries []entry, dir string, limits Limits) error {
+		return File(makeZip(t, entries), dir, limits)
+	}},
+}
+
+// workspace returns the directory to extract into along with a sibling directory that must stay empty
+func workspace(t *testing.T) (dir, outside string) {
+	t.Helper()
+	parent := t.TempDir()
+	dir, outside = filepath.Join(parent, "a", "out"), filepath.Join(parent, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir, outside
+}
+
+func checkEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("%s holds %d entries written from outside of the archive directory", dir, len(entries))
+	}
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(outside string) []entry
+	}{
+		{"parent path", func(string) []entry { return []entry{file("../../outside/x", "evil")} }},
+		{"nested parent path", func(string) []entry { return []entry{file("dir/../../../outside/x", "evil")} }},
+		{"absolute path", func(outside string) []entry { return []entry{file(filepath.Join(outside, "x"), "evil")} }},
+		{"absolute symlink followed by a write", func(outside string) []entry {
+			return []entry{symlink("link", outside), file("link/x", "evil")}
+		}},
+		{"symlink escaping through parents", func(string) []entry {
+			return []entry{{name: "dir/", typeflag: tar.TypeDir}, symlink("dir/link", "../../../outside")}
+		}},
+		{"symlink escaping after a directory", func(string) []entry {
+			return []entry{{name: "dir/", typeflag: tar.TypeDir}, symlink("link", "dir/../../../outside")}
+		}},
+		{"symlink escaping through a symlink", func(string) []entry {
+			return []entry{{name: "dir/", typeflag: tar.TypeDir}, symlink("self", "dir"), symlink("link", "self/../../outside")}
+		}},
+		{"symlink inside a symlink", func(string) []entry {
+			return []entry{{name: "dir/", typeflag: tar.TypeDir}, symlink("up", "dir"), symlink("up/link", "..")}
+		}},
+	}
+	for _, x := range extractors {
+		for _, tt := range tests {
+			t.Run(x.name+"/"+tt.name, func(t *testing.T) {
+				dir, outside := workspace(t)
+				err := x.extract(t, tt.entries(outside), dir, Limits{})
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("error %v, expected %v", err, ErrUnsafePath)
+				}
+				checkEmpty(t, outside)
+			})
+		}
+	}
+}
+
+// TestExtractThroughExistingSymlink checks that entries are not written through a symlink left in the directory
+func TestExtractThroughExistingSymlink(t *testing.T) {
+	for _, x := range extractors {
+		t.Run(x.name, func(t *testing.T) {
+			dir, outside := workspace(t)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+				t.Fatal(err)
+			}
+			if err := x.extract(t, []entry{file("link/x", "evil")}, dir, Limits{}); err == nil {
+				t.Error("entry was written through a symlink")
+			}
+			checkEmpty(t, outside)
+		})
+	}
+}
+
+func TestExtractHardLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		target func(outside string) string
+		unsafe bool
+	}{
+		{"inside", func(string) string { return "data" }, false},
+		{"parent", func(string) string { return "../../outside/secret" }, true},
+		{"absolute", func(outside string) string { return filepath.Join(outside, "secret") }, true},
+		{"itself", func(string) string { return "hard" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, outside := workspace(t)
+			secret := filepath.Join(outside, "secret")
+			if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			entries := []entry{file("data", "content"), {name: "hard", typeflag: tar.TypeLink, linkname: tt.target(outside)}}
+			err := Stream(bytes.NewReader(makeTar(t, entries)), "test.tar", dir, Limits{})
+			if tt.unsafe {
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("error %v, expected %v", err, ErrUnsafePath)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "hard")); err == nil {
+					t.Error("hardlink was extracted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "hard"))
+			if err != nil || string(data) != "content" {
+				t.Errorf("hardlink holds %q (%v), expected a copy of data", data, err)
+			}
+		})
+	}
+}
+
+func TestExtractSafeSymlinks(t *testing.T) {
+	entries := []entry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		file("dir/data", "content"),
+		symlink("dir/sibling", "data"),
+		symlink("dir/up", "../dir/data"),
+		symlink("top", "dir/data"),
+	}
+	for _, x := range extractors {
+		t.Run(x.name, func(t *testing.T) {
+			dir, _ := workspace(t)
+			if err := x.extract(t, entries, dir, Limits{}); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"dir/sibling", "dir/up", "top"} {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(data) != "content" {
+					t.Errorf("%s holds %q (%v), expected content", name, data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		limits  Limits
+		ok      bool
+	}{
+		{"within size", []entry{file("a", "12345"), file("b", "12345")}, Limits{MaxSize: 10}, true},
+		{"over size", []entry{file("a", "12345"), file("b", "123456")}, Limits{MaxSize: 10}, false},
+		{"within entries", []entry{{name: "dir/", typeflag: tar.TypeDir}, file("dir/a", "1")}, Limits{MaxEntries: 2}, true},
+		{"over entries", []entry{file("a", "1"), file("b", "2"), file("c", "3")}, Limits{MaxEntries: 2}, false},
+		{"links count as entries", []entry{file("a", "1"), symlink("b", "a"), symlink("c", "a")}, Limits{MaxEntries: 2}, false},
+	}
+	for _, x := range extractors {
+		for _, tt := range tests {
+			t.Run(x.name+"/"+tt.name, func(t *testing.T) {
+				dir, _ := workspace(t)
+				err := x.extract(t, tt.entries, dir, tt.limits)
+				if tt.ok && err != nil {
+					t.Error(err)
+				}
+				if !tt.ok && err == nil {
+					t.Error("archive over the limits was extracted")
+				}
+			})
+		}
+	}
+}
+
+// TestExtractCompressedFileLimit checks that a compressed file is cut at the limit, its size is only known once
+// it is decompressed
+func TestExtractCompressedFileLimit(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(strings.Repeat("0", 1<<20)))
+	zw.Close()
+	dir, _ := workspace(t)
+	if err := Stream(bytes.NewReader(buf.Bytes()), "bomb.gz", dir, Limits{MaxSize: 1000}); err == nil {
+		t.Fatal("compressed file over the limit was extracted")
+	}
+	fi, err := os.Stat(filepath.Join(dir, "bomb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 1001 {
+		t.Errorf("%d bytes were written, expected at most the limit", fi.Size())
+	}
+}
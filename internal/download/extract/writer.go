@@ -0,0 +1,79 @@
+package extract
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// errResume is returned when asked to resume an archive that is extracted while it is downloaded
+var errResume = errors.New("extracted archives cannot be resumed")
+
+// WriterFactory extracts the archives written to it into Dir instead of saving them. Other files, and zip archives
+// which cannot be extracted from a stream, are saved with Factory
+type WriterFactory struct {
+	Factory storage.WriterFactory
+	Dir     string
+	Limits  Limits
+}
+
+// CreateStream returns a stream that extracts the archive as it is written, Close returns the result of the
+// extraction. Extracted files are left in place if it fails
+func (f *WriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	if !Streamable(fileName) {
+		return f.Factory.CreateStream(fileName)
+	}
+	pr, pw := io.Pipe()
+	w := &streamWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := Stream(pr, fileName, f.Dir, f.Limits)
+		if err == nil {
+			// Consume what follows the end of the archive, such as the padding of tar archives
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return fileName, w, nil
+}
+
+// StreamSize returns the size of a file saved by Factory if it can be resumed
+func (f *WriterFactory) StreamSize(fileName string) (int64, error) {
+	rf, ok := f.Factory.(storage.ResumableWriterFactory)
+	if !ok || Streamable(fileName) {
+		return 0, errResume
+	}
+	return rf.StreamSize(fileName)
+}
+
+// ResumeStream resumes a file saved by Factory if it can be resumed
+func (f *WriterFactory) ResumeStream(fileName string, offset int64) (io.WriteCloser, error) {
+	rf, ok := f.Factory.(storage.ResumableWriterFactory)
+	if !ok || Streamable(fileName) {
+		return nil, errResume
+	}
+	return rf.ResumeStream(fileName, offset)
+}
+
+// streamWriter feeds an extraction running in another goroutine, writes fail once the extraction has failed
+type streamWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+	once sync.Once
+	err  error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the archive and waits for the extraction, it may be called more than once
+func (w *streamWriter) Close() error {
+	w.once.Do(func() {
+		w.pw.Close()
+		w.err = <-w.done
+	})
+	return w.err
+}
@@ -0,0 +1,256 @@
+package glob
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// part is either a literal piece of the URL or a glob that produces a sequence of values
+type part struct {
+	literal string
+	values  valueSet
+}
+
+// valueSet is a lazily evaluated list of values produced by a glob
+type valueSet interface {
+	len() uint64
+	at(i uint64) string
+}
+
+// braceSet is a {a,b,c} glob
+type braceSet []string
+
+func (b braceSet) len() uint64        { return uint64(len(b)) }
+func (b braceSet) at(i uint64) string { return b[i] }
+
+// numericRange is a [start-end:step] glob, values are zero padded to width
+type numericRange struct {
+	start, end, step uint64
+	width            int
+}
+
+func (n numericRange) len() uint64 { return (n.end-n.start)/n.step + 1 }
+func (n numericRange) at(i uint64) string {
+	return fmt.Sprintf("%0*d", n.width, n.start+i*n.step)
+}
+
+// alphaRange is a [a-z:step] glob
+type alphaRange struct {
+	start, end byte
+	step       uint64
+}
+
+func (a alphaRange) len() uint64        { return uint64(a.end-a.start)/a.step + 1 }
+func (a alphaRange) at(i uint64) string { return string(rune(a.start + byte(i*a.step))) }
+
+// Pattern is a URL containing curl style globs: numeric ranges such as [001-100] or [0-100:10],
+// alphabetic ranges such as [a-z] and sets such as {one,two,three}.
+// A backslash escapes a glob character so that it is used literally
+type Pattern struct {
+	parts []part
+	globs int
+}
+
+// Parse parses a URL pattern. Brackets around an IPv6 host (http://[::1]/) are kept as they are
+func Parse(s string) (*Pattern, error) {
+	p := &Pattern{}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			p.parts = append(p.parts, part{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte("[]{}\\", s[i+1]) >= 0 {
+				i++
+			}
+			literal.WriteByte(s[i])
+		case '[', '{':
+			closing := byte(']')
+			if c == '{' {
+				closing = '}'
+			}
+			end := strings.IndexByte(s[i+1:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched %q at position %d", c, i)
+			}
+			body := s[i+1 : i+1+end]
+			if c == '[' && isIPv6Host(s, i, body) {
+				literal.WriteString(s[i : i+end+2])
+				i += end + 1
+				continue
+			}
+			var values valueSet
+			var err error
+			if c == '{' {
+				values = braceSet(strings.Split(body, ","))
+			} else {
+				values, err = parseRange(body)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob at position %d: %w", i, err)
+			}
+			flush()
+			p.parts = append(p.parts, part{values: values})
+			p.globs++
+			i += end + 1
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return p, nil
+}
+
+// isIPv6Host reports whether the bracket at position i encloses the host of the URL, an IPv6 address with an
+// optional zone, rather than a range such as [1-9:2]
+func isIPv6Host(s string, i int, body string) bool {
+	addr, _, _ := strings.Cut(body, "%")
+	return strings.HasSuffix(s[:i], "://") && strings.Contains(addr, ":") && net.ParseIP(addr) != nil
+}
+
+// parseRange parses the inside of a [start-end:step] glob
+func parseRange(body string) (valueSet, error) {
+	bounds, stepStr, hasStep := strings.Cut(body, ":")
+	step := uint64(1)
+	if hasStep {
+		var err error
+		step, err = strconv.ParseUint(stepStr, 10, 64)
+		if err != nil || step == 0 {
+			return nil, fmt.Errorf("invalid step %q", stepStr)
+		}
+	}
+	startStr, endStr, ok := strings.Cut(bounds, "-")
+	if !ok || startStr == "" || endStr == "" {
+		return nil, fmt.Errorf("invalid range %q", body)
+	}
+
+	if len(startStr) == 1 && len(endStr) == 1 && isLetter(startStr[0]) && isLetter(endStr[0]) {
+		start, end := startStr[0], endStr[0]
+		if (start >= 'a') != (end >= 'a') || start > end {
+			return nil, fmt.Errorf("invalid range %q", body)
+		}
+		return alphaRange{start: start, end: end, step: step}, nil
+	}
+
+	start, err1 := strconv.ParseUint(startStr, 10, 64)
+	end, err2 := strconv.ParseUint(endStr, 10, 64)
+	if err1 != nil || err2 != nil || start > end {
+		return nil, fmt.Errorf("invalid range %q", body)
+	}
+	if (end-start)/step == math.MaxUint64 {
+		// The number of values, one more than that, does not fit in an uint64
+		return nil, fmt.Errorf("range %q has too many values", body)
+	}
+	width := 0
+	if len(startStr) > 1 && startStr[0] == '0' {
+		// A leading zero requests zero padding to the width of the start value
+		width = len(startStr)
+	}
+	return numericRange{start: start, end: end, step: step, width: width}, nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// HasGlobs reports whether the pattern contains any glob
+func (p *Pattern) HasGlobs() bool {
+	return p.globs > 0
+}
+
+// Count returns the number of URLs the pattern expands to, or an error if the number does not fit in an uint64
+func (p *Pattern) Count() (uint64, error) {
+	total := uint64(1)
+	for _, pt := range p.parts {
+		if pt.values == nil {
+			continue
+		}
+		n := pt.values.len()
+		if n != 0 && total > ^uint64(0)/n {
+			return 0, errors.New("pattern expands to too many urls")
+		}
+		total *= n
+	}
+	return total, nil
+}
+
+// All returns an iterator over every URL of the pattern along with the values matched by each glob.
+// URLs are produced one at a time, with the rightmost glob changing fastest
+func (p *Pattern) All() iter.Seq2[string, []string] {
+	return func(yield func(string, []string) bool) {
+		var globs []valueSet
+		for _, pt := range p.parts {
+			if pt.values != nil {
+				if pt.values.len() == 0 {
+					return
+				}
+				globs = append(globs, pt.values)
+			}
+		}
+		indices := make([]uint64, len(globs))
+		for {
+			matches := make([]string, len(globs))
+			var b strings.Builder
+			g := 0
+			for _, pt := range p.parts {
+				if pt.values == nil {
+					b.WriteString(pt.literal)
+					continue
+				}
+				matches[g] = globs[g].at(indices[g])
+				b.WriteString(matches[g])
+				g++
+			}
+			if !yield(b.String(), matches) {
+				return
+			}
+			// Advance the indices like an odometer
+			g = len(globs) - 1
+			for ; g >= 0; g-- {
+				indices[g]++
+				if indices[g] < globs[g].len() {
+					break
+				}
+				indices[g] = 0
+			}
+			if g < 0 {
+				return
+			}
+		}
+	}
+}
+
+// ExpandTemplate replaces #1, #2, ... in template with the values matched by the corresponding glob.
+// References to globs that do not exist are left unchanged
+func ExpandTemplate(template string, matches []string) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '#' {
+			b.WriteByte(template[i])
+			continue
+		}
+		j := i + 1
+		for j < len(template) && template[j] >= '0' && template[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(template[i+1 : j])
+		if err != nil || n < 1 || n > len(matches) {
+			b.WriteByte('#')
+			continue
+		}
+		b.WriteString(matches[n-1])
+		i = j - 1
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package glob
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		globs   int
+		pattern string
+		urls    []string
+	}{
+		{"no glob", 0, "http://example.com/file.txt", []string{"http://example.com/file.txt"}},
+		{"range", 1, "http://example.com/[1-3].txt",
+			[]string{"http://example.com/1.txt", "http://example.com/2.txt", "http://example.com/3.txt"}},
+		{"single value range", 1, "http://example.com/[7-7]", []string{"http://example.com/7"}},
+		{"zero padding", 1, "http://example.com/[08-11]",
+			[]string{"http://example.com/08", "http://example.com/09", "http://example.com/10", "http://example.com/11"}},
+		{"zero padding wider than end", 1, "http://example.com/[001-3]",
+			[]string{"http://example.com/001", "http://example.com/002", "http://example.com/003"}},
+		{"step", 1, "http://example.com/[0-10:5]",
+			[]string{"http://example.com/0", "http://example.com/5", "http://example.com/10"}},
+		{"step past end", 1, "http://example.com/[1-8:3]",
+			[]string{"http://example.com/1", "http://example.com/4", "http://example.com/7"}},
+		{"largest values", 1, "http://example.com/[18446744073709551614-18446744073709551615]",
+			[]string{"http://example.com/18446744073709551614", "http://example.com/18446744073709551615"}},
+		{"letters", 1, "http://example.com/[a-c]",
+			[]string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}},
+		{"upper case letters with step", 1, "http://example.com/[A-E:2]",
+			[]string{"http://example.com/A", "http://example.com/C", "http://example.com/E"}},
+		{"set", 1, "http://{one,two}.example.com/",
+			[]string{"http://one.example.com/", "http://two.example.com/"}},
+		{"set with empty value", 1, "http://example.com/file{,.bak}",
+			[]string{"http://example.com/file", "http://example.com/file.bak"}},
+		{"several globs", 2, "http://example.com/{a,b}/[1-2]",
+			[]string{"http://example.com/a/1", "http://example.com/a/2", "http://example.com/b/1", "http://example.com/b/2"}},
+		{"escaped brackets", 0, `http://example.com/\[1-2\]`, []string{"http://example.com/[1-2]"}},
+		{"escaped braces", 1, `http://example.com/\{a,b\}/[1-2]`,
+			[]string{"http://example.com/{a,b}/1", "http://example.com/{a,b}/2"}},
+		{"escaped backslash", 1, `http://example.com/\\[1-2]`,
+			[]string{`http://example.com/\1`, `http://example.com/\2`}},
+		{"backslash before other character", 0, `http://example.com/a\b`, []string{`http://example.com/a\b`}},
+		{"trailing backslash", 0, `http://example.com/a\`, []string{`http://example.com/a\`}},
+		{"ipv6 host", 0, "http://[::1]/file", []string{"http://[::1]/file"}},
+		{"ipv6 host with port and glob", 1, "http://[::1]:8080/[1-2]",
+			[]string{"http://[::1]:8080/1", "http://[::1]:8080/2"}},
+		{"ipv6 host with zone", 1, "http://[fe80::1%25eth0]/[a-b]",
+			[]string{"http://[fe80::1%25eth0]/a", "http://[fe80::1%25eth0]/b"}},
+		{"ipv4 mapped ipv6 host", 0, "https://[::ffff:192.0.2.1]/", []string{"https://[::ffff:192.0.2.1]/"}},
+		{"range in host", 1, "http://[1-2].example.com/",
+			[]string{"http://1.example.com/", "http://2.example.com/"}},
+		{"range with step in host", 1, "http://[1-5:2].example.com/",
+			[]string{"http://1.example.com/", "http://3.example.com/", "http://5.example.com/"}},
+		{"ipv6 address in path", 0, "http://example.com/[::1]", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.pattern)
+			if tt.urls == nil {
+				if err == nil {
+					t.Fatalf("parsed %q, expected an error", tt.pattern)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var urls []string
+			for url := range p.All() {
+				urls = append(urls, url)
+			}
+			if !slices.Equal(urls, tt.urls) {
+				t.Errorf("%q expands to %q, expected %q", tt.pattern, urls, tt.urls)
+			}
+			count, err := p.Count()
+			if err != nil || count != uint64(len(tt.urls)) {
+				t.Errorf("count %d (%v), expected %d", count, err, len(tt.urls))
+			}
+			if p.globs != tt.globs || p.HasGlobs() != (tt.globs > 0) {
+				t.Errorf("%d globs, expected %d", p.globs, tt.globs)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{"unmatched bracket", "http://example.com/[1-2"},
+		{"unmatched brace", "http://example.com/{a,b"},
+		{"missing end", "http://example.com/[1-]"},
+		{"missing start", "http://example.com/[-2]"},
+		{"no dash", "http://example.com/[12]"},
+		{"reversed range", "http://example.com/[3-1]"},
+		{"reversed letters", "http://example.com/[c-a]"},
+		{"mixed case letters", "http://example.com/[a-Z]"},
+		{"letter and number", "http://example.com/[a-9]"},
+		{"zero step", "http://example.com/[1-9:0]"},
+		{"invalid step", "http://example.com/[1-9:x]"},
+		{"negative value", "http://example.com/[-1-5]"},
+		{"value out of range", "http://example.com/[0-18446744073709551616]"},
+		{"whole uint64 domain", "http://example.com/[0-18446744073709551615]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.pattern); err == nil {
+				t.Errorf("parsed %q, expected an error", tt.pattern)
+			}
+		})
+	}
+}
+
+func TestCountOverflow(t *testing.T) {
+	p, err := Parse("http://example.com/[1-18446744073709551615]/[1-2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Count(); err == nil {
+		t.Error("count did not overflow")
+	}
+}
+
+func TestAllMatches(t *testing.T) {
+	p, err := Parse("http://example.com/{a,b}/[1-2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var matches [][]string
+	for _, m := range p.All() {
+		matches = append(matches, m)
+	}
+	want := [][]string{{"a", "1"}, {"a", "2"}, {"b", "1"}, {"b", "2"}}
+	if !slices.EqualFunc(matches, want, slices.Equal) {
+		t.Errorf("matches %q, expected %q", matches, want)
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"file_#1_#2.txt", "file_a_1.txt"},
+		{"#2#1", "1a"},
+		{"#3.txt", "#3.txt"},
+		{"#0", "#0"},
+		{"#", "#"},
+		{"no references", "no references"},
+		{"##1", "#a"},
+	}
+	for _, tt := range tests {
+		if got := ExpandTemplate(tt.template, []string{"a", "1"}); got != tt.want {
+			t.Errorf("ExpandTemplate(%q) = %q, expected %q", tt.template, got, tt.want)
+		}
+	}
+}
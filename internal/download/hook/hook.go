@@ -0,0 +1,312 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+const (
+	// DefaultConcurrency is the number of hooks run at the same time when Runner.Concurrency is not set
+	DefaultConcurrency = 4
+	// DefaultTimeout limits the time taken by a hook when Runner.Timeout is not set
+	DefaultTimeout = time.Minute
+	// webhookRetries is the number of times a webhook is posted again after a temporary failure
+	webhookRetries = 3
+	// maxOutput is the amount of the output of a command kept for its error
+	maxOutput = 4096
+)
+
+// webhookRetryDelay is multiplied by the attempt number to get the time waited before posting a webhook again
+var webhookRetryDelay = time.Second
+
+// Events passed to the hooks
+const (
+	EventComplete      = "complete"
+	EventError         = "error"
+	EventBatchComplete = "batch-complete"
+)
+
+// Hook is a command or a webhook. Commands are run by the shell with the path, url, size and digest of the download
+// as arguments and GODOWN_* environment variables, and webhooks receive the Payload in a POST request.
+// Commands also get the Payload on stdin
+type Hook struct {
+	Command string
+	URL     string
+}
+
+// Parse returns a webhook for http(s) urls and a command for anything else
+func Parse(s string) *Hook {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return &Hook{URL: s}
+	}
+	return &Hook{Command: s}
+}
+
+// Payload describes what a hook is run for. Path and Size are those of the saved file, if it is known.
+// Batch hooks get the number of completed and failed jobs instead, and the jobs themselves
+type Payload struct {
+	Event     string             `json:"event"`
+	Path      string             `json:"path,omitempty"`
+	Size      int64              `json:"size,omitempty"`
+	Job       *download.JobInfo  `json:"job,omitempty"`
+	Completed int                `json:"completed,omitempty"`
+	Failed    int                `json:"failed,omitempty"`
+	Jobs      []download.JobInfo `json:"jobs,omitempty"`
+}
+
+// Runner runs hooks for the jobs of a Downloader: OnComplete when a job completes, OnError when it fails, unless it
+// was cancelled because godown is stopping, and OnBatchComplete once for the jobs that stopped since the last batch.
+// A batch ends when Close is called, or whenever no job is left waiting or running if BatchOnIdle is set.
+// Dir is the directory the files are saved to, or an s3:// url. At most Concurrency hooks run at the same time, each for at most
+// Timeout. The result of the hooks of a job is reported with Downloader.ReportHook
+type Runner struct {
+	Downloader      *download.Downloader
+	Dir             string
+	OnComplete      *Hook
+	OnError         *Hook
+	OnBatchComplete *Hook
+	BatchOnIdle     bool
+	Concurrency     int
+	Timeout         time.Duration
+
+	sem         chan struct{}
+	wg          sync.WaitGroup
+	unsubscribe func()
+	// The fields below are only used by the subscriber and Close
+	pending map[string]bool
+	batch   []download.JobInfo
+}
+
+// Start runs the hooks for the events emitted from now on
+func (r *Runner) Start() {
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	r.sem = make(chan struct{}, concurrency)
+	r.pending = map[string]bool{}
+	r.unsubscribe = r.Downloader.Subscribe(r.event)
+}
+
+// Close waits for the running hooks, and runs the batch hook if any job stopped since the last batch
+func (r *Runner) Close() {
+	r.unsubscribe()
+	r.wg.Wait()
+	r.endBatch()
+	r.wg.Wait()
+}
+
+func (r *Runner) event(e download.Event) {
+	job := e.Job
+	switch {
+	case e.Type == download.EventCompleted:
+		r.batch = append(r.batch, job)
+		r.runJob(r.OnComplete, EventComplete, job)
+	case e.Type == download.EventFailed:
+		r.batch = append(r.batch, job)
+		if job.ErrorClass != download.ErrorCanceled {
+			r.runJob(r.OnError, EventError, job)
+		}
+	}
+	if job.State == download.JobWaiting || job.State == download.JobActive {
+		r.pending[job.ID] = true
+	} else {
+		delete(r.pending, job.ID)
+	}
+	if r.BatchOnIdle && len(r.pending) == 0 {
+		r.endBatch()
+	}
+}
+
+// runJob runs h for a stopped job in the background and reports the result
+func (r *Runner) runJob(h *Hook, event string, job download.JobInfo) {
+	if h == nil {
+		return
+	}
+	payload := Payload{Event: event, Job: &job}
+	if job.FileName != "" {
+		payload.Path = filepath.Join(r.Dir, job.FileName)
+		if strings.Contains(r.Dir, "://") {
+			payload.Path = r.Dir + "/" + job.FileName
+		}
+		payload.Size = job.Completed
+		if fi, err := os.Stat(payload.Path); err == nil {
+			payload.Size = fi.Size()
+		}
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		err := r.run(h, payload)
+		if err != nil {
+			slog.Error("running hook", "event", event, "url", job.URL, "err", err)
+		}
+		r.Downloader.ReportHook(job.ID, err)
+	}()
+}
+
+// endBatch runs the batch hook for the jobs that stopped since the last batch
+func (r *Runner) endBatch() {
+	if r.OnBatchComplete == nil || len(r.batch) == 0 {
+		r.batch = nil
+		return
+	}
+	payload := Payload{Event: EventBatchComplete, Jobs: r.batch}
+	for _, job := range r.batch {
+		if job.State == download.JobComplete {
+			payload.Completed++
+		} else {
+			payload.Failed++
+		}
+	}
+	r.batch = nil
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := r.run(r.OnBatchComplete, payload); err != nil {
+			slog.Error("running hook", "event", EventBatchComplete, "err", err)
+			fmt.Fprintln(os.Stderr, "batch complete hook failed:", err)
+		}
+	}()
+}
+
+// run runs h once a slot is free, within the timeout
+func (r *Runner) run(h *Hook, payload Payload) error {
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if h.URL != "" {
+		return post(ctx, h.URL, body)
+	}
+	return command(ctx, h.Command, payload, body)
+}
+
+// command runs a command with the shell, the payload is passed as arguments, environment variables and on stdin
+func command(ctx context.Context, cmdline string, p Payload, body []byte) error {
+	env := []string{"GODOWN_EVENT=" + p.Event}
+	var args []string
+	if p.Job != nil {
+		env = append(env,
+			"GODOWN_ID="+p.Job.ID,
+			"GODOWN_URL="+p.Job.URL,
+			"GODOWN_PATH="+p.Path,
+			"GODOWN_SIZE="+strconv.FormatInt(p.Size, 10),
+			"GODOWN_DIGEST="+p.Job.Digest,
+			"GODOWN_ERROR="+p.Job.Error,
+			"GODOWN_ERROR_CLASS="+p.Job.ErrorClass,
+		)
+		args = []string{p.Path, p.Job.URL, strconv.FormatInt(p.Size, 10), p.Job.Digest}
+	} else {
+		env = append(env, "GODOWN_COMPLETED="+strconv.Itoa(p.Completed), "GODOWN_FAILED="+strconv.Itoa(p.Failed))
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", cmdline)
+	} else {
+		// $0 is godown and the arguments are $1, $2, ...
+		cmd = exec.CommandContext(ctx, "sh", append([]string{"-c", cmdline, "godown"}, args...)...)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(body)
+	out := &limitedBuffer{limit: maxOutput}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("hook %q timed out", cmdline)
+	}
+	if err != nil {
+		if output := strings.TrimSpace(out.String()); output != "" {
+			return fmt.Errorf("hook %q: %w: %s", cmdline, err, output)
+		}
+		return fmt.Errorf("hook %q: %w", cmdline, err)
+	}
+	return nil
+}
+
+// limitedBuffer keeps the last bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.Buffer.Write(p)
+	if extra := b.Len() - b.limit; extra > 0 {
+		b.Next(extra)
+	}
+	return n, nil
+}
+
+// post sends the payload to a webhook, retrying when the server cannot be reached or is unavailable
+func post(ctx context.Context, url string, body []byte) error {
+	var err error
+	for attempt := 0; attempt <= webhookRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("webhook %s timed out: %w", url, err)
+			case <-time.After(time.Duration(attempt) * webhookRetryDelay):
+			}
+		}
+		var retry bool
+		retry, err = postOnce(ctx, url, body)
+		if err == nil || !retry {
+			return err
+		}
+		slog.Warn("retrying webhook", "url", url, "attempt", attempt+1, "err", err)
+	}
+	return err
+}
+
+func postOnce(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "godown")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return false, fmt.Errorf("webhook %s timed out", url)
+		}
+		return true, fmt.Errorf("webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxOutput))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("webhook %s returned %s", url, resp.Status)
+}
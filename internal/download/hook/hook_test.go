@@ -0,0 +1,223 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+func skipWithoutShell(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run with cmd on windows")
+	}
+}
+
+func TestCommandArguments(t *testing.T) {
+	skipWithoutShell(t)
+	out := filepath.Join(t.TempDir(), "out")
+	job := &download.JobInfo{
+		ID:         "7",
+		URL:        "http://example.com/a b.zip",
+		Digest:     "sha256:abcd",
+		Error:      "server returned 500",
+		ErrorClass: download.ErrorConnection,
+	}
+	p := Payload{Event: EventError, Path: "/tmp/a b.zip", Size: 1234, Job: job}
+	body, _ := json.Marshal(p)
+	script := `{ printf '%s\n' "$1" "$2" "$3" "$4" "$GODOWN_EVENT" "$GODOWN_ID" "$GODOWN_URL" "$GODOWN_PATH" "$GODOWN_SIZE" ` +
+		`"$GODOWN_DIGEST" "$GODOWN_ERROR" "$GODOWN_ERROR_CLASS"; cat; } > ` + out
+	if err := command(context.Background(), script, p, body); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"/tmp/a b.zip", "http://example.com/a b.zip", "1234", "sha256:abcd",
+		"error", "7", "http://example.com/a b.zip", "/tmp/a b.zip", "1234", "sha256:abcd", "server returned 500", download.ErrorConnection,
+	}, "\n") + "\n" + string(body)
+	if string(data) != want {
+		t.Errorf("hook received\n%s\nexpected\n%s", data, want)
+	}
+}
+
+func TestCommandBatchEnvironment(t *testing.T) {
+	skipWithoutShell(t)
+	out := filepath.Join(t.TempDir(), "out")
+	p := Payload{Event: EventBatchComplete, Completed: 3, Failed: 1}
+	if err := command(context.Background(), `echo "$GODOWN_EVENT $GODOWN_COMPLETED $GODOWN_FAILED $#" > `+out, p, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "batch-complete 3 1 0\n" {
+		t.Errorf("hook received %q", data)
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	skipWithoutShell(t)
+	err := command(context.Background(), "echo something went wrong >&2; exit 3", Payload{Event: EventComplete}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "something went wrong") {
+		t.Errorf("error %v, expected the exit status and the output", err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	skipWithoutShell(t)
+	r := &Runner{Timeout: 100 * time.Millisecond, sem: make(chan struct{}, 1)}
+	start := time.Now()
+	err := r.run(&Hook{Command: "sleep 10"}, Payload{Event: EventComplete})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("error %v, expected a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook ran for %s after its timeout", elapsed)
+	}
+}
+
+func TestPostRetries(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int32
+		ok       bool
+	}{
+		{"success", []int{200}, 1, true},
+		{"server error", []int{500, 204}, 2, true},
+		{"too many requests", []int{429, 429, 200}, 3, true},
+		{"request timeout", []int{408, 200}, 2, true},
+		{"client error", []int{400, 200}, 1, false},
+		{"not found", []int{404, 200}, 1, false},
+		{"unavailable", []int{503, 503, 503, 503, 200}, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := attempts.Add(1)
+				body, _ := io.ReadAll(r.Body)
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || string(body) != `{"event":"complete"}` {
+					t.Errorf("unexpected %s request with %q", r.Method, body)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer server.Close()
+			err := post(context.Background(), server.URL, []byte(`{"event":"complete"}`))
+			if (err == nil) != tt.ok {
+				t.Errorf("error %v, expected success %v", err, tt.ok)
+			}
+			if attempts.Load() != tt.attempts {
+				t.Errorf("%d attempts, expected %d", attempts.Load(), tt.attempts)
+			}
+		})
+	}
+}
+
+// TestRunner runs webhooks for completed and failed jobs and checks that their result is reported and that no more
+// than Concurrency of them run at the same time
+func TestRunner(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer files.Close()
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	payloads := map[string]Payload{}
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		payloads[r.URL.Path+" "+p.Job.URL] = p
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer hooks.Close()
+
+	dir := t.TempDir()
+	d := download.NewDownloader(dir, false, reporter.NopProgressBarFactory{})
+	d.SetWriterFactory(&storage.FSWriterFactory{BasePath: dir})
+	r := &Runner{
+		Downloader:  d,
+		Dir:         dir,
+		OnComplete:  Parse(hooks.URL + "/complete"),
+		OnError:     Parse(hooks.URL + "/error"),
+		Concurrency: 2,
+	}
+	r.Start()
+	ids := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "missing"} {
+		id, err := d.Add(context.Background(), files.URL+"/"+name, download.JobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+	d.Wait()
+	r.Close()
+
+	if maxRunning > 2 {
+		t.Errorf("%d hooks ran at the same time, expected at most 2", maxRunning)
+	}
+	for name, id := range ids {
+		info, err := d.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "missing" {
+			if info.State != download.JobError || !strings.Contains(info.HookError, "400") {
+				t.Errorf("%s is %s with hook error %q, expected a failed webhook", name, info.State, info.HookError)
+			}
+			if _, ok := payloads["/error "+info.URL]; !ok {
+				t.Errorf("error hook was not run for %s", name)
+			}
+			continue
+		}
+		if info.HookError != "" {
+			t.Errorf("%s has hook error %q", name, info.HookError)
+		}
+		p, ok := payloads["/complete "+info.URL]
+		if !ok {
+			t.Errorf("complete hook was not run for %s", name)
+			continue
+		}
+		if p.Event != EventComplete || info.FileName == "" || p.Path != filepath.Join(dir, info.FileName) || p.Size != int64(len("content")) {
+			t.Errorf("%s hook payload %+v", name, p)
+		}
+	}
+}
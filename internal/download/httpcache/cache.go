@@ -0,0 +1,505 @@
+package httpcache
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotCached is returned in offline mode for requests that cannot be answered from the cache
+var ErrNotCached = errors.New("not in the http cache")
+
+// maxHeuristicLifetime limits the freshness lifetime guessed from Last-Modified
+const maxHeuristicLifetime = 24 * time.Hour
+
+// Cache is an http.RoundTripper that keeps the responses of GET requests in Dir, as a private cache following
+// RFC 9111. Successful responses are stored unless Cache-Control forbids it, and served while they are fresh
+// according to Cache-Control max-age, Expires or, without them, a tenth of the time since Last-Modified. Stale
+// responses are revalidated with If-None-Match and If-Modified-Since, and a single variant of every URL is kept,
+// used only for requests with the same values of the headers listed in Vary. Requests for ranges, and
+// conditional requests, are passed on. Once the stored bodies exceed MaxSize the least recently used ones are
+// removed, 0 means no limit.
+// In Offline mode nothing is sent to the network: stored responses are served even if they are stale, including
+// to range requests which get the whole body, and other requests fail with ErrNotCached.
+// Transport sends the requests, http.DefaultTransport if nil
+type Cache struct {
+	Dir       string
+	MaxSize   int64
+	Offline   bool
+	Transport http.RoundTripper
+
+	mu   sync.Mutex
+	size int64
+}
+
+// entry is the metadata of a stored response, kept next to the body as JSON. Vary holds the values of the
+// request headers named by the Vary header of the response. The times are those of the request that got the
+// response and of its arrival, which give its age
+type entry struct {
+	URL          string            `json:"url"`
+	Header       http.Header       `json:"header"`
+	Vary         map[string]string `json:"vary,omitempty"`
+	RequestTime  time.Time         `json:"requestTime"`
+	ResponseTime time.Time         `json:"responseTime"`
+	Size         int64             `json:"size"`
+	Body         string            `json:"body"`
+}
+
+// New opens the cache in dir, creating it if needed
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{Dir: dir, MaxSize: maxSize}
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		c.size += e.size
+	}
+	return c, nil
+}
+
+// RoundTrip answers req from the cache if it can, and sends it otherwise
+func (c *Cache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		if c.Offline {
+			return nil, ErrNotCached
+		}
+		return c.transport().RoundTrip(req)
+	}
+	key := req.URL.String()
+	e := c.load(key)
+	if e != nil && !e.matches(req) {
+		e = nil
+	}
+	if c.Offline {
+		if e == nil {
+			return nil, ErrNotCached
+		}
+		return c.serve(req, e)
+	}
+	if req.Header.Get("Range") != "" || conditional(req.Header) {
+		return c.transport().RoundTrip(req)
+	}
+
+	now := time.Now()
+	if e != nil && e.fresh(now) {
+		return c.serve(req, e)
+	}
+	out := req
+	if e != nil && (e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != "") {
+		out = req.Clone(req.Context())
+		if etag := e.Header.Get("ETag"); etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+			out.Header.Set("If-Modified-Since", lastModified)
+		}
+		slog.Info("revalidating cached response", "url", key)
+	}
+	resp, err := c.transport().RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+	if out != req && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		e.update(resp.Header, now, responseTime)
+		if err := c.saveEntry(e); err != nil {
+			slog.Warn("updating cached response", "url", key, "err", err)
+		}
+		return c.serve(req, e)
+	}
+	if c.storable(req, resp) {
+		c.tee(req, resp, now, responseTime)
+	}
+	return resp, nil
+}
+
+func (c *Cache) transport() http.RoundTripper {
+	if c.Transport != nil {
+		return c.Transport
+	}
+	return http.DefaultTransport
+}
+
+// conditional reports whether a request has its own validators, which the cache leaves to the server
+func conditional(h http.Header) bool {
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if h.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// storable reports whether a response may be stored
+func (c *Cache) storable(req *http.Request, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Range") != "" {
+		return false
+	}
+	if _, ok := cacheControl(req.Header)["no-store"]; ok {
+		return false
+	}
+	if _, ok := cacheControl(resp.Header)["no-store"]; ok {
+		return false
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+	return c.MaxSize == 0 || resp.ContentLength <= c.MaxSize
+}
+
+// tee stores the body of resp as it is read, the response is stored once the whole body has been read
+func (c *Cache) tee(req *http.Request, resp *http.Response, requestTime, responseTime time.Time) {
+	tmp, err := os.CreateTemp(filepath.Join(c.Dir, "tmp"), "body-*")
+	if err != nil {
+		slog.Warn("caching response", "url", req.URL.String(), "err", err)
+		return
+	}
+	e := &entry{
+		URL:          req.URL.String(),
+		Header:       resp.Header.Clone(),
+		Vary:         map[string]string{},
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	for _, field := range strings.Split(resp.Header.Get("Vary"), ",") {
+		if name := http.CanonicalHeaderKey(strings.TrimSpace(field)); name != "" {
+			e.Vary[name] = req.Header.Get(name)
+		}
+	}
+	resp.Body = &cacheBody{ReadCloser: resp.Body, cache: c, entry: e, tmp: tmp, expected: resp.ContentLength}
+}
+
+// serve returns the stored response e as a response to req
+func (c *Cache) serve(req *http.Request, e *entry) (*http.Response, error) {
+	body, err := os.Open(filepath.Join(c.Dir, e.Body))
+	if err != nil {
+		if c.Offline {
+			return nil, ErrNotCached
+		}
+		return nil, err
+	}
+	now := time.Now()
+	// Touching the metadata marks the entry as recently used
+	os.Chtimes(c.entryPath(e.URL), now, now)
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	slog.Info("serving cached response", "url", e.URL, "age", e.age(now), "fresh", e.fresh(now))
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: e.Size,
+		Request:       req,
+	}, nil
+}
+
+// entryPath returns the path of the metadata of the response to the url key
+func (c *Cache) entryPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.Dir, name[:2], name+".json")
+}
+
+// load returns the stored response to the url key, or nil if there is none
+func (c *Cache) load(key string) *entry {
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil || e.URL != key {
+		return nil
+	}
+	return &e
+}
+
+// saveEntry writes the metadata of a stored response
+func (c *Cache) saveEntry(e *entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := c.entryPath(e.URL)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(c.Dir, "tmp"), "entry-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// store moves a complete body into the cache along with its metadata, replacing the earlier response
+func (c *Cache) store(e *entry, tmp string) error {
+	path := c.entryPath(e.URL)
+	old := c.load(e.URL)
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	// Every body gets a new name, so that responses being served are not replaced while they are read
+	e.Body = filepath.Join(filepath.Base(filepath.Dir(path)),
+		strings.TrimSuffix(filepath.Base(path), ".json")+"-"+hex.EncodeToString(suffix)+".body")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.Dir, e.Body)); err != nil {
+		return err
+	}
+	if err := c.saveEntry(e); err != nil {
+		os.Remove(filepath.Join(c.Dir, e.Body))
+		return err
+	}
+	c.mu.Lock()
+	c.size += e.Size
+	if old != nil {
+		os.Remove(filepath.Join(c.Dir, old.Body))
+		c.size -= old.Size
+	}
+	full := c.MaxSize > 0 && c.size > c.MaxSize
+	c.mu.Unlock()
+	if full {
+		return c.evict()
+	}
+	return nil
+}
+
+// storedEntry is an entry found on disk by entries
+type storedEntry struct {
+	path string
+	body string
+	size int64
+	used time.Time
+}
+
+// entries lists the stored responses
+func (c *Cache) entries() ([]storedEntry, error) {
+	var entries []storedEntry
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "tmp" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var e entry
+		if json.Unmarshal(data, &e) != nil {
+			return nil
+		}
+		entries = append(entries, storedEntry{path: path, body: filepath.Join(c.Dir, e.Body), size: e.Size, used: info.ModTime()})
+		return nil
+	})
+	return entries, err
+}
+
+// evict removes the least recently used responses until the bodies fit within MaxSize
+func (c *Cache) evict() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b storedEntry) int { return a.used.Compare(b.used) })
+	c.size = 0
+	for _, e := range entries {
+		c.size += e.size
+	}
+	for _, e := range entries {
+		if c.size <= c.MaxSize {
+			break
+		}
+		os.Remove(e.path)
+		os.Remove(e.body)
+		c.size -= e.size
+		slog.Info("evicted cached response", "path", e.path, "size", e.size)
+	}
+	return nil
+}
+
+// matches reports whether the stored response was selected with the same values of the headers in Vary
+func (e *entry) matches(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// update replaces the stored header fields with those of a 304 response that validated it
+func (e *entry) update(header http.Header, requestTime, responseTime time.Time) {
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding":
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime = requestTime
+	e.ResponseTime = responseTime
+}
+
+// fresh reports whether the response can be served without asking the server
+func (e *entry) fresh(now time.Time) bool {
+	if _, ok := cacheControl(e.Header)["no-cache"]; ok {
+		return false
+	}
+	return e.age(now) < e.lifetime()
+}
+
+// lifetime returns the freshness lifetime of the response, RFC 9111 section 4.2.1
+func (e *entry) lifetime() time.Duration {
+	if maxAge, ok := cacheControl(e.Header)["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date := e.date()
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates, such as 0, mean that the response has already expired
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		return min(date.Sub(lastModified)/10, maxHeuristicLifetime)
+	}
+	return 0
+}
+
+// age returns the current age of the response, RFC 9111 section 4.2.3
+func (e *entry) age(now time.Time) time.Duration {
+	apparent := max(0, e.ResponseTime.Sub(e.date()))
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+// date returns the Date of the response, or the time it was received without one
+func (e *entry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// cacheControl parses the Cache-Control header fields into directives and their arguments
+func cacheControl(h http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, field := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(field, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// cacheBody copies a response body to a temporary file, which is stored once the whole body has been read
+type cacheBody struct {
+	io.ReadCloser
+	cache    *Cache
+	entry    *entry
+	tmp      *os.File
+	size     int64
+	expected int64
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.tmp != nil && n > 0 {
+		b.size += int64(n)
+		if _, werr := b.tmp.Write(p[:n]); werr != nil || b.cache.MaxSize > 0 && b.size > b.cache.MaxSize {
+			b.discard()
+		}
+	}
+	if err == io.EOF && b.tmp != nil {
+		b.commit()
+	}
+	return n, err
+}
+
+// Close discards the copy unless the whole body was read
+func (b *cacheBody) Close() error {
+	if b.tmp != nil {
+		b.discard()
+	}
+	return b.ReadCloser.Close()
+}
+
+func (b *cacheBody) commit() {
+	tmp := b.tmp
+	b.tmp = nil
+	err := tmp.Close()
+	if err == nil && b.expected >= 0 && b.size != b.expected {
+		err = fmt.Errorf("received %d of %d bytes", b.size, b.expected)
+	}
+	if err == nil {
+		b.entry.Size = b.size
+		err = b.cache.store(b.entry, tmp.Name())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		slog.Warn("caching response", "url", b.entry.URL, "err", err)
+	}
+}
+
+func (b *cacheBody) discard() {
+	b.tmp.Close()
+	os.Remove(b.tmp.Name())
+	b.tmp = nil
+}
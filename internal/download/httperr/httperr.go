@@ -0,0 +1,12 @@
+package httperr
+
+// StatusError is returned when a server responds with an unexpected HTTP status, to a file, manifest, segment or
+// link check request
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "server returned " + e.Status
+}
@@ -5,8 +5,12 @@ import (
 )
 
 // IsUrl checks if the given string represents a valid URL
-// A valid URL is defined as having a scheme and a host
+// A valid URL is defined as having a scheme and a host, magnet links which
+// have no host are valid if they have a query
 func IsUrl(str string) bool {
 	u, err := url.Parse(str)
+	if err == nil && u.Scheme == "magnet" {
+		return u.RawQuery != ""
+	}
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
@@ -0,0 +1,211 @@
+package download
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/task"
+	"github.com/ananthvk/godown/internal/download/trace"
+)
+
+// DefaultMaxStoppedJobs is the number of stopped jobs kept by a Downloader when MaxStoppedJobs is not set
+const DefaultMaxStoppedJobs = 1000
+
+// JobState is the state of a download job
+type JobState string
+
+const (
+	JobWaiting  JobState = "waiting"
+	JobActive   JobState = "active"
+	JobPaused   JobState = "paused"
+	JobComplete JobState = "complete"
+	JobError    JobState = "error"
+	JobRemoved  JobState = "removed"
+)
+
+// Stopped reports whether a job in this state has finished, failed or was removed
+func (s JobState) Stopped() bool {
+	return s == JobComplete || s == JobError || s == JobRemoved
+}
+
+// JobOptions are the settings of a single job
+// FileName overrides the name of the saved file for HTTP(S) downloads, see Downloader.DownloadAs
+// Waiting jobs with a higher Priority are started first, jobs with the same priority start in the order they were added
+// Digest is the expected SHA-256 of the file as "sha256:<hex>", the job fails if the download has another one
+type JobOptions struct {
+	FileName string `json:"out,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+}
+
+// JobInfo is a snapshot of a job. Completed and Total are the progress reported by the task, which is
+// in bytes for most downloads and in segments for streams; Total is 0 if it is not known
+// Parent is the ID of the job whose page linked to this one in recursive downloads, and Resume is the
+// file that an HTTP(S) download is being saved to. FileName is the name of that file once it is known, and
+// Digest its SHA-256 as "sha256:<hex>" once the download is complete, if the whole file was hashed.
+// ErrorClass is the ErrorClass of Error, and Retries the number of times the download was retried automatically.
+// HookError is the error of the hooks run once the job stopped, if they failed
+type JobInfo struct {
+	ID         string            `json:"id"`
+	URL        string            `json:"url"`
+	Options    JobOptions        `json:"options"`
+	State      JobState          `json:"state"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass string            `json:"errorClass,omitempty"`
+	Retries    int               `json:"retries,omitempty"`
+	Parent     string            `json:"parent,omitempty"`
+	Resume     *task.ResumeState `json:"resume,omitempty"`
+	FileName   string            `json:"fileName,omitempty"`
+	Digest     string            `json:"digest,omitempty"`
+	HookError  string            `json:"hookError,omitempty"`
+	Completed  int64             `json:"completed"`
+	Total      int64             `json:"total"`
+	Created    time.Time         `json:"created"`
+	Finished   time.Time         `json:"finished,omitzero"`
+}
+
+// job is a download managed by the Downloader. info is protected by the Downloader's mutex,
+// while the progress counters are updated by the task without holding it
+type job struct {
+	info       JobInfo
+	seq        uint64
+	task       task.Task
+	ctx        context.Context
+	cancel     context.CancelFunc
+	running    bool
+	pausing    atomic.Bool
+	started    chan struct{}
+	stopWatch  func() bool
+	retryTimer *time.Timer
+	span       *trace.Span
+	progress   *jobProgress
+	completed  atomic.Int64
+	total      atomic.Int64
+}
+
+// snapshot returns a copy of the job's info with the current progress, the Downloader's mutex must be held
+func (j *job) snapshot() JobInfo {
+	info := j.info
+	info.Completed = j.completed.Load()
+	info.Total = j.total.Load()
+	return info
+}
+
+// newJobID returns a random 16 character hexadecimal ID
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobProgress implements reporter.ProgressBarFactory, it creates progress bars that update the
+// progress of the job as well as a bar of the wrapped factory. When the job is paused the bar is marked
+// as paused instead of being aborted, and it is reused once the job runs again.
+// Reads through the bars are limited by limiter
+type jobProgress struct {
+	job     *job
+	factory reporter.ProgressBarFactory
+	limiter *RateLimiter
+	mu      sync.Mutex
+	bar     reporter.ProgressBar
+}
+
+func (p *jobProgress) CreateProgressBar(total int64, name string) reporter.ProgressBar {
+	p.job.total.Store(total)
+	p.job.completed.Store(0)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bar != nil {
+		p.bar.SetPaused(false)
+		p.bar.SetTotal(total, false)
+		p.bar.SetCurrent(0)
+	} else {
+		p.bar = p.factory.CreateProgressBar(total, name)
+	}
+	return &jobProgressBar{job: p.job, progress: p, bar: p.bar}
+}
+
+// abort aborts a bar kept for a paused job
+func (p *jobProgress) abort() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bar != nil {
+		p.bar.Abort(true)
+		p.bar = nil
+	}
+}
+
+type jobProgressBar struct {
+	job      *job
+	progress *jobProgress
+	bar      reporter.ProgressBar
+}
+
+func (b *jobProgressBar) ProxyReader(r io.Reader) io.ReadCloser {
+	proxy := b.bar.ProxyReader(r)
+	if proxy == nil {
+		return nil
+	}
+	return &countingReader{ReadCloser: proxy, n: &b.job.completed, limiter: b.progress.limiter}
+}
+
+func (b *jobProgressBar) SetTotal(total int64, complete bool) {
+	if total < 0 {
+		total = b.job.completed.Load()
+	}
+	b.job.total.Store(total)
+	b.bar.SetTotal(total, complete)
+}
+
+func (b *jobProgressBar) SetCurrent(current int64) {
+	b.job.completed.Store(current)
+	b.bar.SetCurrent(current)
+}
+
+func (b *jobProgressBar) IncrBy(n int) {
+	b.job.completed.Add(int64(n))
+	b.bar.IncrBy(n)
+}
+
+func (b *jobProgressBar) SetPaused(paused bool) {
+	b.bar.SetPaused(paused)
+}
+
+// Abort keeps the bar as paused if the task is being stopped because the job was paused
+func (b *jobProgressBar) Abort(drop bool) {
+	if b.job.pausing.Load() {
+		b.bar.SetPaused(true)
+		return
+	}
+	b.progress.mu.Lock()
+	defer b.progress.mu.Unlock()
+	if b.progress.bar == b.bar {
+		b.progress.bar = nil
+	}
+	b.bar.Abort(drop)
+}
+
+// countingReader adds the number of bytes read to n, reading no faster than limiter allows
+type countingReader struct {
+	io.ReadCloser
+	n       *atomic.Int64
+	limiter *RateLimiter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.limiter != nil {
+		p = p[:c.limiter.chunk(len(p))]
+	}
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	if c.limiter != nil {
+		c.limiter.take(n)
+	}
+	return n, err
+}
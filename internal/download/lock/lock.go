@@ -0,0 +1,214 @@
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// Version is the version of the lockfile format written by Write
+const Version = 1
+
+// ErrDrift is returned for a file that does not match its entry in the lockfile
+var ErrDrift = errors.New("does not match the lockfile")
+
+// Entry is a downloaded file: the url it was downloaded from, its name relative to the output directory with
+// forward slashes, its size in bytes and its SHA-256 as "sha256:<hex>"
+type Entry struct {
+	URL      string `json:"url"`
+	FileName string `json:"fileName"`
+	Size     int64  `json:"size"`
+	Digest   string `json:"digest"`
+}
+
+// File is a lockfile, its entries are sorted by file name
+type File struct {
+	Version int     `json:"version"`
+	Files   []Entry `json:"files"`
+}
+
+// Read reads and checks the lockfile at path. File names must stay inside the output directory and be unique
+func Read(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("%s: unsupported lockfile version %d", path, f.Version)
+	}
+	names := map[string]bool{}
+	for i, e := range f.Files {
+		switch {
+		case e.URL == "":
+			return nil, fmt.Errorf("%s: file %d has no url", path, i+1)
+		case !validName(e.FileName):
+			return nil, fmt.Errorf("%s: invalid file name %q", path, e.FileName)
+		case names[e.FileName]:
+			return nil, fmt.Errorf("%s: %s is listed twice", path, e.FileName)
+		case e.Size < 0:
+			return nil, fmt.Errorf("%s: %s has a negative size", path, e.FileName)
+		}
+		sum, err := storage.ParseDigest(e.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, e.FileName, err)
+		}
+		f.Files[i].Digest = "sha256:" + sum
+		names[e.FileName] = true
+	}
+	return f, nil
+}
+
+// validName reports whether name is a relative path inside the output directory
+func validName(name string) bool {
+	return name != "" && name != "." && !path.IsAbs(name) && !strings.Contains(name, "\\") && path.Clean(name) == name &&
+		name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// Write sorts the entries and replaces the lockfile at path with f
+func (f *File) Write(path string) error {
+	f.Version = Version
+	slices.SortFunc(f.Files, func(a, b Entry) int {
+		return strings.Compare(a.FileName, b.FileName)
+	})
+	if f.Files == nil {
+		f.Files = []Entry{}
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".godown-lock-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Verify checks the file of e in dir, it returns an error wrapping ErrDrift if the file has another size or digest.
+// The file is opened within dir, symlinks leading out of it are not followed
+func Verify(dir string, e Entry) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	file, err := root.Open(filepath.FromSlash(e.FileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", e.FileName)
+	}
+	if info.Size() != e.Size {
+		return fmt.Errorf("%s %w: size %d, expected %d", e.FileName, ErrDrift, info.Size(), e.Size)
+	}
+	digest, _, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	if digest != e.Digest {
+		return fmt.Errorf("%s %w: digest %s, expected %s", e.FileName, ErrDrift, digest, e.Digest)
+	}
+	return nil
+}
+
+func hashFile(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// Recorder collects the downloads of a Downloader that completed, for a lockfile
+type Recorder struct {
+	mu          sync.Mutex
+	completed   []download.JobInfo
+	failed      int
+	unsubscribe func()
+}
+
+// Record starts recording the downloads of d
+func Record(d *download.Downloader) *Recorder {
+	r := &Recorder{}
+	r.unsubscribe = d.Subscribe(r.event)
+	return r
+}
+
+func (r *Recorder) event(e download.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch e.Type {
+	case download.EventCompleted:
+		r.completed = append(r.completed, e.Job)
+	case download.EventFailed:
+		r.failed++
+	}
+}
+
+// Close stops recording and returns the lockfile of the completed downloads, with the sizes of their files in dir.
+// Files whose digest is not known, such as downloads resumed in another run, are hashed. It fails if a download
+// failed or has no file that can be hashed
+func (r *Recorder) Close(dir string) (*File, error) {
+	r.unsubscribe()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed > 0 {
+		return nil, fmt.Errorf("%d download(s) failed", r.failed)
+	}
+	f := &File{Version: Version}
+	for _, job := range r.completed {
+		e := Entry{URL: job.URL, FileName: filepath.ToSlash(job.FileName), Size: job.Completed, Digest: job.Digest}
+		if e.FileName == "" {
+			return nil, fmt.Errorf("%s: no file was saved", job.URL)
+		}
+		filePath := filepath.Join(dir, job.FileName)
+		if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+			// Completed counts the bytes received, which differ from the saved ones for decoded responses
+			e.Size = info.Size()
+		}
+		if e.Digest == "" {
+			file, err := os.Open(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", job.URL, err)
+			}
+			e.Digest, e.Size, err = hashFile(file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", job.URL, err)
+			}
+		}
+		f.Files = append(f.Files, e)
+	}
+	return f, nil
+}
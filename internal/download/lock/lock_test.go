@@ -0,0 +1,242 @@
+package lock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestRead(t *testing.T) {
+	digest := digestOf("content")
+	entry := func(url, name, digest string) string {
+		return `{"url":"` + url + `","fileName":"` + name + `","size":7,"digest":"` + digest + `"}`
+	}
+	lockfile := func(entries ...string) string {
+		return `{"version":1,"files":[` + strings.Join(entries, ",") + `]}`
+	}
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+	}{
+		{"valid", lockfile(entry("http://a/1", "a", digest), entry("http://a/2", "dir/b", digest)), true},
+		{"empty", lockfile(), true},
+		{"upper case digest", lockfile(entry("http://a/1", "a", digest[:7]+strings.ToUpper(digest[7:]))), true},
+		{"unsupported version", `{"version":2,"files":[]}`, false},
+		{"invalid json", `{"version":1,`, false},
+		{"no url", lockfile(entry("", "a", digest)), false},
+		{"duplicate name", lockfile(entry("http://a/1", "a", digest), entry("http://a/2", "a", digest)), false},
+		{"parent", lockfile(entry("http://a/1", "../a", digest)), false},
+		{"parent of parent", lockfile(entry("http://a/1", "..", digest)), false},
+		{"nested parent", lockfile(entry("http://a/1", "dir/../../a", digest)), false},
+		{"absolute", lockfile(entry("http://a/1", "/etc/passwd", digest)), false},
+		{"backslash", lockfile(entry("http://a/1", `..\\a`, digest)), false},
+		{"directory", lockfile(entry("http://a/1", ".", digest)), false},
+		{"not clean", lockfile(entry("http://a/1", "./a", digest)), false},
+		{"empty name", lockfile(entry("http://a/1", "", digest)), false},
+		{"negative size", `{"version":1,"files":[{"url":"http://a/1","fileName":"a","size":-1,"digest":"` + digest + `"}]}`, false},
+		{"missing digest", lockfile(entry("http://a/1", "a", "")), false},
+		{"other algorithm", lockfile(entry("http://a/1", "a", "md5:9a0364b9e99bb480dd25e1f0284c8555")), false},
+		{"short digest", lockfile(entry("http://a/1", "a", digest[:20])), false},
+		{"not hex", lockfile(entry("http://a/1", "a", "sha256:"+strings.Repeat("z", 64))), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "godown.lock")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := Read(path)
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Error("lockfile was accepted")
+				}
+				return
+			}
+			for _, e := range f.Files {
+				if e.Digest != digest {
+					t.Errorf("digest %s, expected %s", e.Digest, digest)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "godown.lock")
+	f := &File{Files: []Entry{
+		{URL: "http://a/2", FileName: "b", Size: 1, Digest: digestOf("b")},
+		{URL: "http://a/1", FileName: "a", Size: 1, Digest: digestOf("a")},
+	}}
+	if err := f.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Files) != 2 || read.Files[0].FileName != "a" || read.Files[1] != f.Files[1] {
+		t.Errorf("read %+v, expected the entries sorted by name", read.Files)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "out")
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "same"), "content")
+	write(filepath.Join(dir, "dir", "nested"), "content")
+	write(filepath.Join(dir, "longer"), "content!")
+	write(filepath.Join(dir, "changed"), "CONTENT")
+	write(filepath.Join(parent, "secret"), "content")
+	if err := os.Symlink(filepath.Join(parent, "secret"), filepath.Join(dir, "outside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("same", filepath.Join(dir, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		drift bool
+		err   error
+	}{
+		{"same", false, nil},
+		{"dir/nested", false, nil},
+		{"inside", false, nil},
+		{"longer", true, nil},
+		{"changed", true, nil},
+		{"missing", false, fs.ErrNotExist},
+		{"outside", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(dir, Entry{URL: "http://a/" + tt.name, FileName: tt.name, Size: 7, Digest: digestOf("content")})
+			switch {
+			case tt.drift:
+				if !errors.Is(err, ErrDrift) {
+					t.Errorf("error %v, expected %v", err, ErrDrift)
+				}
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error %v, expected %v", err, tt.err)
+				}
+			case tt.name == "outside":
+				if err == nil {
+					t.Error("file outside of the directory was verified")
+				}
+			case err != nil:
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		paths []string
+		ok    bool
+	}{
+		{"completed", []string{"/a", "/b"}, true},
+		{"failed", []string{"/a", "/missing"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := download.NewDownloader(dir, false, reporter.NopProgressBarFactory{})
+			d.SetWriterFactory(&storage.FSWriterFactory{BasePath: dir})
+			r := Record(d)
+			for _, p := range tt.paths {
+				if _, err := d.Add(context.Background(), server.URL+p, download.JobOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d.Wait()
+			f, err := r.Close(dir)
+			if !tt.ok {
+				if err == nil {
+					t.Error("lockfile of a failed download was recorded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Files) != len(tt.paths) {
+				t.Fatalf("%d entries, expected %d", len(f.Files), len(tt.paths))
+			}
+			for _, e := range f.Files {
+				content := "content of /" + strings.TrimPrefix(e.URL, server.URL+"/")
+				if e.Size != int64(len(content)) || e.Digest != digestOf(content) {
+					t.Errorf("entry %+v, expected size %d and digest %s", e, len(content), digestOf(content))
+				}
+				if err := Verify(dir, e); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+// TestRecorderHashesFiles checks that files whose digest is not known are hashed, and that the size of the saved
+// file is recorded rather than the number of bytes received
+func TestRecorderHashesFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("decoded content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &Recorder{unsubscribe: func() {}}
+	r.event(download.Event{Type: download.EventCompleted, Job: download.JobInfo{URL: "http://a/a", FileName: "a", Completed: 3}})
+	f, err := r.Close(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{URL: "http://a/a", FileName: "a", Size: int64(len("decoded content")), Digest: digestOf("decoded content")}
+	if len(f.Files) != 1 || f.Files[0] != want {
+		t.Errorf("entries %+v, expected %+v", f.Files, want)
+	}
+
+	r = &Recorder{unsubscribe: func() {}}
+	r.event(download.Event{Type: download.EventCompleted, Job: download.JobInfo{URL: "http://a/b"}})
+	if _, err := r.Close(dir); err == nil {
+		t.Error("download without a file was recorded")
+	}
+}
@@ -0,0 +1,94 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// contentServer serves a distinct body for every path
+func contentServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(bytes.Repeat([]byte(r.URL.Path), 1000))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMemoryWriterFactoryJobs(t *testing.T) {
+	server := contentServer(t)
+	memory := &storage.MemoryWriterFactory{MaxSize: 10000}
+	d := NewDownloader(t.TempDir(), false, reporter.NopProgressBarFactory{})
+	d.MaxConcurrent = 8
+	d.SetWriterFactory(memory)
+	urls := map[string]string{}
+	for i := range 20 {
+		path := "/file" + strconv.Itoa(i)
+		if i == 19 {
+			// Larger than MaxSize
+			path = "/file-too-large"
+		}
+		id, err := d.Add(context.Background(), server.URL+path, JobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls[id] = path
+	}
+	d.Wait()
+
+	for id, path := range urls {
+		info, err := d.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := memory.Bytes(id)
+		if path == "/file-too-large" {
+			if info.State != JobError {
+				t.Errorf("%s is %s, expected an error", path, info.State)
+			}
+			continue
+		}
+		if info.State != JobComplete || !ok || !bytes.Equal(data, bytes.Repeat([]byte(path), 1000)) {
+			t.Errorf("%s is %s with %d bytes in memory", path, info.State, len(data))
+		}
+	}
+}
+
+func TestWriterFuncJobs(t *testing.T) {
+	server := contentServer(t)
+	var mu sync.Mutex
+	buffers := map[string]*bytes.Buffer{}
+	d := NewDownloader(t.TempDir(), false, reporter.NopProgressBarFactory{})
+	d.MaxConcurrent = 8
+	d.SetWriterFactory(storage.WriterFunc(func(id, fileName string) (io.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		buffers[id] = &bytes.Buffer{}
+		return buffers[id], nil
+	}))
+	urls := map[string]string{}
+	for i := range 10 {
+		path := "/file" + strconv.Itoa(i)
+		id, err := d.Add(context.Background(), server.URL+path, JobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls[id] = path
+	}
+	d.Wait()
+	for id, path := range urls {
+		if b := buffers[id]; b == nil || !bytes.Equal(b.Bytes(), bytes.Repeat([]byte(path), 1000)) {
+			t.Errorf("writer of %s holds the wrong data", path)
+		}
+	}
+}
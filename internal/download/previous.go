@@ -0,0 +1,53 @@
+package download
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/task"
+)
+
+// previousDownload lets the task continue or update the file that an earlier run saved from the same url, as
+// recorded in its metadata, see HTTPOptions. Unless fileName is given, a file that is not named after the url, as
+// its name came from the response, is looked up by its url if the WriterFactory is a storage.MetadataFinder.
+// The mutex must be held
+func (d *Downloader) previousDownload(t *task.HTTPDownloadTask, j *job, urlString, fileName string) {
+	reader, ok := d.writerFactory.(storage.MetadataReader)
+	if !ok {
+		return
+	}
+	named := fileName != ""
+	if !named {
+		fileName = fileNameFromURL(urlString)
+	}
+	m, err := reader.ReadMetadata(fileName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, storage.ErrNoMetadata) {
+		slog.Warn("reading file metadata", "filename", fileName, "err", err)
+	}
+	if finder, ok := d.writerFactory.(storage.MetadataFinder); ok && !named && (m == nil || m.URL != urlString) {
+		name, found, err := finder.FindMetadata(urlString)
+		if err != nil {
+			slog.Warn("looking up file by url", "url", urlString, "err", err)
+		}
+		if found != nil {
+			fileName, m = name, found
+		}
+	}
+	if m == nil {
+		return
+	}
+	if m.URL != urlString {
+		slog.Info("file was downloaded from another url", "url", urlString, "filename", fileName, "origin", m.URL)
+		return
+	}
+	state := &task.ResumeState{FileName: fileName, ETag: m.ETag, LastModified: m.LastModified}
+	if m.Complete() && d.HTTP.Update {
+		t.Update = state
+	} else {
+		// A complete file is only checked with a range request past its end
+		t.Resume = state
+	}
+	j.info.FileName = fileName
+}
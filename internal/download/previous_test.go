@@ -0,0 +1,101 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// TestPreviousDownloadByURL checks that files whose name came from Content-Disposition are found by the url
+// recorded in their metadata
+func TestPreviousDownloadByURL(t *testing.T) {
+	content := []byte(strings.Repeat("report content ", 100))
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Get("Range")+r.Header.Get("If-None-Match"))
+		mu.Unlock()
+		w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	url := server.URL + "/download?id=1"
+
+	tests := []struct {
+		name    string
+		saved   []byte
+		options HTTPOptions
+		request string
+	}{
+		{"continue", content[:100], HTTPOptions{Continue: true}, "bytes=100-"},
+		{"update", content, HTTPOptions{Update: true}, `"v1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "report.pdf")
+			if err := os.WriteFile(path, tt.saved, 0644); err != nil {
+				t.Fatal(err)
+			}
+			m := storage.Metadata{URL: url, ETag: `"v1"`}
+			if len(tt.saved) == len(content) {
+				m.Downloaded = time.Now()
+			}
+			if err := storage.WriteMetadata(path, m); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			requests = nil
+			mu.Unlock()
+
+			d := NewDownloader(dir, false, reporter.NopProgressBarFactory{})
+			d.SetWriterFactory(&storage.FSWriterFactory{BasePath: dir, Metadata: true})
+			d.HTTP = tt.options
+			id, err := d.Add(context.Background(), url, JobOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Wait()
+			info, err := d.Job(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.State != JobComplete || info.FileName != "report.pdf" {
+				t.Fatalf("job is %s with file %q (%s), expected complete with report.pdf", info.State, info.FileName, info.Error)
+			}
+			mu.Lock()
+			if len(requests) != 1 || requests[0] != tt.request {
+				t.Errorf("requests %q, expected one with %q", requests, tt.request)
+			}
+			mu.Unlock()
+			saved, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, content) {
+				t.Error("report.pdf differs")
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "report.pdf" && e.Name() != "report.pdf"+storage.SidecarExtension {
+					t.Errorf("unexpected file %s", e.Name())
+				}
+			}
+		})
+	}
+}
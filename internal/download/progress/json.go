@@ -0,0 +1,87 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// TypeProgress is the type of the lines written for progress samples by JSON
+const TypeProgress = "progress"
+
+// JSON writes every event and progress sample as a line of JSON (JSON Lines). Each line has a "type", which is
+// one of the download.EventType values or TypeProgress, the time and the fields of the job that are relevant to it.
+// Speed is in bytes per second, ETA and the latency of response events in seconds
+type JSON struct {
+	enc *json.Encoder
+}
+
+// NewJSON returns a JSON reporter writing to w
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w)}
+}
+
+type jsonLine struct {
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	ID         string            `json:"id"`
+	URL        string            `json:"url"`
+	State      download.JobState `json:"state"`
+	Parent     string            `json:"parent,omitempty"`
+	Priority   int               `json:"priority,omitempty"`
+	FileName   string            `json:"fileName,omitempty"`
+	Completed  int64             `json:"completed"`
+	Total      int64             `json:"total"`
+	Speed      *float64          `json:"speed,omitempty"`
+	ETA        *float64          `json:"eta,omitempty"`
+	Latency    *float64          `json:"latency,omitempty"`
+	Digest     string            `json:"digest,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass string            `json:"errorClass,omitempty"`
+	Retries    int               `json:"retries,omitempty"`
+	HookError  string            `json:"hookError,omitempty"`
+}
+
+func newJSONLine(typ string, t time.Time, job download.JobInfo) jsonLine {
+	return jsonLine{
+		Type:       typ,
+		Time:       t,
+		ID:         job.ID,
+		URL:        job.URL,
+		State:      job.State,
+		Parent:     job.Parent,
+		Priority:   job.Options.Priority,
+		FileName:   job.FileName,
+		Completed:  job.Completed,
+		Total:      job.Total,
+		Digest:     job.Digest,
+		Error:      job.Error,
+		ErrorClass: job.ErrorClass,
+		Retries:    job.Retries,
+		HookError:  job.HookError,
+	}
+}
+
+func (r *JSON) Event(e download.Event) {
+	line := newJSONLine(string(e.Type), e.Time, e.Job)
+	if e.Type == download.EventResponse {
+		latency := e.Latency.Seconds()
+		line.Latency = &latency
+	}
+	r.enc.Encode(line)
+}
+
+func (r *JSON) Progress(samples []Sample) {
+	for _, s := range samples {
+		line := newJSONLine(TypeProgress, s.Time, s.Job)
+		speed := s.Speed
+		line.Speed = &speed
+		if s.ETA > 0 {
+			eta := s.ETA.Seconds()
+			line.ETA = &eta
+		}
+		r.enc.Encode(line)
+	}
+}
@@ -0,0 +1,136 @@
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// Reporter is told about the events of a Downloader and, at regular intervals, about the progress of the active
+// jobs. The methods are called from one goroutine at a time
+type Reporter interface {
+	Event(e download.Event)
+	Progress(samples []Sample)
+}
+
+// Sample is the progress of an active job. Speed is in bytes per second and ETA is 0 if it is not known
+type Sample struct {
+	Time  time.Time
+	Job   download.JobInfo
+	Speed float64
+	ETA   time.Duration
+}
+
+// Watch feeds r with the events of d, and with the progress of the active jobs every interval, until the returned
+// function is called. The function returns once the events emitted before it was called have been reported
+func Watch(d *download.Downloader, interval time.Duration, r Reporter) func() {
+	var mu sync.Mutex
+	// states holds the latest state of the jobs seen in events, so that samples taken before a job stopped
+	// are not reported after its event
+	states := map[string]download.JobState{}
+	unsubscribe := d.Subscribe(func(e download.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == download.EventRemoved || e.Job.State.Stopped() {
+			delete(states, e.Job.ID)
+		} else {
+			states[e.Job.ID] = e.Job.State
+		}
+		r.Event(e)
+	})
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var meter Meter
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			samples := meter.Sample(time.Now(), d.Jobs(download.JobActive))
+			mu.Lock()
+			active := samples[:0]
+			for _, s := range samples {
+				if states[s.Job.ID] == download.JobActive {
+					active = append(active, s)
+				}
+			}
+			if len(active) > 0 {
+				r.Progress(active)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		unsubscribe()
+	}
+}
+
+// Meter measures the speed of jobs from their progress. The speeds are smoothed so that they do not jump
+// between samples
+type Meter struct {
+	last map[string]measurement
+}
+
+type measurement struct {
+	completed int64
+	time      time.Time
+	speed     float64
+}
+
+// Sample returns the progress of the jobs at now. Jobs that are not passed are forgotten
+func (m *Meter) Sample(now time.Time, jobs []download.JobInfo) []Sample {
+	last := make(map[string]measurement, len(jobs))
+	samples := make([]Sample, len(jobs))
+	for i, job := range jobs {
+		current := measurement{completed: job.Completed, time: now}
+		if prev, ok := m.last[job.ID]; ok && job.Completed >= prev.completed && now.After(prev.time) {
+			speed := float64(job.Completed-prev.completed) / now.Sub(prev.time).Seconds()
+			if prev.speed > 0 {
+				speed = 0.7*prev.speed + 0.3*speed
+			}
+			current.speed = speed
+		}
+		last[job.ID] = current
+		samples[i] = Sample{Time: now, Job: job, Speed: current.speed}
+		if current.speed > 0 && job.Total > job.Completed {
+			samples[i].ETA = time.Duration(float64(job.Total-job.Completed) / current.speed * float64(time.Second))
+		}
+	}
+	m.last = last
+	return samples
+}
+
+// FormatBytes formats a number of bytes with a binary unit
+func FormatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// FormatDuration formats an ETA, rounded to seconds
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
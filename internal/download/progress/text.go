@@ -0,0 +1,152 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// Plain writes a line of text for every event and progress sample, for logs and terminals that do not
+// handle progress bars
+type Plain struct {
+	w io.Writer
+}
+
+// NewPlain returns a Plain reporter writing to w
+func NewPlain(w io.Writer) *Plain {
+	return &Plain{w: w}
+}
+
+func (r *Plain) Event(e download.Event) {
+	fmt.Fprintln(r.w, describe(e))
+}
+
+func (r *Plain) Progress(samples []Sample) {
+	for _, s := range samples {
+		fmt.Fprintf(r.w, "%s %s %s\n", s.Time.Format("15:04:05"), name(s.Job), describeProgress(s))
+	}
+}
+
+// dotSize is the number of bytes represented by a dot, and dotsPerLine the number of dots before a line break
+const (
+	dotSize     = 64 * 1024
+	dotsPerLine = 50
+)
+
+// Dot writes a line of text for every event, and a dot for every 64 KiB downloaded by all the jobs together
+// with the total at the end of each line of 50 dots, like wget's dot progress
+type Dot struct {
+	w       io.Writer
+	last    map[string]int64
+	pending int64
+	total   int64
+	column  int
+}
+
+// NewDot returns a Dot reporter writing to w
+func NewDot(w io.Writer) *Dot {
+	return &Dot{w: w, last: map[string]int64{}}
+}
+
+func (r *Dot) Event(e download.Event) {
+	r.count(e.Job)
+	if e.Type == download.EventRemoved || e.Job.State.Stopped() {
+		delete(r.last, e.Job.ID)
+	}
+	r.dots()
+	if r.column > 0 {
+		fmt.Fprintln(r.w)
+		r.column = 0
+	}
+	fmt.Fprintln(r.w, describe(e))
+}
+
+func (r *Dot) Progress(samples []Sample) {
+	for _, s := range samples {
+		r.count(s.Job)
+	}
+	r.dots()
+}
+
+// count adds the bytes downloaded by job since it was last seen
+func (r *Dot) count(job download.JobInfo) {
+	last, ok := r.last[job.ID]
+	r.last[job.ID] = job.Completed
+	if ok && job.Completed > last {
+		r.pending += job.Completed - last
+	}
+}
+
+func (r *Dot) dots() {
+	if r.pending < dotSize {
+		return
+	}
+	var b strings.Builder
+	for ; r.pending >= dotSize; r.pending -= dotSize {
+		b.WriteByte('.')
+		r.total += dotSize
+		r.column++
+		if r.column == dotsPerLine {
+			fmt.Fprintf(&b, " %s\n", FormatBytes(float64(r.total)))
+			r.column = 0
+		}
+	}
+	io.WriteString(r.w, b.String())
+}
+
+// name returns the file name of a job, or its URL when the file name is not known yet
+func name(job download.JobInfo) string {
+	if job.FileName != "" {
+		return job.FileName
+	}
+	return job.URL
+}
+
+func describeProgress(s Sample) string {
+	var b strings.Builder
+	if s.Job.Total > 0 {
+		fmt.Fprintf(&b, "%.1f%% of %s", float64(s.Job.Completed)*100/float64(s.Job.Total), FormatBytes(float64(s.Job.Total)))
+	} else {
+		b.WriteString(FormatBytes(float64(s.Job.Completed)))
+	}
+	fmt.Fprintf(&b, ", %s/s", FormatBytes(s.Speed))
+	if s.ETA > 0 {
+		fmt.Fprintf(&b, ", eta %s", FormatDuration(s.ETA))
+	}
+	return b.String()
+}
+
+// describe returns a line of text about an event
+func describe(e download.Event) string {
+	job := e.Job
+	var text string
+	switch e.Type {
+	case download.EventFileName:
+		text = fmt.Sprintf("saving %s to %s", job.URL, job.FileName)
+	case download.EventResponse:
+		text = fmt.Sprintf("%s responded in %s", job.URL, e.Latency.Round(time.Millisecond))
+	case download.EventPriority:
+		text = fmt.Sprintf("priority %d %s", job.Options.Priority, job.URL)
+	case download.EventRetry:
+		text = fmt.Sprintf("retrying %s (attempt %d): %s", job.URL, job.Retries, job.Error)
+	case download.EventCompleted:
+		text = fmt.Sprintf("completed %s, %s", name(job), FormatBytes(float64(job.Completed)))
+		if job.Digest != "" {
+			text += " " + job.Digest
+		}
+	case download.EventHook:
+		if job.HookError != "" {
+			text = fmt.Sprintf("hook failed for %s: %s", job.URL, job.HookError)
+		} else {
+			text = fmt.Sprintf("hook done for %s", job.URL)
+		}
+	case download.EventFailed:
+		text = fmt.Sprintf("failed %s: %s (%s)", job.URL, job.Error, job.ErrorClass)
+	default:
+		text = fmt.Sprintf("%s %s", e.Type, job.URL)
+	}
+	return e.Time.Format("15:04:05") + " " + text
+}
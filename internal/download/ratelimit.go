@@ -0,0 +1,105 @@
+package download
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the combined speed of the downloads that read through it to a number of bytes per second.
+// It is a token bucket that holds at most one second worth of bytes. The zero value does not limit anything
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+	waited time.Duration
+}
+
+// SetRate changes the limit, 0 removes it
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = max(bytesPerSecond, 0)
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// Rate returns the limit in bytes per second, 0 means no limit
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Waited returns the total time that reads have been held back by the limit
+func (l *RateLimiter) Waited() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waited
+}
+
+// chunk returns the largest read that should be made at once, so that a reader never sleeps for long
+func (l *RateLimiter) chunk(n int) int {
+	rate := l.Rate()
+	if rate <= 0 {
+		return n
+	}
+	return int(min(int64(n), max(rate/10, 512)))
+}
+
+// take accounts for n bytes that were read and sleeps until the rate allows them
+func (l *RateLimiter) take(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.waited += wait
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// ParseRate parses a number of bytes per second such as 500k or 2M, see ParseSize
+func ParseRate(s string) (int64, error) {
+	n, err := ParseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
+
+// ParseSize parses a number of bytes such as 500k or 2M, the suffixes k, M and G are powers of 1024
+func ParseSize(s string) (int64, error) {
+	number := strings.TrimSpace(s)
+	multiplier := int64(1)
+	if number != "" {
+		switch number[len(number)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			number = number[:len(number)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || !(n >= 0) || n*float64(multiplier) > math.MaxInt64/2 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,29 @@
+package reporter
+
+import (
+	"io"
+)
+
+// NopProgressBarFactory creates progress bars that do not display anything, it is used when there is no
+// terminal to draw on, such as in daemon mode
+type NopProgressBarFactory struct{}
+
+func (NopProgressBarFactory) CreateProgressBar(total int64, name string) ProgressBar {
+	return nopProgressBar{}
+}
+
+type nopProgressBar struct{}
+
+func (nopProgressBar) ProxyReader(r io.Reader) io.ReadCloser {
+	return io.NopCloser(r)
+}
+
+func (nopProgressBar) SetTotal(total int64, complete bool) {}
+
+func (nopProgressBar) SetCurrent(current int64) {}
+
+func (nopProgressBar) IncrBy(n int) {}
+
+func (nopProgressBar) SetPaused(paused bool) {}
+
+func (nopProgressBar) Abort(drop bool) {}
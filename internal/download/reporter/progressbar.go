@@ -2,6 +2,7 @@ package reporter
 
 import (
 	"io"
+	"sync/atomic"
 
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
@@ -11,9 +12,14 @@ type ProgressBarFactory interface {
 	CreateProgressBar(total int64, name string) ProgressBar
 }
 
+// ProgressBar displays the progress of a single download. SetPaused marks the bar as paused, or running again,
+// so that a paused download keeps its bar instead of aborting it
 type ProgressBar interface {
 	ProxyReader(r io.Reader) io.ReadCloser
 	SetTotal(total int64, complete bool)
+	SetCurrent(current int64)
+	IncrBy(n int)
+	SetPaused(paused bool)
 	Abort(drop bool)
 }
 
@@ -22,12 +28,30 @@ type MpbProgressBar struct {
 }
 
 func (pb *MpbProgressBar) CreateProgressBar(total int64, name string) ProgressBar {
-	return pb.Progress.New(total,
+	bar := &mpbBar{}
+	bar.Bar = pb.Progress.New(total,
 		mpb.BarStyle().Lbound("[").Rbound("]").Tip(">").Padding(".").Filler("="),
 		mpb.PrependDecorators(
 			decor.Name(name+": ", decor.WCSyncWidthR),
+			decor.Any(func(s decor.Statistics) string {
+				if bar.paused.Load() {
+					return "paused"
+				}
+				return ""
+			}, decor.WCSyncWidth),
 			decor.EwmaETA(decor.ET_STYLE_HHMMSS, 30, decor.WCSyncWidth),
 		),
 		mpb.AppendDecorators(decor.Percentage(), decor.Counters(decor.SizeB1024(0), " [% .1f / % .1f]")),
 	)
+	return bar
+}
+
+// mpbBar is an mpb bar that can be marked as paused
+type mpbBar struct {
+	*mpb.Bar
+	paused atomic.Bool
+}
+
+func (b *mpbBar) SetPaused(paused bool) {
+	b.paused.Store(paused)
 }
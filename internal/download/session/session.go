@@ -0,0 +1,217 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// record is a line of the session file. Job holds the latest state of a job, and Forget the ID of a job to drop
+type record struct {
+	Job    *download.JobInfo `json:"job,omitempty"`
+	Forget string            `json:"forget,omitempty"`
+}
+
+// entry is a job known to the session, seq keeps the order in which jobs were first seen
+type entry struct {
+	info download.JobInfo
+	seq  uint64
+}
+
+// Session implements download.Journal and keeps jobs in a file so that they survive restarts.
+// The file is a write-ahead log with one JSON record per line. Every record is flushed to disk with fsync
+// before the Downloader goes on, so a crash loses at most the record being written, which is ignored when the
+// file is loaded again. The log is compacted into one record per job when the session is opened and closed
+type Session struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	jobs map[string]*entry
+	seq  uint64
+}
+
+// Open loads the session file at path, creating it if it does not exist, and compacts it
+func Open(path string) (*Session, error) {
+	s := &Session{path: path, jobs: map[string]*entry{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	return s, nil
+}
+
+// load replays the records of the session file
+func (s *Session) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// Most likely a record that was being written when the process died
+			slog.Warn("skipping invalid session record", "file", s.path, "line", line, "err", err)
+			continue
+		}
+		s.apply(r)
+	}
+	return scanner.Err()
+}
+
+// apply updates the jobs with a record, the mutex must be held unless the session is being loaded
+func (s *Session) apply(r record) {
+	if r.Forget != "" {
+		delete(s.jobs, r.Forget)
+	}
+	if r.Job != nil {
+		if e, ok := s.jobs[r.Job.ID]; ok {
+			e.info = *r.Job
+		} else {
+			s.seq++
+			s.jobs[r.Job.ID] = &entry{info: *r.Job, seq: s.seq}
+		}
+	}
+}
+
+// sorted returns the entries in the order they were first seen
+func (s *Session) sorted() []*entry {
+	entries := make([]*entry, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *entry) int {
+		if a.seq < b.seq {
+			return -1
+		}
+		return 1
+	})
+	return entries
+}
+
+// compact writes one record per job to a temporary file and atomically replaces the session file with it
+func (s *Session) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, e := range s.sorted() {
+		if err := writeRecord(w, record{Job: &e.info}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	// Make the rename durable
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func writeRecord(w io.Writer, r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Jobs returns the jobs loaded from the session file in the order they were added
+func (s *Session) Jobs() []download.JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.sorted()
+	jobs := make([]download.JobInfo, len(entries))
+	for i, e := range entries {
+		jobs[i] = e.info
+	}
+	return jobs
+}
+
+// Update appends the state of a job to the session file
+func (s *Session) Update(job download.JobInfo) {
+	s.write(record{Job: &job})
+}
+
+// Forget appends a record that drops a job from the session
+func (s *Session) Forget(id string) {
+	s.write(record{Forget: id})
+}
+
+// write applies the record and appends it to the file, errors are logged as the Downloader cannot act on them
+func (s *Session) write(r record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply(r)
+	if s.file == nil {
+		return
+	}
+	if err := writeRecord(s.file, r); err != nil {
+		slog.Error("writing session", "file", s.path, "err", err)
+		return
+	}
+	if err := s.file.Sync(); err != nil {
+		slog.Error("syncing session", "file", s.path, "err", err)
+	}
+}
+
+// Close compacts the session file and closes it. Records passed to the session afterwards are not saved
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if cerr := s.compact(); err == nil {
+		err = cerr
+	}
+	return err
+}
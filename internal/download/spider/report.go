@@ -0,0 +1,105 @@
+package spider
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+)
+
+// Result is the outcome of checking a single URL.
+// FinalURL is the URL after following redirects, ContentLength is -1 when the server did not report it and
+// Method is the request method that produced the result. A link is broken if the request failed or the final
+// status code is 400 or above
+type Result struct {
+	URL           string `json:"url"`
+	FinalURL      string `json:"final_url,omitempty"`
+	Status        int    `json:"status"`
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type,omitempty"`
+	Method        string `json:"method,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Broken        bool   `json:"broken"`
+}
+
+// Report collects the results of concurrent link checks and keeps them in the order the URLs were added
+type Report struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+// Add reserves a slot for url and returns a function that stores its result
+func (r *Report) Add(url string) func(Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	index := len(r.results)
+	r.results = append(r.results, Result{URL: url, ContentLength: -1, Broken: true, Error: "not checked"})
+	return func(res Result) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		res.URL = url
+		r.results[index] = res
+	}
+}
+
+// Results returns a copy of the results
+func (r *Report) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.results...)
+}
+
+// Broken returns the number of broken links
+func (r *Report) Broken() int {
+	n := 0
+	for _, res := range r.Results() {
+		if res.Broken {
+			n++
+		}
+	}
+	return n
+}
+
+// Write prints the report to w in the given format, which is one of "table", "json" or "csv"
+func (r *Report) Write(w io.Writer, format string) error {
+	results := r.Results()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"url", "status", "final_url", "content_length", "content_type", "broken", "error"})
+		for _, res := range results {
+			cw.Write([]string{res.URL, strconv.Itoa(res.Status), res.FinalURL, strconv.FormatInt(res.ContentLength, 10),
+				res.ContentType, strconv.FormatBool(res.Broken), res.Error})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STATUS\tURL\tFINAL URL\tLENGTH\tTYPE")
+		for _, res := range results {
+			status := strconv.Itoa(res.Status)
+			if res.Error != "" {
+				status = "ERR"
+			}
+			length := "-"
+			if res.ContentLength >= 0 {
+				length = strconv.FormatInt(res.ContentLength, 10)
+			}
+			final := res.FinalURL
+			if res.Error != "" {
+				final = res.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", status, res.URL, final, length, res.ContentType)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
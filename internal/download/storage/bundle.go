@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ManifestName is the name of the manifest added at the end of a bundle
+const ManifestName = "godown-manifest.json"
+
+// BundleEntry describes a file of a bundle in its manifest
+type BundleEntry struct {
+	Name   string `json:"name"`
+	URL    string `json:"url,omitempty"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest"`
+	// Error is set for a download that failed while it was written to the archive, the entry holds what was
+	// received followed by zeros in tar archives
+	Error string `json:"error,omitempty"`
+}
+
+// BundleWriterFactory implements WriterFactory by writing every file into a single tar or zip archive, chosen by
+// the extension of the path: .tar, .tar.gz, .tgz, .tar.zst, .tzst, .tar.xz, .txz or .zip. The archive holds one
+// file at a time: a file whose size is known is written to it directly when it is free, other files are spooled
+// to temporary files in SpoolDir and added once complete. Files are named as given, with a number added to names
+// already taken, and all have the modification time of the creation of the bundle.
+// Close adds the manifest ManifestName listing every file with its URL, size and SHA-256 digest, and completes
+// the archive. JobURL, if set, returns the URL of a job for the manifest
+type BundleWriterFactory struct {
+	SpoolDir string
+	JobURL   func(id string) string
+
+	mu      sync.Mutex
+	names   map[string]string
+	entries []BundleEntry
+
+	// archive is held while a file is written to the archive
+	archive    sync.Mutex
+	file       *os.File
+	compressor io.WriteCloser
+	tw         *tar.Writer
+	zw         *zip.Writer
+	modTime    time.Time
+	closed     bool
+}
+
+// NewBundleWriterFactory creates the archive at path
+func NewBundleWriterFactory(path string) (*BundleWriterFactory, error) {
+	lower := strings.ToLower(path)
+	hasSuffix := func(suffixes ...string) bool {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(lower, suffix) {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasSuffix(".tar", ".tar.gz", ".tgz", ".tar.zst", ".tzst", ".tar.xz", ".txz", ".zip") {
+		return nil, fmt.Errorf("unknown bundle format %q, use .tar, .tar.gz, .tar.zst, .tar.xz or .zip", path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &BundleWriterFactory{
+		file:    file,
+		names:   map[string]string{ManifestName: ""},
+		modTime: time.Now().Truncate(time.Second),
+	}
+	var w io.Writer = file
+	switch {
+	case hasSuffix(".gz", ".tgz"):
+		f.compressor = gzip.NewWriter(file)
+	case hasSuffix(".zst", ".tzst"):
+		f.compressor, err = zstd.NewWriter(file)
+	case hasSuffix(".xz", ".txz"):
+		f.compressor, err = xz.NewWriter(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if f.compressor != nil {
+		w = f.compressor
+	}
+	if hasSuffix(".zip") {
+		f.zw = zip.NewWriter(w)
+	} else {
+		f.tw = tar.NewWriter(w)
+	}
+	return f, nil
+}
+
+// CreateStream creates the file fileName outside of a job
+func (f *BundleWriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	return f.ForJob("").CreateStream(fileName)
+}
+
+// ForJob returns a factory creating the files of the job id, which keeps its names when it is retried
+func (f *BundleWriterFactory) ForJob(id string) WriterFactory {
+	return &bundleJobFactory{factory: f, id: id}
+}
+
+// Entries returns the files added to the bundle so far
+func (f *BundleWriterFactory) Entries() []BundleEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]BundleEntry(nil), f.entries...)
+}
+
+// Close waits for the file being written, adds the manifest and completes the archive. Files still spooled are
+// left out
+func (f *BundleWriterFactory) Close() error {
+	f.archive.Lock()
+	defer f.archive.Unlock()
+	if f.closed {
+		return nil
+	}
+	manifest, err := json.MarshalIndent(struct {
+		Files []BundleEntry `json:"files"`
+	}{f.Entries()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifest = append(manifest, '\n')
+	errs := []error{f.writeEntry(ManifestName, manifest)}
+	f.closed = true
+	if f.tw != nil {
+		errs = append(errs, f.tw.Close())
+	} else {
+		errs = append(errs, f.zw.Close())
+	}
+	if f.compressor != nil {
+		errs = append(errs, f.compressor.Close())
+	}
+	errs = append(errs, f.file.Close())
+	return errors.Join(errs...)
+}
+
+// reserve returns a name for a file of the job id that no other job uses
+func (f *BundleWriterFactory) reserve(id, fileName string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fileName = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(fileName, "\\", "/")), "/")
+	name := fileName
+	ext := path.Ext(fileName)
+	for n := 1; ; n++ {
+		owner, taken := f.names[name]
+		if !taken || owner == id && id != "" && name != ManifestName {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(fileName, ext), n, ext)
+	}
+	f.names[name] = id
+	return name
+}
+
+func (f *BundleWriterFactory) addEntry(e BundleEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, e)
+}
+
+// createEntry starts a file of size bytes in the archive, the archive mutex must be held
+func (f *BundleWriterFactory) createEntry(name string, size int64) (io.Writer, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "write", Path: name, Err: fs.ErrClosed}
+	}
+	if f.zw != nil {
+		return f.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: f.modTime})
+	}
+	err := f.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  f.modTime,
+		Format:   tar.FormatPAX,
+	})
+	return f.tw, err
+}
+
+// writeEntry adds a file held in memory to the archive, the archive mutex must be held
+func (f *BundleWriterFactory) writeEntry(name string, data []byte) error {
+	w, err := f.createEntry(name, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// bundleJobFactory creates the files of a single job
+type bundleJobFactory struct {
+	factory *BundleWriterFactory
+	id      string
+}
+
+func (j *bundleJobFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	name := j.factory.reserve(j.id, fileName)
+	s := &bundleStream{factory: j.factory, name: name, size: -1, hash: sha256.New()}
+	if j.id != "" && j.factory.JobURL != nil {
+		s.url = j.factory.JobURL(j.id)
+	}
+	return name, s, nil
+}
+
+// bundleStream writes a file to the archive, directly or through a spool file
+type bundleStream struct {
+	factory *BundleWriterFactory
+	name    string
+	url     string
+	size    int64
+	hash    hash.Hash
+	written int64
+	started bool
+	closed  bool
+	direct  bool
+	w       io.Writer
+	spool   *os.File
+}
+
+// SetSize announces the size of the file, which can then be written to the archive without being spooled
+func (s *bundleStream) SetSize(size int64) {
+	if !s.started {
+		s.size = size
+	}
+}
+
+// start writes to the archive if the size is known and no other file is being written, and to a spool file
+// otherwise
+func (s *bundleStream) start() error {
+	s.started = true
+	f := s.factory
+	if s.size >= 0 && f.archive.TryLock() {
+		w, err := f.createEntry(s.name, s.size)
+		if err != nil {
+			f.archive.Unlock()
+			return err
+		}
+		s.direct, s.w = true, w
+		return nil
+	}
+	spool, err := os.CreateTemp(f.SpoolDir, "godown-bundle-*")
+	if err != nil {
+		return err
+	}
+	s.spool, s.w = spool, spool
+	return nil
+}
+
+func (s *bundleStream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, &fs.PathError{Op: "write", Path: s.name, Err: fs.ErrClosed}
+	}
+	if !s.started {
+		if err := s.start(); err != nil {
+			return 0, err
+		}
+	}
+	if s.direct && s.written+int64(len(p)) > s.size {
+		return 0, fmt.Errorf("%s: received more than the %d bytes announced", s.name, s.size)
+	}
+	n, err := s.w.Write(p)
+	s.hash.Write(p[:n])
+	s.written += int64(n)
+	return n, err
+}
+
+// Close adds the file to the archive, waiting for the file being written
+func (s *bundleStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	s.closed = true
+	f := s.factory
+	if s.direct {
+		defer f.archive.Unlock()
+		if s.written != s.size {
+			s.fail()
+			return fmt.Errorf("%s: received %d of the %d bytes announced", s.name, s.written, s.size)
+		}
+		s.addEntry("")
+		return nil
+	}
+
+	defer os.Remove(s.spool.Name())
+	defer s.spool.Close()
+	if _, err := s.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.archive.Lock()
+	defer f.archive.Unlock()
+	w, err := f.createEntry(s.name, s.written)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, s.spool, s.written); err != nil {
+		return err
+	}
+	s.addEntry("")
+	return nil
+}
+
+// Abort discards a spooled file. A file being written to the archive cannot be removed, so it is padded to its
+// size and recorded as failed in the manifest
+func (s *bundleStream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if !s.started {
+		return nil
+	}
+	if s.direct {
+		defer s.factory.archive.Unlock()
+		s.fail()
+		return nil
+	}
+	s.spool.Close()
+	return os.Remove(s.spool.Name())
+}
+
+// fail pads a file written to the archive that ended early, the archive mutex must be held
+func (s *bundleStream) fail() {
+	if s.factory.tw != nil && s.written < s.size {
+		io.CopyN(s.w, zeroReader{}, s.size-s.written)
+	}
+	s.addEntry(fmt.Sprintf("incomplete, received %d of %d bytes", s.written, s.size))
+}
+
+func (s *bundleStream) addEntry(errMsg string) {
+	s.factory.addEntry(BundleEntry{
+		Name:   s.name,
+		URL:    s.url,
+		Size:   s.written,
+		Digest: "sha256:" + hex.EncodeToString(s.hash.Sum(nil)),
+		Error:  errMsg,
+	})
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
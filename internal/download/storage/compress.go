@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Formats of a CompressWriterFactory
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+	CompressXz   = "xz"
+)
+
+// compressExtensions maps the formats of a CompressWriterFactory to the extension added to the files
+var compressExtensions = map[string]string{CompressGzip: ".gz", CompressZstd: ".zst", CompressXz: ".xz"}
+
+// compressContentTypes are the media types of the formats
+var compressContentTypes = map[string]string{
+	CompressGzip: "application/gzip",
+	CompressZstd: "application/zstd",
+	CompressXz:   "application/x-xz",
+}
+
+// Decorator returns a WriterFactory that changes the streams of the factory it wraps, such as to compress them
+type Decorator func(WriterFactory) WriterFactory
+
+// Decorate wraps f with the decorators, the last one being the outermost so that it sees the data first
+func Decorate(f WriterFactory, decorators ...Decorator) WriterFactory {
+	for _, decorator := range decorators {
+		f = decorator(f)
+	}
+	return f
+}
+
+// Compress returns a Decorator that compresses the files in format, see CompressWriterFactory
+func Compress(format string) (Decorator, error) {
+	if _, ok := compressExtensions[format]; !ok {
+		return nil, fmt.Errorf("unknown compression %q, use %s, %s or %s", format, CompressGzip, CompressZstd, CompressXz)
+	}
+	return func(f WriterFactory) WriterFactory {
+		return &CompressWriterFactory{Factory: f, Format: format}
+	}, nil
+}
+
+// CompressWriterFactory compresses the files saved with Factory in Format, adding the extension of the format to
+// their names. It keeps the files of every job apart if Factory does, and closes Factory when it is closed.
+// Compressed files cannot be resumed
+type CompressWriterFactory struct {
+	Factory WriterFactory
+	Format  string
+}
+
+// CreateStream creates fileName with the extension of the format added, and returns a stream that compresses
+// what is written to it
+func (f *CompressWriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	ext, ok := compressExtensions[f.Format]
+	if !ok {
+		return fileName, nil, fmt.Errorf("unknown compression %q", f.Format)
+	}
+	name, w, err := f.Factory.CreateStream(fileName + ext)
+	if err != nil {
+		return name, nil, err
+	}
+	var enc io.WriteCloser
+	switch f.Format {
+	case CompressGzip:
+		enc = gzip.NewWriter(w)
+	case CompressZstd:
+		enc, err = zstd.NewWriter(w)
+	case CompressXz:
+		enc, err = xz.NewWriter(w)
+	}
+	if err != nil {
+		Abort(w)
+		return name, nil, err
+	}
+	return name, &compressStream{WriteCloser: enc, dest: w, format: f.Format}, nil
+}
+
+// ForJob returns a factory compressing the files of the job id, if Factory keeps jobs apart
+func (f *CompressWriterFactory) ForJob(id string) WriterFactory {
+	if jf, ok := f.Factory.(JobWriterFactory); ok {
+		return &CompressWriterFactory{Factory: jf.ForJob(id), Format: f.Format}
+	}
+	return f
+}
+
+// Close closes Factory if it is an io.Closer
+func (f *CompressWriterFactory) Close() error {
+	if c, ok := f.Factory.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// compressStream compresses the data written to it into dest
+type compressStream struct {
+	io.WriteCloser
+	dest   io.WriteCloser
+	format string
+	closed bool
+}
+
+// Close ends the compressed data and closes dest
+func (s *compressStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return errors.Join(s.WriteCloser.Close(), s.dest.Close())
+}
+
+// Abort discards the compressed data and aborts dest
+func (s *compressStream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.WriteCloser.Close()
+	return Abort(s.dest)
+}
+
+// SetContentType tells dest that it stores compressed data
+func (s *compressStream) SetContentType(string) {
+	if ct, ok := s.dest.(ContentTyper); ok {
+		ct.SetContentType(compressContentTypes[s.format])
+	}
+}
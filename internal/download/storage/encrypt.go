@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptedExtension is added to the names of encrypted files
+const EncryptedExtension = ".enc"
+
+const (
+	// encMagic starts every encrypted file, the digit is the version of the format
+	encMagic = "GODOWNENC1"
+	// encHeaderSize is the size of the header: the magic, the kdf, the scrypt parameters, the salt, the nonce
+	// prefix and the chunk size
+	encHeaderSize = len(encMagic) + 1 + 3 + 16 + 16 + 4
+	// encChunkSize is the amount of plaintext sealed in every chunk
+	encChunkSize = 64 * 1024
+	// encSealedChunk is the size of a complete chunk once sealed
+	encSealedChunk = encChunkSize + chacha20poly1305.Overhead
+	// encLastChunk is set in the counter of the nonce of the last chunk, so that truncated files are detected
+	encLastChunk = 1 << 63
+
+	kdfKey    = 0
+	kdfScrypt = 1
+	// scryptLogN, scryptR and scryptP are the scrypt parameters of new files, they are stored in the header
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+// ErrDecrypt is returned when an encrypted file was modified, truncated, or encrypted with another key
+var ErrDecrypt = errors.New("cannot decrypt, the data was modified or the key is wrong")
+
+// EncryptionKey is the secret of encrypted files: a 32 byte Key, or a Passphrase stretched with scrypt and a salt
+// stored in every file
+type EncryptionKey struct {
+	Key        []byte
+	Passphrase string
+}
+
+// ParseEncryptionKey decodes a key written as 64 hex digits, or returns a 32 byte key as is
+func ParseEncryptionKey(data []byte) ([]byte, error) {
+	if len(data) == chacha20poly1305.KeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, errors.New("the key must be 32 bytes or 64 hex digits")
+	}
+	return key, nil
+}
+
+// Encrypt returns a Decorator that encrypts the files with key, see EncryptWriterFactory
+func Encrypt(key EncryptionKey) (Decorator, error) {
+	if key.Key == nil && key.Passphrase == "" {
+		return nil, errors.New("no encryption key or passphrase")
+	}
+	if key.Key != nil && len(key.Key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("the key must be %d bytes", chacha20poly1305.KeySize)
+	}
+	return func(f WriterFactory) WriterFactory {
+		return &EncryptWriterFactory{Factory: f, Key: key}
+	}, nil
+}
+
+// EncryptWriterFactory encrypts the files saved with Factory with XChaCha20-Poly1305, adding EncryptedExtension
+// to their names. The data is sealed in chunks of 64KiB whose nonces hold their position and mark the last one,
+// so that reordered, modified and truncated files are detected when they are decrypted with NewDecryptReader.
+// The files can be resumed at a chunk if Factory is a ResumableWriterFactory and a StreamOpener
+type EncryptWriterFactory struct {
+	Factory WriterFactory
+	Key     EncryptionKey
+}
+
+// CreateStream creates fileName with EncryptedExtension added, and returns a stream that encrypts what is written
+// to it
+func (f *EncryptWriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	header, aead, err := newEncHeader(f.Key)
+	if err != nil {
+		return fileName, nil, err
+	}
+	name, w, err := f.Factory.CreateStream(fileName + EncryptedExtension)
+	if err != nil {
+		return name, nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		Abort(w)
+		return name, nil, err
+	}
+	return name, newEncryptStream(w, aead, header, 0), nil
+}
+
+// ForJob returns a factory encrypting the files of the job id, if Factory keeps jobs apart
+func (f *EncryptWriterFactory) ForJob(id string) WriterFactory {
+	if jf, ok := f.Factory.(JobWriterFactory); ok {
+		return &EncryptWriterFactory{Factory: jf.ForJob(id), Key: f.Key}
+	}
+	return f
+}
+
+// Close closes Factory if it is an io.Closer
+func (f *EncryptWriterFactory) Close() error {
+	if c, ok := f.Factory.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// StreamSize returns the amount of plaintext held by the complete chunks of fileName, other than the last chunk
+func (f *EncryptWriterFactory) StreamSize(fileName string) (int64, error) {
+	_, chunks, err := f.resumable(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return chunks * encChunkSize, nil
+}
+
+// ResumeStream continues fileName after the chunk holding offset, which must be a size returned by StreamSize
+func (f *EncryptWriterFactory) ResumeStream(fileName string, offset int64) (io.WriteCloser, error) {
+	if offset%encChunkSize != 0 {
+		return nil, fmt.Errorf("%s: cannot resume encrypted file at %d, not at the end of a chunk", fileName, offset)
+	}
+	r, _, err := f.resumable(fileName)
+	if err != nil {
+		return nil, err
+	}
+	chunks := offset / encChunkSize
+	w, err := f.Factory.(ResumableWriterFactory).ResumeStream(fileName, int64(encHeaderSize)+chunks*encSealedChunk)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptStream(w, r.aead, r.header, uint64(chunks)), nil
+}
+
+// resumable reads the header of fileName and returns the number of complete chunks that can be kept. The last
+// complete chunk is checked, which makes sure that the key is the same, and dropped if it ends the file
+func (f *EncryptWriterFactory) resumable(fileName string) (*decryptReader, int64, error) {
+	rf, ok := f.Factory.(ResumableWriterFactory)
+	opener, canOpen := f.Factory.(StreamOpener)
+	if !ok || !canOpen {
+		return nil, 0, &fs.PathError{Op: "resume", Path: fileName, Err: errors.ErrUnsupported}
+	}
+	size, err := rf.StreamSize(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := opener.OpenStream(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	r, err := readEncHeader(file, f.Key)
+	if err != nil {
+		return nil, 0, err
+	}
+	chunks := (size - int64(encHeaderSize)) / encSealedChunk
+	if chunks <= 0 {
+		return r, 0, nil
+	}
+	if _, err := io.CopyN(io.Discard, file, (chunks-1)*encSealedChunk); err != nil {
+		return nil, 0, err
+	}
+	sealed := make([]byte, encSealedChunk)
+	if _, err := io.ReadFull(file, sealed); err != nil {
+		return nil, 0, err
+	}
+	if _, err := r.aead.Open(nil, r.nonce(uint64(chunks-1), false), sealed, r.header); err != nil {
+		if _, err := r.aead.Open(nil, r.nonce(uint64(chunks-1), true), sealed, r.header); err != nil {
+			return nil, 0, &fs.PathError{Op: "resume", Path: fileName, Err: ErrDecrypt}
+		}
+		chunks--
+	}
+	return r, chunks, nil
+}
+
+// newEncHeader returns the header of a new file and the cipher its chunks are sealed with
+func newEncHeader(key EncryptionKey) ([]byte, cipher.AEAD, error) {
+	header := make([]byte, 0, encHeaderSize)
+	header = append(header, encMagic...)
+	salt := make([]byte, 16)
+	prefix := make([]byte, 16)
+	rand.Read(salt)
+	rand.Read(prefix)
+	if key.Key != nil {
+		header = append(header, kdfKey, 0, 0, 0)
+	} else {
+		header = append(header, kdfScrypt, scryptLogN, scryptR, scryptP)
+	}
+	header = append(header, salt...)
+	header = append(header, prefix...)
+	header = binary.BigEndian.AppendUint32(header, encChunkSize)
+	aead, err := encCipher(header, key)
+	return header, aead, err
+}
+
+// encCipher returns the cipher of a file with header, deriving the key from the passphrase if the file uses one
+func encCipher(header []byte, key EncryptionKey) (cipher.AEAD, error) {
+	k := key.Key
+	params := header[len(encMagic):]
+	switch params[0] {
+	case kdfKey:
+		if k == nil {
+			return nil, errors.New("the file was encrypted with a key, not a passphrase")
+		}
+	case kdfScrypt:
+		if key.Passphrase == "" {
+			return nil, errors.New("the file was encrypted with a passphrase, not a key")
+		}
+		if params[1] > 22 {
+			return nil, fmt.Errorf("scrypt cost 2^%d is too large", params[1])
+		}
+		var err error
+		k, err = scrypt.Key([]byte(key.Passphrase), params[4:20], 1<<params[1], int(params[2]), int(params[3]),
+			chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown key derivation %d", params[0])
+	}
+	return chacha20poly1305.NewX(k)
+}
+
+// encryptStream seals what is written to it in chunks written to dest. A full chunk is only sealed once more data
+// is written, as the last chunk is sealed differently when the stream is closed
+type encryptStream struct {
+	dest    io.WriteCloser
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+	buf     []byte
+	sealed  []byte
+	closed  bool
+}
+
+func newEncryptStream(dest io.WriteCloser, aead cipher.AEAD, header []byte, counter uint64) *encryptStream {
+	return &encryptStream{dest: dest, aead: aead, header: header, counter: counter, buf: make([]byte, 0, encChunkSize)}
+}
+
+func (s *encryptStream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, fs.ErrClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == encChunkSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):encChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// seal writes the buffered chunk to dest
+func (s *encryptStream) seal(last bool) error {
+	s.sealed = s.aead.Seal(s.sealed[:0], encNonce(s.header, s.counter, last), s.buf, s.header)
+	s.buf = s.buf[:0]
+	s.counter++
+	_, err := s.dest.Write(s.sealed)
+	return err
+}
+
+// Close seals the last chunk and closes dest
+func (s *encryptStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if err := s.seal(true); err != nil {
+		Abort(s.dest)
+		return err
+	}
+	return s.dest.Close()
+}
+
+// Abort drops the chunk being filled and aborts dest, the complete chunks of a file can be resumed
+func (s *encryptStream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return Abort(s.dest)
+}
+
+// SetContentType tells dest that it stores opaque data
+func (s *encryptStream) SetContentType(string) {
+	if ct, ok := s.dest.(ContentTyper); ok {
+		ct.SetContentType("application/octet-stream")
+	}
+}
+
+// encNonce returns the nonce of a chunk: the nonce prefix of the header followed by the counter
+func encNonce(header []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, 0, chacha20poly1305.NonceSizeX)
+	nonce = append(nonce, header[encHeaderSize-20:encHeaderSize-4]...)
+	if last {
+		counter |= encLastChunk
+	}
+	return binary.BigEndian.AppendUint64(nonce, counter)
+}
+
+// NewDecryptReader returns a reader of the plaintext of a file written by an EncryptWriterFactory. Reads fail
+// with ErrDecrypt once a chunk that was modified is reached, or at the end of a truncated file
+func NewDecryptReader(r io.Reader, key EncryptionKey) (io.Reader, error) {
+	d, err := readEncHeader(r, key)
+	if err != nil {
+		return nil, err
+	}
+	d.src = bufio.NewReaderSize(r, encSealedChunk)
+	return d, nil
+}
+
+// readEncHeader reads and checks the header of an encrypted file
+func readEncHeader(r io.Reader, key EncryptionKey) (*decryptReader, error) {
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errors.New("not an encrypted file, it is too short")
+		}
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte(encMagic)) {
+		return nil, errors.New("not an encrypted file")
+	}
+	if size := binary.BigEndian.Uint32(header[encHeaderSize-4:]); size != encChunkSize {
+		return nil, fmt.Errorf("unsupported chunk size %d", size)
+	}
+	aead, err := encCipher(header, key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{aead: aead, header: header}, nil
+}
+
+// decryptReader opens the chunks of an encrypted file
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+	plain   []byte
+	sealed  []byte
+	done    bool
+}
+
+func (d *decryptReader) nonce(counter uint64, last bool) []byte {
+	return encNonce(d.header, counter, last)
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and opens the next chunk, which is the last one if nothing follows it
+func (d *decryptReader) open() error {
+	if d.sealed == nil {
+		d.sealed = make([]byte, encSealedChunk)
+	}
+	n, err := io.ReadFull(d.src, d.sealed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	last := n < encSealedChunk
+	if !last {
+		_, err := d.src.Peek(1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		last = errors.Is(err, io.EOF)
+	}
+	plain, err := d.aead.Open(d.sealed[:0], d.nonce(d.counter, last), d.sealed[:n], d.header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.plain = plain
+	d.counter++
+	d.done = last
+	return nil
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+var testKey = EncryptionKey{Key: bytes.Repeat([]byte{7}, 32)}
+
+// encryptBytes encrypts plain as a file saved with an EncryptWriterFactory
+func encryptBytes(t *testing.T, key EncryptionKey, plain []byte) []byte {
+	t.Helper()
+	mem := &MemoryWriterFactory{}
+	f := &EncryptWriterFactory{Factory: mem, Key: key}
+	name, w, err := f.CreateStream("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := mem.Bytes("")
+	if !ok {
+		t.Fatalf("%s was not saved", name)
+	}
+	return data
+}
+
+func decryptBytes(data []byte, key EncryptionKey) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+		plain := randomBytes(size)
+		data := encryptBytes(t, testKey, plain)
+		// An empty file still has a chunk, the last one
+		chunks := max(1, (size+encChunkSize-1)/encChunkSize)
+		if want := encHeaderSize + size + chunks*(encSealedChunk-encChunkSize); len(data) != want {
+			t.Errorf("size %d: encrypted to %d bytes, expected %d", size, len(data), want)
+		}
+		got, err := decryptBytes(data, testKey)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted data differs", size)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	plain := randomBytes(2*encChunkSize + 100)
+	data := encryptBytes(t, testKey, plain)
+	chunk := func(i int) []byte {
+		start := encHeaderSize + i*encSealedChunk
+		return data[start:min(start+encSealedChunk, len(data))]
+	}
+	tests := []struct {
+		name   string
+		tamper func() []byte
+	}{
+		{"flipped ciphertext bit", func() []byte {
+			d := bytes.Clone(data)
+			d[encHeaderSize+encSealedChunk+10] ^= 1
+			return d
+		}},
+		{"flipped header byte", func() []byte {
+			d := bytes.Clone(data)
+			// A byte of the salt, which is authenticated with every chunk
+			d[len(encMagic)+5] ^= 0x80
+			return d
+		}},
+		{"flipped nonce prefix byte", func() []byte {
+			d := bytes.Clone(data)
+			d[encHeaderSize-10] ^= 1
+			return d
+		}},
+		{"cut at a chunk boundary", func() []byte {
+			return bytes.Clone(data[:encHeaderSize+2*encSealedChunk])
+		}},
+		{"cut inside a chunk", func() []byte {
+			return bytes.Clone(data[:len(data)-1])
+		}},
+		{"chunks swapped", func() []byte {
+			d := bytes.Clone(data[:encHeaderSize])
+			d = append(d, chunk(1)...)
+			d = append(d, chunk(0)...)
+			return append(d, chunk(2)...)
+		}},
+		{"data appended", func() []byte {
+			return append(bytes.Clone(data), chunk(2)...)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decryptBytes(tt.tamper(), testKey); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("got %v, expected ErrDecrypt", err)
+			}
+		})
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	data := encryptBytes(t, testKey, randomBytes(100))
+	wrong := EncryptionKey{Key: bytes.Repeat([]byte{8}, 32)}
+	if _, err := decryptBytes(data, wrong); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("got %v, expected ErrDecrypt", err)
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	plain := randomBytes(100)
+	data := encryptBytes(t, EncryptionKey{Passphrase: "correct horse"}, plain)
+	if _, err := decryptBytes(data, EncryptionKey{Passphrase: "battery staple"}); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("got %v, expected ErrDecrypt", err)
+	}
+	got, err := decryptBytes(data, EncryptionKey{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("decrypted data differs")
+	}
+}
+
+func TestEncryptResume(t *testing.T) {
+	dir := t.TempDir()
+	plain := randomBytes(3*encChunkSize + 500)
+	f := &EncryptWriterFactory{Factory: &FSWriterFactory{BasePath: dir}, Key: testKey}
+	name, w, err := f.CreateStream("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The download is cut inside the third chunk, only the first two were written
+	if _, err := w.Write(plain[:2*encChunkSize+100]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Abort(w); err != nil {
+		t.Fatal(err)
+	}
+	offset, err := f.StreamSize(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 2*encChunkSize {
+		t.Fatalf("resumable at %d, expected %d", offset, 2*encChunkSize)
+	}
+	w, err = f.ResumeStream(name, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := (&FSWriterFactory{BasePath: dir}).OpenStream(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	dr, err := NewDecryptReader(r, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("resumed file decrypts to other data")
+	}
+}
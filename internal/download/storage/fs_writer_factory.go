@@ -1,22 +1,32 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 // FSWriterFactory implements WriterFactory and creates streams to write to local file system.
 // BasePath specifies the directory where files are created
 // An internal Mutex is used to ensure that concurrent goroutines cannot create a stream to the same file.
+// When Metadata is true, the Metadata given to the streams is saved with the files, see WriteMetadata
 type FSWriterFactory struct {
 	BasePath string
+	Metadata bool
 	mu       sync.Mutex
+
+	// index maps the urls of the files of BasePath to their names, see FindMetadata
+	indexOnce sync.Once
+	index     map[string]string
+	indexErr  error
 }
 
 // CreateStream creates a new WriterCloser stream that can be used to save the response body
@@ -63,8 +73,24 @@ func (f *FSWriterFactory) CreateStream(fileName string) (string, io.WriteCloser,
 		ctr++
 	}
 
+	// fileName may contain directories, such as the files of a multi file torrent
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fileName, nil, err
+	}
+
 	file, err := os.Create(filePath)
-	return fileName, file, err
+	if err != nil {
+		return fileName, nil, err
+	}
+	return fileName, f.stream(file), nil
+}
+
+// stream returns a stream writing to file, which saves the metadata of the file if enabled
+func (f *FSWriterFactory) stream(file *os.File) io.WriteCloser {
+	if !f.Metadata {
+		return file
+	}
+	return &fsStream{File: file}
 }
 
 // doesFileExist checks if a file exists at filePath location.
@@ -78,3 +104,195 @@ func doesFileExist(filePath string) (bool, error) {
 	}
 	return err == nil, err
 }
+
+// StreamSize returns the size of the file fileName
+func (f *FSWriterFactory) StreamSize(fileName string) (int64, error) {
+	fi, err := os.Stat(path.Join(f.BasePath, fileName))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// OpenStream opens the file fileName for reading
+func (f *FSWriterFactory) OpenStream(fileName string) (io.ReadCloser, error) {
+	return os.Open(path.Join(f.BasePath, fileName))
+}
+
+// ResumeStream opens the existing file fileName, truncates it to offset and returns a stream that writes after it.
+// The file is opened for reading too, so that a torrent can verify and seed what was written before
+func (f *FSWriterFactory) ResumeStream(fileName string, offset int64) (io.WriteCloser, error) {
+	file, err := os.OpenFile(path.Join(f.BasePath, fileName), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f.stream(file), nil
+}
+
+// FindMetadata returns the name and metadata of the file saved from url, or a nil Metadata if there is none. The
+// files of BasePath are indexed by url the first time it is called, if several were saved from the same url the
+// first one in lexical order is found
+func (f *FSWriterFactory) FindMetadata(url string) (string, *Metadata, error) {
+	f.indexOnce.Do(func() {
+		f.index, f.indexErr = indexMetadata(f.BasePath)
+	})
+	name, ok := f.index[url]
+	if !ok {
+		return "", nil, f.indexErr
+	}
+	m, err := f.ReadMetadata(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, m, nil
+}
+
+// indexMetadata maps the urls recorded in the metadata of the files of dir to their names relative to dir.
+// Hidden files and directories, such as the temporary files of ReplaceStream, are skipped
+func indexMetadata(dir string) (map[string]string, error) {
+	index := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), SidecarExtension) {
+			return nil
+		}
+		m, err := ReadMetadata(p)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := index[m.URL]; !ok {
+			index[m.URL] = filepath.ToSlash(rel)
+		}
+		return nil
+	})
+	return index, err
+}
+
+// ReplaceStream returns a stream writing a new version of the file fileName to a temporary file next to it, which is
+// renamed over fileName once the stream is closed. Aborting the stream removes the temporary file
+func (f *FSWriterFactory) ReplaceStream(fileName string) (io.WriteCloser, error) {
+	target := path.Join(f.BasePath, fileName)
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(target); err == nil {
+		file.Chmod(fi.Mode().Perm())
+	}
+	return &replaceStream{File: file, target: target, saveMetadata: f.Metadata}, nil
+}
+
+// ReadMetadata returns the metadata saved with the file fileName
+func (f *FSWriterFactory) ReadMetadata(fileName string) (*Metadata, error) {
+	return ReadMetadata(path.Join(f.BasePath, fileName))
+}
+
+// fsStream saves the metadata of a file, which is marked as complete once the file is closed. A stream that is
+// aborted keeps the file and its metadata as incomplete, so that it can be resumed
+type fsStream struct {
+	*os.File
+	metadata *Metadata
+	closed   bool
+}
+
+// SetMetadata saves m with the file as incomplete
+func (s *fsStream) SetMetadata(m Metadata) {
+	m.Downloaded = time.Time{}
+	s.metadata = &m
+	if err := WriteMetadata(s.Name(), m); err != nil {
+		slog.Warn("saving file metadata", "path", s.Name(), "err", err)
+	}
+}
+
+// Close closes the file and marks its metadata as complete
+func (s *fsStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if err := s.File.Close(); err != nil {
+		return err
+	}
+	if s.metadata == nil {
+		return nil
+	}
+	s.metadata.Downloaded = time.Now()
+	return WriteMetadata(s.Name(), *s.metadata)
+}
+
+// Abort closes the file without marking it as complete
+func (s *fsStream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.File.Close()
+}
+
+// replaceStream writes a temporary file that is renamed over target once it is closed. The metadata is only saved
+// then, so that target keeps its own until it is replaced
+type replaceStream struct {
+	*os.File
+	target       string
+	saveMetadata bool
+	metadata     *Metadata
+	closed       bool
+}
+
+// SetMetadata records m, which is saved with target once it is replaced
+func (s *replaceStream) SetMetadata(m Metadata) {
+	s.metadata = &m
+}
+
+// Close closes the temporary file, renames it over target and saves its metadata as complete
+func (s *replaceStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.File.Close()
+	if err == nil {
+		err = os.Rename(s.Name(), s.target)
+	}
+	if err != nil {
+		os.Remove(s.Name())
+		return err
+	}
+	if !s.saveMetadata || s.metadata == nil {
+		return nil
+	}
+	s.metadata.Downloaded = time.Now()
+	return WriteMetadata(s.target, *s.metadata)
+}
+
+// Abort removes the temporary file, target is kept as it was
+func (s *replaceStream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return errors.Join(s.File.Close(), os.Remove(s.Name()))
+}
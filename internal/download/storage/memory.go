@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"sync"
+)
+
+// ErrTooLarge is returned when a file does not fit within the limits of a MemoryWriterFactory
+var ErrTooLarge = errors.New("file exceeds the memory limit")
+
+// MemoryFile is a file held by a MemoryWriterFactory
+type MemoryFile struct {
+	Name string
+	Data []byte
+}
+
+// MemoryWriterFactory implements WriterFactory by keeping the files in memory, by job ID when used by a Downloader
+// and under the empty ID otherwise. Writes fail with ErrTooLarge once a file is larger than MaxSize or all the files
+// together are larger than MaxTotal, a zero limit means no limit. A file created again, such as when a download is
+// retried, replaces the earlier one, and interrupted downloads can be resumed. The files are kept until Release
+type MemoryWriterFactory struct {
+	MaxSize  int64
+	MaxTotal int64
+
+	mu    sync.Mutex
+	jobs  map[string][]*memoryFile
+	total int64
+}
+
+type memoryFile struct {
+	name string
+	data []byte
+}
+
+// CreateStream creates the file fileName of the empty job ID
+func (f *MemoryWriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	return f.ForJob("").CreateStream(fileName)
+}
+
+// ForJob returns a factory creating the files of the job id
+func (f *MemoryWriterFactory) ForJob(id string) WriterFactory {
+	return &memoryJobFactory{factory: f, id: id}
+}
+
+// Bytes returns the content of the file saved by the job id, or of the first one if it saved several
+func (f *MemoryWriterFactory) Bytes(id string) ([]byte, bool) {
+	files := f.Files(id)
+	if len(files) == 0 {
+		return nil, false
+	}
+	return files[0].Data, true
+}
+
+// Files returns the files saved by the job id in the order they were created. The data must not be modified
+func (f *MemoryWriterFactory) Files(id string) []MemoryFile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var files []MemoryFile
+	for _, file := range f.jobs[id] {
+		files = append(files, MemoryFile{Name: file.name, Data: file.data})
+	}
+	return files
+}
+
+// Release forgets the files of the job id, which no longer count towards MaxTotal
+func (f *MemoryWriterFactory) Release(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, file := range f.jobs[id] {
+		f.total -= int64(len(file.data))
+	}
+	delete(f.jobs, id)
+}
+
+// find returns the file of a job, the mutex must be held
+func (f *MemoryWriterFactory) find(id, fileName string) *memoryFile {
+	i := slices.IndexFunc(f.jobs[id], func(file *memoryFile) bool { return file.name == fileName })
+	if i < 0 {
+		return nil
+	}
+	return f.jobs[id][i]
+}
+
+// truncate shortens a file to size, the mutex must be held
+func (f *MemoryWriterFactory) truncate(file *memoryFile, size int64) {
+	f.total -= int64(len(file.data)) - size
+	file.data = file.data[:size]
+}
+
+// memoryJobFactory creates the files of a single job
+type memoryJobFactory struct {
+	factory *MemoryWriterFactory
+	id      string
+}
+
+func (j *memoryJobFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	f := j.factory
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file := f.find(j.id, fileName)
+	if file != nil {
+		f.truncate(file, 0)
+	} else {
+		if f.jobs == nil {
+			f.jobs = map[string][]*memoryFile{}
+		}
+		file = &memoryFile{name: fileName}
+		f.jobs[j.id] = append(f.jobs[j.id], file)
+	}
+	return fileName, &memoryStream{factory: f, file: file}, nil
+}
+
+// StreamSize returns the size of the file fileName
+func (j *memoryJobFactory) StreamSize(fileName string) (int64, error) {
+	j.factory.mu.Lock()
+	defer j.factory.mu.Unlock()
+	file := j.factory.find(j.id, fileName)
+	if file == nil {
+		return 0, &fs.PathError{Op: "stat", Path: fileName, Err: fs.ErrNotExist}
+	}
+	return int64(len(file.data)), nil
+}
+
+// ResumeStream truncates the file fileName to offset and returns a stream that writes after it
+func (j *memoryJobFactory) ResumeStream(fileName string, offset int64) (io.WriteCloser, error) {
+	f := j.factory
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file := f.find(j.id, fileName)
+	if file == nil {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: fs.ErrNotExist}
+	}
+	if offset > int64(len(file.data)) {
+		return nil, &fs.PathError{Op: "truncate", Path: fileName, Err: fs.ErrInvalid}
+	}
+	f.truncate(file, offset)
+	return &memoryStream{factory: f, file: file}, nil
+}
+
+// memoryStream appends to a file held in memory
+type memoryStream struct {
+	factory *MemoryWriterFactory
+	file    *memoryFile
+}
+
+func (s *memoryStream) Write(p []byte) (int, error) {
+	f := s.factory
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	size := int64(len(s.file.data)) + int64(len(p))
+	if f.MaxSize > 0 && size > f.MaxSize || f.MaxTotal > 0 && f.total+int64(len(p)) > f.MaxTotal {
+		return 0, &fs.PathError{Op: "write", Path: s.file.name, Err: ErrTooLarge}
+	}
+	s.file.data = append(s.file.data, p...)
+	f.total += int64(len(p))
+	return len(p), nil
+}
+
+func (s *memoryStream) Close() error {
+	return nil
+}
+
+// WriterFunc adapts a function returning the writer of every download to a WriterFactory. It is called with the ID
+// of the job, empty outside of a Downloader, and the name of the file. Writers that are io.Closer are closed when
+// the download ends
+type WriterFunc func(id, fileName string) (io.Writer, error)
+
+// CreateStream returns the writer of the file fileName of the empty job ID
+func (fn WriterFunc) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	return fn.ForJob("").CreateStream(fileName)
+}
+
+// ForJob returns a factory calling fn with the job id
+func (fn WriterFunc) ForJob(id string) WriterFactory {
+	return jobWriterFunc{fn: fn, id: id}
+}
+
+type jobWriterFunc struct {
+	fn WriterFunc
+	id string
+}
+
+func (j jobWriterFunc) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	w, err := j.fn(j.id, fileName)
+	if err != nil {
+		return fileName, nil, err
+	}
+	if wc, ok := w.(io.WriteCloser); ok {
+		return fileName, wc, nil
+	}
+	return fileName, nopCloser{w}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// writeFile creates fileName with factory and writes data to it
+func writeFile(factory WriterFactory, fileName, data string) error {
+	_, w, err := factory.CreateStream(fileName)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, data)
+	return errors.Join(err, w.Close())
+}
+
+func TestMemoryLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		factory *MemoryWriterFactory
+		files   []string
+		failing int
+	}{
+		{"no limits", &MemoryWriterFactory{}, []string{"12345", "12345"}, -1},
+		{"within size", &MemoryWriterFactory{MaxSize: 5}, []string{"12345", "12345"}, -1},
+		{"over size", &MemoryWriterFactory{MaxSize: 5}, []string{"1234", "123456"}, 1},
+		{"within total", &MemoryWriterFactory{MaxTotal: 10}, []string{"12345", "12345"}, -1},
+		{"over total", &MemoryWriterFactory{MaxTotal: 10}, []string{"12345", "123456"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, data := range tt.files {
+				// Every file belongs to another job, the limits apply to each file and to all the jobs together
+				err := writeFile(tt.factory.ForJob(string(rune('a'+i))), "file", data)
+				if i == tt.failing {
+					if !errors.Is(err, ErrTooLarge) {
+						t.Errorf("file %d: error %v, expected %v", i, err, ErrTooLarge)
+					}
+				} else if err != nil {
+					t.Errorf("file %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryJobs(t *testing.T) {
+	f := &MemoryWriterFactory{MaxTotal: 30}
+	for _, file := range []struct{ id, name, data string }{
+		{"1", "a", "first"},
+		{"1", "b", "second"},
+		{"2", "a", "other job"},
+		{"", "a", "no job"},
+	} {
+		factory := f.ForJob(file.id)
+		if file.id == "" {
+			factory = f
+		}
+		if err := writeFile(factory, file.name, file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, ok := f.Bytes("1"); !ok || string(data) != "first" {
+		t.Errorf("job 1 holds %q, expected its first file", data)
+	}
+	if files := f.Files("1"); len(files) != 2 || files[1].Name != "b" || string(files[1].Data) != "second" {
+		t.Errorf("job 1 files %+v", files)
+	}
+	if data, ok := f.Bytes("2"); !ok || string(data) != "other job" {
+		t.Errorf("job 2 holds %q", data)
+	}
+	if data, ok := f.Bytes(""); !ok || string(data) != "no job" {
+		t.Errorf("empty job holds %q", data)
+	}
+	if _, ok := f.Bytes("3"); ok {
+		t.Error("unknown job has a file")
+	}
+
+	// The files of job 1 take 11 of the 30 bytes until they are released
+	if err := writeFile(f.ForJob("3"), "a", "0123456789"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("error %v, expected %v", err, ErrTooLarge)
+	}
+	f.Release("1")
+	f.Release("3")
+	if _, ok := f.Bytes("1"); ok {
+		t.Error("released job still has files")
+	}
+	if err := writeFile(f.ForJob("3"), "a", "0123456789"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryRetryAndResume(t *testing.T) {
+	f := &MemoryWriterFactory{MaxTotal: 10}
+	job := f.ForJob("1")
+	if err := writeFile(job, "a", "0123456789"); err != nil {
+		t.Fatal(err)
+	}
+	// Creating the file again replaces it and frees its space
+	if err := writeFile(job, "a", "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	rf := job.(ResumableWriterFactory)
+	size, err := rf.StreamSize("a")
+	if err != nil || size != 6 {
+		t.Fatalf("size %d (%v), expected 6", size, err)
+	}
+	w, err := rf.ResumeStream("a", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "DEFGHIJ")
+	w.Close()
+	if data, _ := f.Bytes("1"); !bytes.Equal(data, []byte("abcDEFGHIJ")) {
+		t.Errorf("file holds %q, expected abcDEFGHIJ", data)
+	}
+	if _, err := rf.ResumeStream("a", 11); err == nil {
+		t.Error("resumed past the end of the file")
+	}
+	if _, err := rf.StreamSize("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error %v, expected %v", err, fs.ErrNotExist)
+	}
+	if _, err := f.ForJob("2").(ResumableWriterFactory).ResumeStream("a", 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("resumed the file of another job: %v", err)
+	}
+}
+
+// closingBuffer records whether it was closed
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestWriterFunc(t *testing.T) {
+	buffers := map[string]io.Writer{}
+	fn := WriterFunc(func(id, fileName string) (io.Writer, error) {
+		if fileName == "fail" {
+			return nil, errors.New("no writer")
+		}
+		var w io.Writer = &bytes.Buffer{}
+		if id == "closing" {
+			w = &closingBuffer{}
+		}
+		buffers[id+"/"+fileName] = w
+		return w, nil
+	})
+	if err := writeFile(fn, "a", "no job"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fn.ForJob("closing"), "b", "closing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fn.ForJob("1").CreateStream("fail"); err == nil {
+		t.Error("error of the function was not returned")
+	}
+	if b, ok := buffers["/a"].(*bytes.Buffer); !ok || b.String() != "no job" {
+		t.Errorf("writer of the empty job holds %v", buffers["/a"])
+	}
+	if b, ok := buffers["closing/b"].(*closingBuffer); !ok || b.String() != "closing" || !b.closed {
+		t.Errorf("closing writer %+v, expected it written and closed", buffers["closing/b"])
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SidecarExtension is added to the name of a file to name the sidecar holding its metadata, when the file system
+// does not support extended attributes
+const SidecarExtension = ".godown.json"
+
+// ErrNoMetadata is returned by ReadMetadata for files that were not saved with their metadata
+var ErrNoMetadata = errors.New("no download metadata")
+
+// Metadata records where a file was downloaded from. URL is the url that was requested and FinalURL the one that
+// answered after redirects. Downloaded is the time the download completed, it is zero while the file is incomplete.
+// Digest is the SHA-256 of the file if it is known
+type Metadata struct {
+	URL          string    `json:"url"`
+	FinalURL     string    `json:"finalUrl,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	Downloaded   time.Time `json:"downloaded,omitzero"`
+	Digest       string    `json:"digest,omitempty"`
+}
+
+// Complete reports whether the file was completely downloaded
+func (m *Metadata) Complete() bool {
+	return !m.Downloaded.IsZero()
+}
+
+// metadataXattrs are the extended attributes holding the fields of Metadata other than Downloaded. The url and the
+// media type use the names of the freedesktop.org conventions, which other tools understand as well
+var metadataXattrs = []struct {
+	name  string
+	field func(m *Metadata) *string
+}{
+	{"user.xdg.origin.url", func(m *Metadata) *string { return &m.URL }},
+	{"user.godown.final_url", func(m *Metadata) *string { return &m.FinalURL }},
+	{"user.godown.etag", func(m *Metadata) *string { return &m.ETag }},
+	{"user.godown.last_modified", func(m *Metadata) *string { return &m.LastModified }},
+	{"user.mime_type", func(m *Metadata) *string { return &m.ContentType }},
+	{"user.godown.digest", func(m *Metadata) *string { return &m.Digest }},
+}
+
+// downloadedXattr holds Metadata.Downloaded in RFC 3339 format
+const downloadedXattr = "user.godown.downloaded"
+
+// WriteMetadata stores m in extended attributes of the file at path, or in its sidecar file if the file system does
+// not support them
+func WriteMetadata(path string, m Metadata) error {
+	err := writeXattrs(path, m)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return writeSidecar(path, m)
+	}
+	return err
+}
+
+func writeXattrs(path string, m Metadata) error {
+	for _, attr := range metadataXattrs {
+		if err := setXattr(path, attr.name, *attr.field(&m)); err != nil {
+			return err
+		}
+	}
+	var downloaded string
+	if m.Complete() {
+		downloaded = m.Downloaded.UTC().Format(time.RFC3339)
+	}
+	return setXattr(path, downloadedXattr, downloaded)
+}
+
+// writeSidecar replaces the sidecar of the file at path with m
+func writeSidecar(path string, m Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".godown-metadata-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path+SidecarExtension)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// ReadMetadata returns the metadata of the file at path from its extended attributes or its sidecar file, or an
+// error wrapping ErrNoMetadata if it has none
+func ReadMetadata(path string) (*Metadata, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	m, err := readXattrs(path)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return nil, err
+	}
+	if m != nil {
+		return m, nil
+	}
+	data, err := os.ReadFile(path + SidecarExtension)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, ErrNoMetadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m = &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path+SidecarExtension, err)
+	}
+	return m, nil
+}
+
+// readXattrs returns the metadata held in extended attributes of the file at path, or nil if it has no url
+func readXattrs(path string) (*Metadata, error) {
+	m := &Metadata{}
+	for _, attr := range metadataXattrs {
+		value, err := getXattr(path, attr.name)
+		if err != nil {
+			return nil, err
+		}
+		*attr.field(m) = value
+	}
+	if m.URL == "" {
+		return nil, nil
+	}
+	downloaded, err := getXattr(path, downloadedXattr)
+	if err != nil {
+		return nil, err
+	}
+	if downloaded != "" {
+		if m.Downloaded, err = time.Parse(time.RFC3339, downloaded); err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %w", path, downloadedXattr, err)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setXattr sets the extended attribute name of path to value, or removes it if value is empty
+func setXattr(path, name, value string) error {
+	var err error
+	if value == "" {
+		err = syscall.Removexattr(path, name)
+		if errors.Is(err, syscall.ENODATA) {
+			return nil
+		}
+	} else {
+		err = syscall.Setxattr(path, name, []byte(value), 0)
+	}
+	return xattrError("setxattr", path, err)
+}
+
+// getXattr returns the extended attribute name of path, or an empty string if it is not set
+func getXattr(path, name string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := syscall.Getxattr(path, name, buf)
+		switch {
+		case errors.Is(err, syscall.ENODATA):
+			return "", nil
+		case errors.Is(err, syscall.ERANGE):
+			buf = make([]byte, len(buf)*4)
+		case err != nil:
+			return "", xattrError("getxattr", path, err)
+		default:
+			return string(buf[:n]), nil
+		}
+	}
+}
+
+// xattrError reports file systems without extended attributes as errors.ErrUnsupported
+func xattrError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, syscall.ENOTSUP) {
+		err = errors.ErrUnsupported
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
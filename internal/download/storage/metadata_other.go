@@ -0,0 +1,16 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+func setXattr(path, name, value string) error {
+	return &os.PathError{Op: "setxattr", Path: path, Err: errors.ErrUnsupported}
+}
+
+func getXattr(path, name string) (string, error) {
+	return "", &os.PathError{Op: "getxattr", Path: path, Err: errors.ErrUnsupported}
+}
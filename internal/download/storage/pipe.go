@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// maxPipeOutput is the amount of the stderr of a command kept for its error
+const maxPipeOutput = 4096
+
+// PipeWriterFactory implements WriterFactory by starting Command with the shell for every download and writing the
+// data to its stdin. The command runs in Dir, gets the file name as $1 and in GODOWN_FILENAME, and the ID of the
+// job in GODOWN_ID, and shares the stdout and stderr of godown. Closing the stream waits for the command and fails
+// if it did, aborting it kills the command
+type PipeWriterFactory struct {
+	Command string
+	Dir     string
+}
+
+// CreateStream starts the command for the file fileName
+func (f *PipeWriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	return f.ForJob("").CreateStream(fileName)
+}
+
+// ForJob returns a factory starting the command for the job id
+func (f *PipeWriterFactory) ForJob(id string) WriterFactory {
+	return WriterFunc(func(_, fileName string) (io.Writer, error) {
+		return f.start(id, fileName)
+	})
+}
+
+func (f *PipeWriterFactory) start(id, fileName string) (*pipeStream, error) {
+	if f.Dir != "" {
+		if err := os.MkdirAll(f.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", f.Command)
+	} else {
+		// $0 is godown and $1 the file name
+		cmd = exec.Command("sh", "-c", f.Command, "godown", fileName)
+	}
+	cmd.Dir = f.Dir
+	cmd.Env = append(os.Environ(), "GODOWN_ID="+id, "GODOWN_FILENAME="+fileName)
+	s := &pipeStream{command: f.Command, cmd: cmd, stderr: &tailBuffer{limit: maxPipeOutput}}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, s.stderr)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.stdin = stdin
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("pipe to %q: %w", f.Command, err)
+	}
+	return s, nil
+}
+
+// pipeStream writes to the stdin of a running command
+type pipeStream struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  *tailBuffer
+	done    bool
+	err     error
+}
+
+func (s *pipeStream) Write(p []byte) (int, error) {
+	n, err := s.stdin.Write(p)
+	if err != nil {
+		// The command stopped reading, its exit status tells why
+		if waitErr := s.wait(); waitErr != nil {
+			return n, waitErr
+		}
+		return n, fmt.Errorf("pipe to %q: %w", s.command, err)
+	}
+	return n, nil
+}
+
+// Close ends the input of the command and waits for it to exit
+func (s *pipeStream) Close() error {
+	s.stdin.Close()
+	return s.wait()
+}
+
+// Abort kills the command
+func (s *pipeStream) Abort() error {
+	if !s.done {
+		s.cmd.Process.Kill()
+	}
+	s.wait()
+	return nil
+}
+
+func (s *pipeStream) wait() error {
+	if s.done {
+		return s.err
+	}
+	s.done = true
+	if err := s.cmd.Wait(); err != nil {
+		if output := strings.TrimSpace(s.stderr.String()); output != "" {
+			s.err = fmt.Errorf("pipe to %q: %w: %s", s.command, err, output)
+		} else {
+			s.err = fmt.Errorf("pipe to %q: %w", s.command, err)
+		}
+	}
+	return s.err
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.Buffer.Write(p)
+	if extra := b.Len() - b.limit; extra > 0 {
+		b.Next(extra)
+	}
+	return n, nil
+}
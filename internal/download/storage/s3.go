@@ -0,0 +1,434 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultS3PartSize is the size of the parts of multipart uploads when S3WriterFactory.PartSize is not set
+	DefaultS3PartSize = 8 << 20
+	// MinS3PartSize is the smallest part S3 accepts, except for the last part of an upload
+	MinS3PartSize = 5 << 20
+	// s3Retries is the number of times a request is sent again when the service is unavailable
+	s3Retries = 3
+	// s3Timeout limits the time taken by a single request
+	s3Timeout = 5 * time.Minute
+)
+
+// Server side encryption modes of S3WriterFactory.SSE
+const (
+	S3SSEAES256 = "AES256"
+	S3SSEKMS    = "aws:kms"
+)
+
+// S3WriterFactory implements WriterFactory by uploading the files to an S3 compatible bucket, under Prefix.
+// Endpoint is the URL of the service, such as https://s3.eu-west-1.amazonaws.com or http://localhost:9000, and
+// the bucket is added to its path when PathStyle is set, which most S3 compatible servers expect, or to its host
+// name otherwise. Requests are signed with AWS Signature Version 4 unless AccessKey is empty.
+// Files are sent with a multipart upload, a part at a time while they are downloaded, and files smaller than a
+// part with a single request once they are complete. Parts are PartSize bytes, doubling every 1000 parts to stay
+// within the limit of 10000 parts, or end with a segment of the download if the task reports segments and at least
+// MinS3PartSize bytes are pending. Uploads are aborted when the download fails.
+// SSE enables server side encryption, S3SSEAES256 or S3SSEKMS with the key SSEKMSKeyID, or the default key of the
+// bucket if it is empty. Objects with the same name are replaced
+type S3WriterFactory struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	PathStyle    bool
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	SSE          string
+	SSEKMSKeyID  string
+	PartSize     int64
+	Client       *http.Client
+}
+
+// CreateStream returns a stream uploading to the object named fileName under Prefix
+func (f *S3WriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	if f.Bucket == "" {
+		return fileName, nil, errors.New("no s3 bucket")
+	}
+	partSize := f.PartSize
+	if partSize <= 0 {
+		partSize = DefaultS3PartSize
+	}
+	partSize = max(partSize, MinS3PartSize)
+	return fileName, &s3Stream{factory: f, key: path.Join(f.Prefix, fileName), partSize: partSize}, nil
+}
+
+// URL returns the s3:// url of the object named fileName
+func (f *S3WriterFactory) URL(fileName string) string {
+	return "s3://" + f.Bucket + "/" + path.Join(f.Prefix, fileName)
+}
+
+// s3Stream buffers the data written to it and uploads it in parts, the upload is started with the first part
+type s3Stream struct {
+	factory     *S3WriterFactory
+	key         string
+	partSize    int64
+	contentType string
+	buf         bytes.Buffer
+	uploadID    string
+	parts       []s3Part
+	closed      bool
+}
+
+func (s *s3Stream) SetContentType(contentType string) {
+	s.contentType = contentType
+}
+
+func (s *s3Stream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, fs.ErrClosed
+	}
+	s.buf.Write(p)
+	for int64(s.buf.Len()) >= s.currentPartSize() {
+		if err := s.uploadPart(s.buf.Next(int(s.currentPartSize()))); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// EndSegment uploads the pending data as a part if it is large enough
+func (s *s3Stream) EndSegment() error {
+	if s.closed || s.buf.Len() < MinS3PartSize {
+		return nil
+	}
+	return s.uploadPart(s.buf.Next(s.buf.Len()))
+}
+
+// currentPartSize doubles the part size every 1000 parts, S3 allows at most 10000
+func (s *s3Stream) currentPartSize() int64 {
+	return s.partSize << (len(s.parts) / 1000)
+}
+
+// Close uploads the rest of the data and completes the upload, which is aborted if that fails
+func (s *s3Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.uploadID == "" {
+		return s.put()
+	}
+	if s.buf.Len() > 0 {
+		if err := s.uploadPart(s.buf.Next(s.buf.Len())); err != nil {
+			s.abort()
+			return err
+		}
+	}
+	if err := s.complete(); err != nil {
+		s.abort()
+		return err
+	}
+	return nil
+}
+
+// Abort discards the parts uploaded so far
+func (s *s3Stream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.buf.Reset()
+	if s.uploadID == "" {
+		return nil
+	}
+	return s.abort()
+}
+
+// objectHeader returns the headers given when the object is created
+func (s *s3Stream) objectHeader() http.Header {
+	header := http.Header{}
+	if s.contentType != "" {
+		header.Set("Content-Type", s.contentType)
+	}
+	if s.factory.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", s.factory.SSE)
+		if s.factory.SSE == S3SSEKMS && s.factory.SSEKMSKeyID != "" {
+			header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.factory.SSEKMSKeyID)
+		}
+	}
+	return header
+}
+
+// put uploads a file smaller than a part with a single request
+func (s *s3Stream) put() error {
+	_, _, err := s.factory.do("put", http.MethodPut, s.key, nil, s.objectHeader(), s.buf.Bytes())
+	if err == nil {
+		slog.Info("uploaded object", "bucket", s.factory.Bucket, "key", s.key, "bytes", s.buf.Len())
+	}
+	return err
+}
+
+func (s *s3Stream) uploadPart(data []byte) error {
+	if s.uploadID == "" {
+		_, body, err := s.factory.do("create upload", http.MethodPost, s.key, url.Values{"uploads": {""}}, s.objectHeader(), nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil || result.UploadID == "" {
+			return s.factory.pathError("create upload", s.key, fmt.Errorf("invalid response to create multipart upload: %q", body))
+		}
+		s.uploadID = result.UploadID
+		slog.Info("started multipart upload", "bucket", s.factory.Bucket, "key", s.key, "upload", s.uploadID)
+	}
+	number := len(s.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {s.uploadID}}
+	header, _, err := s.factory.do("upload part", http.MethodPut, s.key, query, nil, data)
+	if err != nil {
+		return err
+	}
+	s.parts = append(s.parts, s3Part{Number: number, ETag: header.Get("ETag")})
+	slog.Debug("uploaded part", "key", s.key, "part", number, "bytes", len(data))
+	return nil
+}
+
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (s *s3Stream) complete() error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: s.parts})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	_, _, err = s.factory.do("complete upload", http.MethodPost, s.key, url.Values{"uploadId": {s.uploadID}}, header, body)
+	if err == nil {
+		slog.Info("completed multipart upload", "bucket", s.factory.Bucket, "key", s.key, "parts", len(s.parts))
+	}
+	return err
+}
+
+func (s *s3Stream) abort() error {
+	_, _, err := s.factory.do("abort upload", http.MethodDelete, s.key, url.Values{"uploadId": {s.uploadID}}, nil, nil)
+	if err != nil {
+		slog.Error("aborting multipart upload", "bucket", s.factory.Bucket, "key", s.key, "err", err)
+		return err
+	}
+	slog.Info("aborted multipart upload", "bucket", s.factory.Bucket, "key", s.key, "upload", s.uploadID)
+	return nil
+}
+
+// s3Error is the body of an S3 error response
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// do sends a request about the object key, retrying when the service is unavailable, and returns the header and
+// the body of the response. Errors are *fs.PathError with op describing the request
+func (f *S3WriterFactory) do(op, method, key string, query url.Values, header http.Header, body []byte) (http.Header, []byte, error) {
+	var err error
+	for attempt := 0; attempt <= s3Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		var respHeader http.Header
+		var respBody []byte
+		var retry bool
+		respHeader, respBody, retry, err = f.send(method, key, query, header, body)
+		if err == nil {
+			return respHeader, respBody, nil
+		}
+		if !retry {
+			break
+		}
+		slog.Warn("retrying s3 request", "op", op, "key", key, "attempt", attempt+1, "err", err)
+	}
+	return nil, nil, f.pathError(op, key, err)
+}
+
+// send sends a request once and reports whether it may be sent again after a failure. S3 may report an error
+// in the body of a 200 response to a request completing an upload
+func (f *S3WriterFactory) send(method, key string, query url.Values, header http.Header, body []byte) (http.Header, []byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	req, err := f.newRequest(ctx, method, key, query, header, body)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	var s3Err s3Error
+	isError := xml.Unmarshal(respBody, &s3Err) == nil
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || isError {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || s3Err.Code == "InternalError" ||
+			s3Err.Code == "SlowDown"
+		if s3Err.Code != "" {
+			return nil, nil, retry, fmt.Errorf("%s: %s (%s)", s3Err.Code, s3Err.Message, resp.Status)
+		}
+		return nil, nil, retry, errors.New(resp.Status)
+	}
+	return resp.Header, respBody, false, nil
+}
+
+func (f *S3WriterFactory) pathError(op, key string, err error) error {
+	return &fs.PathError{Op: op, Path: "s3://" + f.Bucket + "/" + key, Err: err}
+}
+
+// newRequest creates a signed request about the object key
+func (f *S3WriterFactory) newRequest(ctx context.Context, method, key string, query url.Values, header http.Header,
+	body []byte) (*http.Request, error) {
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + f.region() + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	objectPath := "/" + s3Escape(key, false)
+	if f.PathStyle {
+		objectPath = "/" + s3Escape(f.Bucket, true) + objectPath
+	} else {
+		u.Host = f.Bucket + "." + u.Host
+	}
+	canonicalQuery := s3Query(query)
+	rawURL := u.Scheme + "://" + u.Host + u.EscapedPath() + objectPath
+	if canonicalQuery != "" {
+		rawURL += "?" + canonicalQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", "godown")
+	if f.AccessKey != "" {
+		f.sign(req, u.EscapedPath()+objectPath, canonicalQuery, body, time.Now().UTC())
+	}
+	return req, nil
+}
+
+func (f *S3WriterFactory) region() string {
+	if f.Region == "" {
+		return "us-east-1"
+	}
+	return f.Region
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (f *S3WriterFactory) sign(req *http.Request, canonicalPath, canonicalQuery string, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if f.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", f.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + f.region() + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+f.SecretKey), date)
+	key = hmacSHA256(key, f.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+f.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query returns the canonical form of a query string, sorted by name
+func s3Query(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of RFC 3986, and slashes unless encodeSlash
+// is set, as Signature Version 4 requires
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
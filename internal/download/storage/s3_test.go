@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testS3 is an S3 stand-in serving a single bucket with path style requests
+type testS3 struct {
+	mu        sync.Mutex
+	bucket    string
+	objects   map[string][]byte
+	headers   map[string]http.Header
+	uploads   map[string]*testUpload
+	completed [][]s3Part
+	sizes     [][]int
+	aborted   []string
+	nextID    int
+}
+
+type testUpload struct {
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+func newTestS3(t *testing.T) (*testS3, *S3WriterFactory) {
+	s := &testS3{bucket: "bucket", objects: map[string][]byte{}, headers: map[string]http.Header{},
+		uploads: map[string]*testUpload{}}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	f := &S3WriterFactory{Endpoint: server.URL, Region: "us-east-1", Bucket: s.bucket, Prefix: "downloads",
+		PathStyle: true, AccessKey: "access", SecretKey: "secret"}
+	return s, f
+}
+
+func (s *testS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>unsigned request</Message></Error>")
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.bucket+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	q := r.URL.Query()
+	id := q.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &testUpload{key: key, header: r.Header.Clone(), parts: map[int][]byte{}}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && id != "":
+		number, _ := strconv.Atoi(q.Get("partNumber"))
+		s.uploads[id].parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, id, number))
+	case r.Method == http.MethodPost && id != "":
+		var req struct {
+			Parts []s3Part `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		upload := s.uploads[id]
+		var object []byte
+		var sizes []int
+		for i, p := range req.Parts {
+			if p.ETag != fmt.Sprintf(`"%s-%d"`, id, p.Number) || (i < len(req.Parts)-1 && len(upload.parts[p.Number]) < MinS3PartSize) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>invalid part</Message></Error>")
+				return
+			}
+			object = append(object, upload.parts[p.Number]...)
+			sizes = append(sizes, len(upload.parts[p.Number]))
+		}
+		s.objects[key] = object
+		s.headers[key] = upload.header
+		s.completed = append(s.completed, req.Parts)
+		s.sizes = append(s.sizes, sizes)
+		delete(s.uploads, id)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && id != "":
+		delete(s.uploads, id)
+		s.aborted = append(s.aborted, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+		s.headers[key] = r.Header.Clone()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadedParts returns the sizes of the parts of the upload that is in progress
+func (s *testS3) uploadedParts() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, upload := range s.uploads {
+		for number := 1; number <= len(upload.parts); number++ {
+			sizes = append(sizes, len(upload.parts[number]))
+		}
+	}
+	return sizes
+}
+
+func TestS3SegmentedUpload(t *testing.T) {
+	s, f := newTestS3(t)
+	f.SSE = S3SSEKMS
+	f.SSEKMSKeyID = "key-id"
+	const mib = 1 << 20
+	segments := []int{6 * mib, 3 * mib, 4 * mib, 2*mib + 100, 1 * mib}
+	data := randomBytes(6*mib + 3*mib + 4*mib + 2*mib + 100 + 1*mib)
+	name, w, err := f.CreateStream("video.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.(ContentTyper).SetContentType("video/mp2t")
+	var boundaries []int
+	offset := 0
+	for _, size := range segments {
+		if _, err := w.Write(data[offset : offset+size]); err != nil {
+			t.Fatal(err)
+		}
+		offset += size
+		boundaries = append(boundaries, offset)
+		if err := w.(SegmentWriter).EndSegment(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "downloads/" + name
+	if !bytes.Equal(s.objects[key], data) {
+		t.Fatalf("the object %s differs from the data written", key)
+	}
+	if len(s.completed) != 1 {
+		t.Fatalf("%d uploads completed, expected 1", len(s.completed))
+	}
+	parts := s.completed[0]
+	// 6MiB is sent with the first segment, the next two make 7MiB and the rest is sent once the stream is closed
+	if want := 3; len(parts) != want {
+		t.Fatalf("%d parts, expected %d", len(parts), want)
+	}
+	end := 0
+	for i, p := range parts {
+		if p.Number != i+1 {
+			t.Errorf("part %d is numbered %d", i+1, p.Number)
+		}
+		size := s.sizes[0][i]
+		end += size
+		if !slices.Contains(boundaries, end) {
+			t.Errorf("part %d ends at %d, which is not the end of a segment", p.Number, end)
+		}
+		if i < len(parts)-1 && size < MinS3PartSize {
+			t.Errorf("part %d has %d bytes, less than the minimum part size", p.Number, size)
+		}
+	}
+	header := s.headers[key]
+	if got := header.Get("Content-Type"); got != "video/mp2t" {
+		t.Errorf("content type %q, expected video/mp2t", got)
+	}
+	if got := header.Get("X-Amz-Server-Side-Encryption"); got != S3SSEKMS {
+		t.Errorf("server side encryption %q, expected %s", got, S3SSEKMS)
+	}
+	if got := header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"); got != "key-id" {
+		t.Errorf("kms key id %q, expected key-id", got)
+	}
+}
+
+func TestS3SmallObject(t *testing.T) {
+	s, f := newTestS3(t)
+	f.SSE = S3SSEAES256
+	data := []byte("small file")
+	name, w, err := f.CreateStream("small.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.(ContentTyper).SetContentType("text/plain")
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	key := "downloads/" + name
+	if !bytes.Equal(s.objects[key], data) {
+		t.Fatalf("the object %s differs from the data written", key)
+	}
+	if len(s.completed) != 0 {
+		t.Error("a multipart upload was used for a file smaller than a part")
+	}
+	if got := s.headers[key].Get("X-Amz-Server-Side-Encryption"); got != S3SSEAES256 {
+		t.Errorf("server side encryption %q, expected %s", got, S3SSEAES256)
+	}
+	if got := s.headers[key].Get("Content-Type"); got != "text/plain" {
+		t.Errorf("content type %q, expected text/plain", got)
+	}
+}
+
+func TestS3AbortedUpload(t *testing.T) {
+	s, f := newTestS3(t)
+	name, w, err := f.CreateStream("failed.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(randomBytes(MinS3PartSize + 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.(SegmentWriter).EndSegment(); err != nil {
+		t.Fatal(err)
+	}
+	if parts := s.uploadedParts(); len(parts) != 1 {
+		t.Fatalf("%d parts uploaded before the download failed, expected 1", len(parts))
+	}
+	// The download fails, the task aborts the stream instead of closing it
+	if err := Abort(w); err != nil {
+		t.Fatal(err)
+	}
+	key := "downloads/" + name
+	if !slices.Equal(s.aborted, []string{key}) {
+		t.Errorf("aborted uploads %v, expected %s", s.aborted, key)
+	}
+	if len(s.uploads) != 0 {
+		t.Errorf("%d uploads left in progress", len(s.uploads))
+	}
+	if _, ok := s.objects[key]; ok {
+		t.Errorf("the object %s was created", key)
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Error("write after abort succeeded")
+	}
+}
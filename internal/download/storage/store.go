@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ways a StoreWriterFactory saves files as links to its objects
+const (
+	LinkHard    = "hardlink"
+	LinkReflink = "reflink"
+	LinkSymlink = "symlink"
+)
+
+// ErrInvalidDigest is returned for digests that are not "sha256:<hex>"
+var ErrInvalidDigest = errors.New("invalid digest, expected sha256:<64 hex digits>")
+
+// ContentStore is implemented by WriterFactories that store files by digest. Link saves the file fileName from
+// the stored object with the digest "sha256:<hex>" if there is one, and returns the name of the saved file
+type ContentStore interface {
+	Link(digest, fileName string) (string, bool, error)
+}
+
+// StoreWriterFactory implements WriterFactory with a content addressed store in Dir. Files are hashed while they
+// are written to Dir/tmp and moved to Dir/objects/sha256/<2 hex digits>/<62 hex digits> once complete, unless the
+// same content is already stored. They are then saved in BasePath, named like FSWriterFactory does, as a link to
+// the object made as LinkMode says, a hard link by default. Objects are read only, and so are files hard linked to
+// them. Every link is recorded in Dir/links so that GC can tell which objects are still used
+type StoreWriterFactory struct {
+	Dir      string
+	BasePath string
+	LinkMode string
+
+	names FSWriterFactory
+	mu    sync.Mutex
+}
+
+// StoreStats is the result of StoreWriterFactory.GC: the number of objects kept, and the number of objects and
+// temporary files removed along with their size
+type StoreStats struct {
+	Kept    int
+	Removed int
+	Freed   int64
+}
+
+// ParseDigest returns the hex SHA-256 of a digest written as "sha256:<hex>"
+func ParseDigest(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+	}
+	return strings.ToLower(sum), nil
+}
+
+// CreateStream reserves fileName in BasePath and returns a stream that stores the file when closed
+func (f *StoreWriterFactory) CreateStream(fileName string) (string, io.WriteCloser, error) {
+	name, err := f.reserve(fileName)
+	if err != nil {
+		return name, nil, err
+	}
+	tmpDir := filepath.Join(f.Dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		f.remove(name)
+		return name, nil, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "object-*")
+	if err != nil {
+		f.remove(name)
+		return name, nil, err
+	}
+	return name, &storeStream{factory: f, name: name, tmp: tmp, hash: sha256.New()}, nil
+}
+
+// Link saves fileName in BasePath as a link to the object with the digest if it is stored
+func (f *StoreWriterFactory) Link(digest, fileName string) (string, bool, error) {
+	sum, err := ParseDigest(digest)
+	if err != nil {
+		return fileName, false, err
+	}
+	object := f.objectPath(sum)
+	if _, err := os.Stat(object); errors.Is(err, fs.ErrNotExist) {
+		return fileName, false, nil
+	} else if err != nil {
+		return fileName, false, err
+	}
+	name, err := f.reserve(fileName)
+	if err != nil {
+		return name, false, err
+	}
+	target, err := f.link(sum, name)
+	if err != nil {
+		f.remove(name)
+		return name, false, err
+	}
+	return name, true, f.record(sum, target)
+}
+
+// reserve creates an empty file in BasePath with a free name, which is replaced by the link
+func (f *StoreWriterFactory) reserve(fileName string) (string, error) {
+	f.names.BasePath = f.BasePath
+	name, file, err := f.names.CreateStream(fileName)
+	if err != nil {
+		return name, err
+	}
+	return name, file.Close()
+}
+
+func (f *StoreWriterFactory) remove(name string) {
+	os.Remove(filepath.Join(f.BasePath, name))
+}
+
+func (f *StoreWriterFactory) objectPath(sum string) string {
+	return filepath.Join(f.Dir, "objects", "sha256", sum[:2], sum[2:])
+}
+
+// store moves a complete temporary file to the object with the digest sum, or removes it if the object exists
+func (f *StoreWriterFactory) store(tmp string, sum string) error {
+	object := f.objectPath(sum)
+	if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0444); err != nil {
+		return err
+	}
+	// Unlike renaming, linking fails if the object exists, so that downloads of the same content share one object
+	err := os.Link(tmp, object)
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		// Stored by an earlier download, which refreshes the object for GC
+		now := time.Now()
+		os.Chtimes(object, now, now)
+	case err != nil:
+		return os.Rename(tmp, object)
+	}
+	return os.Remove(tmp)
+}
+
+// link replaces the reserved file name in BasePath with a link to the object sum and returns its path
+func (f *StoreWriterFactory) link(sum string, name string) (string, error) {
+	object, err := filepath.Abs(f.objectPath(sum))
+	if err != nil {
+		return "", err
+	}
+	target, err := filepath.Abs(filepath.Join(f.BasePath, name))
+	if err != nil {
+		return "", err
+	}
+	tmp := filepath.Join(filepath.Dir(target), ".godown-link-"+filepath.Base(target))
+	os.Remove(tmp)
+	mode := f.LinkMode
+	if mode == "" {
+		mode = LinkHard
+	}
+	switch mode {
+	case LinkHard:
+		err = os.Link(object, tmp)
+	case LinkSymlink:
+		err = os.Symlink(object, tmp)
+	case LinkReflink:
+		err = reflink(object, tmp)
+	default:
+		err = fmt.Errorf("unknown link mode %q", mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		var linkErr *os.LinkError
+		if errors.As(err, &linkErr) {
+			err = linkErr.Err
+		}
+		return "", &fs.PathError{Op: mode, Path: target, Err: err}
+	}
+	return target, nil
+}
+
+// record appends a link to Dir/links
+func (f *StoreWriterFactory) record(sum string, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(filepath.Join(f.Dir, "links"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "sha256:%s\t%s\n", sum, target)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GC removes the objects that no file links to any more, and temporary files left by downloads that did not
+// finish. An object is still used while a file recorded in Dir/links is a symbolic link to it, a hard link to it
+// or a copy with the same digest, or while it has other hard links. Objects and temporary files modified less
+// than minAge ago are kept, as downloads running at the same time may be about to link them. With dryRun nothing
+// is removed, and the result tells what would be
+func (f *StoreWriterFactory) GC(minAge time.Duration, dryRun bool) (StoreStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var stats StoreStats
+	used, live, err := f.usedObjects()
+	if err != nil {
+		return stats, err
+	}
+	cutoff := time.Now().Add(-minAge)
+	prune := func(path string, info fs.FileInfo) error {
+		stats.Removed++
+		stats.Freed += info.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	}
+
+	objects := filepath.Join(f.Dir, "objects", "sha256")
+	err = filepath.WalkDir(objects, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == objects {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		sum := filepath.Base(filepath.Dir(path)) + d.Name()
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if used[sum] || hardLinks(info) > 1 || info.ModTime().After(cutoff) {
+			stats.Kept++
+			return nil
+		}
+		return prune(path, info)
+	})
+	if err != nil {
+		return stats, err
+	}
+	entries, err := os.ReadDir(filepath.Join(f.Dir, "tmp"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return stats, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := prune(filepath.Join(f.Dir, "tmp", entry.Name()), info); err != nil {
+			return stats, err
+		}
+	}
+	if dryRun {
+		return stats, nil
+	}
+	return stats, f.rewriteLinks(live)
+}
+
+// usedObjects reads Dir/links and returns the objects that recorded files still link to, with the lines of
+// those files. The mutex must be held
+func (f *StoreWriterFactory) usedObjects() (map[string]bool, []string, error) {
+	used := map[string]bool{}
+	var live []string
+	file, err := os.Open(filepath.Join(f.Dir, "links"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return used, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		digest, target, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		sum, err := ParseDigest(digest)
+		if err != nil || used[sum+"\x00"+target] {
+			continue
+		}
+		if f.linksTo(target, sum) {
+			used[sum] = true
+			used[sum+"\x00"+target] = true
+			live = append(live, scanner.Text())
+		}
+	}
+	return used, live, scanner.Err()
+}
+
+// linksTo reports whether the file target still holds the object sum
+func (f *StoreWriterFactory) linksTo(target string, sum string) bool {
+	object := f.objectPath(sum)
+	info, err := os.Lstat(target)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		dest, err := os.Readlink(target)
+		if err != nil {
+			return false
+		}
+		abs, err := filepath.Abs(object)
+		return err == nil && filepath.Clean(dest) == abs
+	}
+	objectInfo, err := os.Stat(object)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != objectInfo.Size() {
+		return false
+	}
+	if os.SameFile(info, objectInfo) {
+		return true
+	}
+	// A reflink, or a copy, holds the object while its content is the same
+	file, err := os.Open(target)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sum
+}
+
+// rewriteLinks replaces Dir/links with the lines of the files that still link to objects
+func (f *StoreWriterFactory) rewriteLinks(live []string) error {
+	path := filepath.Join(f.Dir, "links")
+	tmp := path + ".tmp"
+	var b strings.Builder
+	for _, line := range live {
+		b.WriteString(line + "\n")
+	}
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// storeStream hashes a file while it is written to a temporary file of the store
+type storeStream struct {
+	factory *StoreWriterFactory
+	name    string
+	tmp     *os.File
+	hash    hash.Hash
+	closed  bool
+}
+
+func (s *storeStream) Write(p []byte) (int, error) {
+	n, err := s.tmp.Write(p)
+	s.hash.Write(p[:n])
+	return n, err
+}
+
+// Close stores the file and links it in BasePath
+func (s *storeStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	f := s.factory
+	if err := s.tmp.Close(); err != nil {
+		os.Remove(s.tmp.Name())
+		f.remove(s.name)
+		return err
+	}
+	sum := hex.EncodeToString(s.hash.Sum(nil))
+	if err := f.store(s.tmp.Name(), sum); err != nil {
+		os.Remove(s.tmp.Name())
+		f.remove(s.name)
+		return err
+	}
+	target, err := f.link(sum, s.name)
+	if err != nil {
+		f.remove(s.name)
+		return err
+	}
+	return f.record(sum, target)
+}
+
+// Abort removes the temporary file and the reserved name
+func (s *storeStream) Abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.tmp.Close()
+	s.factory.remove(s.name)
+	return os.Remove(s.tmp.Name())
+}
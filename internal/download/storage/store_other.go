@@ -0,0 +1,17 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+func reflink(src, dst string) error {
+	return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: errors.ErrUnsupported}
+}
+
+func hardLinks(info fs.FileInfo) uint64 {
+	return 1
+}
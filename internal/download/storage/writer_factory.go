@@ -8,3 +8,80 @@ import "io"
 type WriterFactory interface {
 	CreateStream(fileName string) (string, io.WriteCloser, error)
 }
+
+// ResumableWriterFactory is implemented by WriterFactories that can continue writing a stream created earlier,
+// which is used to resume interrupted downloads
+type ResumableWriterFactory interface {
+	WriterFactory
+	// StreamSize returns the number of bytes written to the stream fileName
+	StreamSize(fileName string) (int64, error)
+	// ResumeStream opens the stream fileName for writing at offset, discarding anything after it
+	ResumeStream(fileName string, offset int64) (io.WriteCloser, error)
+}
+
+// ReplacingWriterFactory is implemented by WriterFactories that can write a new version of an existing stream, which
+// replaces it only once the new stream is closed. Aborting the new stream keeps the existing one as it was
+type ReplacingWriterFactory interface {
+	ReplaceStream(fileName string) (io.WriteCloser, error)
+}
+
+// StreamOpener is implemented by WriterFactories whose streams can be read back, such as files
+type StreamOpener interface {
+	OpenStream(fileName string) (io.ReadCloser, error)
+}
+
+// MetadataReader is implemented by WriterFactories that keep the Metadata of the files they saved
+type MetadataReader interface {
+	ReadMetadata(fileName string) (*Metadata, error)
+}
+
+// MetadataFinder is implemented by WriterFactories that can look up the file saved from a url by its Metadata.
+// FindMetadata returns its name and Metadata, or a nil Metadata if there is none
+type MetadataFinder interface {
+	FindMetadata(url string) (string, *Metadata, error)
+}
+
+// JobWriterFactory is implemented by WriterFactories that keep the files of every download apart. The Downloader
+// creates the streams of a job with the factory returned by ForJob, id being the ID of the job
+type JobWriterFactory interface {
+	WriterFactory
+	ForJob(id string) WriterFactory
+}
+
+// Aborter is implemented by streams that discard what was written when the download fails, such as multipart
+// uploads. Tasks that fail call Abort instead of Close
+type Aborter interface {
+	Abort() error
+}
+
+// ContentTyper is implemented by streams that store the media type of the data, tasks that know it call
+// SetContentType before writing
+type ContentTyper interface {
+	SetContentType(contentType string)
+}
+
+// MetadataWriter is implemented by streams that record where the data comes from. Tasks call SetMetadata before
+// writing, and again with the digest before closing the stream, which marks the file as complete
+type MetadataWriter interface {
+	SetMetadata(m Metadata)
+}
+
+// Sizer is implemented by streams that need the size of the file before it is written, such as the files of a
+// bundle. Tasks that know it call SetSize before writing
+type Sizer interface {
+	SetSize(size int64)
+}
+
+// SegmentWriter is implemented by streams that store the data in parts, such as multipart uploads. Tasks that
+// download a file in segments call EndSegment after writing each one, so that parts end at segment boundaries
+type SegmentWriter interface {
+	EndSegment() error
+}
+
+// Abort aborts w if it is an Aborter, and closes it otherwise
+func Abort(w io.WriteCloser) error {
+	if a, ok := w.(Aborter); ok {
+		return a.Abort()
+	}
+	return w.Close()
+}
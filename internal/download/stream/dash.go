@@ -0,0 +1,433 @@
+package stream
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSegments limits the number of segments of a representation, so that a hostile manifest cannot make the
+// expansion of a SegmentTimeline or SegmentTemplate run without bound
+const maxSegments = 100_000
+
+var errTooManySegments = fmt.Errorf("representation has more than %d segments", maxSegments)
+
+type mpd struct {
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	BaseURL                   string   `xml:"BaseURL"`
+	Periods                   []period `xml:"Period"`
+}
+
+type period struct {
+	Start          string          `xml:"start,attr"`
+	Duration       string          `xml:"duration,attr"`
+	BaseURL        string          `xml:"BaseURL"`
+	AdaptationSets []adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	MimeType        string           `xml:"mimeType,attr"`
+	ContentType     string           `xml:"contentType,attr"`
+	BaseURL         string           `xml:"BaseURL"`
+	SegmentTemplate *segmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *segmentList     `xml:"SegmentList"`
+	Representations []representation `xml:"Representation"`
+}
+
+type representation struct {
+	ID              string           `xml:"id,attr"`
+	Bandwidth       int64            `xml:"bandwidth,attr"`
+	Width           int              `xml:"width,attr"`
+	Height          int              `xml:"height,attr"`
+	MimeType        string           `xml:"mimeType,attr"`
+	Codecs          string           `xml:"codecs,attr"`
+	BaseURL         string           `xml:"BaseURL"`
+	SegmentTemplate *segmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *segmentList     `xml:"SegmentList"`
+}
+
+type segmentTemplate struct {
+	Media          string           `xml:"media,attr"`
+	Initialization string           `xml:"initialization,attr"`
+	StartNumber    *int64           `xml:"startNumber,attr"`
+	Timescale      int64            `xml:"timescale,attr"`
+	Duration       int64            `xml:"duration,attr"`
+	Timeline       *segmentTimeline `xml:"SegmentTimeline"`
+}
+
+type segmentTimeline struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type segmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+		Range     string `xml:"range,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// ParseDASH parses a static MPD manifest and returns one variant per representation.
+// Since segments of separate adaptation sets cannot be concatenated into a single playable file, only video
+// representations are returned when the manifest has any, otherwise the audio (or untyped) representations are returned.
+// Representations are matched across periods by their id, and their segments are appended in period order
+func ParseDASH(data []byte, base *url.URL) ([]Variant, error) {
+	var m mpd
+	if err := xml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.Type == "dynamic" {
+		return nil, errors.New("live (dynamic) DASH manifests are not supported")
+	}
+	if len(m.Periods) == 0 {
+		return nil, errors.New("manifest does not contain any periods")
+	}
+	mpdBase, err := joinBase(base, m.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	total, err := parseISODuration(m.MediaPresentationDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	durations, err := periodDurations(m.Periods, total)
+	if err != nil {
+		return nil, err
+	}
+
+	var variants []Variant
+	index := map[string]int{}
+	for pi, p := range m.Periods {
+		periodBase, err := joinBase(mpdBase, p.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		duration := durations[pi]
+		sets := selectAdaptationSets(p.AdaptationSets)
+		for _, as := range sets {
+			asBase, err := joinBase(periodBase, as.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+			for _, rep := range as.Representations {
+				pl, err := representationPlaylist(as, rep, asBase, duration)
+				if err != nil {
+					return nil, fmt.Errorf("representation %q: %w", rep.ID, err)
+				}
+				if i, ok := index[rep.ID]; ok && pi > 0 {
+					if len(variants[i].Playlist.Segments)+len(pl.Segments) > maxSegments {
+						return nil, fmt.Errorf("representation %q: %w", rep.ID, errTooManySegments)
+					}
+					variants[i].Playlist.Segments = append(variants[i].Playlist.Segments, pl.Segments...)
+					continue
+				}
+				if pi > 0 {
+					continue
+				}
+				mimeType := rep.MimeType
+				if mimeType == "" {
+					mimeType = as.MimeType
+				}
+				index[rep.ID] = len(variants)
+				variants = append(variants, Variant{
+					Bandwidth: rep.Bandwidth,
+					Width:     rep.Width,
+					Height:    rep.Height,
+					Codecs:    rep.Codecs,
+					MimeType:  mimeType,
+					Playlist:  pl,
+				})
+			}
+		}
+	}
+	if len(variants) == 0 {
+		return nil, errors.New("manifest does not contain any representations")
+	}
+	return variants, nil
+}
+
+// periodDurations returns the duration of every period: its duration attribute, or else the time from its start
+// until the start of the next period, or until the end of the presentation for the last period. A period without a
+// start attribute starts when the previous one ends. Durations that cannot be determined are zero
+func periodDurations(periods []period, total time.Duration) ([]time.Duration, error) {
+	const unknown = time.Duration(-1)
+	starts := make([]time.Duration, len(periods))
+	durations := make([]time.Duration, len(periods))
+	next := time.Duration(0)
+	for i, p := range periods {
+		starts[i], durations[i] = next, unknown
+		if p.Start != "" {
+			start, err := parseISODuration(p.Start)
+			if err != nil {
+				return nil, err
+			}
+			starts[i] = start
+		}
+		next = unknown
+		if p.Duration != "" {
+			d, err := parseISODuration(p.Duration)
+			if err != nil {
+				return nil, err
+			}
+			durations[i] = d
+			if starts[i] != unknown {
+				next = starts[i] + d
+			}
+		}
+	}
+	for i := range periods {
+		if durations[i] != unknown {
+			continue
+		}
+		durations[i] = 0
+		end := unknown
+		if i+1 < len(periods) {
+			end = starts[i+1]
+		} else if total > 0 {
+			end = total
+		}
+		if starts[i] != unknown && end != unknown && end > starts[i] {
+			durations[i] = end - starts[i]
+		}
+	}
+	return durations, nil
+}
+
+// selectAdaptationSets returns the video adaptation sets, or all sets when there is no video
+func selectAdaptationSets(sets []adaptationSet) []adaptationSet {
+	var video []adaptationSet
+	for _, as := range sets {
+		if as.ContentType == "video" || strings.HasPrefix(as.MimeType, "video/") {
+			video = append(video, as)
+			continue
+		}
+		for _, rep := range as.Representations {
+			if strings.HasPrefix(rep.MimeType, "video/") {
+				video = append(video, as)
+				break
+			}
+		}
+	}
+	if len(video) > 0 {
+		return video
+	}
+	return sets
+}
+
+// representationPlaylist builds the list of segments of a representation from its SegmentTemplate, SegmentList
+// or BaseURL, in that order of preference. Segment information on the representation overrides the adaptation set
+func representationPlaylist(as adaptationSet, rep representation, base *url.URL, duration time.Duration) (*Playlist, error) {
+	repBase, err := joinBase(base, rep.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := rep.SegmentTemplate
+	if tmpl == nil {
+		tmpl = as.SegmentTemplate
+	}
+	list := rep.SegmentList
+	if list == nil {
+		list = as.SegmentList
+	}
+
+	switch {
+	case tmpl != nil:
+		return templatePlaylist(tmpl, rep, repBase, duration)
+	case list != nil:
+		pl := &Playlist{}
+		if list.Initialization != nil {
+			pl.Init = &Segment{Range: list.Initialization.Range}
+			ref := list.Initialization.SourceURL
+			if ref == "" {
+				ref = repBase.String()
+			}
+			if pl.Init.URL, err = resolveURL(repBase, ref); err != nil {
+				return nil, err
+			}
+		}
+		if len(list.SegmentURLs) > maxSegments {
+			return nil, errTooManySegments
+		}
+		for _, su := range list.SegmentURLs {
+			seg := Segment{Range: su.MediaRange}
+			ref := su.Media
+			if ref == "" {
+				ref = repBase.String()
+			}
+			if seg.URL, err = resolveURL(repBase, ref); err != nil {
+				return nil, err
+			}
+			pl.Segments = append(pl.Segments, seg)
+		}
+		return pl, nil
+	default:
+		// A single self contained file
+		return &Playlist{Segments: []Segment{{URL: repBase.String()}}}, nil
+	}
+}
+
+// templatePlaylist expands a SegmentTemplate into segments, either following the SegmentTimeline
+// or dividing the period into segments of a fixed duration
+func templatePlaylist(tmpl *segmentTemplate, rep representation, base *url.URL, duration time.Duration) (*Playlist, error) {
+	pl := &Playlist{}
+	timescale := tmpl.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+	number := int64(1)
+	if tmpl.StartNumber != nil {
+		number = *tmpl.StartNumber
+	}
+	if tmpl.Initialization != "" {
+		ref := expandTemplate(tmpl.Initialization, rep, 0, 0)
+		u, err := resolveURL(base, ref)
+		if err != nil {
+			return nil, err
+		}
+		pl.Init = &Segment{URL: u}
+	}
+	add := func(num, t int64) error {
+		if len(pl.Segments) >= maxSegments {
+			return errTooManySegments
+		}
+		u, err := resolveURL(base, expandTemplate(tmpl.Media, rep, num, t))
+		if err != nil {
+			return err
+		}
+		pl.Segments = append(pl.Segments, Segment{URL: u, Sequence: num})
+		return nil
+	}
+	periodEnd := int64(duration.Seconds() * float64(timescale))
+
+	if tmpl.Timeline != nil {
+		var t int64
+		for i, s := range tmpl.Timeline.S {
+			if s.T != nil {
+				t = *s.T
+			}
+			if s.D <= 0 {
+				return nil, errors.New("segment timeline entry without duration")
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// Repeat until the start of the next entry or the end of the period
+				end := periodEnd
+				if i+1 < len(tmpl.Timeline.S) && tmpl.Timeline.S[i+1].T != nil {
+					end = *tmpl.Timeline.S[i+1].T
+				}
+				repeat = (end-t+s.D-1)/s.D - 1
+			}
+			for r := int64(0); r <= repeat; r++ {
+				if err := add(number, t); err != nil {
+					return nil, err
+				}
+				number++
+				t += s.D
+			}
+		}
+		return pl, nil
+	}
+
+	if tmpl.Duration <= 0 {
+		return nil, errors.New("segment template has neither a duration nor a timeline")
+	}
+	if duration <= 0 {
+		return nil, errors.New("cannot determine the number of segments without a period duration")
+	}
+	count := math.Ceil(float64(periodEnd) / float64(tmpl.Duration))
+	if count > maxSegments {
+		return nil, errTooManySegments
+	}
+	for i := int64(0); i < int64(count); i++ {
+		if err := add(number+i, i*tmpl.Duration); err != nil {
+			return nil, err
+		}
+	}
+	return pl, nil
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth)(%0(\d+)d)?\$|\$\$`)
+
+// expandTemplate replaces the $RepresentationID$, $Number$, $Time$ and $Bandwidth$ identifiers (with optional
+// %0Nd width) and the $$ escape in a segment template
+func expandTemplate(tmpl string, rep representation, number, t int64) string {
+	return templateIdentifier.ReplaceAllStringFunc(tmpl, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		parts := templateIdentifier.FindStringSubmatch(match)
+		var value int64
+		switch parts[1] {
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			value = number
+		case "Time":
+			value = t
+		case "Bandwidth":
+			value = rep.Bandwidth
+		}
+		if parts[3] != "" {
+			width, _ := strconv.Atoi(parts[3])
+			return fmt.Sprintf("%0*d", width, value)
+		}
+		return strconv.FormatInt(value, 10)
+	})
+}
+
+// joinBase resolves a BaseURL element against the parent base URL, an empty element keeps the parent
+func joinBase(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(u), nil
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)Y)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISODuration parses an xs:duration such as PT1H2M3.5S. An empty string is a zero duration
+func parseISODuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	parts := isoDuration.FindStringSubmatch(s)
+	if parts == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	units := []float64{365 * 24 * 3600, 30 * 24 * 3600, 24 * 3600, 3600, 60, 1}
+	var seconds float64
+	for i, unit := range units {
+		if parts[i+1] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(parts[i+1], 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds += v * unit
+	}
+	if seconds*float64(time.Second) >= math.MaxInt64 {
+		return 0, fmt.Errorf("duration %q is too long", s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mpdOf wraps periods into a static manifest with the given presentation duration
+func mpdOf(duration, periods string) string {
+	return `<MPD type="static" mediaPresentationDuration="` + duration + `">` + periods + `</MPD>`
+}
+
+// templatePeriod is a period with a single video representation "v" using tmpl as its SegmentTemplate
+func templatePeriod(attrs, baseURL, tmpl string) string {
+	return `<Period ` + attrs + `><BaseURL>` + baseURL + `</BaseURL><AdaptationSet mimeType="video/mp4">` +
+		`<Representation id="v" bandwidth="1000">` + tmpl + `</Representation></AdaptationSet></Period>`
+}
+
+func TestParseDASH(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/manifest.mpd")
+	const numbered = `<SegmentTemplate media="$RepresentationID$-$Number$.m4s" duration="2"/>`
+	tests := []struct {
+		name     string
+		manifest string
+		want     []string
+		err      error
+	}{
+		{
+			"number template",
+			mpdOf("PT10S", templatePeriod("", "", `<SegmentTemplate media="seg-$Number%03d$.m4s" startNumber="5" timescale="10" duration="40"/>`)),
+			[]string{"/video/seg-005.m4s", "/video/seg-006.m4s", "/video/seg-007.m4s"},
+			nil,
+		},
+		{
+			"timeline with time template",
+			mpdOf("PT10S", templatePeriod("", "", `<SegmentTemplate media="$Time$.m4s"><SegmentTimeline>`+
+				`<S t="0" d="2" r="2"/><S d="3"/></SegmentTimeline></SegmentTemplate>`)),
+			[]string{"/video/0.m4s", "/video/2.m4s", "/video/4.m4s", "/video/6.m4s"},
+			nil,
+		},
+		{
+			"timeline repeated until the next entry",
+			mpdOf("PT20S", templatePeriod("", "", `<SegmentTemplate media="$Time%02d$-$$.m4s"><SegmentTimeline>`+
+				`<S t="0" d="3" r="-1"/><S t="9" d="1"/></SegmentTimeline></SegmentTemplate>`)),
+			[]string{"/video/00-$.m4s", "/video/03-$.m4s", "/video/06-$.m4s", "/video/09-$.m4s"},
+			nil,
+		},
+		{
+			"timeline repeated until the end of the period",
+			mpdOf("PT10S", templatePeriod("", "", `<SegmentTemplate media="$Time$.m4s" timescale="1000"><SegmentTimeline>`+
+				`<S t="0" d="4000" r="-1"/></SegmentTimeline></SegmentTemplate>`)),
+			[]string{"/video/0.m4s", "/video/4000.m4s", "/video/8000.m4s"},
+			nil,
+		},
+		{
+			"periods with start",
+			mpdOf("PT10S", templatePeriod(`start="PT0S"`, "p1/", numbered)+templatePeriod(`start="PT6S"`, "p2/", numbered)),
+			[]string{"/video/p1/v-1.m4s", "/video/p1/v-2.m4s", "/video/p1/v-3.m4s", "/video/p2/v-1.m4s", "/video/p2/v-2.m4s"},
+			nil,
+		},
+		{
+			"period following one with a duration",
+			mpdOf("PT10S", templatePeriod(`duration="PT4S"`, "p1/", numbered)+templatePeriod("", "p2/", numbered)),
+			[]string{"/video/p1/v-1.m4s", "/video/p1/v-2.m4s", "/video/p2/v-1.m4s", "/video/p2/v-2.m4s", "/video/p2/v-3.m4s"},
+			nil,
+		},
+		{
+			"segment list",
+			mpdOf("PT10S", `<Period><AdaptationSet><Representation id="a"><SegmentList>`+
+				`<SegmentURL media="a.mp4" mediaRange="0-99"/><SegmentURL media="/b.mp4"/></SegmentList>`+
+				`</Representation></AdaptationSet></Period>`),
+			[]string{"/video/a.mp4", "/b.mp4"},
+			nil,
+		},
+		{
+			"template count over the limit",
+			mpdOf("PT1000000S", templatePeriod("", "", `<SegmentTemplate media="$Number$.m4s" duration="1"/>`)),
+			nil,
+			errTooManySegments,
+		},
+		{
+			"timeline repeat over the limit",
+			mpdOf("PT10S", templatePeriod("", "", `<SegmentTemplate media="$Time$.m4s"><SegmentTimeline>`+
+				`<S t="0" d="1" r="1000000000000"/></SegmentTimeline></SegmentTemplate>`)),
+			nil,
+			errTooManySegments,
+		},
+		{
+			"periods over the limit together",
+			mpdOf("PT120000S", templatePeriod(`duration="PT60000S"`, "p1/", `<SegmentTemplate media="$Number$.m4s" duration="1"/>`)+
+				templatePeriod(`duration="PT60000S"`, "p2/", `<SegmentTemplate media="$Number$.m4s" duration="1"/>`)),
+			nil,
+			errTooManySegments,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variants, err := ParseDASH([]byte(tt.manifest), base)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error %v, expected %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(variants) != 1 {
+				t.Fatalf("%d variants, expected 1", len(variants))
+			}
+			var got []string
+			for _, seg := range variants[0].Playlist.Segments {
+				got = append(got, strings.TrimPrefix(seg.URL, "https://example.com"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segments %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDASHSelectsVideo(t *testing.T) {
+	base, _ := url.Parse("https://example.com/manifest.mpd")
+	manifest := mpdOf("PT4S", `<Period><AdaptationSet contentType="audio"><Representation id="a"/></AdaptationSet>`+
+		`<AdaptationSet><Representation id="v" mimeType="video/mp4" width="1280" height="720"/></AdaptationSet></Period>`)
+	variants, err := ParseDASH([]byte(manifest), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 1 || variants[0].Height != 720 || variants[0].MimeType != "video/mp4" {
+		t.Errorf("variants %v, expected only the video representation", variants)
+	}
+}
+
+func TestParseDASHInvalid(t *testing.T) {
+	base, _ := url.Parse("https://example.com/manifest.mpd")
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"dynamic", `<MPD type="dynamic"><Period/></MPD>`},
+		{"no periods", mpdOf("PT1S", "")},
+		{"invalid duration", mpdOf("1 hour", "<Period/>")},
+		{"too long duration", mpdOf("P999999999999Y", "<Period/>")},
+		{"template without duration", mpdOf("PT1S", templatePeriod("", "", `<SegmentTemplate media="$Number$"/>`))},
+		{"unknown period duration", mpdOf("", templatePeriod("", "", `<SegmentTemplate media="$Number$" duration="1"/>`))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDASH([]byte(tt.manifest), base); err == nil {
+				t.Error("manifest was accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/httperr"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/trace"
+)
+
+// defaultConcurrency is the number of segments fetched at once when Fetcher.Concurrency is not set
+const defaultConcurrency = 4
+
+// segmentRetries is the number of times a failed segment request is retried
+const segmentRetries = 3
+
+// maxSegmentSize limits the size of a segment, which is held in memory until it is written
+const maxSegmentSize = 512 * 1024 * 1024
+
+// maxKeySize limits the size of the response read for an AES key
+const maxKeySize = 1024
+
+// Fetcher downloads the segments of a playlist concurrently and writes them to a single stream in playlist order.
+// At most Concurrency segments are held in memory at any time
+type Fetcher struct {
+	Client      *http.Client
+	Concurrency int
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+type segmentResult struct {
+	data []byte
+	err  error
+}
+
+// Fetch downloads the initialization segment (if any) followed by every segment of pl and writes them to w.
+// onSegment is called after each segment is written with the number of bytes written. If w is a
+// storage.SegmentWriter, it is told where every segment ends
+func (f *Fetcher) Fetch(ctx context.Context, pl *Playlist, w io.Writer, onSegment func(n int)) error {
+	if f.Client == nil {
+		f.Client = http.DefaultClient
+	}
+	concurrency := f.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	segments := pl.Segments
+	if pl.Init != nil {
+		segments = append([]Segment{*pl.Init}, segments...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]chan segmentResult, len(segments))
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+	sem := make(chan struct{}, concurrency)
+	go func() {
+		for i, seg := range segments {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := f.fetchSegment(ctx, seg)
+				results[i] <- segmentResult{data, err}
+			}()
+		}
+	}()
+
+	for i := range segments {
+		var res segmentResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-sem
+		if res.err != nil {
+			return fmt.Errorf("segment %d: %w", i, res.err)
+		}
+		n, err := w.Write(res.data)
+		if err != nil {
+			return err
+		}
+		if sw, ok := w.(storage.SegmentWriter); ok {
+			if err := sw.EndSegment(); err != nil {
+				return err
+			}
+		}
+		if onSegment != nil {
+			onSegment(n)
+		}
+	}
+	return nil
+}
+
+// fetchSegment downloads and decrypts a single segment, retrying transient failures. It is traced as a "segment"
+// span with a "request" span for every attempt, if ctx holds a span
+func (f *Fetcher) fetchSegment(ctx context.Context, seg Segment) (data []byte, err error) {
+	ctx, span := trace.Start(ctx, "segment")
+	span.SetAttr("segment.sequence", seg.Sequence)
+	defer func() { span.End(err) }()
+	for attempt := 0; attempt <= segmentRetries; attempt++ {
+		if attempt > 0 {
+			slog.Warn("retrying segment", "url", seg.URL, "attempt", attempt, "err", err)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		span.SetAttr("segment.attempts", attempt+1)
+		data, err = f.get(ctx, seg.URL, seg.Range, maxSegmentSize)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if seg.Key == nil {
+		return data, nil
+	}
+	key, err := f.key(ctx, seg.Key.URI)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key: %w", err)
+	}
+	iv := seg.Key.IV
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(seg.Sequence))
+	}
+	return decryptAES128(data, key, iv)
+}
+
+// key returns the AES key at uri, keys are cached since every segment usually shares the same key
+func (f *Fetcher) key(ctx context.Context, uri string) ([]byte, error) {
+	f.mu.Lock()
+	key, ok := f.keys[uri]
+	f.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := f.get(ctx, uri, "", maxKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("invalid AES-128 key length %d", len(key))
+	}
+	f.mu.Lock()
+	if f.keys == nil {
+		f.keys = map[string][]byte{}
+	}
+	f.keys[uri] = key
+	f.mu.Unlock()
+	return key, nil
+}
+
+// get performs a GET request for url, optionally limited to byteRange, and returns the body. Bodies longer than
+// limit bytes are rejected
+func (f *Fetcher) get(ctx context.Context, url, byteRange string, limit int64) (data []byte, err error) {
+	ctx, span := trace.Start(ctx, "request")
+	span.SetAttr("http.url", url)
+	defer func() { span.End(err) }()
+	req, err := http.NewRequestWithContext(trace.WithClientTrace(ctx), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", "bytes="+byteRange)
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttr("http.status_code", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &httperr.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+	if byteRange != "" && resp.StatusCode == http.StatusOK {
+		// The server ignored the range header and sent the whole resource
+		return sliceRange(data, byteRange)
+	}
+	return data, nil
+}
+
+// sliceRange returns the part of data described by an HTTP byte range "start-end"
+func sliceRange(data []byte, byteRange string) ([]byte, error) {
+	startStr, endStr, _ := strings.Cut(byteRange, "-")
+	start, err1 := strconv.Atoi(startStr)
+	end, err2 := strconv.Atoi(endStr)
+	if err1 != nil || err2 != nil || start > end || end >= len(data) {
+		return nil, fmt.Errorf("byte range %q is outside the resource", byteRange)
+	}
+	return data[start : end+1], nil
+}
+
+// decryptAES128 decrypts an AES-128-CBC encrypted segment and removes the PKCS7 padding
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted segment is not a multiple of the block size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("invalid padding, wrong key or IV")
+	}
+	return out[:len(out)-pad], nil
+}
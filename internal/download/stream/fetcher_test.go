@@ -0,0 +1,23 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetcherLimitsResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("k", maxKeySize+1)))
+	}))
+	defer server.Close()
+	f := &Fetcher{Client: server.Client()}
+	if _, err := f.get(context.Background(), server.URL, "", maxKeySize); err == nil {
+		t.Error("response larger than the limit was accepted")
+	}
+	if _, err := f.get(context.Background(), server.URL, "", maxKeySize+1); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// IsHLSMaster reports whether the playlist is a master playlist which lists variants instead of segments
+func IsHLSMaster(data []byte) bool {
+	return bytes.Contains(data, []byte("#EXT-X-STREAM-INF"))
+}
+
+// ParseHLSMaster parses a master playlist and returns its variants. Relative URIs are resolved against base
+func ParseHLSMaster(data []byte, base *url.URL) ([]Variant, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+	var variants []Variant
+	var pending *Variant
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			v := Variant{Codecs: attrs["CODECS"]}
+			v.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				v.Width, _ = strconv.Atoi(w)
+				v.Height, _ = strconv.Atoi(h)
+			}
+			pending = &v
+		case strings.HasPrefix(line, "#"):
+		default:
+			if pending == nil {
+				continue
+			}
+			pending.URL, err = resolveURL(base, line)
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, *pending)
+			pending = nil
+		}
+	}
+	if len(variants) == 0 {
+		return nil, errors.New("master playlist does not contain any variants")
+	}
+	return variants, nil
+}
+
+// ParseHLSMedia parses a media playlist. Relative URIs are resolved against base
+func ParseHLSMedia(data []byte, base *url.URL) (*Playlist, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+	pl := &Playlist{Live: true}
+	var key *Key
+	var sequence int64
+	var byteRange string
+	// Byte ranges without an offset continue from the end of the previous range of the same resource
+	var nextOffset int64
+	var lastURL string
+	haveSegment := false
+
+	for _, line := range lines {
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-MEDIA-SEQUENCE":
+			sequence, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence: %w", err)
+			}
+		case "#EXT-X-KEY":
+			attrs := parseAttributes(value)
+			switch attrs["METHOD"] {
+			case "NONE":
+				key = nil
+			case "AES-128":
+				key = &Key{Method: "AES-128"}
+				if key.URI, err = resolveURL(base, attrs["URI"]); err != nil {
+					return nil, err
+				}
+				if iv := attrs["IV"]; iv != "" {
+					key.IV, err = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+					if err != nil || len(key.IV) != 16 {
+						return nil, fmt.Errorf("invalid IV %q", iv)
+					}
+				}
+			default:
+				return nil, fmt.Errorf("unsupported encryption method %q", attrs["METHOD"])
+			}
+		case "#EXT-X-MAP":
+			attrs := parseAttributes(value)
+			init := &Segment{Key: key}
+			if init.URL, err = resolveURL(base, attrs["URI"]); err != nil {
+				return nil, err
+			}
+			if br := attrs["BYTERANGE"]; br != "" {
+				init.Range, _, err = parseByteRange(br, 0)
+				if err != nil {
+					return nil, err
+				}
+			}
+			pl.Init = init
+		case "#EXT-X-BYTERANGE":
+			byteRange = value
+		case "#EXT-X-ENDLIST":
+			pl.Live = false
+		case "#EXTINF":
+			haveSegment = true
+		default:
+			if strings.HasPrefix(line, "#") || !haveSegment {
+				continue
+			}
+			seg := Segment{Key: key, Sequence: sequence}
+			if seg.URL, err = resolveURL(base, line); err != nil {
+				return nil, err
+			}
+			if byteRange != "" {
+				if seg.URL != lastURL {
+					nextOffset = 0
+				}
+				seg.Range, nextOffset, err = parseByteRange(byteRange, nextOffset)
+				if err != nil {
+					return nil, err
+				}
+				byteRange = ""
+			}
+			lastURL = seg.URL
+			pl.Segments = append(pl.Segments, seg)
+			sequence++
+			haveSegment = false
+		}
+	}
+	if len(pl.Segments) == 0 {
+		return nil, errors.New("media playlist does not contain any segments")
+	}
+	return pl, nil
+}
+
+// playlistLines returns the non empty lines of a playlist, after checking the #EXTM3U header
+func playlistLines(data []byte) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "#EXTM3U") {
+		return nil, errors.New("not an m3u8 playlist")
+	}
+	return lines, nil
+}
+
+// parseAttributes parses an attribute list such as BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2".
+// Quoted values may contain commas, the quotes are removed from the returned values
+func parseAttributes(s string) map[string]string {
+	attrs := map[string]string{}
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(name)] = value
+		s = rest
+	}
+	return attrs
+}
+
+// parseByteRange converts an HLS byte range "length[@offset]" into an HTTP range and returns the offset
+// after the range. defaultOffset is used when the offset is omitted
+func parseByteRange(s string, defaultOffset int64) (string, int64, error) {
+	lengthStr, offsetStr, hasOffset := strings.Cut(s, "@")
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length <= 0 {
+		return "", 0, fmt.Errorf("invalid byte range %q", s)
+	}
+	offset := defaultOffset
+	if hasOffset {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 {
+			return "", 0, fmt.Errorf("invalid byte range %q", s)
+		}
+	}
+	return fmt.Sprintf("%d-%d", offset, offset+length-1), offset + length, nil
+}
@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseHLSMedia(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/index.m3u8")
+	tests := []struct {
+		name     string
+		playlist string
+		want     []Segment
+		live     bool
+	}{
+		{
+			"segments",
+			"#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:7\n#EXTINF:4,\na.ts\n#EXTINF:4,\n/b.ts\n#EXT-X-ENDLIST\n",
+			[]Segment{
+				{URL: "https://example.com/video/a.ts", Sequence: 7},
+				{URL: "https://example.com/b.ts", Sequence: 8},
+			},
+			false,
+		},
+		{
+			"byte ranges continue within a resource",
+			"#EXTM3U\n#EXTINF:4,\n#EXT-X-BYTERANGE:100@50\nall.ts\n#EXTINF:4,\n#EXT-X-BYTERANGE:20\nall.ts\n" +
+				"#EXTINF:4,\n#EXT-X-BYTERANGE:10\nother.ts\n",
+			[]Segment{
+				{URL: "https://example.com/video/all.ts", Range: "50-149"},
+				{URL: "https://example.com/video/all.ts", Range: "150-169", Sequence: 1},
+				{URL: "https://example.com/video/other.ts", Range: "0-9", Sequence: 2},
+			},
+			true,
+		},
+		{
+			"keys",
+			"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x000102030405060708090a0b0c0d0e0f\n#EXTINF:4,\na.ts\n" +
+				"#EXT-X-KEY:METHOD=NONE\n#EXTINF:4,\nb.ts\n#EXT-X-ENDLIST\n",
+			[]Segment{
+				{URL: "https://example.com/video/a.ts", Key: &Key{
+					Method: "AES-128",
+					URI:    "https://example.com/video/key.bin",
+					IV:     []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+				}},
+				{URL: "https://example.com/video/b.ts", Sequence: 1},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl, err := ParseHLSMedia([]byte(tt.playlist), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pl.Segments, tt.want) {
+				t.Errorf("segments %+v, expected %+v", pl.Segments, tt.want)
+			}
+			if pl.Live != tt.live {
+				t.Errorf("live %v, expected %v", pl.Live, tt.live)
+			}
+		})
+	}
+}
+
+func TestParseHLSMediaInit(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/index.m3u8")
+	pl, err := ParseHLSMedia([]byte("#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\",BYTERANGE=\"720@0\"\n#EXTINF:4,\na.m4s\n"), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Segment{URL: "https://example.com/video/init.mp4", Range: "0-719"}
+	if !reflect.DeepEqual(pl.Init, want) {
+		t.Errorf("init %+v, expected %+v", pl.Init, want)
+	}
+}
+
+func TestParseHLSMediaInvalid(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/index.m3u8")
+	tests := []struct {
+		name     string
+		playlist string
+	}{
+		{"no header", "#EXTINF:4,\na.ts\n"},
+		{"no segments", "#EXTM3U\n#EXT-X-ENDLIST\n"},
+		{"unsupported method", "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"k\"\n#EXTINF:4,\na.ts\n"},
+		{"short IV", "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0x01\n#EXTINF:4,\na.ts\n"},
+		{"invalid byte range", "#EXTM3U\n#EXTINF:4,\n#EXT-X-BYTERANGE:-1\na.ts\n"},
+		{"invalid media sequence", "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:x\n#EXTINF:4,\na.ts\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseHLSMedia([]byte(tt.playlist), base); err == nil {
+				t.Error("playlist was accepted")
+			}
+		})
+	}
+}
+
+func TestParseHLSMaster(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/master.m3u8")
+	data := []byte("#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.4d401e,mp4a.40.2\"\nlow/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2400000,RESOLUTION=1280x720\nhttps://cdn.example.com/high.m3u8\n")
+	if !IsHLSMaster(data) {
+		t.Fatal("master playlist not detected")
+	}
+	variants, err := ParseHLSMaster(data, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Variant{
+		{URL: "https://example.com/video/low/index.m3u8", Bandwidth: 800000, Width: 640, Height: 360, Codecs: "avc1.4d401e,mp4a.40.2"},
+		{URL: "https://cdn.example.com/high.m3u8", Bandwidth: 2400000, Width: 1280, Height: 720},
+	}
+	if !reflect.DeepEqual(variants, want) {
+		t.Errorf("variants %+v, expected %+v", variants, want)
+	}
+}
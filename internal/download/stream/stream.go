@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Key describes how a segment is encrypted. Only AES-128 (full segment AES-CBC with PKCS7 padding) is supported.
+// IV is nil when the playlist does not specify one, in which case the media sequence number is used
+type Key struct {
+	Method string
+	URI    string
+	IV     []byte
+}
+
+// Segment is a single media segment. Range is an HTTP byte range ("start-end") or empty for the whole resource
+type Segment struct {
+	URL      string
+	Range    string
+	Key      *Key
+	Sequence int64
+}
+
+// Playlist is the ordered list of segments that make up a single rendition.
+// Init is the initialization segment (EXT-X-MAP or DASH Initialization) which is written before all other segments
+type Playlist struct {
+	Init     *Segment
+	Segments []Segment
+	Live     bool
+}
+
+// Variant is one rendition of a stream. For HLS master playlists URL points to the media playlist;
+// for DASH, Playlist already contains the segments of the representation
+type Variant struct {
+	URL       string
+	Bandwidth int64
+	Width     int
+	Height    int
+	Codecs    string
+	MimeType  string
+	Playlist  *Playlist
+}
+
+// String describes the variant for logging
+func (v Variant) String() string {
+	if v.Height > 0 {
+		return fmt.Sprintf("%dx%d@%dbps", v.Width, v.Height, v.Bandwidth)
+	}
+	return fmt.Sprintf("%dbps", v.Bandwidth)
+}
+
+// SelectVariant picks the variant with the highest bandwidth that does not exceed maxBandwidth (bits per second)
+// and maxHeight (pixels), a limit of 0 means no limit. If no variant satisfies the limits, the variant with the
+// lowest bandwidth is returned. variants must not be empty
+func SelectVariant(variants []Variant, maxBandwidth int64, maxHeight int) Variant {
+	best, lowest := -1, 0
+	for i, v := range variants {
+		if v.Bandwidth < variants[lowest].Bandwidth {
+			lowest = i
+		}
+		if maxBandwidth > 0 && v.Bandwidth > maxBandwidth {
+			continue
+		}
+		if maxHeight > 0 && v.Height > maxHeight {
+			continue
+		}
+		if best < 0 || v.Bandwidth > variants[best].Bandwidth {
+			best = i
+		}
+	}
+	if best < 0 {
+		return variants[lowest]
+	}
+	return variants[best]
+}
+
+// resolveURL resolves ref relative to base
+func resolveURL(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(u).String(), nil
+}
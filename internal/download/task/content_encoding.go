@@ -0,0 +1,109 @@
+package task
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// AcceptEncoding is the Accept-Encoding header of tasks that request compressed responses
+const AcceptEncoding = "zstd, br, gzip, deflate"
+
+// encodingExtensions maps the content codings that can be decoded to the extension of a file holding the encoded
+// body
+var encodingExtensions = map[string]string{
+	"gzip":    ".gz",
+	"x-gzip":  ".gz",
+	"br":      ".br",
+	"zstd":    ".zst",
+	"deflate": ".zz",
+}
+
+// contentEncodings returns the codings applied to the body of resp, in the order they were applied. It is empty
+// for an identity body
+func contentEncodings(resp *http.Response) ([]string, error) {
+	var codings []string
+	for _, value := range resp.Header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" || coding == "identity" {
+				continue
+			}
+			if _, ok := encodingExtensions[coding]; !ok {
+				return nil, fmt.Errorf("unsupported content encoding %q", coding)
+			}
+			codings = append(codings, coding)
+		}
+	}
+	return codings, nil
+}
+
+// encodingExtension returns the extensions of a file holding a body encoded with codings, such as ".gz"
+func encodingExtension(codings []string) string {
+	var ext string
+	for _, coding := range codings {
+		ext += encodingExtensions[coding]
+	}
+	return ext
+}
+
+// decodeBody returns a reader of r decoded from codings, closing it releases the decoders
+func decodeBody(r io.Reader, codings []string) (io.ReadCloser, error) {
+	d := &decoder{Reader: r}
+	for i := len(codings) - 1; i >= 0; i-- {
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(d.Reader)
+			if err != nil {
+				d.Close()
+				return nil, err
+			}
+			d.push(zr, zr)
+		case "deflate":
+			zr, err := zlib.NewReader(d.Reader)
+			if err != nil {
+				d.Close()
+				return nil, err
+			}
+			d.push(zr, zr)
+		case "br":
+			d.push(brotli.NewReader(d.Reader), nil)
+		case "zstd":
+			zr, err := zstd.NewReader(d.Reader)
+			if err != nil {
+				d.Close()
+				return nil, err
+			}
+			d.push(zr, zr.IOReadCloser())
+		}
+	}
+	return d, nil
+}
+
+// decoder reads from the last of a chain of decoders
+type decoder struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decoder) push(r io.Reader, c io.Closer) {
+	d.Reader = r
+	if c != nil {
+		d.closers = append(d.closers, c)
+	}
+}
+
+func (d *decoder) Close() error {
+	var errs []error
+	for _, c := range d.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
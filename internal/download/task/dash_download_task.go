@@ -0,0 +1,56 @@
+package task
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/stream"
+)
+
+// DASHDownloadTask Implements Task and downloads a static MPEG-DASH presentation
+// Url is the manifest (.mpd). The initialization and media segments of the selected representation are
+// concatenated into a single file whose extension is derived from the mime type of the representation
+type DASHDownloadTask struct {
+	Url                string
+	WriterFactory      storage.WriterFactory
+	ProgressBarFactory reporter.ProgressBarFactory
+	Options            StreamOptions
+	output             streamOutput
+}
+
+// Execute fetches the manifest, picks a representation and saves its segments.
+// The passed context is used for cancelling the task if required.
+func (d *DASHDownloadTask) Execute(ctx context.Context) error {
+	slog.Info("starting dash download", slog.String("url", d.Url))
+	data, base, err := fetchManifest(ctx, &http.Client{}, d.Url)
+	if err != nil {
+		slog.Error("fetching manifest", "url", d.Url, "err", err)
+		return err
+	}
+	variants, err := stream.ParseDASH(data, base)
+	if err != nil {
+		slog.Error("parsing manifest", "url", d.Url, "err", err)
+		return err
+	}
+	variant := stream.SelectVariant(variants, d.Options.MaxBandwidth, d.Options.MaxHeight)
+	slog.Info("selected representation", "url", d.Url, "variant", variant.String(), "segments", len(variant.Playlist.Segments))
+
+	return saveStream(ctx, variant.Playlist, d.Options, streamFileName(base, dashExtension(variant.MimeType)),
+		&d.output, d.WriterFactory, d.ProgressBarFactory, d.Url)
+}
+
+// dashExtension returns the file extension for a representation mime type, defaulting to .mp4
+func dashExtension(mimeType string) string {
+	switch {
+	case strings.HasSuffix(mimeType, "/webm"):
+		return ".webm"
+	case mimeType == "audio/mp4":
+		return ".m4a"
+	default:
+		return ".mp4"
+	}
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/stream"
+)
+
+// HLSDownloadTask Implements Task and downloads an HLS stream
+// Url is the master or media playlist (.m3u8). The segments of the selected variant are concatenated into a
+// single .ts file, or a .mp4 file for fragmented MP4 streams
+type HLSDownloadTask struct {
+	Url                string
+	WriterFactory      storage.WriterFactory
+	ProgressBarFactory reporter.ProgressBarFactory
+	Options            StreamOptions
+	output             streamOutput
+}
+
+// Execute fetches the playlist, picks a variant if it is a master playlist and saves the segments.
+// The passed context is used for cancelling the task if required.
+func (h *HLSDownloadTask) Execute(ctx context.Context) error {
+	slog.Info("starting hls download", slog.String("url", h.Url))
+	client := &http.Client{}
+	data, base, err := fetchManifest(ctx, client, h.Url)
+	if err != nil {
+		slog.Error("fetching playlist", "url", h.Url, "err", err)
+		return err
+	}
+	// Name the output after the playlist that was requested rather than the media playlist of the variant
+	fileName := streamFileName(base, "")
+
+	if stream.IsHLSMaster(data) {
+		variants, err := stream.ParseHLSMaster(data, base)
+		if err != nil {
+			slog.Error("parsing master playlist", "url", h.Url, "err", err)
+			return err
+		}
+		variant := stream.SelectVariant(variants, h.Options.MaxBandwidth, h.Options.MaxHeight)
+		slog.Info("selected variant", "url", h.Url, "variant", variant.String(), "playlist", variant.URL)
+		data, base, err = fetchManifest(ctx, client, variant.URL)
+		if err != nil {
+			slog.Error("fetching media playlist", "url", variant.URL, "err", err)
+			return err
+		}
+	}
+
+	pl, err := stream.ParseHLSMedia(data, base)
+	if err != nil {
+		slog.Error("parsing media playlist", "url", h.Url, "err", err)
+		return err
+	}
+	if pl.Live {
+		slog.Warn("playlist has no end tag, only the currently listed segments will be downloaded", "url", h.Url)
+	}
+
+	ext := ".ts"
+	if pl.Init != nil {
+		ext = ".mp4"
+	}
+	return saveStream(ctx, pl, h.Options, fileName+ext, &h.output, h.WriterFactory, h.ProgressBarFactory, h.Url)
+}
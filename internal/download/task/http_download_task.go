@@ -1,29 +1,94 @@
 package task
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"mime"
 	"net/http"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ananthvk/godown/internal/download/httperr"
 	"github.com/ananthvk/godown/internal/download/reporter"
 	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/trace"
 )
 
+// maxPageSize limits the size of an HTML or CSS document kept in memory for a PageHandler
+const maxPageSize = 16 * 1024 * 1024
+
 // This is the default file name of the download when no file is detected from either the URL
 // or from the header
 const defaultFileName = "download"
 
 // HTTPDownloadTask Implements Task and represents a HTTP(S) download
-// Url is the resource to be fetched; WriterFactory creates WriterCloser streams
-// to be used by the task to save the response to some location
+// The saved data is hashed, see Digest. Streams that are storage.MetadataWriters are told where the response comes from
 type HTTPDownloadTask struct {
-	Url                string
+	// Url is the resource to be fetched
+	Url string
+	// Client sends the request, a new http.Client if nil
+	Client *http.Client
+	// Compressed requests the response with a gzip, br, zstd or deflate content coding and decodes it while it is
+	// saved, progress is reported against the encoded bytes
+	Compressed bool
+	// KeepEncoding saves the encoded body instead, with the extension of the coding added to the file name. Such
+	// files are not resumed, and pages are always decoded as their links are extracted
+	KeepEncoding bool
+	// WriterFactory creates WriterCloser streams to be used by the task to save the response to some location
 	WriterFactory      storage.WriterFactory
 	ProgressBarFactory reporter.ProgressBarFactory
+	// Pages is optional, and is used by recursive downloads to name files and find links in saved pages
+	Pages PageHandler
+	// FileName is optional, if set the response is saved with this name instead of one detected from the response
+	FileName string
+	// Resume is set by the task once the file has been created, executing the task again continues the download
+	// with a range request if the WriterFactory is a ResumableWriterFactory
+	Resume *ResumeState
+	// Update is optional and is a complete file saved by an earlier download, which is downloaded again, replacing
+	// it, only if the resource changed since. It needs a storage.ReplacingWriterFactory, which keeps the file until
+	// the new version is complete
+	Update *ResumeState
+	// OnCheckpoint is optional and is called whenever Resume changes
+	OnCheckpoint func(ResumeState)
+	// OnResponse is optional and is called with the time the server took to send the response headers
+	OnResponse func(latency time.Duration)
+	hash       hash.Hash
+	hashed     int64
+}
+
+// ResumeState is what is needed to continue an interrupted download: the name of the partially written file
+// and the validators of the response, which make sure that the resource has not changed in the meantime
+type ResumeState struct {
+	FileName     string `json:"fileName"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// validator returns the value of the If-Range header, weak ETags cannot be used for range requests
+func (r *ResumeState) validator() string {
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// PageHandler is notified about the responses saved by an HTTPDownloadTask.
+// FileName returns the name to save the response as. WantsBody reports whether the body should be kept in memory
+// and passed to Saved, which is called once the response has been saved. body is nil if it was not wanted
+// or was larger than maxPageSize
+type PageHandler interface {
+	FileName(resp *http.Response) string
+	WantsBody(resp *http.Response) bool
+	Saved(resp *http.Response, fileName string, body []byte)
 }
 
 // Execute performs a HTTP GET request for the task's url and saves the response to the location.
@@ -31,36 +96,166 @@ type HTTPDownloadTask struct {
 // The passed context is used for cancelling the task if required.
 // If the server returns with a status code < 200 or >= 300, the download is aborted.
 // WriterFactory is used to create a WriteCloser stream to save the response to, and the filename is determined from the
-// response header or the URL.
-func (h *HTTPDownloadTask) Execute(ctx context.Context) {
+// response header or the URL. If the task was interrupted before, the rest of the file is requested instead
+// The request is traced as a "request" span with its phases and a "transfer" span for the body, if ctx holds a span
+func (h *HTTPDownloadTask) Execute(ctx context.Context) error {
+	ctx, span := trace.Start(ctx, "request")
+	span.SetAttr("http.url", h.Url)
+	err := h.execute(ctx, span)
+	span.End(err)
+	return err
+}
+
+func (h *HTTPDownloadTask) execute(ctx context.Context, span *trace.Span) error {
 	slog.Info("starting download", slog.String("url", h.Url))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.Url, nil)
+	req, err := http.NewRequestWithContext(trace.WithClientTrace(ctx), http.MethodGet, h.Url, nil)
 	if err != nil {
 		slog.Error("creating request", slog.String("url", h.Url), "err", err)
+		return err
+	}
+	resumable, offset := h.resumeOffset()
+	update := !resumable && h.Update != nil && h.Pages == nil
+	if update {
+		_, update = h.WriterFactory.(storage.ReplacingWriterFactory)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator := h.Resume.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+		slog.Info("resuming download", "url", h.Url, "filename", h.Resume.FileName, "offset", offset)
+		span.SetAttr("http.range_start", offset)
+	} else if h.Compressed {
+		// The rest of a decoded body cannot be requested in an encoded form, so only whole files are compressed
+		req.Header.Set("Accept-Encoding", AcceptEncoding)
+	}
+	if update {
+		if h.Update.ETag != "" {
+			req.Header.Set("If-None-Match", h.Update.ETag)
+		}
+		if h.Update.LastModified != "" {
+			req.Header.Set("If-Modified-Since", h.Update.LastModified)
+		}
 	}
 	// TODO: Set a timeout
-	client := &http.Client{}
+	client := h.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
 		slog.Error("starting download", slog.String("url", h.Url), "err", err)
-		return
+		return err
+	}
+	if h.OnResponse != nil {
+		h.OnResponse(time.Since(start))
 	}
 	defer resp.Body.Close()
+	span.SetAttr("http.status_code", resp.StatusCode)
 
+	if offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if contentRangeTotal(resp) == offset {
+			slog.Info("download already complete", "url", h.Url, "filename", h.Resume.FileName)
+			return nil
+		}
+		// The file on disk does not match the resource, start over
+		resp.Body.Close()
+		h.Resume = nil
+		return h.execute(ctx, span)
+	}
+	if update && resp.StatusCode == http.StatusNotModified {
+		slog.Info("file is up to date", "url", h.Url, "filename", h.Update.FileName)
+		state := *h.Update
+		h.Resume = &state
+		if h.OnCheckpoint != nil {
+			h.OnCheckpoint(state)
+		}
+		return nil
+	}
 	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
 		slog.Info("http request sent", "status", resp.Status, "url", h.Url)
 	} else {
 		slog.Error("http request sent", "status", resp.Status, "url", h.Url)
-		return
+		return &httperr.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		slog.Info("server sent the whole file, restarting download", "url", h.Url)
+		offset = 0
+	} else if offset > 0 && contentRangeStart(resp) != offset {
+		return fmt.Errorf("server returned range %q instead of offset %d", resp.Header.Get("Content-Range"), offset)
 	}
 
-	fileName := getFileName(resp)
+	keepEncoding := h.KeepEncoding && h.Pages == nil
+	var codings []string
+	if h.Compressed && offset == 0 {
+		if codings, err = contentEncodings(resp); err != nil {
+			slog.Error("decoding response", "url", h.Url, "err", err)
+			return err
+		}
+	}
 
-	fileName, dest, err := h.WriterFactory.CreateStream(fileName)
+	var fileName string
+	var dest io.WriteCloser
+	// A file that is written again from the start is kept until the new version is complete
+	replacing := false
+	if resumable && offset > 0 {
+		fileName = h.Resume.FileName
+		dest, err = h.WriterFactory.(storage.ResumableWriterFactory).ResumeStream(fileName, offset)
+	} else if resumable || update {
+		if update {
+			slog.Info("file changed, replacing it", "url", h.Url, "filename", h.Update.FileName)
+			fileName = h.Update.FileName
+		} else {
+			fileName = h.Resume.FileName
+		}
+		replacing, dest, err = h.replaceStream(fileName)
+	} else {
+		if h.Pages != nil {
+			fileName = h.Pages.FileName(resp)
+		} else if h.FileName != "" {
+			fileName = h.FileName
+		} else {
+			fileName = getFileName(resp)
+		}
+		if keepEncoding {
+			fileName += encodingExtension(codings)
+		}
+		fileName, dest, err = h.WriterFactory.CreateStream(fileName)
+	}
 	if err != nil {
 		slog.Error("failed to create write stream", "url", h.Url, "filename", fileName, "err", err)
-		return
+		return err
+	}
+	// The stream is ended exactly once: closed below once the whole response is written, aborted on any failure
+	stream := dest
+	closed := false
+	defer func() {
+		if !closed {
+			storage.Abort(stream)
+		}
+	}()
+	if ct, ok := dest.(storage.ContentTyper); ok {
+		ct.SetContentType(resp.Header.Get("Content-Type"))
+	}
+	if sz, ok := dest.(storage.Sizer); ok && resp.ContentLength >= 0 && (len(codings) == 0 || keepEncoding) {
+		sz.SetSize(offset + resp.ContentLength)
+	}
+	if !replacing {
+		h.checkpoint(resp, fileName)
 	}
+	metadata := storage.Metadata{
+		URL:          h.Url,
+		FinalURL:     resp.Request.URL.String(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}
+	mw, hasMetadata := dest.(storage.MetadataWriter)
+	if hasMetadata {
+		mw.SetMetadata(metadata)
+	}
+	dest = h.hashWriter(dest, offset)
 
 	total := resp.ContentLength
 	if total <= 0 {
@@ -68,28 +263,167 @@ func (h *HTTPDownloadTask) Execute(ctx context.Context) {
 		slog.Info("content length header not found", "url", h.Url, "length", resp.ContentLength)
 	} else {
 		slog.Info("content length header found", "url", h.Url, "length", resp.ContentLength)
+		total += offset
 	}
 
 	bar := h.ProgressBarFactory.CreateProgressBar(total, "Download "+fileName)
+	if offset > 0 {
+		bar.IncrBy(int(offset))
+	}
 
 	r := bar.ProxyReader(resp.Body)
 	if r == nil {
 		slog.Error("failed to create progress bar proxy reader", "url", h.Url, "filename", fileName, "err", err)
 		r = resp.Body
 	}
+	if len(codings) > 0 && !keepEncoding {
+		decoded, err := decodeBody(r, codings)
+		if err != nil {
+			slog.Error("decoding response", "url", h.Url, "filename", fileName, "encoding", codings, "err", err)
+			bar.Abort(true)
+			return err
+		}
+		defer decoded.Close()
+		r = decoded
+	}
 
+	var page *pageBuffer
+	if h.Pages != nil && h.Pages.WantsBody(resp) {
+		page = &pageBuffer{}
+		r = io.NopCloser(io.TeeReader(r, page))
+	}
+
+	_, transfer := trace.Start(ctx, "transfer")
 	b, err := io.Copy(dest, r)
+	transfer.SetAttr("bytes", b)
+	transfer.End(err)
+	if err == nil {
+		if hasMetadata {
+			metadata.Digest = h.Digest()
+			mw.SetMetadata(metadata)
+		}
+		// Writers such as those extracting archives only report some errors when closed
+		closed = true
+		err = dest.Close()
+	}
 	if err != nil {
 		slog.Error("failed to save response", "url", h.Url, "filename", fileName, "err", err)
 		bar.Abort(true)
-		return
+		return err
 	}
 
+	if replacing {
+		// Until now, the file on disk and the validators of Resume were those of the earlier version
+		h.checkpoint(resp, fileName)
+	}
 	if total == 0 {
 		bar.SetTotal(-1, true)
 	}
+	if h.Pages != nil {
+		h.Pages.Saved(resp, fileName, page.Bytes())
+	}
+
+	slog.Info("finished download", "url", h.Url, "filename", fileName, "bytes", offset+b)
+	return nil
+}
+
+// resumeOffset reports whether the file of an earlier attempt can be continued, and the number of bytes
+// already saved. Pages are always downloaded again as their links are extracted from the whole body, and encoded
+// bodies as the rest of them cannot be requested
+func (h *HTTPDownloadTask) resumeOffset() (bool, int64) {
+	if h.Resume == nil || h.Resume.FileName == "" || h.Pages != nil || h.KeepEncoding {
+		return false, 0
+	}
+	rf, ok := h.WriterFactory.(storage.ResumableWriterFactory)
+	if !ok {
+		return false, 0
+	}
+	size, err := rf.StreamSize(h.Resume.FileName)
+	if err != nil {
+		slog.Info("cannot resume download", "url", h.Url, "filename", h.Resume.FileName, "err", err)
+		return false, 0
+	}
+	return true, size
+}
+
+// checkpoint records the file that the response is saved to so that the download can be resumed
+func (h *HTTPDownloadTask) checkpoint(resp *http.Response, fileName string) {
+	h.Resume = &ResumeState{FileName: fileName, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if h.OnCheckpoint != nil {
+		h.OnCheckpoint(*h.Resume)
+	}
+}
+
+// replaceStream returns a stream writing fileName from the start. It reports whether the existing file is only
+// replaced once the stream is closed, which is the case if the WriterFactory is a storage.ReplacingWriterFactory
+func (h *HTTPDownloadTask) replaceStream(fileName string) (bool, io.WriteCloser, error) {
+	if rf, ok := h.WriterFactory.(storage.ReplacingWriterFactory); ok {
+		w, err := rf.ReplaceStream(fileName)
+		return true, w, err
+	}
+	w, err := h.WriterFactory.(storage.ResumableWriterFactory).ResumeStream(fileName, 0)
+	return false, w, err
+}
+
+// hashWriter returns a writer that hashes the data written to dest. The hash of an earlier attempt is continued if
+// it covers the offset, otherwise, such as after a restart, the digest is not known
+func (h *HTTPDownloadTask) hashWriter(dest io.WriteCloser, offset int64) io.WriteCloser {
+	if offset == 0 {
+		h.hash = sha256.New()
+		h.hashed = 0
+	} else if h.hash == nil || h.hashed != offset {
+		h.hash = nil
+		return dest
+	}
+	return &hashingWriter{WriteCloser: dest, task: h}
+}
+
+// Digest returns the SHA-256 of the saved file if the whole file was hashed
+func (h *HTTPDownloadTask) Digest() string {
+	if h.hash == nil {
+		return ""
+	}
+	return "sha256:" + hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// hashingWriter adds the bytes that were written to the hash of the task
+type hashingWriter struct {
+	io.WriteCloser
+	task *HTTPDownloadTask
+}
 
-	slog.Info("finished download", "url", h.Url, "filename", fileName, "bytes", b)
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.task.hash.Write(p[:n])
+	w.task.hashed += int64(n)
+	return n, err
+}
+
+// contentRangeStart returns the first byte of a Content-Range header such as "bytes 100-199/200", or -1
+func contentRangeStart(resp *http.Response) int64 {
+	rng, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, _ := strings.Cut(rng, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// contentRangeTotal returns the complete length from a Content-Range header such as "bytes */200", or -1
+func contentRangeTotal(resp *http.Response) int64 {
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
 }
 
 // getFileName returns the filename from the response
@@ -158,3 +492,29 @@ func getFileExt(resp *http.Response) string {
 	}
 	return ""
 }
+
+// pageBuffer keeps up to maxPageSize bytes written to it, and discards everything once the limit is exceeded
+type pageBuffer struct {
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (p *pageBuffer) Write(b []byte) (int, error) {
+	if !p.overflow {
+		if p.buf.Len()+len(b) > maxPageSize {
+			p.overflow = true
+			p.buf = bytes.Buffer{}
+		} else {
+			p.buf.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+// Bytes returns the buffered data, or nil if p is nil or the data was too large
+func (p *pageBuffer) Bytes() []byte {
+	if p == nil || p.overflow {
+		return nil
+	}
+	return p.buf.Bytes()
+}
@@ -0,0 +1,170 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// replacementServer serves a new version of file.txt with the ETag "new", cutting the body short if failing is set
+func replacementServer(t *testing.T, failing *atomic.Bool) *httptest.Server {
+	t.Helper()
+	const body = "new content of the file"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"new"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		// Range requests are ignored, the whole file is always sent
+		w.Header().Set("ETag", `"new"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if failing.Load() {
+			w.Write([]byte(body[:5]))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// checkDir checks that dir only holds file.txt with the given content, and its metadata sidecar
+func checkDir(t *testing.T, dir, content string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), storage.SidecarExtension) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) != 1 || names[0] != "file.txt" {
+		t.Fatalf("files %v, expected only file.txt", names)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("file.txt holds %q, expected %q", data, content)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	const old = "old content"
+	tests := []struct {
+		name  string
+		state func(h *HTTPDownloadTask, s *ResumeState)
+	}{
+		{"update", func(h *HTTPDownloadTask, s *ResumeState) { h.Update = s }},
+		// A complete file is resumed past its end, the server sends the whole changed file instead
+		{"resume", func(h *HTTPDownloadTask, s *ResumeState) { h.Resume = s }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(old), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var failing atomic.Bool
+			failing.Store(true)
+			server := replacementServer(t, &failing)
+			h := &HTTPDownloadTask{
+				Url:                server.URL + "/file.txt",
+				WriterFactory:      &storage.FSWriterFactory{BasePath: dir, Metadata: true},
+				ProgressBarFactory: reporter.NopProgressBarFactory{},
+			}
+			tt.state(h, &ResumeState{FileName: "file.txt", ETag: `"old"`})
+
+			if err := h.Execute(context.Background()); err == nil {
+				t.Fatal("download of a cut body succeeded")
+			}
+			checkDir(t, dir, old)
+
+			failing.Store(false)
+			if err := h.Execute(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			checkDir(t, dir, "new content of the file")
+			m, err := storage.ReadMetadata(filepath.Join(dir, "file.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.ETag != `"new"` || !m.Complete() {
+				t.Errorf("metadata %+v, expected the complete new version", m)
+			}
+			if h.Resume == nil || h.Resume.ETag != `"new"` {
+				t.Errorf("resume state %+v, expected the new ETag", h.Resume)
+			}
+		})
+	}
+}
+
+// endingStream counts how many times it is closed and aborted
+type endingStream struct {
+	closes, aborts int
+	closeErr       error
+}
+
+func (s *endingStream) Write(p []byte) (int, error) { return len(p), nil }
+
+func (s *endingStream) Close() error {
+	s.closes++
+	return s.closeErr
+}
+
+func (s *endingStream) Abort() error {
+	s.aborts++
+	return nil
+}
+
+// TestStreamEndedOnce checks that the stream of a download is either closed or aborted, and only once
+func TestStreamEndedOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		cut      bool
+		closeErr error
+		closes   int
+		aborts   int
+	}{
+		{"complete", false, nil, 1, 0},
+		{"cut body", true, nil, 0, 1},
+		{"failed close", false, errors.New("disk full"), 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failing atomic.Bool
+			failing.Store(tt.cut)
+			server := replacementServer(t, &failing)
+			s := &endingStream{closeErr: tt.closeErr}
+			h := &HTTPDownloadTask{
+				Url: server.URL + "/file.txt",
+				WriterFactory: storage.WriterFunc(func(id, fileName string) (io.Writer, error) {
+					return s, nil
+				}),
+				ProgressBarFactory: reporter.NopProgressBarFactory{},
+			}
+			err := h.Execute(context.Background())
+			if (err == nil) != (tt.closes == 1 && tt.closeErr == nil) {
+				t.Errorf("error %v", err)
+			}
+			if s.closes != tt.closes || s.aborts != tt.aborts {
+				t.Errorf("stream closed %d and aborted %d times, expected %d and %d", s.closes, s.aborts, tt.closes, tt.aborts)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package task
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ananthvk/godown/internal/download/httperr"
+	"github.com/ananthvk/godown/internal/download/spider"
+)
+
+// LinkCheckTask Implements Task and checks that a URL can be downloaded without saving anything
+// A HEAD request is sent first, falling back to a GET for the first byte (Range: bytes=0-0) when the server
+// does not support HEAD. The result is passed to Record, and replaces the earlier one when the task is executed again
+type LinkCheckTask struct {
+	Url    string
+	Record func(spider.Result)
+}
+
+// headUnsupported lists status codes that servers return when they do not implement HEAD properly
+var headUnsupported = map[int]bool{
+	http.StatusBadRequest:       true,
+	http.StatusForbidden:        true,
+	http.StatusMethodNotAllowed: true,
+	http.StatusNotImplemented:   true,
+}
+
+// Execute checks the URL and records the result. A broken link is reported in the result rather than as an error,
+// except for failed requests and 408, 429 or 5xx responses, which are returned so that the check can be retried.
+// The passed context is used for cancelling the task if required.
+func (l *LinkCheckTask) Execute(ctx context.Context) error {
+	client := &http.Client{}
+	res, err := l.check(ctx, client, http.MethodHead)
+	if err == nil && headUnsupported[res.Status] {
+		res, err = l.check(ctx, client, http.MethodGet)
+	}
+	slog.Info("checked link", "url", l.Url, "status", res.Status, "broken", res.Broken)
+	l.Record(res)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// check sends a single request and converts the response into a result. The error is that of a failed request, or
+// an httperr.StatusError for responses that may succeed when the check is retried. A HEAD answered with one of the
+// headUnsupported codes, such as 501, is not an error
+func (l *LinkCheckTask) check(ctx context.Context, client *http.Client, method string) (spider.Result, error) {
+	res := spider.Result{URL: l.Url, ContentLength: -1, Method: method, Broken: true}
+	req, err := http.NewRequestWithContext(ctx, method, l.Url, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res, err
+	}
+	resp.Body.Close()
+
+	res.Status = resp.StatusCode
+	res.FinalURL = resp.Request.URL.String()
+	res.ContentType = resp.Header.Get("Content-Type")
+	res.ContentLength = resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		// The length of the resource is the part after the slash in "bytes 0-0/1234"
+		res.ContentLength = -1
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+				res.ContentLength = n
+			}
+		}
+	}
+	res.Broken = resp.StatusCode >= 400
+	if method == http.MethodHead && headUnsupported[resp.StatusCode] {
+		// Execute falls back to a GET instead of retrying the HEAD
+		return res, nil
+	}
+	if code := resp.StatusCode; code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests {
+		return res, &httperr.StatusError{StatusCode: code, Status: resp.Status}
+	}
+	return res, nil
+}
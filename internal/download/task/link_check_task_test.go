@@ -0,0 +1,70 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download/httperr"
+	"github.com/ananthvk/godown/internal/download/spider"
+)
+
+func TestLinkCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		head      int
+		get       int
+		method    string
+		status    int
+		broken    bool
+		retryable bool
+	}{
+		{"head", http.StatusOK, http.StatusOK, http.MethodHead, http.StatusOK, false, false},
+		{"head not implemented", http.StatusNotImplemented, http.StatusPartialContent, http.MethodGet, http.StatusPartialContent, false, false},
+		{"head not allowed", http.StatusMethodNotAllowed, http.StatusPartialContent, http.MethodGet, http.StatusPartialContent, false, false},
+		{"not found", http.StatusNotFound, http.StatusNotFound, http.MethodHead, http.StatusNotFound, true, false},
+		{"unavailable", http.StatusServiceUnavailable, http.StatusPartialContent, http.MethodHead, http.StatusServiceUnavailable, true, true},
+		{"get unavailable", http.StatusNotImplemented, http.StatusServiceUnavailable, http.MethodGet, http.StatusServiceUnavailable, true, true},
+		{"too many requests", http.StatusTooManyRequests, http.StatusOK, http.MethodHead, http.StatusTooManyRequests, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(tt.head)
+					return
+				}
+				if r.Header.Get("Range") != "bytes=0-0" {
+					t.Errorf("range %q, expected bytes=0-0", r.Header.Get("Range"))
+				}
+				if tt.get == http.StatusPartialContent {
+					w.Header().Set("Content-Range", "bytes 0-0/1234")
+				}
+				w.WriteHeader(tt.get)
+				w.Write([]byte("x"))
+			}))
+			defer server.Close()
+
+			var results []spider.Result
+			task := &LinkCheckTask{Url: server.URL, Record: func(r spider.Result) { results = append(results, r) }}
+			err := task.Execute(context.Background())
+			var statusErr *httperr.StatusError
+			if retryable := errors.As(err, &statusErr); retryable != tt.retryable {
+				t.Errorf("error %v, expected a retryable error: %v", err, tt.retryable)
+			}
+			if len(results) != 1 {
+				t.Fatalf("%d results recorded, expected 1", len(results))
+			}
+			res := results[0]
+			if res.Method != tt.method || res.Status != tt.status || res.Broken != tt.broken {
+				t.Errorf("%s %d broken %v, expected %s %d broken %v",
+					res.Method, res.Status, res.Broken, tt.method, tt.status, tt.broken)
+			}
+			if tt.status == http.StatusPartialContent && res.ContentLength != 1234 {
+				t.Errorf("content length %d, expected 1234", res.ContentLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package task
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/ananthvk/godown/internal/download/httperr"
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/stream"
+	"github.com/ananthvk/godown/internal/download/trace"
+)
+
+// maxManifestSize limits the size of a playlist or manifest
+const maxManifestSize = 16 * 1024 * 1024
+
+// StreamOptions configures HLS and DASH downloads.
+// Concurrency is the number of segments fetched at once. MaxBandwidth (bits per second) and MaxHeight (pixels)
+// limit the variant that is picked, the best variant within the limits is used and 0 means no limit
+type StreamOptions struct {
+	Concurrency  int
+	MaxBandwidth int64
+	MaxHeight    int
+}
+
+// fetchManifest downloads a playlist or manifest and returns its body along with the final URL after redirects,
+// against which relative URIs are resolved
+func fetchManifest(ctx context.Context, client *http.Client, manifestURL string) (data []byte, u *url.URL, err error) {
+	ctx, span := trace.Start(ctx, "request")
+	span.SetAttr("http.url", manifestURL)
+	defer func() { span.End(err) }()
+	req, err := http.NewRequestWithContext(trace.WithClientTrace(ctx), http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttr("http.status_code", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &httperr.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, resp.Request.URL, nil
+}
+
+// streamFileName returns the name of the output file, which is the name of the manifest with ext as the extension
+func streamFileName(u *url.URL, ext string) string {
+	name := path.Base(u.Path)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" || name == "." || name == "/" || name == ".." {
+		name = defaultFileName
+	}
+	return name + ext
+}
+
+// streamOutput remembers the file created by a stream task, so that executing the task again, such as when it is
+// retried or resumed, writes the file from the start instead of creating another one next to it
+type streamOutput struct {
+	requested string
+	fileName  string
+}
+
+// create returns a stream writing to fileName. The file created by an earlier execution for the same name is
+// truncated if writerFactory is a storage.ResumableWriterFactory
+func (o *streamOutput) create(fileName string, writerFactory storage.WriterFactory) (string, io.WriteCloser, error) {
+	if rf, ok := writerFactory.(storage.ResumableWriterFactory); ok && o.fileName != "" && o.requested == fileName {
+		w, err := rf.ResumeStream(o.fileName, 0)
+		if err == nil {
+			slog.Info("restarting stream download", "filename", o.fileName)
+			return o.fileName, w, nil
+		}
+		slog.Info("cannot reuse stream file", "filename", o.fileName, "err", err)
+	}
+	name, w, err := writerFactory.CreateStream(fileName)
+	if err == nil {
+		o.requested, o.fileName = fileName, name
+	}
+	return name, w, err
+}
+
+// saveStream fetches every segment of pl and concatenates them into a single stream created by writerFactory, or
+// the one output created before. Progress is reported as the number of segments written
+func saveStream(ctx context.Context, pl *stream.Playlist, opts StreamOptions, fileName string, output *streamOutput,
+	writerFactory storage.WriterFactory, progressBarFactory reporter.ProgressBarFactory, manifestURL string) error {
+	fileName, dest, err := output.create(fileName, writerFactory)
+	if err != nil {
+		slog.Error("failed to create write stream", "url", manifestURL, "filename", fileName, "err", err)
+		return err
+	}
+
+	// dest is ended exactly once: aborted if a segment fails, closed otherwise
+	total := int64(len(pl.Segments))
+	if pl.Init != nil {
+		total++
+	}
+	bar := progressBarFactory.CreateProgressBar(total, "Download "+fileName)
+	fetcher := &stream.Fetcher{Client: &http.Client{}, Concurrency: opts.Concurrency}
+	var written int64
+	err = fetcher.Fetch(ctx, pl, dest, func(n int) {
+		written += int64(n)
+		bar.IncrBy(1)
+	})
+	if err != nil {
+		slog.Error("failed to save stream", "url", manifestURL, "filename", fileName, "err", err)
+		storage.Abort(dest)
+		bar.Abort(true)
+		return err
+	}
+	if err := dest.Close(); err != nil {
+		slog.Error("failed to save stream", "url", manifestURL, "filename", fileName, "err", err)
+		bar.Abort(true)
+		return err
+	}
+	slog.Info("finished download", "url", manifestURL, "filename", fileName, "segments", total, "bytes", written)
+	return nil
+}
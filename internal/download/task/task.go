@@ -8,6 +8,12 @@ import (
 // Implementations can either be written in Go as internal functions or can call external CLI tools as required
 type Task interface {
 	// Execute performs the download. The provided context allows cancellation of the operation
-	// and other sub operations if required
-	Execute(ctx context.Context)
+	// and other sub operations if required. A non nil error is returned if the download failed
+	Execute(ctx context.Context) error
+}
+
+// Digester is implemented by tasks that hash the data they save. Digest returns the digest of the saved data as
+// "sha256:<hex>", or an empty string if it is not known, such as when the download was resumed after a restart
+type Digester interface {
+	Digest() string
 }
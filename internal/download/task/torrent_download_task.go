@@ -0,0 +1,96 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/httperr"
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/torrent"
+)
+
+// maxTorrentFileSize limits the size of a .torrent file fetched over HTTP
+const maxTorrentFileSize = 16 * 1024 * 1024
+
+// TorrentDownloadTask Implements Task and represents a BitTorrent download
+// Source is either a magnet link or the URL of a .torrent file. ListenAddr is the address on which
+// incoming peer connections are accepted, and SeedRatio is the upload to download ratio to reach
+// before the task finishes, 0 disables seeding.
+type TorrentDownloadTask struct {
+	Source             string
+	WriterFactory      storage.WriterFactory
+	ProgressBarFactory reporter.ProgressBarFactory
+	ListenAddr         string
+	SeedRatio          float64
+
+	// fileNames remembers the files created by an earlier execution, which are reused when the task is retried
+	fileNames map[string]string
+}
+
+// Execute resolves the torrent metainfo, then downloads the files from peers and web seeds.
+// The passed context is used for cancelling the task if required.
+func (t *TorrentDownloadTask) Execute(ctx context.Context) error {
+	slog.Info("starting torrent download", "source", t.Source)
+	meta, err := t.loadMetaInfo(ctx)
+	if err != nil {
+		slog.Error("loading torrent", "source", t.Source, "err", err)
+		return err
+	}
+
+	var bar reporter.ProgressBar
+	session := &torrent.Session{
+		MetaInfo:      meta,
+		WriterFactory: t.WriterFactory,
+		ListenAddr:    t.ListenAddr,
+		SeedRatio:     t.SeedRatio,
+		StallTimeout:  5 * time.Minute,
+		FileNames:     t.fileNames,
+		OnMetadata: func(info *torrent.Info) {
+			bar = t.ProgressBarFactory.CreateProgressBar(info.TotalLength(), "Torrent "+info.Name)
+		},
+		OnProgress: func(n int64) {
+			bar.IncrBy(int(n))
+		},
+	}
+	err = session.Run(ctx)
+	t.fileNames = session.FileNames
+	if err != nil {
+		slog.Error("torrent download failed", "source", t.Source, "err", err)
+		if bar != nil {
+			bar.Abort(true)
+		}
+		return err
+	}
+	slog.Info("finished torrent download", "source", t.Source, "uploaded", session.Uploaded())
+	return nil
+}
+
+// loadMetaInfo parses the magnet link or fetches and parses the .torrent file
+func (t *TorrentDownloadTask) loadMetaInfo(ctx context.Context) (*torrent.MetaInfo, error) {
+	if strings.HasPrefix(t.Source, "magnet:") {
+		return torrent.ParseMagnet(t.Source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("fetching torrent file: %w", &httperr.StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if err != nil {
+		return nil, err
+	}
+	return torrent.ParseMetaInfo(data)
+}
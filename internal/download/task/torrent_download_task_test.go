@@ -0,0 +1,158 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/torrent"
+)
+
+// testTracker is an HTTP tracker returning every peer that announced a port, in the compact format
+type testTracker struct {
+	mu    sync.Mutex
+	peers map[string]bool
+}
+
+func (tr *testTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	port, _ := strconv.Atoi(q.Get("port"))
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	tr.mu.Lock()
+	if port > 0 && q.Get("event") != "stopped" {
+		tr.peers[net.JoinHostPort(host, strconv.Itoa(port))] = true
+	}
+	var compact []byte
+	for addr := range tr.peers {
+		ap := netip.MustParseAddrPort(addr)
+		ip := ap.Addr().As4()
+		compact = append(compact, ip[:]...)
+		compact = binary.BigEndian.AppendUint16(compact, ap.Port())
+	}
+	tr.mu.Unlock()
+	fmt.Fprintf(w, "d8:intervali60e5:peers%d:%se", len(compact), compact)
+}
+
+// bencodeString encodes s as a bencoded string
+func bencodeString(s string) string {
+	return strconv.Itoa(len(s)) + ":" + s
+}
+
+func TestTorrentDownload(t *testing.T) {
+	const name = "data.bin"
+	const pieceLength = 32 * 1024
+	data := make([]byte, 3*pieceLength+1234)
+	rand.Read(data)
+	var pieces []byte
+	for i := 0; i < len(data); i += pieceLength {
+		sum := sha1.Sum(data[i:min(i+pieceLength, len(data))])
+		pieces = append(pieces, sum[:]...)
+	}
+	info := fmt.Sprintf("d6:lengthi%de4:name%s12:piece lengthi%de6:pieces%se",
+		len(data), bencodeString(name), pieceLength, bencodeString(string(pieces)))
+	infoHash := sha1.Sum([]byte(info))
+
+	tracker := httptest.NewServer(&testTracker{peers: map[string]bool{}})
+	defer tracker.Close()
+	torrentFile := "d8:announce" + bencodeString(tracker.URL+"/announce") + "4:info" + info + "e"
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + name:
+			http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+		case "/data.torrent":
+			w.Write([]byte(torrentFile))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer files.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// The seeder downloads the file from a web seed, then uploads it to the peers returned by the tracker
+	meta, err := torrent.ParseMetaInfo([]byte(torrentFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.WebSeeds = []string{files.URL + "/" + name}
+	seeded := make(chan struct{})
+	var received atomic.Int64
+	seeder := &torrent.Session{
+		MetaInfo:      meta,
+		WriterFactory: &storage.FSWriterFactory{BasePath: t.TempDir()},
+		ListenAddr:    "127.0.0.1:0",
+		SeedRatio:     100,
+		OnProgress: func(n int64) {
+			if received.Add(n) == int64(len(data)) {
+				close(seeded)
+			}
+		},
+	}
+	seederDone := make(chan error, 1)
+	go func() { seederDone <- seeder.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-seederDone
+	}()
+	select {
+	case <-seeded:
+	case err := <-seederDone:
+		t.Fatalf("seeder stopped: %v", err)
+	case <-ctx.Done():
+		t.Fatal("seeder did not complete the download")
+	}
+
+	sources := map[string]string{
+		"magnet": "magnet:?xt=urn:btih:" + hex.EncodeToString(infoHash[:]) + "&dn=" + name +
+			"&tr=" + url.QueryEscape(tracker.URL+"/announce"),
+		"torrent file": files.URL + "/data.torrent",
+	}
+	for kind, source := range sources {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			task := &TorrentDownloadTask{
+				Source:             source,
+				WriterFactory:      &storage.FSWriterFactory{BasePath: dir},
+				ProgressBarFactory: reporter.NopProgressBarFactory{},
+			}
+			if err := task.Execute(ctx); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i*pieceLength < len(saved); i++ {
+				sum := sha1.Sum(saved[i*pieceLength : min((i+1)*pieceLength, len(saved))])
+				if !bytes.Equal(sum[:], pieces[i*sha1.Size:(i+1)*sha1.Size]) {
+					t.Errorf("piece %d failed hash check", i)
+				}
+			}
+			if !bytes.Equal(saved, data) {
+				t.Error("saved file differs")
+			}
+		})
+	}
+	if seeder.Uploaded() < 2*int64(len(data)) {
+		t.Errorf("seeder uploaded %d bytes, expected at least %d", seeder.Uploaded(), 2*len(data))
+	}
+}
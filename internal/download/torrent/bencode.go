@@ -0,0 +1,183 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+var errInvalidBencode = errors.New("invalid bencode data")
+
+// decodeBencode decodes a single bencoded value from the start of data.
+// Integers are returned as int64, byte strings as string, lists as []any and dictionaries as map[string]any.
+// It also returns the number of bytes consumed, so that callers can find data that follows the value
+func decodeBencode(data []byte) (any, int, error) {
+	return decodeValue(data, 0)
+}
+
+// decodeValue decodes the bencoded value starting at pos and returns the value along with the position
+// of the first byte after it
+func decodeValue(data []byte, pos int) (any, int, error) {
+	if pos >= len(data) {
+		return nil, pos, io.ErrUnexpectedEOF
+	}
+	switch c := data[pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[pos:], 'e')
+		if end < 0 {
+			return nil, pos, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.ParseInt(string(data[pos+1:pos+end]), 10, 64)
+		if err != nil {
+			return nil, pos, fmt.Errorf("%w: %v", errInvalidBencode, err)
+		}
+		return n, pos + end + 1, nil
+	case c >= '0' && c <= '9':
+		return decodeString(data, pos)
+	case c == 'l':
+		list := []any{}
+		pos++
+		for {
+			if pos >= len(data) {
+				return nil, pos, io.ErrUnexpectedEOF
+			}
+			if data[pos] == 'e' {
+				return list, pos + 1, nil
+			}
+			v, next, err := decodeValue(data, pos)
+			if err != nil {
+				return nil, pos, err
+			}
+			list = append(list, v)
+			pos = next
+		}
+	case c == 'd':
+		dict := map[string]any{}
+		pos++
+		for {
+			if pos >= len(data) {
+				return nil, pos, io.ErrUnexpectedEOF
+			}
+			if data[pos] == 'e' {
+				return dict, pos + 1, nil
+			}
+			key, next, err := decodeString(data, pos)
+			if err != nil {
+				return nil, pos, err
+			}
+			v, next, err := decodeValue(data, next)
+			if err != nil {
+				return nil, pos, err
+			}
+			dict[key.(string)] = v
+			pos = next
+		}
+	default:
+		return nil, pos, fmt.Errorf("%w: unexpected byte %q at offset %d", errInvalidBencode, c, pos)
+	}
+}
+
+// decodeString decodes a length prefixed byte string starting at pos
+func decodeString(data []byte, pos int) (any, int, error) {
+	colon := bytes.IndexByte(data[pos:], ':')
+	if colon < 0 {
+		return nil, pos, io.ErrUnexpectedEOF
+	}
+	n, err := strconv.Atoi(string(data[pos : pos+colon]))
+	if err != nil || n < 0 {
+		return nil, pos, fmt.Errorf("%w: bad string length at offset %d", errInvalidBencode, pos)
+	}
+	start := pos + colon + 1
+	if start+n > len(data) {
+		return nil, pos, io.ErrUnexpectedEOF
+	}
+	return string(data[start : start+n]), start + n, nil
+}
+
+// rawDictValue returns the undecoded bytes of the value stored under key in the top level dictionary.
+// This is used to compute the info hash, which must be calculated over the exact bytes present in the file
+func rawDictValue(data []byte, key string) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("%w: expected dictionary", errInvalidBencode)
+	}
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		k, next, err := decodeString(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		_, end, err := decodeValue(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if k.(string) == key {
+			return data[next:end], nil
+		}
+		pos = end
+	}
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+// encodeBencode returns the bencoded form of v. Supported types are integers, strings, byte slices,
+// []any and map[string]any; dictionary keys are written in sorted order as required by the specification
+func encodeBencode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeBencode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeBencode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := writeBencode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			fmt.Fprintf(buf, "%d:%s", len(k), k)
+			if err := writeBencode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("cannot bencode value of type %T", v)
+	}
+	return nil
+}
+
+// dictString returns the string stored under key, or an empty string if it is missing or of another type
+func dictString(d map[string]any, key string) string {
+	s, _ := d[key].(string)
+	return s
+}
+
+// dictInt returns the integer stored under key, or 0 if it is missing or of another type
+func dictInt(d map[string]any, key string) int64 {
+	n, _ := d[key].(int64)
+	return n
+}
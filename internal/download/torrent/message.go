@@ -0,0 +1,206 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const protocolName = "BitTorrent protocol"
+
+// Message ids of the peer wire protocol (BEP 3) and the extension protocol (BEP 10)
+const (
+	msgChoke         byte = 0
+	msgUnchoke       byte = 1
+	msgInterested    byte = 2
+	msgNotInterested byte = 3
+	msgHave          byte = 4
+	msgBitfield      byte = 5
+	msgRequest       byte = 6
+	msgPiece         byte = 7
+	msgCancel        byte = 8
+	msgExtended      byte = 20
+)
+
+// blockSize is the size of a single request, 16 KiB is the size every client is expected to support
+const blockSize = 16 * 1024
+
+// maxMessageLength bounds the size of a message read from a peer so that a misbehaving peer cannot make us
+// allocate an arbitrary amount of memory. The bitfield of a torrent with many pieces may exceed a block
+func maxMessageLength(numPieces int) uint32 {
+	return uint32(max(blockSize+1024, (numPieces+7)/8+1))
+}
+
+// message is a single length prefixed message. A nil *message represents a keep-alive
+type message struct {
+	ID      byte
+	Payload []byte
+}
+
+// handshake is the first message exchanged on a connection
+type handshake struct {
+	Reserved [8]byte
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+// supportsExtensions reports whether the remote peer has set the BEP 10 extension protocol bit
+func (h *handshake) supportsExtensions() bool {
+	return h.Reserved[5]&0x10 != 0
+}
+
+func writeHandshake(w io.Writer, h handshake) error {
+	buf := make([]byte, 0, 68)
+	buf = append(buf, byte(len(protocolName)))
+	buf = append(buf, protocolName...)
+	buf = append(buf, h.Reserved[:]...)
+	buf = append(buf, h.InfoHash[:]...)
+	buf = append(buf, h.PeerID[:]...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readHandshake(r io.Reader) (handshake, error) {
+	var h handshake
+	var pstrlen [1]byte
+	if _, err := io.ReadFull(r, pstrlen[:]); err != nil {
+		return h, err
+	}
+	buf := make([]byte, int(pstrlen[0])+48)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return h, err
+	}
+	if string(buf[:pstrlen[0]]) != protocolName {
+		return h, fmt.Errorf("unknown protocol %q", buf[:pstrlen[0]])
+	}
+	rest := buf[pstrlen[0]:]
+	copy(h.Reserved[:], rest[0:8])
+	copy(h.InfoHash[:], rest[8:28])
+	copy(h.PeerID[:], rest[28:48])
+	return h, nil
+}
+
+func writeMessage(w io.Writer, m *message) error {
+	if m == nil {
+		_, err := w.Write([]byte{0, 0, 0, 0})
+		return err
+	}
+	buf := make([]byte, 5+len(m.Payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(m.Payload)))
+	buf[4] = m.ID
+	copy(buf[5:], m.Payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readMessage reads a single message, failing if it is longer than maxLength
+func readMessage(r io.Reader, maxLength uint32) (*message, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lenBuf[:])
+	if length == 0 {
+		return nil, nil
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("message of length %d is too large", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &message{ID: buf[0], Payload: buf[1:]}, nil
+}
+
+// newRequestMessage creates a request (or cancel) message for a block
+func newRequestMessage(id byte, index, begin, length int) *message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return &message{ID: id, Payload: payload}
+}
+
+func newHaveMessage(index int) *message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return &message{ID: msgHave, Payload: payload}
+}
+
+func newPieceMessage(index, begin int, block []byte) *message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+	return &message{ID: msgPiece, Payload: payload}
+}
+
+// newExtendedMessage creates a BEP 10 message with the given extended message id.
+// The dictionary is bencoded and followed by trailer, which is used by ut_metadata to carry raw data
+func newExtendedMessage(extID byte, dict map[string]any, trailer []byte) (*message, error) {
+	encoded, err := encodeBencode(dict)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, 0, 1+len(encoded)+len(trailer))
+	payload = append(payload, extID)
+	payload = append(payload, encoded...)
+	payload = append(payload, trailer...)
+	return &message{ID: msgExtended, Payload: payload}, nil
+}
+
+// parseExtendedMessage splits an extended message into its id, bencoded dictionary and any trailing data
+func parseExtendedMessage(payload []byte) (byte, map[string]any, []byte, error) {
+	if len(payload) < 1 {
+		return 0, nil, nil, errors.New("empty extended message")
+	}
+	v, n, err := decodeBencode(payload[1:])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return 0, nil, nil, errors.New("extended message is not a dictionary")
+	}
+	return payload[0], dict, payload[1+n:], nil
+}
+
+// parseBlock parses the index, begin and length fields shared by request and cancel messages
+func parseBlock(payload []byte) (index, begin, length int, err error) {
+	if len(payload) != 12 {
+		return 0, 0, 0, errors.New("malformed request message")
+	}
+	return int(binary.BigEndian.Uint32(payload[0:4])),
+		int(binary.BigEndian.Uint32(payload[4:8])),
+		int(binary.BigEndian.Uint32(payload[8:12])), nil
+}
+
+// bitfield is a set of piece indices, where the high bit of the first byte is piece 0
+type bitfield []byte
+
+func newBitfield(n int) bitfield {
+	return make(bitfield, (n+7)/8)
+}
+
+func (b bitfield) has(index int) bool {
+	i := index / 8
+	if index < 0 || i >= len(b) {
+		return false
+	}
+	return b[i]>>(7-uint(index%8))&1 != 0
+}
+
+func (b bitfield) set(index int) {
+	i := index / 8
+	if index < 0 || i >= len(b) {
+		return
+	}
+	b[i] |= 1 << (7 - uint(index%8))
+}
+
+func (b bitfield) clone() bitfield {
+	return bytes.Clone(b)
+}
@@ -0,0 +1,164 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Extension message ids advertised by us in the BEP 10 handshake
+const (
+	extHandshake  byte = 0
+	extUtMetadata byte = 1
+)
+
+// Message types of the ut_metadata extension (BEP 9)
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// metadataPieceSize is the size of each piece of the info dictionary exchanged with ut_metadata
+const metadataPieceSize = 16 * 1024
+
+// maxMetadataSize limits the size of the info dictionary accepted from peers
+const maxMetadataSize = 16 * 1024 * 1024
+
+// newExtHandshake creates our BEP 10 handshake, metadataSize is 0 when we do not have the metadata yet
+func newExtHandshake(metadataSize int) (*message, error) {
+	dict := map[string]any{
+		"m": map[string]any{"ut_metadata": int(extUtMetadata)},
+		"v": "godown",
+	}
+	if metadataSize > 0 {
+		dict["metadata_size"] = metadataSize
+	}
+	return newExtendedMessage(extHandshake, dict, nil)
+}
+
+// fetchMetadata connects to a single peer and downloads the info dictionary using the ut_metadata extension.
+// The returned bytes are verified against infoHash
+func fetchMetadata(ctx context.Context, addr string, infoHash, peerID [20]byte) ([]byte, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	remote, err := exchangeHandshake(conn, infoHash, peerID, true)
+	if err != nil {
+		return nil, err
+	}
+	if !remote.supportsExtensions() {
+		return nil, errors.New("peer does not support the extension protocol")
+	}
+	hs, err := newExtHandshake(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, hs); err != nil {
+		return nil, err
+	}
+
+	var remoteID byte
+	var data []byte
+	var received int
+	numPieces := 0
+	for {
+		// The peer may send the bitfield of a torrent whose info dictionary is as large as we accept
+		msg, err := readMessage(conn, maxMessageLength(maxMetadataSize/sha1.Size))
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil || msg.ID != msgExtended {
+			continue
+		}
+		id, dict, trailer, err := parseExtendedMessage(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case extHandshake:
+			m, _ := dict["m"].(map[string]any)
+			utID := dictInt(m, "ut_metadata")
+			size := dictInt(dict, "metadata_size")
+			if utID <= 0 || utID > 255 {
+				return nil, errors.New("peer does not support ut_metadata")
+			}
+			if size <= 0 || size > maxMetadataSize {
+				return nil, fmt.Errorf("invalid metadata size %d", size)
+			}
+			remoteID = byte(utID)
+			data = make([]byte, size)
+			numPieces = int((size + metadataPieceSize - 1) / metadataPieceSize)
+			for i := 0; i < numPieces; i++ {
+				req, err := newExtendedMessage(remoteID, map[string]any{"msg_type": metadataRequest, "piece": i}, nil)
+				if err != nil {
+					return nil, err
+				}
+				if err := writeMessage(conn, req); err != nil {
+					return nil, err
+				}
+			}
+		case extUtMetadata:
+			if data == nil {
+				continue
+			}
+			switch dictInt(dict, "msg_type") {
+			case metadataReject:
+				return nil, errors.New("peer rejected metadata request")
+			case metadataData:
+				piece := int(dictInt(dict, "piece"))
+				begin := piece * metadataPieceSize
+				if piece < 0 || piece >= numPieces || begin+len(trailer) > len(data) {
+					return nil, errors.New("invalid metadata piece")
+				}
+				copy(data[begin:], trailer)
+				received++
+				if received == numPieces {
+					if sha1.Sum(data) != infoHash {
+						return nil, errors.New("metadata does not match info hash")
+					}
+					return data, nil
+				}
+			}
+		}
+	}
+}
+
+// exchangeHandshake performs the BitTorrent handshake on conn, when initiator is true our handshake is sent first.
+// It fails if the remote peer is serving a different torrent or if we have connected to ourselves
+func exchangeHandshake(conn net.Conn, infoHash, peerID [20]byte, initiator bool) (handshake, error) {
+	ours := handshake{InfoHash: infoHash, PeerID: peerID}
+	ours.Reserved[5] |= 0x10
+	if initiator {
+		if err := writeHandshake(conn, ours); err != nil {
+			return handshake{}, err
+		}
+	}
+	remote, err := readHandshake(conn)
+	if err != nil {
+		return remote, err
+	}
+	if remote.InfoHash != infoHash {
+		return remote, errors.New("info hash mismatch")
+	}
+	if remote.PeerID == peerID {
+		return remote, errors.New("connected to self")
+	}
+	if !initiator {
+		if err := writeHandshake(conn, ours); err != nil {
+			return remote, err
+		}
+	}
+	return remote, nil
+}
@@ -0,0 +1,243 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// File is a single file inside a torrent. Path is slash separated and relative to the torrent name
+// for multi file torrents. Offset is the position of the first byte of the file in the concatenated content
+type File struct {
+	Path   string
+	Length int64
+	Offset int64
+}
+
+// Info holds the parsed "info" dictionary of a torrent
+// Raw contains the exact bencoded bytes of the dictionary, which are hashed to get the info hash
+// and served to peers requesting the metadata
+type Info struct {
+	Name        string
+	PieceLength int64
+	Pieces      [][sha1.Size]byte
+	Files       []File
+	MultiFile   bool
+	Raw         []byte
+}
+
+// TotalLength returns the sum of the lengths of all files in the torrent
+func (i *Info) TotalLength() int64 {
+	var total int64
+	for _, f := range i.Files {
+		total += f.Length
+	}
+	return total
+}
+
+// PieceSize returns the size of the piece at index, the last piece may be shorter than PieceLength
+func (i *Info) PieceSize(index int) int64 {
+	begin := int64(index) * i.PieceLength
+	end := begin + i.PieceLength
+	if total := i.TotalLength(); end > total {
+		end = total
+	}
+	return end - begin
+}
+
+// MetaInfo describes a torrent either loaded from a .torrent file or from a magnet link.
+// Info is nil for magnet links until the metadata has been fetched from peers, DisplayName is the
+// "dn" parameter of the magnet link and can be used to refer to the torrent until then
+type MetaInfo struct {
+	InfoHash    [sha1.Size]byte
+	DisplayName string
+	Info        *Info
+	Trackers    []string
+	WebSeeds    []string
+}
+
+// ParseMetaInfo parses the contents of a .torrent file
+func ParseMetaInfo(data []byte) (*MetaInfo, error) {
+	v, _, err := decodeBencode(data)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metainfo is not a dictionary", errInvalidBencode)
+	}
+	rawInfo, err := rawDictValue(data, "info")
+	if err != nil {
+		return nil, err
+	}
+	info, err := ParseInfo(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+	m := &MetaInfo{InfoHash: sha1.Sum(rawInfo), Info: info, DisplayName: info.Name}
+
+	if announce := dictString(dict, "announce"); announce != "" {
+		m.Trackers = append(m.Trackers, announce)
+	}
+	if tiers, ok := dict["announce-list"].([]any); ok {
+		for _, tier := range tiers {
+			list, _ := tier.([]any)
+			for _, tr := range list {
+				if s, ok := tr.(string); ok && !slices.Contains(m.Trackers, s) {
+					m.Trackers = append(m.Trackers, s)
+				}
+			}
+		}
+	}
+	// BEP 19 allows url-list to be either a single string or a list of strings
+	switch urls := dict["url-list"].(type) {
+	case string:
+		m.WebSeeds = append(m.WebSeeds, urls)
+	case []any:
+		for _, u := range urls {
+			if s, ok := u.(string); ok && s != "" {
+				m.WebSeeds = append(m.WebSeeds, s)
+			}
+		}
+	}
+	return m, nil
+}
+
+// maxPieceLength limits the piece length accepted from a torrent, as a whole piece is held in memory while it is
+// downloaded and verified
+const maxPieceLength = 64 * 1024 * 1024
+
+// maxTotalLength limits the total length of the files of a torrent so that computing the number of pieces
+// cannot overflow
+const maxTotalLength = math.MaxInt64 - maxPieceLength
+
+// ParseInfo parses a bencoded info dictionary. File paths are validated so that a malicious torrent
+// cannot write outside of the download directory
+func ParseInfo(raw []byte) (*Info, error) {
+	v, _, err := decodeBencode(raw)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: info is not a dictionary", errInvalidBencode)
+	}
+	info := &Info{Name: dictString(dict, "name"), PieceLength: dictInt(dict, "piece length"), Raw: raw}
+	if !isSafePathElement(info.Name) {
+		return nil, fmt.Errorf("invalid torrent name %q", info.Name)
+	}
+	if info.PieceLength <= 0 || info.PieceLength > maxPieceLength {
+		return nil, fmt.Errorf("invalid piece length %d", info.PieceLength)
+	}
+
+	pieces := dictString(dict, "pieces")
+	if len(pieces)%sha1.Size != 0 {
+		return nil, errors.New("pieces length is not a multiple of 20")
+	}
+	info.Pieces = make([][sha1.Size]byte, len(pieces)/sha1.Size)
+	for i := range info.Pieces {
+		copy(info.Pieces[i][:], pieces[i*sha1.Size:])
+	}
+
+	if files, ok := dict["files"].([]any); ok {
+		info.MultiFile = true
+		var offset int64
+		for _, f := range files {
+			fd, ok := f.(map[string]any)
+			if !ok {
+				return nil, errors.New("invalid file entry")
+			}
+			elems, _ := fd["path"].([]any)
+			parts := make([]string, 0, len(elems))
+			for _, e := range elems {
+				s, _ := e.(string)
+				if !isSafePathElement(s) {
+					return nil, fmt.Errorf("invalid path element %q", s)
+				}
+				parts = append(parts, s)
+			}
+			if len(parts) == 0 {
+				return nil, errors.New("file entry without path")
+			}
+			length := dictInt(fd, "length")
+			if length < 0 {
+				return nil, errors.New("negative file length")
+			}
+			if length > maxTotalLength-offset {
+				return nil, errors.New("torrent is too large")
+			}
+			info.Files = append(info.Files, File{Path: path.Join(parts...), Length: length, Offset: offset})
+			offset += length
+		}
+	} else {
+		length := dictInt(dict, "length")
+		if length < 0 {
+			return nil, errors.New("negative file length")
+		}
+		if length > maxTotalLength {
+			return nil, errors.New("torrent is too large")
+		}
+		info.Files = []File{{Path: info.Name, Length: length}}
+	}
+
+	expected := (info.TotalLength() + info.PieceLength - 1) / info.PieceLength
+	if int64(len(info.Pieces)) != expected {
+		return nil, fmt.Errorf("expected %d pieces, found %d", expected, len(info.Pieces))
+	}
+	return info, nil
+}
+
+// ParseMagnet parses a magnet URI of the form magnet:?xt=urn:btih:<hash>&dn=<name>&tr=<tracker>&ws=<webseed>
+// Both hex and base32 encoded info hashes are accepted
+func ParseMagnet(uri string) (*MetaInfo, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet link: %q", uri)
+	}
+	q := u.Query()
+	m := &MetaInfo{}
+	found := false
+	for _, xt := range q["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+		hash := strings.TrimPrefix(xt, "urn:btih:")
+		var decoded []byte
+		switch len(hash) {
+		case 40:
+			decoded, err = hex.DecodeString(hash)
+		case 32:
+			decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("invalid info hash length %d", len(hash))
+		}
+		if err != nil {
+			return nil, err
+		}
+		copy(m.InfoHash[:], decoded)
+		found = true
+		break
+	}
+	if !found {
+		return nil, errors.New("magnet link does not contain a btih info hash")
+	}
+	m.DisplayName = q.Get("dn")
+	m.Trackers = q["tr"]
+	m.WebSeeds = q["ws"]
+	return m, nil
+}
+
+// isSafePathElement reports whether s can be used as a single path component without escaping the parent directory
+func isSafePathElement(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\\x00")
+}
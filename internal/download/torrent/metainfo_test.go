@@ -0,0 +1,76 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testInfo builds an info dictionary with the given piece length, file lengths and number of pieces
+func testInfo(pieceLength int64, numPieces int, lengths ...int64) []byte {
+	pieces := strings.Repeat("x", numPieces*20)
+	var b strings.Builder
+	b.WriteString("d")
+	if len(lengths) == 1 {
+		b.WriteString("6:lengthi" + strconv.FormatInt(lengths[0], 10) + "e")
+	} else {
+		b.WriteString("5:filesl")
+		for i, length := range lengths {
+			b.WriteString("d6:lengthi" + strconv.FormatInt(length, 10) + "e4:pathl1:" + strconv.Itoa(i) + "ee")
+		}
+		b.WriteString("e")
+	}
+	b.WriteString("4:name4:test12:piece lengthi" + strconv.FormatInt(pieceLength, 10) + "e")
+	b.WriteString("6:pieces" + strconv.Itoa(len(pieces)) + ":" + pieces + "e")
+	return []byte(b.String())
+}
+
+func TestParseInfoLimits(t *testing.T) {
+	const maxInt64 = 1<<63 - 1
+	tests := []struct {
+		name  string
+		raw   []byte
+		valid bool
+	}{
+		{"single file", testInfo(16384, 3, 40000), true},
+		{"multiple files", testInfo(16384, 2, 20000, 12768), true},
+		{"largest piece length", testInfo(maxPieceLength, 1, 100), true},
+		{"zero piece length", testInfo(0, 1, 100), false},
+		{"piece length too large", testInfo(maxPieceLength+1, 1, 100), false},
+		{"wrong number of pieces", testInfo(16384, 2, 40000), false},
+		{"negative length", testInfo(16384, 1, -1), false},
+		{"length overflowing piece count", testInfo(16384, 1, maxInt64), false},
+		{"total length overflowing", testInfo(16384, 1, maxInt64/2+1, maxInt64/2+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseInfo(tt.raw)
+			if (err == nil) != tt.valid {
+				t.Errorf("error %v, expected valid: %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestReadBitfieldOfManyPieces(t *testing.T) {
+	const numPieces = 200000
+	var buf bytes.Buffer
+	bf := newBitfield(numPieces)
+	bf.set(numPieces - 1)
+	if err := writeMessage(&buf, &message{ID: msgBitfield, Payload: bf}); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	if _, err := readMessage(bytes.NewReader(raw), maxMessageLength(numPieces)); err != nil {
+		t.Fatalf("bitfield of %d pieces rejected: %v", numPieces, err)
+	}
+	if _, err := readMessage(bytes.NewReader(raw), maxMessageLength(1)); err == nil {
+		t.Error("bitfield larger than the limit accepted")
+	}
+	huge := binary.BigEndian.AppendUint32(nil, 1<<30)
+	if _, err := readMessage(bytes.NewReader(huge), maxMessageLength(numPieces)); err == nil {
+		t.Error("message of 1GiB accepted")
+	}
+}
@@ -0,0 +1,370 @@
+package torrent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// maxBacklog is the number of block requests kept outstanding with a single peer
+const maxBacklog = 5
+
+// peerTimeout is the time after which a silent peer is disconnected, peers send keep-alives every two minutes
+const peerTimeout = 3 * time.Minute
+
+// peerConn holds the state of a connection to a single peer.
+// All writes to the connection happen on the goroutine running loop; messages are read on a separate goroutine
+type peerConn struct {
+	s    *Session
+	conn net.Conn
+	addr string
+
+	has            bitfield
+	sentHave       bitfield
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+	remoteMetaID   byte
+
+	// State of the piece currently being downloaded from this peer
+	active     bool
+	piece      int
+	buf        []byte
+	downloaded int
+	requested  int
+	backlog    int
+}
+
+// runPeer performs the handshake on conn and then exchanges messages with the peer until the connection fails,
+// the torrent is complete and the peer has nothing more to give us, or the context is cancelled
+func (s *Session) runPeer(ctx context.Context, conn net.Conn, initiator bool) {
+	addr := conn.RemoteAddr().String()
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	remote, err := exchangeHandshake(conn, s.MetaInfo.InfoHash, s.peerID, initiator)
+	if err != nil {
+		slog.Info("peer handshake failed", "peer", addr, "err", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	numPieces := len(s.MetaInfo.Info.Pieces)
+	p := &peerConn{
+		s:           s,
+		conn:        conn,
+		addr:        addr,
+		has:         newBitfield(numPieces),
+		sentHave:    s.picker.bitfield(),
+		amChoking:   true,
+		peerChoking: true,
+	}
+	defer p.cleanup()
+
+	if remote.supportsExtensions() {
+		hs, err := newExtHandshake(len(s.MetaInfo.Info.Raw))
+		if err == nil {
+			err = p.send(hs)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if err := p.send(&message{ID: msgBitfield, Payload: p.sentHave.clone()}); err != nil {
+		return
+	}
+	slog.Info("connected to peer", "peer", addr)
+	if err := p.loop(ctx); err != nil && ctx.Err() == nil {
+		slog.Info("peer disconnected", "peer", addr, "err", err)
+	}
+}
+
+func (p *peerConn) send(m *message) error {
+	p.conn.SetWriteDeadline(time.Now().Add(peerTimeout))
+	return writeMessage(p.conn, m)
+}
+
+// cleanup releases the piece being downloaded and removes the peer's pieces from the availability counts
+func (p *peerConn) cleanup() {
+	if p.active {
+		p.s.picker.release(p.piece)
+		p.active = false
+	}
+	p.s.picker.addAvailability(p.has, -1)
+}
+
+// loop reads messages from the peer and reacts to them
+func (p *peerConn) loop(ctx context.Context) error {
+	msgs := make(chan *message)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			p.conn.SetReadDeadline(time.Now().Add(peerTimeout))
+			msg, err := readMessage(p.conn, maxMessageLength(len(p.s.MetaInfo.Info.Pieces)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	lastSent := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case msg := <-msgs:
+			if msg != nil {
+				if err := p.handle(msg); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := p.sendHaves(); err != nil {
+				return err
+			}
+			if time.Since(lastSent) > 2*time.Minute {
+				if err := p.send(nil); err != nil {
+					return err
+				}
+				lastSent = time.Now()
+			}
+			if p.s.picker.isComplete() && p.isSeed() {
+				return nil
+			}
+		}
+		if err := p.updateInterest(); err != nil {
+			return err
+		}
+		if err := p.requestBlocks(); err != nil {
+			return err
+		}
+	}
+}
+
+// isSeed reports whether the peer has announced every piece
+func (p *peerConn) isSeed() bool {
+	for i := range p.s.MetaInfo.Info.Pieces {
+		if !p.has.has(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendHaves announces pieces that were completed since the last call
+func (p *peerConn) sendHaves() error {
+	have := p.s.picker.bitfield()
+	for i := range p.s.MetaInfo.Info.Pieces {
+		if have.has(i) && !p.sentHave.has(i) {
+			p.sentHave.set(i)
+			if err := p.send(newHaveMessage(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handle processes a single message from the peer
+func (p *peerConn) handle(msg *message) error {
+	numPieces := len(p.s.MetaInfo.Info.Pieces)
+	switch msg.ID {
+	case msgChoke:
+		p.peerChoking = true
+		// Requests are discarded by a choking peer, give the piece back so that others can fetch it
+		if p.active {
+			p.s.picker.release(p.piece)
+			p.active = false
+		}
+	case msgUnchoke:
+		p.peerChoking = false
+	case msgInterested:
+		p.peerInterested = true
+		if p.amChoking && p.s.SeedRatio > 0 && p.s.store.readable {
+			p.amChoking = false
+			return p.send(&message{ID: msgUnchoke})
+		}
+	case msgNotInterested:
+		p.peerInterested = false
+	case msgHave:
+		if len(msg.Payload) != 4 {
+			return errors.New("malformed have message")
+		}
+		index := int(binary.BigEndian.Uint32(msg.Payload))
+		if index < numPieces && !p.has.has(index) {
+			p.has.set(index)
+			p.s.picker.addHave(index)
+		}
+	case msgBitfield:
+		if len(msg.Payload) != len(p.has) {
+			return errors.New("malformed bitfield message")
+		}
+		p.s.picker.addAvailability(p.has, -1)
+		p.has = bitfield(msg.Payload).clone()
+		p.s.picker.addAvailability(p.has, 1)
+	case msgRequest:
+		return p.serveRequest(msg.Payload)
+	case msgPiece:
+		return p.receiveBlock(msg.Payload)
+	case msgExtended:
+		return p.handleExtended(msg.Payload)
+	}
+	return nil
+}
+
+// updateInterest tells the peer whether it has pieces that we still need
+func (p *peerConn) updateInterest() error {
+	interested := false
+	if !p.s.picker.isComplete() {
+		have := p.s.picker.bitfield()
+		for i := range p.s.MetaInfo.Info.Pieces {
+			if p.has.has(i) && !have.has(i) {
+				interested = true
+				break
+			}
+		}
+	}
+	if interested == p.amInterested {
+		return nil
+	}
+	p.amInterested = interested
+	if interested {
+		return p.send(&message{ID: msgInterested})
+	}
+	return p.send(&message{ID: msgNotInterested})
+}
+
+// requestBlocks picks a piece if none is active and keeps the request pipeline full
+func (p *peerConn) requestBlocks() error {
+	if p.peerChoking || !p.amInterested {
+		return nil
+	}
+	if !p.active {
+		index, ok := p.s.picker.pick(p.has.has)
+		if !ok {
+			return nil
+		}
+		p.active = true
+		p.piece = index
+		p.buf = make([]byte, p.s.MetaInfo.Info.PieceSize(index))
+		p.downloaded, p.requested, p.backlog = 0, 0, 0
+	}
+	for p.backlog < maxBacklog && p.requested < len(p.buf) {
+		length := min(blockSize, len(p.buf)-p.requested)
+		if err := p.send(newRequestMessage(msgRequest, p.piece, p.requested, length)); err != nil {
+			return err
+		}
+		p.requested += length
+		p.backlog++
+	}
+	return nil
+}
+
+// receiveBlock copies a block into the active piece and completes the piece once all blocks have arrived
+func (p *peerConn) receiveBlock(payload []byte) error {
+	if len(payload) < 8 {
+		return errors.New("malformed piece message")
+	}
+	index := int(binary.BigEndian.Uint32(payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(payload[4:8]))
+	block := payload[8:]
+	if !p.active || index != p.piece {
+		// A late block from a piece that was given up after a choke
+		return nil
+	}
+	if begin+len(block) > len(p.buf) {
+		return errors.New("block out of range")
+	}
+	copy(p.buf[begin:], block)
+	p.downloaded += len(block)
+	p.backlog--
+	if p.downloaded < len(p.buf) {
+		return nil
+	}
+
+	p.active = false
+	if err := p.s.completePiece(p.piece, p.buf); err != nil {
+		p.s.picker.release(p.piece)
+		slog.Warn("discarding piece", "peer", p.addr, "piece", p.piece, "err", err)
+	}
+	p.buf = nil
+	return nil
+}
+
+// serveRequest uploads a block to an unchoked peer
+func (p *peerConn) serveRequest(payload []byte) error {
+	index, begin, length, err := parseBlock(payload)
+	if err != nil {
+		return err
+	}
+	if p.amChoking || !p.s.picker.hasPiece(index) {
+		return nil
+	}
+	if length <= 0 || length > blockSize || int64(begin+length) > p.s.MetaInfo.Info.PieceSize(index) {
+		return errors.New("invalid block request")
+	}
+	block, err := p.s.store.readBlock(index, begin, length)
+	if err != nil {
+		return err
+	}
+	if err := p.send(newPieceMessage(index, begin, block)); err != nil {
+		return err
+	}
+	p.s.addUploaded(length)
+	return nil
+}
+
+// handleExtended processes BEP 10 messages, we record the peer's ut_metadata id and answer metadata requests
+func (p *peerConn) handleExtended(payload []byte) error {
+	id, dict, _, err := parseExtendedMessage(payload)
+	if err != nil {
+		return err
+	}
+	switch id {
+	case extHandshake:
+		m, _ := dict["m"].(map[string]any)
+		if utID := dictInt(m, "ut_metadata"); utID > 0 && utID < 256 {
+			p.remoteMetaID = byte(utID)
+		}
+	case extUtMetadata:
+		if p.remoteMetaID == 0 || dictInt(dict, "msg_type") != metadataRequest {
+			return nil
+		}
+		raw := p.s.MetaInfo.Info.Raw
+		piece := int(dictInt(dict, "piece"))
+		begin := piece * metadataPieceSize
+		if piece < 0 || begin >= len(raw) {
+			reject, err := newExtendedMessage(p.remoteMetaID, map[string]any{"msg_type": metadataReject, "piece": piece}, nil)
+			if err != nil {
+				return err
+			}
+			return p.send(reject)
+		}
+		end := min(begin+metadataPieceSize, len(raw))
+		resp, err := newExtendedMessage(p.remoteMetaID,
+			map[string]any{"msg_type": metadataData, "piece": piece, "total_size": len(raw)}, raw[begin:end])
+		if err != nil {
+			return err
+		}
+		return p.send(resp)
+	}
+	return nil
+}
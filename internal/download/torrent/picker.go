@@ -0,0 +1,141 @@
+package torrent
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// picker decides which piece should be downloaded next using the rarest first strategy.
+// It keeps track of how many connected peers have each piece, the pieces that are being downloaded
+// and the pieces that have been verified. When every remaining piece is already assigned, pieces are
+// handed out again to other peers (end game mode) so that a single slow peer cannot stall the download
+type picker struct {
+	mu           sync.Mutex
+	have         bitfield
+	haveCount    int
+	numPieces    int
+	availability []int
+	inProgress   map[int]int
+	done         chan struct{}
+}
+
+func newPicker(numPieces int) *picker {
+	p := &picker{
+		have:         newBitfield(numPieces),
+		numPieces:    numPieces,
+		availability: make([]int, numPieces),
+		inProgress:   map[int]int{},
+		done:         make(chan struct{}),
+	}
+	if numPieces == 0 {
+		close(p.done)
+	}
+	return p
+}
+
+// addAvailability records that a peer has the pieces in bf, delta is -1 when the peer disconnects
+func (p *picker) addAvailability(bf bitfield, delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < p.numPieces; i++ {
+		if bf.has(i) {
+			p.availability[i] += delta
+		}
+	}
+}
+
+// addHave records that a peer announced a single piece
+func (p *picker) addHave(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index >= 0 && index < p.numPieces {
+		p.availability[index]++
+	}
+}
+
+// pick returns the rarest piece that peerHas reports as available and that is neither verified nor being downloaded.
+// The boolean is false when there is nothing left for this peer to download
+func (p *picker) pick(peerHas func(int) bool) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best, bestAvail, ties := -1, 0, 0
+	for i := 0; i < p.numPieces; i++ {
+		if p.have.has(i) || p.inProgress[i] > 0 || !peerHas(i) {
+			continue
+		}
+		avail := p.availability[i]
+		switch {
+		case best < 0 || avail < bestAvail:
+			best, bestAvail, ties = i, avail, 1
+		case avail == bestAvail:
+			// Reservoir sampling so that peers do not all start with the same piece
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		// End game: request a piece that is already being downloaded by the fewest peers
+		for i, n := range p.inProgress {
+			if n > 0 && !p.have.has(i) && peerHas(i) && (best < 0 || n < p.inProgress[best]) {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	p.inProgress[best]++
+	return best, true
+}
+
+// release returns a piece that could not be downloaded so that it can be picked again
+func (p *picker) release(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inProgress[index] > 0 {
+		p.inProgress[index]--
+	}
+}
+
+// complete marks a piece as verified. It returns false if the piece had already been completed by another peer
+func (p *picker) complete(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.inProgress, index)
+	if p.have.has(index) {
+		return false
+	}
+	p.have.set(index)
+	p.haveCount++
+	if p.haveCount == p.numPieces {
+		close(p.done)
+	}
+	return true
+}
+
+// hasPiece reports whether the piece has been verified
+func (p *picker) hasPiece(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.have.has(index)
+}
+
+// bitfield returns a copy of the verified pieces
+func (p *picker) bitfield() bitfield {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.have.clone()
+}
+
+// isComplete reports whether all pieces have been verified
+func (p *picker) isComplete() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,458 @@
+package torrent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// peerIDPrefix identifies the client in the Azureus style peer id convention
+const peerIDPrefix = "-GD0001-"
+
+// defaultAnnounceInterval is used when the tracker does not specify an interval
+const defaultAnnounceInterval = 2 * time.Minute
+
+// maxWebSeedFailures is the number of consecutive failures after which a web seed is no longer used
+const maxWebSeedFailures = 5
+
+// Session downloads a single torrent from peers returned by HTTP trackers and from web seeds,
+// and optionally keeps seeding it after the download completes.
+// ListenAddr is the address used to accept incoming peer connections, if it is empty, only outgoing connections are made.
+// The download is considered finished once every file is complete; if SeedRatio is greater than zero the session keeps
+// uploading until SeedRatio times the torrent size has been uploaded. StallTimeout aborts the session if no piece is
+// completed for that long, zero disables it. OnMetadata is called once the info dictionary is known and OnProgress is
+// called with the size of every verified piece.
+// FileNames maps the path of every file of the torrent to the name it was saved as. A session run with the map of an
+// earlier one reopens those files and keeps their intact pieces instead of creating new files, which is used when a
+// download is retried or resumed
+type Session struct {
+	MetaInfo      *MetaInfo
+	WriterFactory storage.WriterFactory
+	FileNames     map[string]string
+	HTTPClient    *http.Client
+	ListenAddr    string
+	MaxPeers      int
+	SeedRatio     float64
+	StallTimeout  time.Duration
+	OnMetadata    func(info *Info)
+	OnProgress    func(n int64)
+
+	peerID     [20]byte
+	port       int
+	store      *pieceStore
+	picker     *picker
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	lastPiece  atomic.Int64
+	seedDone   chan struct{}
+	seedOnce   sync.Once
+
+	mu    sync.Mutex
+	known map[string]bool
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Run downloads the torrent and blocks until it is complete (and seeded if requested), the context is cancelled
+// or the download stalls
+func (s *Session) Run(ctx context.Context) error {
+	if s.MetaInfo == nil {
+		return errors.New("torrent metainfo is required")
+	}
+	if s.HTTPClient == nil {
+		s.HTTPClient = http.DefaultClient
+	}
+	if s.MaxPeers <= 0 {
+		s.MaxPeers = 30
+	}
+	copy(s.peerID[:], peerIDPrefix)
+	if _, err := rand.Read(s.peerID[len(peerIDPrefix):]); err != nil {
+		return err
+	}
+	s.known = map[string]bool{}
+	s.slots = make(chan struct{}, s.MaxPeers)
+	s.seedDone = make(chan struct{})
+	s.lastPiece.Store(time.Now().UnixNano())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	var listener net.Listener
+	if s.ListenAddr != "" {
+		l, err := net.Listen("tcp", s.ListenAddr)
+		if err != nil {
+			slog.Warn("could not listen for peers, trying a random port", "addr", s.ListenAddr, "err", err)
+			l, err = net.Listen("tcp", ":0")
+		}
+		if err != nil {
+			slog.Warn("could not listen for peers", "err", err)
+		} else {
+			listener = l
+			s.port = l.Addr().(*net.TCPAddr).Port
+			context.AfterFunc(ctx, func() { l.Close() })
+		}
+	}
+
+	if s.MetaInfo.Info == nil {
+		info, err := s.resolveMetadata(ctx)
+		if err != nil {
+			return err
+		}
+		s.MetaInfo.Info = info
+	}
+	info := s.MetaInfo.Info
+	if s.OnMetadata != nil {
+		s.OnMetadata(info)
+	}
+
+	if s.FileNames == nil {
+		s.FileNames = map[string]string{}
+	}
+	store, err := newPieceStore(info, s.WriterFactory, s.FileNames)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	s.store = store
+	s.picker = newPicker(len(info.Pieces))
+	if existing := store.existingPieces(); len(existing) > 0 {
+		slog.Info("resuming torrent", "name", info.Name, "pieces", len(existing))
+		for _, index := range existing {
+			s.picker.complete(index)
+			if s.OnProgress != nil {
+				s.OnProgress(info.PieceSize(index))
+			}
+		}
+	}
+
+	if listener != nil {
+		s.goWorker(func() { s.acceptLoop(ctx, listener) })
+	}
+	for _, tracker := range s.MetaInfo.Trackers {
+		s.goWorker(func() { s.trackerLoop(ctx, tracker) })
+	}
+	for _, u := range s.MetaInfo.WebSeeds {
+		ws := &webSeed{url: u, info: info, client: s.HTTPClient}
+		s.goWorker(func() { s.webSeedLoop(ctx, ws) })
+	}
+	if len(s.MetaInfo.Trackers) == 0 && len(s.MetaInfo.WebSeeds) == 0 && listener == nil {
+		return errors.New("torrent has no trackers or web seeds")
+	}
+
+	if err := s.waitForCompletion(ctx); err != nil {
+		return err
+	}
+	slog.Info("torrent download complete", "name", info.Name, "bytes", info.TotalLength())
+
+	if s.SeedRatio > 0 {
+		if listener == nil || !store.readable {
+			slog.Warn("seeding is not possible without a listener and a readable storage backend", "name", info.Name)
+			return nil
+		}
+		slog.Info("seeding torrent", "name", info.Name, "ratio", s.SeedRatio)
+		select {
+		case <-ctx.Done():
+		case <-s.seedDone:
+			slog.Info("seed ratio reached", "name", info.Name, "uploaded", s.uploaded.Load())
+		}
+	}
+	return nil
+}
+
+// Uploaded returns the number of piece bytes sent to other peers
+func (s *Session) Uploaded() int64 {
+	return s.uploaded.Load()
+}
+
+// goWorker runs fn in a goroutine that Run waits for before returning
+func (s *Session) goWorker(fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+}
+
+// waitForCompletion blocks until every piece has been verified, checking periodically that the download has not stalled
+func (s *Session) waitForCompletion(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.picker.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			last := time.Unix(0, s.lastPiece.Load())
+			if s.StallTimeout > 0 && time.Since(last) > s.StallTimeout {
+				return fmt.Errorf("no progress for %s", s.StallTimeout)
+			}
+		}
+	}
+}
+
+// resolveMetadata fetches the info dictionary of a magnet link from the peers returned by the trackers.
+// Up to MaxPeers peers are asked concurrently and the first verified reply is used
+func (s *Session) resolveMetadata(ctx context.Context) (*Info, error) {
+	deadline := time.Now().Add(s.StallTimeout)
+	for attempt := 0; ; attempt++ {
+		var peers []string
+		for _, tracker := range s.MetaInfo.Trackers {
+			resp, err := announce(ctx, s.HTTPClient, tracker, s.announceRequest(""))
+			if err != nil {
+				slog.Warn("tracker announce failed", "tracker", tracker, "err", err)
+				continue
+			}
+			for _, addr := range resp.Peers {
+				if !slices.Contains(peers, addr) && !(s.port != 0 && isLocalAddr(addr, s.port)) {
+					peers = append(peers, addr)
+				}
+			}
+		}
+		if raw := s.fetchMetadataFromAny(ctx, peers); raw != nil {
+			return ParseInfo(raw)
+		}
+		if s.StallTimeout > 0 && time.Now().After(deadline) {
+			return nil, errors.New("could not fetch torrent metadata from any peer")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(time.Duration(attempt+1)*5*time.Second, time.Minute)):
+		}
+	}
+}
+
+// fetchMetadataFromAny asks the peers for the metadata concurrently and returns the first result, or nil
+func (s *Session) fetchMetadataFromAny(ctx context.Context, peers []string) []byte {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan []byte, len(peers))
+	sem := make(chan struct{}, s.MaxPeers)
+	for _, addr := range peers {
+		go func() {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results <- nil
+				return
+			}
+			raw, err := fetchMetadata(ctx, addr, s.MetaInfo.InfoHash, s.peerID)
+			if err != nil {
+				slog.Info("fetching metadata failed", "peer", addr, "err", err)
+			} else {
+				slog.Info("fetched metadata", "peer", addr, "size", len(raw))
+			}
+			results <- raw
+		}()
+	}
+	for range peers {
+		if raw := <-results; raw != nil {
+			return raw
+		}
+	}
+	return nil
+}
+
+// announceRequest returns the current announce parameters
+func (s *Session) announceRequest(event string) announceRequest {
+	left := int64(1)
+	if s.MetaInfo.Info != nil {
+		left = s.MetaInfo.Info.TotalLength() - s.downloaded.Load()
+	}
+	return announceRequest{
+		InfoHash:   s.MetaInfo.InfoHash,
+		PeerID:     s.peerID,
+		Port:       s.port,
+		Uploaded:   s.uploaded.Load(),
+		Downloaded: s.downloaded.Load(),
+		Left:       max(left, 0),
+		Event:      event,
+	}
+}
+
+// trackerLoop announces to a tracker periodically and connects to the peers it returns
+func (s *Session) trackerLoop(ctx context.Context, tracker string) {
+	event := "started"
+	completed := s.picker.done
+	for {
+		resp, err := announce(ctx, s.HTTPClient, tracker, s.announceRequest(event))
+		interval := defaultAnnounceInterval
+		if err != nil {
+			slog.Warn("tracker announce failed", "tracker", tracker, "err", err)
+		} else {
+			slog.Info("tracker announce", "tracker", tracker, "peers", len(resp.Peers))
+			event = ""
+			if resp.Interval > 0 {
+				interval = max(resp.Interval, 30*time.Second)
+			}
+			for _, addr := range resp.Peers {
+				s.connect(ctx, addr)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			announce(stopCtx, s.HTTPClient, tracker, s.announceRequest("stopped"))
+			cancel()
+			return
+		case <-completed:
+			// Tell the tracker once that the download has completed, a nil channel is never ready
+			completed = nil
+			event = "completed"
+		case <-time.After(interval):
+		}
+	}
+}
+
+// connect starts a connection to addr unless it is already known or all peer slots are in use
+func (s *Session) connect(ctx context.Context, addr string) {
+	if s.port != 0 && isLocalAddr(addr, s.port) {
+		return
+	}
+	s.mu.Lock()
+	if s.known[addr] {
+		s.mu.Unlock()
+		return
+	}
+	s.known[addr] = true
+	s.mu.Unlock()
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		s.forget(addr)
+		return
+	}
+	s.goWorker(func() {
+		defer func() { <-s.slots }()
+		defer s.forget(addr)
+		dialer := net.Dialer{Timeout: 10 * time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			slog.Info("connecting to peer failed", "peer", addr, "err", err)
+			return
+		}
+		s.runPeer(ctx, conn, true)
+	})
+}
+
+// forget removes addr from the set of known peers, allowing a later announce to reconnect to it
+func (s *Session) forget(addr string) {
+	s.mu.Lock()
+	delete(s.known, addr)
+	s.mu.Unlock()
+}
+
+// acceptLoop accepts incoming peer connections until the listener is closed
+func (s *Session) acceptLoop(ctx context.Context, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			conn.Close()
+			continue
+		}
+		s.goWorker(func() {
+			defer func() { <-s.slots }()
+			s.runPeer(ctx, conn, false)
+		})
+	}
+}
+
+// webSeedLoop downloads pieces from a web seed until the torrent is complete or the seed fails repeatedly
+func (s *Session) webSeedLoop(ctx context.Context, ws *webSeed) {
+	failures := 0
+	for ctx.Err() == nil && !s.picker.isComplete() {
+		index, ok := s.picker.pick(func(int) bool { return true })
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-s.picker.done:
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		data, err := ws.fetchPiece(ctx, index)
+		if err == nil {
+			err = s.completePiece(index, data)
+		}
+		if err != nil {
+			s.picker.release(index)
+			failures++
+			slog.Warn("web seed failed", "url", ws.url, "piece", index, "err", err)
+			if failures >= maxWebSeedFailures {
+				slog.Error("disabling web seed", "url", ws.url)
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(failures) * time.Second):
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// completePiece verifies a downloaded piece and writes it to the store
+func (s *Session) completePiece(index int, data []byte) error {
+	if sha1.Sum(data) != s.MetaInfo.Info.Pieces[index] {
+		return fmt.Errorf("piece %d failed hash check", index)
+	}
+	if s.picker.hasPiece(index) {
+		// Another source finished the same piece during end game
+		s.picker.release(index)
+		return nil
+	}
+	if err := s.store.writePiece(index, data); err != nil {
+		return err
+	}
+	if s.picker.complete(index) {
+		s.downloaded.Add(int64(len(data)))
+		s.lastPiece.Store(time.Now().UnixNano())
+		if s.OnProgress != nil {
+			s.OnProgress(int64(len(data)))
+		}
+	}
+	return nil
+}
+
+// addUploaded records uploaded bytes and signals the end of seeding once the target ratio is reached
+func (s *Session) addUploaded(n int) {
+	total := s.uploaded.Add(int64(n))
+	if s.SeedRatio > 0 && float64(total) >= s.SeedRatio*float64(s.MetaInfo.Info.TotalLength()) {
+		s.seedOnce.Do(func() { close(s.seedDone) })
+	}
+}
+
+// isLocalAddr reports whether addr refers to our own listener on a loopback address
+func isLocalAddr(addr string, port int) bool {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil || p != strconv.Itoa(port) {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
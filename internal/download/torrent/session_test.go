@@ -0,0 +1,128 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+// countingWriter counts the bytes of the responses of a web seed
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	w.n.Add(int64(len(b)))
+	return w.ResponseWriter.Write(b)
+}
+
+func TestSessionReusesFiles(t *testing.T) {
+	const pieceLength = 16 * 1024
+	data := make([]byte, 5*pieceLength+100)
+	rand.Read(data)
+	// The first file ends in the middle of piece 1
+	files := map[string][]byte{"a.bin": data[:pieceLength+500], "b.bin": data[pieceLength+500:]}
+	var pieces []byte
+	for i := 0; i < len(data); i += pieceLength {
+		sum := sha1.Sum(data[i:min(i+pieceLength, len(data))])
+		pieces = append(pieces, sum[:]...)
+	}
+	raw, err := encodeBencode(map[string]any{
+		"name":         "test",
+		"piece length": pieceLength,
+		"pieces":       pieces,
+		"files": []any{
+			map[string]any{"length": len(files["a.bin"]), "path": []any{"a.bin"}},
+			map[string]any{"length": len(files["b.bin"]), "path": []any{"b.bin"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ParseInfo(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var served atomic.Int64
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(countingWriter{w, &served}, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer seed.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	run := func(names map[string]string) (map[string]string, int64) {
+		t.Helper()
+		served.Store(0)
+		var progress atomic.Int64
+		s := &Session{
+			MetaInfo:      &MetaInfo{Info: info, WebSeeds: []string{seed.URL + "/"}},
+			WriterFactory: &storage.FSWriterFactory{BasePath: dir},
+			FileNames:     names,
+			OnProgress:    func(n int64) { progress.Add(n) },
+		}
+		if err := s.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if progress.Load() != int64(len(data)) {
+			t.Errorf("progress %d, expected %d", progress.Load(), len(data))
+		}
+		return s.FileNames, served.Load()
+	}
+
+	names, _ := run(nil)
+	// The earlier download was interrupted: a byte of piece 2 is wrong and the last piece is missing
+	path := filepath.Join(dir, "test", "b.bin")
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved[2*pieceLength-(pieceLength+500)] ^= 1
+	saved = saved[:len(saved)-50]
+	if err := os.WriteFile(path, saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, fetched := run(names)
+	if want := int64(pieceLength + 100); fetched != want {
+		t.Errorf("fetched %d bytes again, expected %d for pieces 2 and 5", fetched, want)
+	}
+	for name, content := range files {
+		saved, err := os.ReadFile(filepath.Join(dir, "test", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(saved, content) {
+			t.Errorf("%s differs", name)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(files) {
+		var created []string
+		for _, e := range entries {
+			created = append(created, e.Name())
+		}
+		t.Errorf("files %v, expected only the files of the torrent", created)
+	}
+}
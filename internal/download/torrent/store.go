@@ -0,0 +1,199 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"io"
+	"log/slog"
+	"path"
+	"sync"
+
+	"github.com/ananthvk/godown/internal/download/storage"
+)
+
+var errPieceUnavailable = errors.New("piece is not available for reading")
+
+// storeFile is a file of the torrent along with the stream it is being written to. existing is the number of bytes
+// kept from an earlier session
+type storeFile struct {
+	File
+	w        io.WriteCloser
+	existing int64
+}
+
+// pieceStore maps pieces to the files of a torrent.
+// When every stream supports io.WriterAt (such as *os.File), pieces are written directly at their offset.
+// Otherwise verified pieces are held in memory until all earlier pieces have arrived and are then written in order.
+// Pieces can only be read back (for seeding) when the streams also implement io.ReaderAt
+type pieceStore struct {
+	info     *Info
+	files    []storeFile
+	random   bool
+	readable bool
+
+	mu      sync.Mutex
+	pending map[int][]byte
+	next    int
+}
+
+// newPieceStore creates a stream for every file in the torrent using writerFactory.
+// Files of multi file torrents are placed in a directory named after the torrent. names maps the path of every file
+// to the name it was created with by an earlier session; those files are reopened instead of created again, and the
+// names of the files that are created are added to it
+func newPieceStore(info *Info, writerFactory storage.WriterFactory, names map[string]string) (*pieceStore, error) {
+	s := &pieceStore{info: info, random: true, readable: true, pending: map[int][]byte{}}
+	for _, f := range info.Files {
+		name := f.Path
+		if info.MultiFile {
+			name = path.Join(info.Name, f.Path)
+		}
+		sf, err := openStoreFile(f, name, writerFactory, names)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if _, ok := sf.w.(io.WriterAt); !ok {
+			s.random = false
+		}
+		if _, ok := sf.w.(io.ReaderAt); !ok {
+			s.readable = false
+		}
+		s.files = append(s.files, sf)
+	}
+	return s, nil
+}
+
+// openStoreFile reopens the file created for name by an earlier session, or creates it.
+// What the earlier session wrote is kept only if the stream can be read back and written at any offset, so that
+// its pieces can be verified
+func openStoreFile(f File, name string, writerFactory storage.WriterFactory, names map[string]string) (storeFile, error) {
+	if rf, ok := writerFactory.(storage.ResumableWriterFactory); ok && names[name] != "" {
+		created := names[name]
+		size, err := rf.StreamSize(created)
+		var w io.WriteCloser
+		if err == nil {
+			size = min(size, f.Length)
+			w, err = rf.ResumeStream(created, size)
+		}
+		if err == nil && size > 0 && !isRandomAccess(w) {
+			w.Close()
+			size = 0
+			w, err = rf.ResumeStream(created, 0)
+		}
+		if err == nil {
+			slog.Info("reusing torrent file", "filename", created, "bytes", size)
+			return storeFile{File: f, w: w, existing: size}, nil
+		}
+		slog.Info("cannot reuse torrent file", "filename", created, "err", err)
+	}
+	created, w, err := writerFactory.CreateStream(name)
+	if err != nil {
+		return storeFile{}, err
+	}
+	names[name] = created
+	return storeFile{File: f, w: w}, nil
+}
+
+func isRandomAccess(w io.WriteCloser) bool {
+	_, writerAt := w.(io.WriterAt)
+	_, readerAt := w.(io.ReaderAt)
+	return writerAt && readerAt
+}
+
+// existingPieces hashes the pieces that lie entirely within the data kept from an earlier session and returns the
+// indices of those that are intact
+func (s *pieceStore) existingPieces() []int {
+	if !s.random || !s.readable {
+		return nil
+	}
+	var intact []int
+	for index := range s.info.Pieces {
+		offset, length := int64(index)*s.info.PieceLength, s.info.PieceSize(index)
+		written := true
+		s.forEachSpan(offset, length, func(f *storeFile, fileOffset, lo, hi int64) error {
+			written = written && fileOffset+hi-lo <= f.existing
+			return nil
+		})
+		if !written {
+			continue
+		}
+		data, err := s.readBlock(index, 0, int(length))
+		if err == nil && sha1.Sum(data) == s.info.Pieces[index] {
+			intact = append(intact, index)
+		}
+	}
+	return intact
+}
+
+// forEachSpan calls fn for every file that overlaps the byte range [offset, offset+length) of the torrent,
+// with the offset inside the file and the range of the buffer that corresponds to it
+func (s *pieceStore) forEachSpan(offset, length int64, fn func(f *storeFile, fileOffset int64, lo, hi int64) error) error {
+	end := offset + length
+	for i := range s.files {
+		f := &s.files[i]
+		fileEnd := f.Offset + f.Length
+		if fileEnd <= offset || f.Offset >= end || f.Length == 0 {
+			continue
+		}
+		lo := max(offset, f.Offset)
+		hi := min(end, fileEnd)
+		if err := fn(f, lo-f.Offset, lo-offset, hi-offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePiece stores a verified piece
+func (s *pieceStore) writePiece(index int, data []byte) error {
+	if s.random {
+		return s.forEachSpan(int64(index)*s.info.PieceLength, int64(len(data)), func(f *storeFile, fileOffset, lo, hi int64) error {
+			_, err := f.w.(io.WriterAt).WriteAt(data[lo:hi], fileOffset)
+			return err
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[index] = data
+	for {
+		piece, ok := s.pending[s.next]
+		if !ok {
+			return nil
+		}
+		err := s.forEachSpan(int64(s.next)*s.info.PieceLength, int64(len(piece)), func(f *storeFile, _, lo, hi int64) error {
+			_, err := f.w.Write(piece[lo:hi])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		delete(s.pending, s.next)
+		s.next++
+	}
+}
+
+// readBlock reads length bytes starting at begin from a piece that has already been written
+func (s *pieceStore) readBlock(index, begin, length int) ([]byte, error) {
+	if !s.random || !s.readable {
+		return nil, errPieceUnavailable
+	}
+	buf := make([]byte, length)
+	offset := int64(index)*s.info.PieceLength + int64(begin)
+	err := s.forEachSpan(offset, int64(length), func(f *storeFile, fileOffset, lo, hi int64) error {
+		_, err := f.w.(io.ReaderAt).ReadAt(buf[lo:hi], fileOffset)
+		return err
+	})
+	return buf, err
+}
+
+// Close closes every stream of the torrent and returns the first error encountered
+func (s *pieceStore) Close() error {
+	var first error
+	for _, f := range s.files {
+		if err := f.w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
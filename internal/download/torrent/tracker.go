@@ -0,0 +1,117 @@
+package torrent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// announceRequest contains the parameters sent to a tracker on every announce
+type announceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string
+}
+
+// announceResponse is the parsed reply of a tracker
+type announceResponse struct {
+	Interval time.Duration
+	Peers    []string
+}
+
+// announce contacts an HTTP(S) tracker and returns the list of peers as host:port strings.
+// UDP trackers (BEP 15) are not supported and return an error
+func announce(ctx context.Context, client *http.Client, tracker string, req announceRequest) (*announceResponse, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+	q := u.Query()
+	q.Set("info_hash", string(req.InfoHash[:]))
+	q.Set("peer_id", string(req.PeerID[:]))
+	q.Set("port", strconv.Itoa(req.Port))
+	q.Set("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	q.Set("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	q.Set("left", strconv.FormatInt(req.Left, 10))
+	q.Set("compact", "1")
+	if req.Event != "" {
+		q.Set("event", req.Event)
+	}
+	u.RawQuery = q.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("tracker returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	v, _, err := decodeBencode(body)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("tracker response is not a dictionary")
+	}
+	if reason := dictString(dict, "failure reason"); reason != "" {
+		return nil, fmt.Errorf("tracker failure: %s", reason)
+	}
+
+	res := &announceResponse{Interval: time.Duration(dictInt(dict, "interval")) * time.Second}
+	switch peers := dict["peers"].(type) {
+	case string:
+		res.Peers = append(res.Peers, parseCompactPeers([]byte(peers), net.IPv4len)...)
+	case []any:
+		for _, p := range peers {
+			pd, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			ip, port := dictString(pd, "ip"), dictInt(pd, "port")
+			if ip != "" && port > 0 && port < 65536 {
+				res.Peers = append(res.Peers, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+			}
+		}
+	}
+	if peers6 := dictString(dict, "peers6"); peers6 != "" {
+		res.Peers = append(res.Peers, parseCompactPeers([]byte(peers6), net.IPv6len)...)
+	}
+	return res, nil
+}
+
+// parseCompactPeers parses the compact peer format, which consists of ip addresses of ipLen bytes each
+// followed by a two byte big endian port number
+func parseCompactPeers(data []byte, ipLen int) []string {
+	size := ipLen + 2
+	peers := make([]string, 0, len(data)/size)
+	for i := 0; i+size <= len(data); i += size {
+		ip := net.IP(data[i : i+ipLen])
+		port := binary.BigEndian.Uint16(data[i+ipLen:])
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers
+}
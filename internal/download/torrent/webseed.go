@@ -0,0 +1,84 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// webSeed downloads pieces over HTTP from a server that hosts the files of the torrent (BEP 19).
+// For single file torrents, a URL ending with a slash has the torrent name appended to it;
+// for multi file torrents the name and the path of each file are always appended
+type webSeed struct {
+	url    string
+	info   *Info
+	client *http.Client
+}
+
+// fileURL returns the URL that serves f
+func (w *webSeed) fileURL(f File) string {
+	base := w.url
+	if !w.info.MultiFile {
+		if strings.HasSuffix(base, "/") {
+			return base + url.PathEscape(w.info.Name)
+		}
+		return base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	parts := []string{url.PathEscape(w.info.Name)}
+	for _, p := range strings.Split(f.Path, "/") {
+		parts = append(parts, url.PathEscape(p))
+	}
+	return base + strings.Join(parts, "/")
+}
+
+// fetchPiece downloads a single piece, issuing one ranged request for every file the piece spans
+func (w *webSeed) fetchPiece(ctx context.Context, index int) ([]byte, error) {
+	offset := int64(index) * w.info.PieceLength
+	buf := make([]byte, w.info.PieceSize(index))
+	for _, f := range w.info.Files {
+		fileEnd := f.Offset + f.Length
+		if fileEnd <= offset || f.Offset >= offset+int64(len(buf)) || f.Length == 0 {
+			continue
+		}
+		lo := max(offset, f.Offset)
+		hi := min(offset+int64(len(buf)), fileEnd)
+		if err := w.fetchRange(ctx, f, lo-f.Offset, buf[lo-offset:hi-offset]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// fetchRange reads len(dst) bytes of f starting at fileOffset into dst
+func (w *webSeed) fetchRange(ctx context.Context, f File, fileOffset int64, dst []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.fileURL(f), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", fileOffset, fileOffset+int64(len(dst))-1))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range header, skip to the requested offset
+		if _, err := io.CopyN(io.Discard, resp.Body, fileOffset); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("web seed returned %s", resp.Status)
+	}
+	_, err = io.ReadFull(body, dst)
+	return err
+}
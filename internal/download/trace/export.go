@@ -0,0 +1,214 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileExporter writes every span as a line of JSON to a file
+type FileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileExporter creates the file at path, replacing it if it exists
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *FileExporter) Export(ctx context.Context, records []Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		if err := e.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *FileExporter) Close() error {
+	return e.f.Close()
+}
+
+// otlpRetries is the number of times a batch is sent again when the collector is unavailable
+const otlpRetries = 3
+
+// OTLPExporter sends the spans to an OpenTelemetry collector with OTLP over HTTP, encoded as JSON
+type OTLPExporter struct {
+	// Endpoint is the URL the spans are posted to, such as http://localhost:4318/v1/traces
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting to endpoint. The standard /v1/traces path is added to endpoints
+// without a path
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if scheme, rest, ok := strings.Cut(endpoint, "://"); ok && !strings.Contains(rest, "/") {
+		endpoint = scheme + "://" + rest + "/v1/traces"
+	}
+	return &OTLPExporter{Endpoint: endpoint, Client: &http.Client{}}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(otlpRequest(records))
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(ctx, body)
+		if err == nil || !retry || attempt == otlpRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+// post sends a batch once and reports whether it may be sent again after a failure
+func (e *OTLPExporter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		return true, fmt.Errorf("collector returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("collector returned %s", resp.Status)
+	}
+}
+
+// The types below are the JSON encoding of an OTLP ExportTraceServiceRequest
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+const (
+	otlpKindInternal = 1
+	otlpStatusError  = 2
+)
+
+func otlpRequest(records []Record) otlpTraces {
+	spans := make([]otlpSpan, len(records))
+	for i, r := range records {
+		spans[i] = otlpSpan{
+			TraceID:           r.TraceID,
+			SpanID:            r.SpanID,
+			ParentSpanID:      r.ParentID,
+			Name:              r.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(r.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(r.End.UnixNano(), 10),
+		}
+		for _, key := range slices.Sorted(maps.Keys(r.Attributes)) {
+			spans[i].Attributes = append(spans[i].Attributes, otlpKeyValue{Key: key, Value: otlpAttribute(r.Attributes[key])})
+		}
+		if r.Error != "" {
+			spans[i].Status = &otlpStatus{Code: otlpStatusError, Message: r.Error}
+		}
+	}
+	service := "godown"
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpValue{StringValue: &service}}}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "godown"}, Spans: spans}},
+	}}}
+}
+
+func otlpAttribute(v any) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
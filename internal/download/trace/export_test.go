@@ -0,0 +1,207 @@
+package trace_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/trace"
+)
+
+// testSpan is a span as received by the collector stand-in
+type testSpan struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Attributes   map[string]string
+	Failed       bool
+}
+
+// testCollector accepts OTLP/HTTP requests encoded as JSON
+type testCollector struct {
+	mu      sync.Mutex
+	spans   []testSpan
+	service string
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	type keyValue struct {
+		Key   string
+		Value map[string]any
+	}
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []keyValue
+			}
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string
+					SpanID       string
+					ParentSpanID string
+					Name         string
+					Attributes   []keyValue
+					Status       *struct{ Code int }
+				}
+			}
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" {
+				c.service, _ = attr.Value["stringValue"].(string)
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				span := testSpan{TraceID: s.TraceID, SpanID: s.SpanID, ParentSpanID: s.ParentSpanID, Name: s.Name,
+					Attributes: map[string]string{}, Failed: s.Status != nil && s.Status.Code == 2}
+				for _, attr := range s.Attributes {
+					for _, v := range attr.Value {
+						b, _ := json.Marshal(v)
+						span.Attributes[attr.Key] = strings.Trim(string(b), `"`)
+					}
+				}
+				c.spans = append(c.spans, span)
+			}
+		}
+	}
+}
+
+// downloadTraced downloads a file from a local server with the spans exported to e
+func downloadTraced(t *testing.T, e trace.Exporter) string {
+	t.Helper()
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello, world"))
+	}))
+	defer files.Close()
+	tracer := trace.NewTracer(e)
+	d := download.NewDownloader(t.TempDir(), false, reporter.NopProgressBarFactory{})
+	d.Tracer = tracer
+	url := files.URL + "/hello.txt"
+	if _, err := d.Add(context.Background(), url, download.JobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+// checkSpans checks the tree of spans of a single download
+func checkSpans(t *testing.T, spans []testSpan, url string) {
+	t.Helper()
+	byName := map[string]testSpan{}
+	for _, s := range spans {
+		if _, ok := byName[s.Name]; ok && s.Name != "connect" {
+			t.Errorf("span %q recorded twice", s.Name)
+		}
+		byName[s.Name] = s
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("span %q belongs to trace %s, expected %s", s.Name, s.TraceID, spans[0].TraceID)
+		}
+		if s.Failed {
+			t.Errorf("span %q failed", s.Name)
+		}
+	}
+	parents := map[string]string{
+		"download": "",
+		"attempt":  "download",
+		"request":  "attempt",
+		"connect":  "request",
+		"ttfb":     "request",
+		"transfer": "request",
+	}
+	for name, parent := range parents {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("no span %q", name)
+			continue
+		}
+		if want := byName[parent].SpanID; s.ParentSpanID != want {
+			t.Errorf("span %q has parent %q, expected the %q span %q", name, s.ParentSpanID, parent, want)
+		}
+	}
+	attrs := []struct{ span, key, value string }{
+		{"download", "job.url", url},
+		{"download", "job.state", "complete"},
+		{"download", "job.completed", "12"},
+		{"attempt", "job.retries", "0"},
+		{"request", "http.url", url},
+		{"request", "http.status_code", "200"},
+		{"transfer", "bytes", "12"},
+	}
+	for _, a := range attrs {
+		if got := byName[a.span].Attributes[a.key]; got != a.value {
+			t.Errorf("span %q has %s=%q, expected %q", a.span, a.key, got, a.value)
+		}
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	c := &testCollector{}
+	collector := httptest.NewServer(c)
+	defer collector.Close()
+	url := downloadTraced(t, trace.NewOTLPExporter(collector.URL))
+	if c.service != "godown" {
+		t.Errorf("service name %q, expected godown", c.service)
+	}
+	checkSpans(t, c.spans, url)
+}
+
+func TestFileExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	e, err := trace.NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := downloadTraced(t, e)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var spans []testSpan
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r trace.Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		if r.Start.IsZero() || r.End.Before(r.Start) {
+			t.Errorf("span %q has invalid times %v - %v", r.Name, r.Start, r.End)
+		}
+		span := testSpan{TraceID: r.TraceID, SpanID: r.SpanID, ParentSpanID: r.ParentID, Name: r.Name,
+			Attributes: map[string]string{}, Failed: r.Error != ""}
+		for key, value := range r.Attributes {
+			b, _ := json.Marshal(value)
+			span.Attributes[key] = strings.Trim(string(b), `"`)
+		}
+		spans = append(spans, span)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	checkSpans(t, spans, url)
+}
@@ -0,0 +1,75 @@
+package trace
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+)
+
+// WithClientTrace returns a context that records the phases of an HTTP request made with it as children of the
+// span held by ctx: "dns", "connect" for every address tried, "tls", and "ttfb" from the request being sent until
+// the first byte of the response. ctx is returned unchanged if it holds no span
+func WithClientTrace(ctx context.Context) context.Context {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx
+	}
+	var mu sync.Mutex
+	var dns, handshake, wait *Span
+	connects := map[string]*Span{}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dns = parent.child("dns")
+			dns.SetAttr("net.host", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dns.SetAttr("net.addresses", len(info.Addrs))
+			dns.End(info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			s := parent.child("connect")
+			s.SetAttr("net.peer", addr)
+			connects[network+" "+addr] = s
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			connects[network+" "+addr].End(err)
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			handshake = parent.child("tls")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				handshake.SetAttr("tls.version", tls.VersionName(state.Version))
+			}
+			handshake.End(err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			parent.SetAttr("net.reused", info.Reused)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Err == nil {
+				wait = parent.child("ttfb")
+			}
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			wait.End(nil)
+		},
+	})
+}
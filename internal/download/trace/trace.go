@@ -0,0 +1,205 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize is the number of finished spans that are exported together
+	batchSize = 512
+	// exportInterval is how often finished spans are exported when there are fewer than batchSize
+	exportInterval = 5 * time.Second
+	// exportTimeout limits the time taken by a single export
+	exportTimeout = 30 * time.Second
+)
+
+// Record is a finished span. The spans of a download share a TraceID and form a tree through ParentID
+type Record struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	ParentID   string         `json:"parentSpanId,omitempty"`
+	Name       string         `json:"name"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Exporter sends finished spans to a collector or a file. If it is an io.Closer, it is closed with the Tracer
+type Exporter interface {
+	Export(ctx context.Context, records []Record) error
+}
+
+// Tracer starts the spans of downloads and exports them in batches in the background
+type Tracer struct {
+	exporter Exporter
+
+	mu      sync.Mutex
+	pending []Record
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewTracer returns a Tracer exporting to e, it has to be closed to export the last spans
+func NewTracer(e Exporter) *Tracer {
+	t := &Tracer{exporter: e, wake: make(chan struct{}, 1), done: make(chan struct{}), stopped: make(chan struct{})}
+	go t.loop()
+	return t
+}
+
+// Start begins the root span of a new trace and returns a context holding it. A nil Tracer returns a nil Span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, record: Record{TraceID: newID(16), SpanID: newID(8), Name: name, Start: time.Now()}}
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *Tracer) add(r Record) {
+	t.mu.Lock()
+	t.pending = append(t.pending, r)
+	full := len(t.pending) >= batchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// loop exports the pending spans every exportInterval, or sooner once a batch is full
+func (t *Tracer) loop() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			t.flush()
+			return
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		t.flush()
+	}
+}
+
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	records := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := t.exporter.Export(ctx, records); err != nil {
+		slog.Error("exporting spans", "spans", len(records), "err", err)
+	}
+}
+
+// Close exports the remaining spans and closes the exporter. Spans ended afterwards are dropped
+func (t *Tracer) Close() error {
+	close(t.done)
+	<-t.stopped
+	if c, ok := t.exporter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Span is a timed phase of a download. A nil Span does nothing, so code can be instrumented whether tracing is
+// enabled or not. The methods are safe for concurrent use
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	record Record
+	ended  bool
+}
+
+// SetAttr sets an attribute of the span. Values are usually strings, integers, floats or booleans
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Attributes == nil {
+		s.record.Attributes = map[string]any{}
+	}
+	s.record.Attributes[key] = value
+}
+
+// End finishes the span, err is recorded if it is not nil. Only the first call has an effect
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.record.End = time.Now()
+	if err != nil {
+		s.record.Error = err.Error()
+	}
+	r := s.record
+	s.mu.Unlock()
+	s.tracer.add(r)
+}
+
+// child begins a span under s
+func (s *Span) child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{tracer: s.tracer, record: Record{
+		TraceID:  s.record.TraceID,
+		SpanID:   newID(8),
+		ParentID: s.record.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+	}}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context holding s, spans started from it are children of s
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span held by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a child of the span held by ctx and returns a context holding it. If ctx holds no span, nothing
+// is traced and a nil Span is returned
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := FromContext(ctx).child(name)
+	return ContextWithSpan(ctx, s), s
+}
+
+// newID returns a random ID of n bytes in hex
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
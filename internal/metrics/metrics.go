@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/progress"
+)
+
+// Path is the HTTP path the metrics are served on
+const Path = "/metrics"
+
+// latencyBuckets are the upper bounds of the request latency histogram, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector keeps metrics about the jobs of a Downloader in the Prometheus text format. It is a progress.Reporter
+// and is fed by progress.Watch with the events and the progress of the jobs
+type Collector struct {
+	downloader *download.Downloader
+
+	mu        sync.Mutex
+	jobs      map[string]*jobMetrics
+	bytes     map[string]int64
+	completed int64
+	failed    map[string]int64
+	retries   map[string]int64
+	latency   histogram
+}
+
+// jobMetrics is what is known about a job that has not stopped
+type jobMetrics struct {
+	host      string
+	state     download.JobState
+	completed int64
+	speed     float64
+}
+
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// NewCollector returns a Collector for d, it has to be passed to progress.Watch to be fed
+func NewCollector(d *download.Downloader) *Collector {
+	return &Collector{
+		downloader: d,
+		jobs:       map[string]*jobMetrics{},
+		bytes:      map[string]int64{},
+		failed:     map[string]int64{},
+		retries:    map[string]int64{},
+	}
+}
+
+// Watch starts feeding c with the events and progress of its Downloader, until the returned function is called
+func (c *Collector) Watch(interval time.Duration) func() {
+	return progress.Watch(c.downloader, interval, c)
+}
+
+// update adds the bytes downloaded by a job since it was last seen to its host
+func (c *Collector) update(info download.JobInfo) *jobMetrics {
+	j, ok := c.jobs[info.ID]
+	if !ok {
+		host := ""
+		if u, err := url.Parse(info.URL); err == nil {
+			host = u.Hostname()
+		}
+		// Restored jobs start with what was downloaded before
+		j = &jobMetrics{host: host, completed: info.Completed}
+		c.jobs[info.ID] = j
+	}
+	if info.Completed > j.completed {
+		c.bytes[j.host] += info.Completed - j.completed
+	}
+	j.completed = info.Completed
+	j.state = info.State
+	return j
+}
+
+func (c *Collector) Event(e download.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	j := c.update(e.Job)
+	if j.state != download.JobActive {
+		j.speed = 0
+	}
+	switch e.Type {
+	case download.EventResponse:
+		c.latency.observe(e.Latency.Seconds())
+	case download.EventRetry:
+		c.retries[e.Job.ErrorClass]++
+	case download.EventCompleted:
+		c.completed++
+	case download.EventFailed:
+		c.failed[e.Job.ErrorClass]++
+	}
+	if e.Type == download.EventRemoved || e.Job.State.Stopped() {
+		delete(c.jobs, e.Job.ID)
+	}
+}
+
+func (c *Collector) Progress(samples []progress.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range samples {
+		c.update(s.Job).speed = s.Speed
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b strings.Builder
+	states := map[download.JobState]int{download.JobActive: 0, download.JobWaiting: 0, download.JobPaused: 0}
+	var throughput float64
+	for _, j := range c.jobs {
+		states[j.state]++
+		throughput += j.speed
+	}
+
+	header(&b, "godown_downloaded_bytes_total", "counter", "Bytes downloaded by the jobs, by host. Streams count segments instead of bytes")
+	for _, host := range slices.Sorted(maps.Keys(c.bytes)) {
+		fmt.Fprintf(&b, "godown_downloaded_bytes_total{host=\"%s\"} %d\n", escape(host), c.bytes[host])
+	}
+	header(&b, "godown_jobs", "gauge", "Jobs that have not stopped, by state")
+	for _, state := range slices.Sorted(maps.Keys(states)) {
+		fmt.Fprintf(&b, "godown_jobs{state=\"%s\"} %d\n", state, states[state])
+	}
+	header(&b, "godown_jobs_completed_total", "counter", "Jobs that completed")
+	fmt.Fprintf(&b, "godown_jobs_completed_total %d\n", c.completed)
+	header(&b, "godown_jobs_failed_total", "counter", "Jobs that failed, by error class")
+	for _, class := range slices.Sorted(maps.Keys(c.failed)) {
+		fmt.Fprintf(&b, "godown_jobs_failed_total{class=\"%s\"} %d\n", escape(class), c.failed[class])
+	}
+	header(&b, "godown_retries_total", "counter", "Automatic retries of failed jobs, by error class")
+	for _, class := range slices.Sorted(maps.Keys(c.retries)) {
+		fmt.Fprintf(&b, "godown_retries_total{class=\"%s\"} %d\n", escape(class), c.retries[class])
+	}
+	header(&b, "godown_request_duration_seconds", "histogram", "Time until the server responded to a request of an http(s) download")
+	for i, bound := range latencyBuckets {
+		var n int64
+		if c.latency.counts != nil {
+			n = c.latency.counts[i]
+		}
+		fmt.Fprintf(&b, "godown_request_duration_seconds_bucket{le=\"%g\"} %d\n", bound, n)
+	}
+	fmt.Fprintf(&b, "godown_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", c.latency.count)
+	fmt.Fprintf(&b, "godown_request_duration_seconds_sum %g\n", c.latency.sum)
+	fmt.Fprintf(&b, "godown_request_duration_seconds_count %d\n", c.latency.count)
+	header(&b, "godown_throughput_bytes_per_second", "gauge", "Combined speed of the active jobs")
+	fmt.Fprintf(&b, "godown_throughput_bytes_per_second %g\n", throughput)
+	header(&b, "godown_rate_limit_wait_seconds_total", "counter", "Time that downloads have waited for --limit-rate")
+	fmt.Fprintf(&b, "godown_rate_limit_wait_seconds_total %g\n", c.downloader.RateLimitWait().Seconds())
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	c.WriteTo(bw)
+	bw.Flush()
+}
+
+// Listen opens the listener for Serve. Addresses without a host, such as ":9090", are bound to localhost
+func Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve serves the metrics of c at Path on l until ctx is done
+func (c *Collector) Serve(ctx context.Context, l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, c)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("metrics server listening", "addr", l.Addr().String())
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
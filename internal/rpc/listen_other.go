@@ -0,0 +1,20 @@
+//go:build !unix
+
+package rpc
+
+import (
+	"net"
+	"os"
+)
+
+func listenUnix(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package rpc
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix creates the socket at path with a umask that leaves it accessible by the current user only, so that
+// it is never open to others, not even until its permissions could be changed
+func listenUnix(path string) (net.Listener, error) {
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
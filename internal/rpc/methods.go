@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// maxPollTimeout is the longest time godown.pollEvents waits for an event
+const maxPollTimeout = 120 * time.Second
+
+// methods maps the names of the JSON-RPC methods to their implementations
+var methods = map[string]func(s *Server, ctx context.Context, params json.RawMessage) (any, error){
+	"godown.add":                (*Server).add,
+	"godown.pause":              (*Server).pause,
+	"godown.resume":             (*Server).resume,
+	"godown.remove":             (*Server).remove,
+	"godown.cancel":             (*Server).cancel,
+	"godown.retry":              (*Server).retry,
+	"godown.setPriority":        (*Server).setPriority,
+	"godown.status":             (*Server).status,
+	"godown.list":               (*Server).list,
+	"godown.getGlobalOption":    (*Server).getGlobalOption,
+	"godown.changeGlobalOption": (*Server).changeGlobalOption,
+	"godown.pollEvents":         (*Server).pollEvents,
+}
+
+// decodeParams decodes the params object into v, rejecting unknown fields
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return invalidParams(err.Error())
+	}
+	return nil
+}
+
+// jobError converts errors of the job control methods into RPC errors
+func jobError(err error) error {
+	if errors.Is(err, download.ErrJobNotFound) {
+		return invalidParams(err.Error())
+	}
+	return err
+}
+
+// idParams are the params of the methods that act on a single job
+type idParams struct {
+	ID string `json:"id"`
+}
+
+// add queues a download, params: {"url": "...", "options": {"out": "name"}}, result: {"id": "..."}.
+// The id is empty if there is nothing to download. The name given in out must stay inside the output directory
+func (s *Server) add(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		URL     string              `json:"url"`
+		Options download.JobOptions `json:"options"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.URL == "" {
+		return nil, invalidParams("url is required")
+	}
+	if p.Options.FileName != "" && !filepath.IsLocal(p.Options.FileName) {
+		return nil, invalidParams("out must be a relative path inside the output directory")
+	}
+	id, err := s.Downloader.Add(s.ctx, p.URL, p.Options)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	return map[string]string{"id": id}, nil
+}
+
+func (s *Server) pause(ctx context.Context, params json.RawMessage) (any, error) {
+	var p idParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.Downloader.Pause(p.ID); err != nil {
+		return nil, jobError(err)
+	}
+	return "OK", nil
+}
+
+func (s *Server) resume(ctx context.Context, params json.RawMessage) (any, error) {
+	var p idParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.Downloader.Resume(p.ID); err != nil {
+		return nil, jobError(err)
+	}
+	return "OK", nil
+}
+
+func (s *Server) remove(ctx context.Context, params json.RawMessage) (any, error) {
+	var p idParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.Downloader.Remove(p.ID); err != nil {
+		return nil, jobError(err)
+	}
+	return "OK", nil
+}
+
+func (s *Server) cancel(ctx context.Context, params json.RawMessage) (any, error) {
+	var p idParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.Downloader.Cancel(p.ID); err != nil {
+		return nil, jobError(err)
+	}
+	return "OK", nil
+}
+
+func (s *Server) retry(ctx context.Context, params json.RawMessage) (any, error) {
+	var p idParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.Downloader.Retry(p.ID); err != nil {
+		return nil, jobError(err)
+	}
+	return "OK", nil
+}
+
+// setPriority changes the priority of a job, params: {"id": "...", "priority": 10}
+func (s *Server) setPriority(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		ID       string `json:"id"`
+		Priority int    `json:"priority"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.Downloader.SetPriority(p.ID, p.Priority); err != nil {
+		return nil, jobError(err)
+	}
+	return "OK", nil
+}
+
+func (s *Server) status(ctx context.Context, params json.RawMessage) (any, error) {
+	var p idParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	info, err := s.Downloader.Job(p.ID)
+	if err != nil {
+		return nil, jobError(err)
+	}
+	return info, nil
+}
+
+// list returns the jobs, params: {"state": "active" | "waiting" | "stopped"}. Waiting includes paused jobs and
+// stopped includes completed, failed and removed jobs. All jobs are returned if state is empty
+func (s *Server) list(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		State string `json:"state"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	switch p.State {
+	case "":
+		return s.Downloader.Jobs(), nil
+	case "active":
+		return s.Downloader.Jobs(download.JobActive), nil
+	case "waiting":
+		return s.Downloader.Jobs(download.JobWaiting, download.JobPaused), nil
+	case "stopped":
+		return s.Downloader.Jobs(download.JobComplete, download.JobError, download.JobRemoved), nil
+	default:
+		return nil, invalidParams("unknown state " + p.State)
+	}
+}
+
+// GlobalOptions are the settings of the Downloader that can be read and changed at runtime.
+// Options that are nil are left unchanged by godown.changeGlobalOption
+type GlobalOptions struct {
+	MaxConcurrent      *int     `json:"max-concurrent,omitempty"`
+	FollowTorrent      *bool    `json:"follow-torrent,omitempty"`
+	SeedRatio          *float64 `json:"seed-ratio,omitempty"`
+	FollowStream       *bool    `json:"follow-stream,omitempty"`
+	SegmentConcurrency *int     `json:"segment-concurrency,omitempty"`
+	StreamBandwidth    *int64   `json:"stream-bandwidth,omitempty"`
+	StreamHeight       *int     `json:"stream-height,omitempty"`
+	LimitRate          *int64   `json:"limit-rate,omitempty"`
+	Retries            *int     `json:"retries,omitempty"`
+}
+
+// ptr returns a pointer to a copy of v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func (s *Server) getGlobalOption(ctx context.Context, params json.RawMessage) (any, error) {
+	var opts GlobalOptions
+	s.Downloader.Reconfigure(func(d *download.Downloader) {
+		opts = GlobalOptions{
+			MaxConcurrent:      ptr(d.MaxConcurrent),
+			FollowTorrent:      ptr(d.Torrent.FollowTorrent),
+			SeedRatio:          ptr(d.Torrent.SeedRatio),
+			FollowStream:       ptr(d.Stream.FollowStreams),
+			SegmentConcurrency: ptr(d.Stream.Concurrency),
+			StreamBandwidth:    ptr(d.Stream.MaxBandwidth),
+			StreamHeight:       ptr(d.Stream.MaxHeight),
+			Retries:            ptr(d.Retries),
+		}
+	})
+	opts.LimitRate = ptr(s.Downloader.RateLimit())
+	return opts, nil
+}
+
+// changeGlobalOption changes the settings of the Downloader, params: {"options": {"max-concurrent": 2, ...}}.
+// The settings apply to downloads added afterwards, except max-concurrent and limit-rate which apply immediately
+func (s *Server) changeGlobalOption(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Options GlobalOptions `json:"options"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	o := p.Options
+	if (o.MaxConcurrent != nil && *o.MaxConcurrent < 0) || (o.SegmentConcurrency != nil && *o.SegmentConcurrency < 1) {
+		return nil, invalidParams("max-concurrent must not be negative and segment-concurrency must be positive")
+	}
+	if (o.LimitRate != nil && *o.LimitRate < 0) || (o.Retries != nil && *o.Retries < 0) {
+		return nil, invalidParams("limit-rate and retries must not be negative")
+	}
+	if o.LimitRate != nil {
+		s.Downloader.SetRateLimit(*o.LimitRate)
+	}
+	s.Downloader.Reconfigure(func(d *download.Downloader) {
+		if o.MaxConcurrent != nil {
+			d.MaxConcurrent = *o.MaxConcurrent
+		}
+		if o.FollowTorrent != nil {
+			d.Torrent.FollowTorrent = *o.FollowTorrent
+		}
+		if o.SeedRatio != nil {
+			d.Torrent.SeedRatio = *o.SeedRatio
+		}
+		if o.FollowStream != nil {
+			d.Stream.FollowStreams = *o.FollowStream
+		}
+		if o.SegmentConcurrency != nil {
+			d.Stream.Concurrency = *o.SegmentConcurrency
+		}
+		if o.StreamBandwidth != nil {
+			d.Stream.MaxBandwidth = *o.StreamBandwidth
+		}
+		if o.StreamHeight != nil {
+			d.Stream.MaxHeight = *o.StreamHeight
+		}
+		if o.Retries != nil {
+			d.Retries = *o.Retries
+		}
+	})
+	return "OK", nil
+}
+
+// pollEvents waits for job events, params: {"since": 0, "timeout": 30}. It returns the events with a sequence
+// number greater than since, waiting up to timeout seconds for one to happen. The result also holds the sequence
+// number of the last event, to be passed as since in the next call
+func (s *Server) pollEvents(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Since   uint64  `json:"since"`
+		Timeout float64 `json:"timeout"`
+	}
+	p.Timeout = 30
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	timeout := min(time.Duration(p.Timeout*float64(time.Second)), maxPollTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	events := s.Downloader.WaitEvents(ctx, p.Since)
+	last := p.Since
+	if len(events) > 0 {
+		last = events[len(events)-1].Seq
+	} else {
+		events = []download.Event{}
+	}
+	return map[string]any{"events": events, "last": last}, nil
+}
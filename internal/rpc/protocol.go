@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"encoding/json"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerError    = -32000
+)
+
+// request is a JSON-RPC 2.0 request, ID is nil for notifications
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// response is a JSON-RPC 2.0 response, exactly one of Result and Error is set
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error object. Methods return it to choose the error code, other errors are
+// reported with the generic server error code
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{Version: "2.0", Error: &Error{Code: code, Message: message}, ID: id}
+}
+
+// invalidParams returns an error with the invalid params code
+func invalidParams(message string) error {
+	return &Error{Code: codeInvalidParams, Message: message}
+}
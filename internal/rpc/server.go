@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// Path is the HTTP path that JSON-RPC requests are posted to
+const Path = "/jsonrpc"
+
+// maxRequestSize limits the size of a request body
+const maxRequestSize = 1024 * 1024
+
+// Server serves the JSON-RPC 2.0 control API of a Downloader over HTTP.
+// Every request must carry the token in an "Authorization: Bearer <token>" header.
+// Jobs added through the API are bound to the context passed to Serve or Handler
+type Server struct {
+	Downloader *download.Downloader
+	Token      string
+	ctx        context.Context
+}
+
+// Listen opens the listener for the server. addr is either "unix:<path>" for a Unix socket, which is only
+// accessible by the current user, or a host:port pair on a loopback address
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Remove a socket left behind by a previous run
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return listenUnix(path)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("refusing to listen on %q, only loopback addresses and unix sockets are allowed", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve accepts connections on l until ctx is done
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	if s.Token == "" {
+		return errors.New("rpc token must not be empty")
+	}
+	srv := &http.Server{Handler: s.Handler(ctx), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("rpc server listening", "addr", l.Addr().String())
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Handler returns a handler that serves the API at Path, so that it can be served along with other handlers.
+// Jobs added through it are bound to ctx
+func (s *Server) Handler(ctx context.Context) http.Handler {
+	s.ctx = ctx
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	return mux
+}
+
+// Authorized reports whether the request carries the server's token
+func (s *Server) Authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// ServeHTTP handles a single JSON-RPC request or a batch of requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.Authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var out any
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			out = errorResponse(nil, codeInvalidRequest, "invalid batch")
+		} else {
+			var responses []*response
+			for _, raw := range batch {
+				if resp := s.handle(r.Context(), raw); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			out = responses
+		}
+	} else {
+		resp := s.handle(r.Context(), body)
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		out = resp
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handle runs a single request, nil is returned for notifications which do not get a response
+func (s *Server) handle(ctx context.Context, raw json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorResponse(nil, codeParseError, "parse error: "+err.Error())
+	}
+	if req.Version != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, codeInvalidRequest, "invalid request")
+	}
+	method, ok := methods[req.Method]
+	if !ok {
+		if req.ID == nil {
+			return nil
+		}
+		return errorResponse(req.ID, codeMethodNotFound, "method not found: "+req.Method)
+	}
+	result, err := method(s, ctx, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if errors.As(err, &rpcErr) {
+			return errorResponse(req.ID, rpcErr.Code, rpcErr.Message)
+		}
+		return errorResponse(req.ID, codeServerError, err.Error())
+	}
+	return &response{Version: "2.0", Result: result, ID: req.ID}
+}
@@ -0,0 +1,121 @@
+package tui
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxJobLogLines is the number of records kept for every download
+	maxJobLogLines = 200
+	// maxLogLines is the number of records kept in total
+	maxLogLines = 1000
+	// maxLogKeys is the number of downloads whose records are kept, the records of the oldest are dropped first
+	maxLogKeys = 4096
+)
+
+// LogHandler is a slog.Handler that keeps the most recent records in memory instead of writing them out, so that
+// they can be shown by the UI. Records are grouped by the url or source attribute, which is the URL of the job
+// that logged them
+type LogHandler struct {
+	logs  *logStore
+	inner slog.Handler
+	key   string
+}
+
+// logStore holds the records of a LogHandler and the handlers derived from it
+type logStore struct {
+	mu    sync.Mutex
+	key   string
+	all   []string
+	byKey map[string][]string
+	keys  []string
+}
+
+// NewLogHandler creates a LogHandler that keeps records of the given level and above
+func NewLogHandler(level slog.Leveler) *LogHandler {
+	logs := &logStore{byKey: map[string][]string{}}
+	inner := slog.NewTextHandler(logs, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.String(slog.TimeKey, a.Value.Time().Format(time.TimeOnly))
+			}
+			return a
+		},
+	})
+	return &LogHandler{logs: logs, inner: inner}
+}
+
+func (h *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	key := h.key
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "url" || a.Key == "source" {
+			key = a.Value.String()
+			return false
+		}
+		return true
+	})
+	h.logs.mu.Lock()
+	defer h.logs.mu.Unlock()
+	h.logs.key = key
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	key := h.key
+	for _, a := range attrs {
+		if a.Key == "url" || a.Key == "source" {
+			key = a.Value.String()
+		}
+	}
+	return &LogHandler{logs: h.logs, inner: h.inner.WithAttrs(attrs), key: key}
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{logs: h.logs, inner: h.inner.WithGroup(name), key: h.key}
+}
+
+// Lines returns the records logged for url, or all records if url is empty
+func (h *LogHandler) Lines(url string) []string {
+	h.logs.mu.Lock()
+	defer h.logs.mu.Unlock()
+	if url == "" {
+		return append([]string(nil), h.logs.all...)
+	}
+	return append([]string(nil), h.logs.byKey[url]...)
+}
+
+// Write is called by the text handler with one record at a time while the mutex is held
+func (s *logStore) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	s.all = appendLine(s.all, line, maxLogLines)
+	if s.key != "" {
+		lines, ok := s.byKey[s.key]
+		if !ok {
+			s.keys = append(s.keys, s.key)
+			if len(s.keys) > maxLogKeys {
+				delete(s.byKey, s.keys[0])
+				s.keys = s.keys[1:]
+			}
+		}
+		s.byKey[s.key] = appendLine(lines, line, maxJobLogLines)
+	}
+	return len(p), nil
+}
+
+// appendLine appends line, dropping the oldest lines beyond limit
+func appendLine(lines []string, line string, limit int) []string {
+	lines = append(lines, line)
+	if len(lines) > limit {
+		lines = append(lines[:0], lines[len(lines)-limit:]...)
+	}
+	return lines
+}
@@ -0,0 +1,189 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+const (
+	reverse = "\x1b[7m"
+	bold    = "\x1b[1m"
+	reset   = "\x1b[0m"
+)
+
+// help lists the keys, it is shown on the last line
+const help = "↑/↓ select  p pause  r resume  c cancel  +/- priority  R retry  a add  l limit  L log  q quit"
+
+// draw redraws the whole screen
+func (u *UI) draw(downloader *download.Downloader, jobs []download.JobInfo) error {
+	width, height, err := terminalSize(int(u.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	var lines []string
+	lines = append(lines, u.header(downloader, jobs))
+	lines = append(lines, bold+fit(fmt.Sprintf("%-8s %4s %-22s %11s %8s  %s", "STATE", "PRI", "PROGRESS", "SPEED", "ETA", "URL"), width)+reset)
+
+	logHeight := 0
+	if u.showLogs {
+		logHeight = max(3, (height-4)/3)
+	}
+	rows := max(1, height-4-logHeight)
+
+	index := slices.IndexFunc(jobs, func(j download.JobInfo) bool { return j.ID == u.selected })
+	if index < 0 && len(jobs) > 0 {
+		index = 0
+		u.selected = jobs[0].ID
+	}
+	// Scroll so that the selected job is visible
+	u.offset = max(0, min(u.offset, index, len(jobs)-rows))
+	if index >= u.offset+rows {
+		u.offset = index - rows + 1
+	}
+	if len(jobs) == 0 {
+		lines = append(lines, "no downloads, press a to add a url")
+	}
+	for i := u.offset; i < len(jobs) && i < u.offset+rows; i++ {
+		line := fit(u.row(jobs[i]), width)
+		if i == index {
+			line = reverse + line + strings.Repeat(" ", width-utf8.RuneCountInString(line)) + reset
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < rows+2 {
+		lines = append(lines, "")
+	}
+
+	if u.showLogs {
+		lines = append(lines, u.logPane(jobs, index, width, logHeight)...)
+	}
+
+	switch {
+	case u.prompt != nil:
+		lines = append(lines, fit(u.prompt.label+string(u.prompt.text), width-1)+reverse+" "+reset)
+	default:
+		lines = append(lines, fit(u.message, width))
+	}
+	lines = append(lines, bold+fit(help, width)+reset)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines[:min(len(lines), height)] {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	_, err = u.out.WriteString(b.String())
+	return err
+}
+
+// header summarizes the jobs and the total speed
+func (u *UI) header(downloader *download.Downloader, jobs []download.JobInfo) string {
+	counts := map[download.JobState]int{}
+	total := 0.0
+	for _, job := range jobs {
+		counts[job.State]++
+		total += u.rate(job.ID)
+	}
+	return fmt.Sprintf("godown  %d active, %d waiting, %d paused, %d complete, %d failed, %d removed  speed %s/s  limit %s",
+		counts[download.JobActive], counts[download.JobWaiting], counts[download.JobPaused], counts[download.JobComplete],
+		counts[download.JobError], counts[download.JobRemoved], formatBytes(total), formatRate(downloader.RateLimit()))
+}
+
+// row formats a job as a line of the table
+func (u *UI) row(job download.JobInfo) string {
+	progress := formatBytes(float64(job.Completed))
+	if job.Total > 0 {
+		progress = fmt.Sprintf("%5.1f%% of %s", float64(job.Completed)*100/float64(job.Total), formatBytes(float64(job.Total)))
+	}
+	speed, eta := "", ""
+	if job.State == download.JobActive {
+		rate := u.rate(job.ID)
+		speed = formatBytes(rate) + "/s"
+		if rate > 0 && job.Total > job.Completed {
+			eta = formatDuration(time.Duration(float64(job.Total-job.Completed) / rate * float64(time.Second)))
+		}
+	}
+	return fmt.Sprintf("%-8s %4d %-22s %11s %8s  %s", job.State, job.Options.Priority, progress, speed, eta, job.URL)
+}
+
+// logPane returns the lines of the log pane, which shows the records of the selected job
+func (u *UI) logPane(jobs []download.JobInfo, index int, width, height int) []string {
+	title := "log"
+	var logs []string
+	if index >= 0 {
+		job := jobs[index]
+		title = fmt.Sprintf("log of %s (%s)", job.ID, job.State)
+		if job.Error != "" {
+			title += ": " + job.Error
+		}
+		if job.HookError != "" {
+			title += ", hook: " + job.HookError
+		}
+		logs = u.Logs.Lines(job.URL)
+	} else {
+		logs = u.Logs.Lines("")
+	}
+	lines := []string{bold + fit("── "+title+" "+strings.Repeat("─", width), width) + reset}
+	logs = logs[max(0, len(logs)-(height-1)):]
+	for _, line := range logs {
+		lines = append(lines, fit(line, width))
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+// fit cuts s to width characters and removes control characters
+func fit(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if n >= width {
+			break
+		}
+		if r < 0x20 || r == 0x7f {
+			r = ' '
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+// formatBytes formats a number of bytes with a binary unit
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// formatDuration formats an ETA, rounded to seconds
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d >= 100*time.Hour {
+		return "∞"
+	}
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
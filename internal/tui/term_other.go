@@ -0,0 +1,22 @@
+//go:build !linux
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("the terminal ui is only supported on linux")
+
+func checkTerminal(f *os.File) error {
+	return errUnsupported
+}
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errUnsupported
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errUnsupported
+}
@@ -0,0 +1,345 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ananthvk/godown/internal/download"
+)
+
+// refreshInterval is how often the screen is redrawn and the speeds are measured
+const refreshInterval = 500 * time.Millisecond
+
+// messageTimeout is how long the result of an action stays on the status line
+const messageTimeout = 5 * time.Second
+
+// UI is a full screen terminal interface that lists the jobs of a Downloader with their progress, speed and ETA
+// and controls them with the keyboard. Logs holds the slog records shown in the log pane, it should be set as the
+// default slog handler while the UI runs
+type UI struct {
+	Logs *LogHandler
+
+	in      *os.File
+	out     *os.File
+	restore func() error
+	done    chan struct{}
+	notes   chan string
+
+	selected string
+	offset   int
+	showLogs bool
+	prompt   *prompt
+	message  string
+	shownAt  time.Time
+	speeds   map[string]*speed
+}
+
+// prompt is a line of text being typed by the user, submit is called with the text when enter is pressed
+type prompt struct {
+	label  string
+	text   []rune
+	submit func(text string) error
+}
+
+// speed is the measured download speed of a job
+type speed struct {
+	completed int64
+	at        time.Time
+	rate      float64
+}
+
+// New puts the terminal in raw mode and switches to the alternate screen. An error is returned if in or out is
+// not a terminal, callers should show their usual output instead
+func New(in, out *os.File) (*UI, error) {
+	if err := checkTerminal(in); err != nil {
+		return nil, err
+	}
+	if err := checkTerminal(out); err != nil {
+		return nil, err
+	}
+	restore, err := makeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	// Switch to the alternate screen and hide the cursor
+	if _, err := out.WriteString("\x1b[?1049h\x1b[?25l"); err != nil {
+		restore()
+		return nil, err
+	}
+	return &UI{
+		Logs:    NewLogHandler(slog.LevelInfo),
+		in:      in,
+		out:     out,
+		restore: restore,
+		done:    make(chan struct{}),
+		notes:   make(chan string, 16),
+		speeds:  map[string]*speed{},
+	}, nil
+}
+
+// Close restores the terminal
+func (u *UI) Close() error {
+	select {
+	case <-u.done:
+	default:
+		close(u.done)
+	}
+	u.out.WriteString("\x1b[?25h\x1b[?1049l")
+	return u.restore()
+}
+
+// Notify shows a message on the status line, it can be called from any goroutine
+func (u *UI) Notify(message string) {
+	select {
+	case u.notes <- message:
+	default:
+	}
+}
+
+// Run shows the jobs of downloader until the user quits or ctx is done. URLs added by the user are downloaded
+// with ctx
+func (u *UI) Run(ctx context.Context, downloader *download.Downloader) error {
+	keys := make(chan []byte)
+	errs := make(chan error, 1)
+	go u.read(keys, errs)
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		jobs := downloader.Jobs()
+		u.measure(jobs)
+		if err := u.draw(downloader, jobs); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case note := <-u.notes:
+			u.show(note)
+		case input := <-keys:
+			for _, key := range splitKeys(input) {
+				if u.handleKey(ctx, downloader, downloader.Jobs(), key) {
+					return nil
+				}
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// read sends the keys typed by the user until the UI is closed
+func (u *UI) read(keys chan<- []byte, errs chan<- error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := u.in.Read(buf)
+		if err != nil {
+			errs <- err
+			return
+		}
+		select {
+		case keys <- slices.Clone(buf[:n]):
+		case <-u.done:
+			return
+		}
+	}
+}
+
+// splitKeys splits the input read at once, such as pasted text or fast typing, into keys. Escape sequences
+// are kept together
+func splitKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		n := 1
+		switch {
+		case input[0] == 0x1b && len(input) > 2 && input[1] == '[':
+			// CSI sequence, ended by a byte in the range @ to ~
+			n = 2
+			for n < len(input) && (input[n] < 0x40 || input[n] > 0x7e) {
+				n++
+			}
+			n = min(n+1, len(input))
+		case input[0] == 0x1b && len(input) > 2 && input[1] == 'O':
+			n = 3
+		case input[0] >= utf8.RuneSelf:
+			_, n = utf8.DecodeRune(input)
+		}
+		keys = append(keys, string(input[:n]))
+		input = input[n:]
+	}
+	return keys
+}
+
+// show puts a message on the status line
+func (u *UI) show(message string) {
+	u.message = message
+	u.shownAt = time.Now()
+}
+
+// handleKey acts on a key, it returns true if the user wants to quit
+func (u *UI) handleKey(ctx context.Context, downloader *download.Downloader, jobs []download.JobInfo, key string) bool {
+	if u.prompt != nil {
+		u.editPrompt(key)
+		return false
+	}
+
+	index := slices.IndexFunc(jobs, func(j download.JobInfo) bool { return j.ID == u.selected })
+	switch key {
+	case "q", "\x03":
+		return true
+	case "k", "\x1b[A", "\x1bOA":
+		u.selectJob(jobs, index-1)
+	case "j", "\x1b[B", "\x1bOB":
+		u.selectJob(jobs, index+1)
+	case "\x1b[5~":
+		u.selectJob(jobs, index-10)
+	case "\x1b[6~":
+		u.selectJob(jobs, index+10)
+	case "\x1b[H", "\x1bOH", "g":
+		u.selectJob(jobs, 0)
+	case "\x1b[F", "\x1bOF", "G":
+		u.selectJob(jobs, len(jobs)-1)
+	case "L":
+		u.showLogs = !u.showLogs
+	case "a":
+		u.prompt = &prompt{label: "add url: ", submit: func(url string) error {
+			id, err := downloader.Add(ctx, url, download.JobOptions{})
+			if err != nil {
+				return err
+			}
+			if id != "" {
+				u.selected = id
+			}
+			u.show("added " + url)
+			return nil
+		}}
+	case "l":
+		u.prompt = &prompt{label: "rate limit in bytes per second, such as 500k or 2M, 0 for none: ", submit: func(text string) error {
+			rate, err := download.ParseRate(text)
+			if err != nil {
+				return err
+			}
+			downloader.SetRateLimit(rate)
+			u.show("rate limit set to " + formatRate(rate))
+			return nil
+		}}
+	case "p", "r", "c", "R", "+", "=", "-":
+		if index < 0 {
+			u.show("no download selected")
+			return false
+		}
+		if err := u.control(downloader, jobs[index], key); err != nil {
+			u.show(err.Error())
+		}
+	}
+	return false
+}
+
+// control applies the action of key to the job
+func (u *UI) control(downloader *download.Downloader, job download.JobInfo, key string) error {
+	switch key {
+	case "p":
+		return downloader.Pause(job.ID)
+	case "r":
+		return downloader.Resume(job.ID)
+	case "c":
+		return downloader.Cancel(job.ID)
+	case "R":
+		return downloader.Retry(job.ID)
+	case "+", "=":
+		return downloader.SetPriority(job.ID, job.Options.Priority+1)
+	case "-":
+		return downloader.SetPriority(job.ID, job.Options.Priority-1)
+	}
+	return nil
+}
+
+// selectJob selects the job at index, which is clamped to the list
+func (u *UI) selectJob(jobs []download.JobInfo, index int) {
+	if len(jobs) == 0 {
+		return
+	}
+	u.selected = jobs[max(0, min(index, len(jobs)-1))].ID
+}
+
+// editPrompt types key into the prompt
+func (u *UI) editPrompt(key string) {
+	p := u.prompt
+	switch {
+	case key == "\r" || key == "\n":
+		u.prompt = nil
+		text := string(p.text)
+		if text == "" {
+			return
+		}
+		if err := p.submit(text); err != nil {
+			u.show(err.Error())
+		}
+	case key == "\x1b" || key == "\x03":
+		u.prompt = nil
+	case key == "\x7f" || key == "\x08":
+		if len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+	case key == "\x15":
+		p.text = p.text[:0]
+	case len(key) > 0 && key[0] >= 0x20 && key[0] != 0x7f:
+		p.text = append(p.text, []rune(key)...)
+	}
+}
+
+// measure updates the speeds of the jobs, speeds are smoothed so that they do not jump on every refresh
+func (u *UI) measure(jobs []download.JobInfo) {
+	now := time.Now()
+	seen := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		seen[job.ID] = true
+		s, ok := u.speeds[job.ID]
+		if !ok || job.State != download.JobActive || job.Completed < s.completed {
+			u.speeds[job.ID] = &speed{completed: job.Completed, at: now}
+			continue
+		}
+		elapsed := now.Sub(s.at)
+		if elapsed < refreshInterval*4/5 {
+			continue
+		}
+		rate := float64(job.Completed-s.completed) / elapsed.Seconds()
+		if s.rate == 0 {
+			s.rate = rate
+		} else {
+			s.rate = 0.7*s.rate + 0.3*rate
+		}
+		s.completed = job.Completed
+		s.at = now
+	}
+	for id := range u.speeds {
+		if !seen[id] {
+			delete(u.speeds, id)
+		}
+	}
+	if u.message != "" && now.Sub(u.shownAt) > messageTimeout {
+		u.message = ""
+	}
+}
+
+// rate returns the measured speed of a job in bytes per second
+func (u *UI) rate(id string) float64 {
+	if s, ok := u.speeds[id]; ok {
+		return s.rate
+	}
+	return 0
+}
+
+// formatRate formats a rate limit
+func formatRate(rate int64) string {
+	if rate == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%s/s", formatBytes(float64(rate)))
+}
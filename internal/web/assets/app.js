@@ -0,0 +1,338 @@
+'use strict';
+
+// Dashboard of godown daemon --web. Every action goes through the JSON-RPC API, and the jobs are kept up to date
+// with the event stream, which is read with fetch so that the token can be sent in the Authorization header
+
+const jobs = new Map();
+const speeds = new Map();
+let token = sessionStorage.getItem('godown-token') || '';
+let stream = null;
+
+const $ = (id) => document.getElementById(id);
+
+async function rpc(method, params) {
+  const resp = await fetch('/jsonrpc', {
+    method: 'POST',
+    headers: { 'Authorization': 'Bearer ' + token, 'Content-Type': 'application/json' },
+    body: JSON.stringify({ jsonrpc: '2.0', id: 1, method: 'godown.' + method, params: params || {} }),
+  });
+  if (resp.status === 401) {
+    logout();
+    throw new Error('invalid token');
+  }
+  const body = await resp.json();
+  if (body.error) {
+    throw new Error(body.error.message);
+  }
+  return body.result;
+}
+
+function showStatus(message) {
+  $('status').textContent = message;
+}
+
+// run calls an RPC method and shows its error, if any
+async function run(method, params) {
+  try {
+    await rpc(method, params);
+  } catch (err) {
+    showStatus(err.message);
+  }
+}
+
+function login(value) {
+  token = value;
+  sessionStorage.setItem('godown-token', token);
+  $('login').hidden = true;
+  $('dashboard').hidden = false;
+  connect();
+}
+
+function logout() {
+  token = '';
+  sessionStorage.removeItem('godown-token');
+  if (stream) {
+    stream.abort();
+    stream = null;
+  }
+  setOnline(false);
+  $('dashboard').hidden = true;
+  $('login').hidden = false;
+}
+
+function setOnline(online) {
+  const el = $('connection');
+  el.textContent = online ? 'live' : 'offline';
+  el.className = online ? 'online' : 'offline';
+}
+
+// connect reads the event stream, reconnecting when it breaks
+async function connect() {
+  const controller = new AbortController();
+  stream = controller;
+  while (stream === controller) {
+    try {
+      const resp = await fetch('/events', {
+        headers: { 'Authorization': 'Bearer ' + token },
+        signal: controller.signal,
+      });
+      if (resp.status === 401) {
+        logout();
+        return;
+      }
+      if (!resp.ok) {
+        throw new Error(resp.statusText);
+      }
+      setOnline(true);
+      const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = '';
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+          break;
+        }
+        buffer += value;
+        let end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          handleEvent(buffer.slice(0, end));
+          buffer = buffer.slice(end + 2);
+        }
+      }
+    } catch (err) {
+      if (controller.signal.aborted) {
+        return;
+      }
+    }
+    setOnline(false);
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
+function handleEvent(text) {
+  let name = 'message';
+  let data = '';
+  for (const line of text.split('\n')) {
+    if (line.startsWith('event: ')) {
+      name = line.slice(7);
+    } else if (line.startsWith('data: ')) {
+      data += line.slice(6);
+    }
+  }
+  const value = JSON.parse(data);
+  switch (name) {
+    case 'snapshot':
+      jobs.clear();
+      for (const job of value.jobs) {
+        jobs.set(job.id, job);
+      }
+      $('options').elements['max-concurrent'].value = value['max-concurrent'];
+      $('options').elements['limit-rate'].value = value['limit-rate'] ? formatBytes(value['limit-rate']) : '';
+      break;
+    case 'job':
+      jobs.set(value.job.id, value.job);
+      break;
+    case 'progress':
+      measure(value);
+      break;
+  }
+  render();
+}
+
+// measure updates the progress of the active jobs and their speeds
+function measure(progress) {
+  const now = Date.now();
+  for (const p of progress) {
+    const job = jobs.get(p.id);
+    if (!job) {
+      continue;
+    }
+    const last = speeds.get(p.id);
+    if (last && p.completed >= last.completed) {
+      const rate = (p.completed - last.completed) * 1000 / (now - last.time);
+      speeds.set(p.id, { completed: p.completed, time: now, rate: last.rate ? 0.7 * last.rate + 0.3 * rate : rate });
+    } else {
+      speeds.set(p.id, { completed: p.completed, time: now, rate: 0 });
+    }
+    job.completed = p.completed;
+    job.total = p.total;
+  }
+}
+
+function formatBytes(n) {
+  const units = ['B', 'KiB', 'MiB', 'GiB', 'TiB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return i === 0 ? n.toFixed(0) + ' ' + units[i] : n.toFixed(1) + ' ' + units[i];
+}
+
+function formatDuration(seconds) {
+  seconds = Math.round(seconds);
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor(seconds / 60) % 60;
+  const s = String(seconds % 60).padStart(2, '0');
+  return h > 0 ? h + ':' + String(m).padStart(2, '0') + ':' + s : m + ':' + s;
+}
+
+// parseRate parses a rate such as 500k or 2M, the suffixes are powers of 1024 like --limit-rate
+function parseRate(text) {
+  const match = /^\s*(\d+(?:\.\d+)?)\s*([kmg]?)(?:i?b)?\s*$/i.exec(text);
+  if (!match) {
+    throw new Error('invalid rate ' + text);
+  }
+  const power = { '': 0, k: 1, m: 2, g: 3 }[match[2].toLowerCase()];
+  return Math.floor(parseFloat(match[1]) * Math.pow(1024, power));
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function button(td, label, method, params) {
+  const b = document.createElement('button');
+  b.type = 'button';
+  b.textContent = label;
+  b.addEventListener('click', () => run(method, params));
+  td.appendChild(b);
+}
+
+function render() {
+  const all = [...jobs.values()];
+  const counts = {};
+  for (const job of all) {
+    counts[job.state] = (counts[job.state] || 0) + 1;
+  }
+  $('summary').textContent = ['active', 'waiting', 'paused', 'complete', 'error', 'removed']
+    .map((state) => (counts[state] || 0) + ' ' + state).join(', ');
+
+  const order = { active: 0, waiting: 1, paused: 2 };
+  const queued = all.filter((job) => job.state in order)
+    .sort((a, b) => order[a.state] - order[b.state] || (b.options.priority || 0) - (a.options.priority || 0) ||
+      a.created.localeCompare(b.created));
+  const queue = $('queue');
+  queue.replaceChildren();
+  for (const job of queued) {
+    const row = queue.insertRow();
+    const priority = job.options.priority || 0;
+    cell(row, job.state);
+    cell(row, priority);
+    const td = cell(row, '');
+    if (job.total > 0) {
+      const bar = document.createElement('progress');
+      bar.max = job.total;
+      bar.value = job.completed;
+      td.append(bar, ' ' + (job.completed * 100 / job.total).toFixed(1) + '% of ' + formatBytes(job.total));
+    } else {
+      td.textContent = formatBytes(job.completed);
+    }
+    const rate = job.state === 'active' && speeds.has(job.id) ? speeds.get(job.id).rate : 0;
+    cell(row, job.state === 'active' ? formatBytes(rate) + '/s' : '');
+    cell(row, rate > 0 && job.total > job.completed ? formatDuration((job.total - job.completed) / rate) : '');
+    cell(row, job.url, 'url');
+    const actions = row.insertCell();
+    if (job.state === 'paused') {
+      button(actions, 'Resume', 'resume', { id: job.id });
+    } else {
+      button(actions, 'Pause', 'pause', { id: job.id });
+    }
+    button(actions, '+', 'setPriority', { id: job.id, priority: priority + 1 });
+    button(actions, '-', 'setPriority', { id: job.id, priority: priority - 1 });
+    button(actions, 'Cancel', 'cancel', { id: job.id });
+  }
+
+  const stopped = all.filter((job) => !(job.state in order))
+    .sort((a, b) => (b.finished || '').localeCompare(a.finished || ''));
+  const history = $('history');
+  history.replaceChildren();
+  for (const job of stopped) {
+    const row = history.insertRow();
+    cell(row, job.state);
+    cell(row, job.finished ? new Date(job.finished).toLocaleString() : '');
+    cell(row, formatBytes(job.completed));
+    cell(row, job.url, 'url');
+    cell(row, [job.error, job.hookError && 'hook: ' + job.hookError].filter((e) => e).join(', '), 'error');
+    const actions = row.insertCell();
+    if (job.state !== 'complete') {
+      button(actions, 'Retry', 'retry', { id: job.id });
+    }
+    const remove = document.createElement('button');
+    remove.type = 'button';
+    remove.textContent = 'Forget';
+    remove.addEventListener('click', async () => {
+      try {
+        await rpc('remove', { id: job.id });
+        // Forgotten jobs do not emit an event
+        jobs.delete(job.id);
+        render();
+      } catch (err) {
+        showStatus(err.message);
+      }
+    });
+    actions.appendChild(remove);
+  }
+}
+
+$('login').addEventListener('submit', (e) => {
+  e.preventDefault();
+  login(e.target.elements.token.value);
+  e.target.reset();
+});
+
+$('add').addEventListener('submit', async (e) => {
+  e.preventDefault();
+  const form = e.target.elements;
+  const options = { priority: parseInt(form.priority.value, 10) || 0 };
+  if (form.out.value) {
+    options.out = form.out.value;
+  }
+  const urls = form.urls.value.split('\n').map((url) => url.trim()).filter((url) => url);
+  let added = 0;
+  for (const url of urls) {
+    try {
+      await rpc('add', { url, options });
+      added++;
+    } catch (err) {
+      showStatus(url + ': ' + err.message);
+      return;
+    }
+  }
+  showStatus('added ' + added + ' download(s)');
+  form.urls.value = '';
+});
+
+$('options').addEventListener('submit', async (e) => {
+  e.preventDefault();
+  const form = e.target.elements;
+  try {
+    const limit = form['limit-rate'].value.trim();
+    await rpc('changeGlobalOption', {
+      options: {
+        'max-concurrent': parseInt(form['max-concurrent'].value, 10) || 0,
+        'limit-rate': limit === '' || limit === 'none' ? 0 : parseRate(limit),
+      },
+    });
+    showStatus('options changed');
+  } catch (err) {
+    showStatus(err.message);
+  }
+});
+
+// A token in the fragment, as in http://127.0.0.1:8080/#token=..., logs in without typing it. The fragment is
+// never sent to the server and is removed from the address bar
+const fragment = new URLSearchParams(location.hash.slice(1));
+if (fragment.get('token')) {
+  token = fragment.get('token');
+  history.replaceState(null, '', location.pathname);
+}
+if (token) {
+  login(token);
+} else {
+  logout();
+}
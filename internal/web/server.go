@@ -0,0 +1,177 @@
+package web
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/rpc"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// EventsPath is the HTTP path of the server-sent event stream
+const EventsPath = "/events"
+
+// progressInterval is how often the progress of the active jobs is sent to the page
+const progressInterval = time.Second
+
+// Server serves a dashboard page for a Downloader. The page lists the jobs, adds and controls them through the
+// JSON-RPC API at rpc.Path, and follows their progress over server-sent events at EventsPath.
+// Both need the token in an "Authorization: Bearer <token>" header, which the page asks for, while the page
+// itself contains no data and is served to anyone
+type Server struct {
+	Downloader *download.Downloader
+	Token      string
+	api        *rpc.Server
+}
+
+// Listen opens the listener for the server. Addresses without a host, such as ":8080", are bound to localhost
+func Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve accepts connections on l until ctx is done
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	if s.Token == "" {
+		return errors.New("web token must not be empty")
+	}
+	static, err := fs.Sub(assets, "assets")
+	if err != nil {
+		return err
+	}
+	s.api = &rpc.Server{Downloader: s.Downloader, Token: s.Token}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.Path, s.api.Handler(ctx))
+	mux.HandleFunc(EventsPath, s.events)
+	mux.Handle("/", http.FileServerFS(static))
+
+	srv := &http.Server{
+		Handler:           secureHeaders(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Event streams end when ctx is done instead of holding up the shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("web server listening", "addr", l.Addr().String())
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// secureHeaders stops the page from being framed or running scripts from anywhere else
+func secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// snapshot is the first message of an event stream
+type snapshot struct {
+	Jobs          []download.JobInfo `json:"jobs"`
+	MaxConcurrent int                `json:"max-concurrent"`
+	LimitRate     int64              `json:"limit-rate"`
+}
+
+// progress is the progress of an active job
+type progress struct {
+	ID        string `json:"id"`
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+}
+
+// events streams the jobs to the page. The stream starts with a snapshot event holding every job, followed by a
+// job event whenever a job changes state and a progress event with the active jobs every second
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.api.Authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Events emitted while the snapshot is taken are sent again, they only repeat the newest state
+	since := s.Downloader.LastEvent()
+	snap := snapshot{Jobs: s.Downloader.Jobs(), LimitRate: s.Downloader.RateLimit()}
+	s.Downloader.Reconfigure(func(d *download.Downloader) {
+		snap.MaxConcurrent = d.MaxConcurrent
+	})
+	if err := send(w, "snapshot", snap); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	lastProgress := time.Now()
+	for {
+		ctx, cancel := context.WithTimeout(r.Context(), progressInterval)
+		events := s.Downloader.WaitEvents(ctx, since)
+		cancel()
+		if r.Context().Err() != nil {
+			return
+		}
+		for _, event := range events {
+			if err := send(w, "job", event); err != nil {
+				return
+			}
+			since = event.Seq
+		}
+		if time.Since(lastProgress) >= progressInterval {
+			lastProgress = time.Now()
+			active := []progress{}
+			for _, job := range s.Downloader.Jobs(download.JobActive) {
+				active = append(active, progress{ID: job.ID, Completed: job.Completed, Total: job.Total})
+			}
+			if err := send(w, "progress", active); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// send writes a server-sent event with v encoded as JSON
+func send(w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
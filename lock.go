@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/lock"
+	"github.com/urfave/cli/v3"
+)
+
+// lockOptions returns an error if --write-lock or --from-lock is combined with flags that do not save the files in
+// the output directory as they were downloaded, where they are hashed and verified
+func lockOptions(cmd *cli.Command) error {
+	var flag string
+	switch {
+	case cmd.String("write-lock") != "" && cmd.String("from-lock") != "":
+		return errors.New("--write-lock cannot be combined with --from-lock")
+	case cmd.String("write-lock") != "":
+		flag = "--write-lock"
+	case cmd.String("from-lock") != "":
+		flag = "--from-lock"
+	default:
+		return nil
+	}
+	for _, other := range []string{"s3-bucket", "pipe-to", "bundle", "store-compressed", "encrypt-key-file", "encrypt-key",
+		"encrypt-passphrase"} {
+		if cmd.String(other) != "" {
+			return fmt.Errorf("%s cannot be combined with --%s", flag, other)
+		}
+	}
+	for _, other := range []string{"spider", "extract-stream", "keep-encoding"} {
+		if cmd.Bool(other) {
+			return fmt.Errorf("%s cannot be combined with --%s", flag, other)
+		}
+	}
+	if toStdout(cmd) {
+		return fmt.Errorf("%s cannot be combined with -O -", flag)
+	}
+	if flag == "--from-lock" {
+		if cmd.Args().Len() > 0 {
+			return errors.New("--from-lock takes no urls, they are read from the lockfile")
+		}
+		if cmd.String("output") != "" || cmd.Bool("recursive") {
+			return errors.New("--from-lock saves the files under the names in the lockfile, without --output or --recursive")
+		}
+	}
+	return nil
+}
+
+// recordLock starts recording the downloads for --write-lock, it returns nil without --write-lock
+func recordLock(cmd *cli.Command, downloader *download.Downloader) *lock.Recorder {
+	if cmd.String("write-lock") == "" {
+		return nil
+	}
+	return lock.Record(downloader)
+}
+
+// downloadLocked downloads the files of the --from-lock lockfile that are missing from the output directory, with
+// the names and digests of the lockfile. It returns the files to verify once the downloads are done, which are
+// those downloaded and those that do not match the lockfile
+func downloadLocked(ctx context.Context, cmd *cli.Command, downloader *download.Downloader) ([]lock.Entry, error) {
+	f, err := lock.Read(cmd.String("from-lock"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []lock.Entry
+	for _, e := range f.Files {
+		err := lock.Verify(cmd.String("output-dir"), e)
+		if err == nil {
+			slog.Info("locked file already downloaded", "filename", e.FileName)
+			continue
+		}
+		pending = append(pending, e)
+		if !errors.Is(err, fs.ErrNotExist) {
+			// Reported once the downloads are done, the file is not replaced
+			continue
+		}
+		if _, err := downloader.Add(ctx, e.URL, download.JobOptions{FileName: e.FileName, Digest: e.Digest}); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.URL, err)
+		}
+	}
+	return pending, nil
+}
+
+// finishLock writes the --write-lock lockfile, or verifies the files of the --from-lock lockfile that were
+// downloaded or did not match it
+func finishLock(cmd *cli.Command, recorder *lock.Recorder, pending []lock.Entry) error {
+	dir := cmd.String("output-dir")
+	if recorder != nil {
+		path := cmd.String("write-lock")
+		f, err := recorder.Close(dir)
+		if err != nil {
+			return fmt.Errorf("not writing %s: %w", path, err)
+		}
+		return f.Write(path)
+	}
+	drift := 0
+	for _, e := range pending {
+		if err := lock.Verify(dir, e); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			drift++
+		}
+	}
+	if drift > 0 {
+		return fmt.Errorf("%d file(s) do not match %s", drift, cmd.String("from-lock"))
+	}
+	return nil
+}
+
+// lockCommand returns the command that works with lockfiles written by --write-lock
+func lockCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "lock",
+		Usage: "work with lockfiles written by --write-lock",
+		Commands: []*cli.Command{
+			{
+				Name:      "verify",
+				Usage:     "hash the files of a lockfile in the output directory, without downloading anything, and fail if any changed or is missing",
+				ArgsUsage: "<lockfile>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if !cmd.Bool("log") {
+						slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+					}
+					if cmd.Args().Len() != 1 {
+						return cli.Exit("lock verify takes a single lockfile", 1)
+					}
+					f, err := lock.Read(cmd.Args().First())
+					if err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					dir := cmd.String("output-dir")
+					drift := 0
+					for _, e := range f.Files {
+						if err := lock.Verify(dir, e); err != nil {
+							fmt.Println(err)
+							drift++
+						} else if !cmd.Bool("quiet") {
+							fmt.Printf("%s: ok\n", e.FileName)
+						}
+					}
+					if drift > 0 {
+						return cli.Exit(fmt.Sprintf("%d file(s) do not match %s", drift, cmd.Args().First()), 1)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
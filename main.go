@@ -2,18 +2,36 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"syscall"
+	"time"
 
 	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/crawl"
+	"github.com/ananthvk/godown/internal/download/extract"
+	"github.com/ananthvk/godown/internal/download/glob"
+	"github.com/ananthvk/godown/internal/download/hook"
+	"github.com/ananthvk/godown/internal/download/lock"
 	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/download/session"
+	"github.com/ananthvk/godown/internal/download/spider"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/ananthvk/godown/internal/download/task"
+	"github.com/ananthvk/godown/internal/tui"
 	"github.com/urfave/cli/v3"
 	"github.com/vbauerster/mpb/v8"
 )
 
+// defaultMaxConcurrent is the concurrency limit used for recursive and globbed downloads when --max-concurrent is not set
+const defaultMaxConcurrent = 4
+
 func main() {
 	cmd := (&cli.Command{
 		Name:        "godown",
@@ -26,17 +44,349 @@ func main() {
 				Value: ".",
 				Usage: "directory to save files to",
 			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o", "O"},
+				Usage:   "save http(s) downloads with this file name, #1, #2, ... are replaced by the values matched by the globs in the url. - writes a single download to stdout and progress to stderr",
+			},
+			&cli.BoolFlag{
+				Name:  "globoff",
+				Value: false,
+				Usage: "do not expand [1-10], [a-z] and {a,b} globs in urls",
+			},
 			&cli.BoolFlag{
 				Name:  "ignore-invalid-url",
 				Value: false,
 				Usage: "ignores invalid urls that are passed as input, if the input url is missing a scheme, automatically prepends http://",
 			},
+			&cli.BoolFlag{
+				Name:  "follow-torrent",
+				Value: true,
+				Usage: "download the contents of http(s) urls ending with .torrent instead of saving the .torrent file",
+			},
+			&cli.StringFlag{
+				Name:  "torrent-listen",
+				Value: ":6881",
+				Usage: "address to accept incoming bittorrent peer connections on, empty disables incoming connections",
+			},
+			&cli.FloatFlag{
+				Name:  "seed-ratio",
+				Value: 0,
+				Usage: "keep seeding completed torrents until this upload ratio is reached, 0 disables seeding",
+			},
+			&cli.BoolFlag{
+				Name:  "follow-stream",
+				Value: true,
+				Usage: "download the segments of http(s) urls ending with .m3u8 (HLS) or .mpd (DASH) into a single file instead of saving the playlist",
+			},
+			&cli.IntFlag{
+				Name:  "segment-concurrency",
+				Value: 4,
+				Usage: "number of HLS/DASH segments to download concurrently",
+			},
+			&cli.Int64Flag{
+				Name:  "stream-bandwidth",
+				Value: 0,
+				Usage: "pick the best HLS/DASH variant with at most this bandwidth in bits per second, 0 picks the highest",
+			},
+			&cli.IntFlag{
+				Name:  "stream-height",
+				Value: 0,
+				Usage: "pick the best HLS/DASH variant with at most this vertical resolution (e.g. 720), 0 means no limit",
+			},
+			&cli.IntFlag{
+				Name:  "max-concurrent",
+				Value: 0,
+				Usage: "maximum number of downloads running at the same time, 0 means no limit (4 when --recursive is set or a url contains a glob)",
+			},
+			&cli.BoolFlag{
+				Name:    "recursive",
+				Aliases: []string{"r"},
+				Value:   false,
+				Usage:   "download html pages recursively, following links in html and css files",
+			},
+			&cli.IntFlag{
+				Name:  "level",
+				Value: 5,
+				Usage: "maximum recursion depth when downloading recursively, 0 means no limit",
+			},
+			&cli.BoolFlag{
+				Name:  "span-hosts",
+				Value: false,
+				Usage: "follow links to other hosts when downloading recursively",
+			},
+			&cli.StringSliceFlag{
+				Name:  "domains",
+				Usage: "when spanning hosts, only follow links to these domains and their subdomains",
+			},
+			&cli.StringSliceFlag{
+				Name:  "include",
+				Usage: "only follow links whose path (or a parent directory) matches this glob, e.g. /docs/*",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "do not follow links whose path (or a parent directory) matches this glob",
+			},
+			&cli.StringSliceFlag{
+				Name:  "include-regex",
+				Usage: "only follow links whose full url matches this regular expression",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude-regex",
+				Usage: "do not follow links whose full url matches this regular expression",
+			},
+			&cli.BoolFlag{
+				Name:  "ignore-robots",
+				Value: false,
+				Usage: "do not honour robots.txt when downloading recursively",
+			},
+			&cli.BoolFlag{
+				Name:  "convert-links",
+				Value: false,
+				Usage: "after a recursive download, rewrite links in saved pages to point to the local files",
+			},
+			&cli.BoolFlag{
+				Name:  "spider",
+				Value: false,
+				Usage: "only check that the urls exist without saving anything, exits with status 1 if any link is broken",
+			},
+			&cli.StringFlag{
+				Name:  "spider-format",
+				Value: "table",
+				Usage: "format of the --spider report: table, json or csv",
+			},
+			&cli.StringFlag{
+				Name:  "session",
+				Usage: "keep the queue in this file, unfinished downloads in it are resumed at startup and urls it already has are not downloaded again",
+			},
+			&cli.StringFlag{
+				Name:  "limit-rate",
+				Usage: "limit the combined speed of http(s) downloads, in bytes per second with an optional k, M or G suffix",
+			},
+			&cli.IntFlag{
+				Name:  "retries",
+				Value: 0,
+				Usage: "number of times a download or link check that failed with a temporary error (timeout, connection error, http 408, 429 or 5xx) is retried",
+			},
+			&cli.StringFlag{
+				Name:  "progress",
+				Value: "bar",
+				Usage: "how progress is shown: bar, json (json lines events), plain (a line of text per event) or dot",
+			},
+			&cli.IntFlag{
+				Name:  "progress-fd",
+				Value: 1,
+				Usage: "file descriptor that json, plain and dot progress is written to, stderr with -O -",
+			},
+			&cli.DurationFlag{
+				Name:  "progress-interval",
+				Value: time.Second,
+				Usage: "interval between progress reports of --progress json, plain and dot",
+			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Value:   false,
+				Usage:   "do not show progress",
+			},
+			&cli.StringFlag{
+				Name:  "metrics-addr",
+				Usage: "serve prometheus metrics on this host:port at /metrics, addresses without a host such as :9090 are bound to localhost",
+			},
+			&cli.StringFlag{
+				Name:  "trace-file",
+				Usage: "write the timing of every download phase (dns, connect, tls, time to first byte, transfer) as json lines spans to this file",
+			},
+			&cli.StringFlag{
+				Name:  "otlp-endpoint",
+				Usage: "send the timing of every download phase as spans to this opentelemetry collector, using otlp over http, e.g. http://localhost:4318",
+			},
+			&cli.StringFlag{
+				Name:  "on-complete",
+				Usage: "run this shell command, or post to this http(s) webhook, when a download completes. commands get the path, url, size and digest as arguments and GODOWN_* environment variables",
+			},
+			&cli.StringFlag{
+				Name:  "on-error",
+				Usage: "run this shell command, or post to this http(s) webhook, when a download fails",
+			},
+			&cli.StringFlag{
+				Name:  "on-batch-complete",
+				Usage: "run this shell command, or post to this http(s) webhook, once all the downloads stopped",
+			},
+			&cli.IntFlag{
+				Name:  "hook-concurrency",
+				Value: hook.DefaultConcurrency,
+				Usage: "maximum number of hooks running at the same time",
+			},
+			&cli.DurationFlag{
+				Name:  "hook-timeout",
+				Value: hook.DefaultTimeout,
+				Usage: "maximum time a hook may take, including the retries of webhooks",
+			},
+			&cli.BoolFlag{
+				Name:  "extract",
+				Value: false,
+				Usage: "extract downloaded archives (.tar.gz, .tar.zst, .tar.xz, .tar.bz2, .tar, .zip) and decompress .gz, .zst, .xz and .bz2 files",
+			},
+			&cli.StringFlag{
+				Name:  "extract-dir",
+				Usage: "directory archives are extracted into, the output directory by default",
+			},
+			&cli.StringFlag{
+				Name:  "extract-max-size",
+				Value: "10G",
+				Usage: "fail the download if its archive is larger than this once extracted, with an optional k, M or G suffix, 0 means no limit",
+			},
+			&cli.IntFlag{
+				Name:  "extract-max-entries",
+				Value: 100000,
+				Usage: "fail the download if its archive has more files, directories and links than this, 0 means no limit",
+			},
+			&cli.BoolFlag{
+				Name:  "remove-archive",
+				Value: false,
+				Usage: "delete archives once they are extracted",
+			},
+			&cli.BoolFlag{
+				Name:  "extract-stream",
+				Value: false,
+				Usage: "extract archives other than zip while they are downloaded instead of saving them, implies --extract",
+			},
+			&cli.StringFlag{
+				Name:  "s3-bucket",
+				Usage: "upload the downloads to this s3 bucket instead of saving them, credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN",
+			},
+			&cli.StringFlag{
+				Name:  "s3-prefix",
+				Usage: "prefix of the names of the uploaded objects, such as downloads/",
+			},
+			&cli.StringFlag{
+				Name:    "s3-endpoint",
+				Usage:   "url of an s3 compatible service, such as http://localhost:9000, aws is used by default",
+				Sources: cli.EnvVars("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+			},
+			&cli.StringFlag{
+				Name:    "s3-region",
+				Value:   "us-east-1",
+				Usage:   "region of the s3 bucket",
+				Sources: cli.EnvVars("AWS_REGION", "AWS_DEFAULT_REGION"),
+			},
+			&cli.BoolFlag{
+				Name:  "s3-path-style",
+				Value: false,
+				Usage: "put the bucket in the path of the urls instead of the host name, as most s3 compatible services expect",
+			},
+			&cli.StringFlag{
+				Name:  "s3-sse",
+				Usage: "server side encryption of the uploaded objects: AES256 or aws:kms",
+			},
+			&cli.StringFlag{
+				Name:  "s3-sse-kms-key-id",
+				Usage: "kms key used with --s3-sse aws:kms, the default key of the bucket otherwise",
+			},
+			&cli.StringFlag{
+				Name:  "s3-part-size",
+				Value: "8M",
+				Usage: "size of the parts of multipart uploads, at least 5M",
+			},
+			&cli.StringFlag{
+				Name:  "pipe-to",
+				Usage: "run this shell command for every download and write the download to its stdin instead of saving it, the download fails if the command does. the command runs in the output directory and gets the file name as $1",
+			},
+			&cli.StringFlag{
+				Name:  "bundle",
+				Usage: "write all the downloads into this single archive instead of the output directory, a .tar, .tar.gz, .tar.zst, .tar.xz or .zip file ending with a manifest of the urls and digests",
+			},
+			&cli.StringFlag{
+				Name:  "store",
+				Usage: "keep the downloads in this content addressed store and save them in the output directory as links to it. urls ending with #sha256=<hex> are not downloaded if that content is already stored",
+			},
+			&cli.StringFlag{
+				Name:  "store-link",
+				Value: storage.LinkHard,
+				Usage: "how files are linked to the --store: hardlink (read only files), reflink (copies sharing the data, on btrfs and xfs) or symlink",
+			},
+			&cli.BoolFlag{
+				Name:  "cache",
+				Usage: "keep http(s) responses in an on-disk cache shared by every run, and reuse them while they are fresh according to Cache-Control, Expires, ETag and Vary",
+			},
+			&cli.StringFlag{
+				Name:  "cache-dir",
+				Usage: "directory of the --cache, implies --cache (default: godown/http in the user cache directory)",
+			},
+			&cli.StringFlag{
+				Name:  "cache-max-size",
+				Value: "1G",
+				Usage: "remove the least recently used responses once the --cache holds more than this, 0 means no limit",
+			},
+			&cli.BoolFlag{
+				Name:  "offline",
+				Usage: "only serve http(s) downloads from the --cache, even stale responses, and fail those that are not cached",
+			},
+			&cli.BoolFlag{
+				Name:  "compressed",
+				Usage: "request gzip, br, zstd or deflate compressed http(s) responses and decode them while they are saved",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-encoding",
+				Usage: "save compressed http(s) responses as sent, adding .gz, .br, .zst or .zz to their names, implies --compressed. pages of a --recursive download are still decoded",
+			},
+			&cli.StringFlag{
+				Name:  "store-compressed",
+				Usage: "compress the saved files with gzip, zstd or xz, adding .gz, .zst or .xz to their names",
+			},
+			&cli.StringFlag{
+				Name:  "encrypt-key-file",
+				Usage: "encrypt the saved files with the key in this file, 32 bytes or 64 hex digits, adding .enc to their names. godown decrypt restores them",
+			},
+			&cli.StringFlag{
+				Name:    "encrypt-key",
+				Usage:   "encrypt the saved files with this key of 64 hex digits, see --encrypt-key-file",
+				Sources: cli.EnvVars("GODOWN_ENCRYPT_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "encrypt-passphrase",
+				Usage:   "encrypt the saved files with a key derived from this passphrase with scrypt, see --encrypt-key-file",
+				Sources: cli.EnvVars("GODOWN_ENCRYPT_PASSPHRASE"),
+			},
+			&cli.BoolFlag{
+				Name:  "metadata",
+				Usage: "record the url, final url, etag, last-modified, content type, time and digest of every file in extended attributes (user.xdg.origin.url, ...), or a .godown.json sidecar file where they are not supported. godown info shows them",
+			},
+			&cli.BoolFlag{
+				Name:  "continue",
+				Usage: "resume files of the output directory that an earlier run with --metadata did not finish downloading from the same url, implies --metadata",
+			},
+			&cli.BoolFlag{
+				Name:  "update",
+				Usage: "like --continue, and download complete files again, replacing them, only if the server reports that they changed, implies --metadata",
+			},
+			&cli.StringFlag{
+				Name:  "write-lock",
+				Usage: "once every download completed, write the url, file name, size and sha-256 of the files to this json lockfile",
+			},
+			&cli.StringFlag{
+				Name:  "from-lock",
+				Usage: "download the files of this lockfile that are missing from the output directory, and fail if any file does not match its size and sha-256. godown lock verify checks the files without downloading",
+			},
+			&cli.BoolFlag{
+				Name:  "tui",
+				Value: false,
+				Usage: "show a full screen terminal ui to manage the downloads, progress bars are shown if stdin or stdout is not a terminal",
+			},
 			&cli.BoolFlag{
 				Name:  "log",
 				Value: false,
 				Usage: "Enables logging",
 			},
 		},
+		Commands: []*cli.Command{
+			daemonCommand(),
+			gcCommand(),
+			decryptCommand(),
+			infoCommand(),
+			lockCommand(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 
 			/*
@@ -57,9 +407,12 @@ func main() {
 				}
 				p.Wait()
 			*/
-			if cmd.Args().Len() == 0 {
+			if cmd.Args().Len() == 0 && cmd.String("session") == "" && !cmd.Bool("tui") && cmd.String("from-lock") == "" {
 				return cli.Exit("no urls specified", 1)
 			}
+			if err := lockOptions(cmd); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
 
 			if !cmd.Bool("log") {
 				slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
@@ -68,21 +421,353 @@ func main() {
 			ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 			defer cancel()
 
-			progressBar := &reporter.MpbProgressBar{Progress: mpb.NewWithContext(ctx, mpb.WithWidth(64))}
-			downloader := download.NewDownloader(cmd.String("output-dir"), cmd.Bool("ignore-invalid-url"), progressBar)
+			if cmd.Bool("tui") {
+				ui, err := tui.New(os.Stdin, os.Stdout)
+				if err == nil {
+					return runTUI(ctx, cmd, ui)
+				}
+				fmt.Fprintln(os.Stderr, "cannot show the terminal ui, showing progress bars instead:", err)
+				if cmd.Args().Len() == 0 && cmd.String("session") == "" {
+					return cli.Exit("no urls specified", 1)
+				}
+			}
+
+			var progressBar *reporter.MpbProgressBar
+			var bars reporter.ProgressBarFactory = reporter.NopProgressBarFactory{}
+			if showBars(cmd) {
+				output := io.Writer(os.Stdout)
+				if toStdout(cmd) {
+					output = os.Stderr
+				}
+				progressBar = &reporter.MpbProgressBar{Progress: mpb.NewWithContext(ctx, mpb.WithWidth(64), mpb.WithOutput(output))}
+				bars = progressBar
+			}
+			downloader, err := newDownloader(cmd, bars)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			recorder := recordLock(cmd, downloader)
+			stopProgress, err := watchProgress(cmd, downloader)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			stopTracing, err := startTracing(cmd, downloader)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			stopHooks := startHooks(cmd, downloader, false)
+			metricsURL, stopMetrics, err := serveMetrics(ctx, cmd, downloader)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			if metricsURL != "" {
+				fmt.Fprintln(os.Stderr, "metrics on", metricsURL)
+			}
 
-			for _, url := range cmd.Args().Slice() {
-				downloader.Download(ctx, url)
+			// Nothing can resume paused jobs without the daemon, so they are resumed as well
+			sess, err := openSession(ctx, cmd, downloader, true)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			var locked []lock.Entry
+			if cmd.String("from-lock") != "" {
+				locked, err = downloadLocked(ctx, cmd, downloader)
+			} else {
+				err = downloadAll(ctx, cmd, downloader, sess)
+			}
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
 			}
 
 			slog.Info("waiting for all downloads to complete")
 			downloader.Wait()
-			progressBar.Progress.Wait()
+			closeErr := downloader.Close()
+			stopHooks()
+			stopProgress()
+			stopMetrics()
+			stopTracing()
+			if progressBar != nil {
+				progressBar.Progress.Wait()
+			}
 			slog.Info("completed all downloads")
-			return nil
+			if closeErr != nil {
+				return cli.Exit("closing storage: "+closeErr.Error(), 1)
+			}
+			if sess != nil {
+				if err := sess.Close(); err != nil {
+					return cli.Exit("saving session: "+err.Error(), 1)
+				}
+			}
+			if err := finishLock(cmd, recorder, locked); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			return finish(cmd, downloader)
 		},
 	})
 	if err := cmd.Run(context.Background(), os.Args); err != nil {
 		os.Exit(1)
 	}
 }
+
+// finish prints the link check report and converts the links of a recursive download once the downloads are done
+func finish(cmd *cli.Command, downloader *download.Downloader) error {
+	if downloader.Spider != nil {
+		if err := downloader.Spider.Report.Write(os.Stdout, cmd.String("spider-format")); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		if broken := downloader.Spider.Report.Broken(); broken > 0 {
+			return cli.Exit(fmt.Sprintf("%d broken link(s)", broken), 1)
+		}
+	}
+
+	if downloader.Recursive != nil && cmd.Bool("convert-links") {
+		if err := downloader.Recursive.ConvertLinks(cmd.String("output-dir")); err != nil {
+			return cli.Exit("converting links: "+err.Error(), 1)
+		}
+	}
+	return nil
+}
+
+// newDownloader creates a Downloader configured from the command line flags
+func newDownloader(cmd *cli.Command, progressBar reporter.ProgressBarFactory) (*download.Downloader, error) {
+	downloader := download.NewDownloader(cmd.String("output-dir"), cmd.Bool("ignore-invalid-url"), progressBar)
+	downloader.Torrent = download.TorrentOptions{
+		FollowTorrent: cmd.Bool("follow-torrent"),
+		ListenAddr:    cmd.String("torrent-listen"),
+		SeedRatio:     cmd.Float("seed-ratio"),
+	}
+	factory, err := writerFactory(cmd)
+	if err != nil {
+		return nil, err
+	}
+	metadata := cmd.Bool("metadata") || cmd.Bool("continue") || cmd.Bool("update")
+	if metadata {
+		if err := metadataOptions(cmd, factory); err != nil {
+			return nil, err
+		}
+		factory = &storage.FSWriterFactory{BasePath: cmd.String("output-dir"), Metadata: true}
+	}
+	if factory != nil {
+		downloader.SetWriterFactory(factory)
+	}
+	if bundle, ok := factory.(*storage.BundleWriterFactory); ok {
+		bundle.JobURL = func(id string) string {
+			info, err := downloader.Job(id)
+			if err != nil {
+				return ""
+			}
+			return info.URL
+		}
+	}
+	// Files are compressed before they are encrypted
+	var decorators []storage.Decorator
+	encrypt, err := encryptFiles(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if encrypt != nil {
+		decorators = append(decorators, encrypt)
+	}
+	if format := cmd.String("store-compressed"); format != "" {
+		compress, err := storeCompressed(cmd, format)
+		if err != nil {
+			return nil, err
+		}
+		decorators = append(decorators, compress)
+	}
+	downloader.DecorateWriterFactory(decorators...)
+	downloader.HTTP = download.HTTPOptions{
+		Compressed:   cmd.Bool("compressed") || cmd.Bool("keep-encoding"),
+		KeepEncoding: cmd.Bool("keep-encoding"),
+		Continue:     cmd.Bool("continue"),
+		Update:       cmd.Bool("update"),
+	}
+	downloader.MaxConcurrent = cmd.Int("max-concurrent")
+	downloader.Retries = cmd.Int("retries")
+	if limit := cmd.String("limit-rate"); limit != "" {
+		rate, err := download.ParseRate(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --limit-rate: %w", err)
+		}
+		downloader.SetRateLimit(rate)
+	}
+	if cmd.Bool("extract") || cmd.Bool("extract-stream") {
+		maxSize, err := download.ParseSize(cmd.String("extract-max-size"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --extract-max-size: %w", err)
+		}
+		downloader.Extract = &download.ExtractOptions{
+			Dir:           cmd.String("extract-dir"),
+			Limits:        extract.Limits{MaxSize: maxSize, MaxEntries: cmd.Int("extract-max-entries")},
+			RemoveArchive: cmd.Bool("remove-archive"),
+			Stream:        cmd.Bool("extract-stream"),
+		}
+	}
+	if cmd.Bool("spider") {
+		if cmd.Bool("recursive") {
+			return nil, errors.New("--spider cannot be combined with --recursive")
+		}
+		switch cmd.String("spider-format") {
+		case "table", "json", "csv":
+		default:
+			return nil, errors.New("unknown --spider-format " + cmd.String("spider-format"))
+		}
+		downloader.Spider = &download.SpiderOptions{Report: &spider.Report{}}
+	}
+	if cmd.Bool("recursive") {
+		if cmd.String("output") != "" {
+			return nil, errors.New("--output cannot be combined with --recursive")
+		}
+		rules, err := crawlRules(cmd)
+		if err != nil {
+			return nil, err
+		}
+		downloader.Recursive = crawl.NewCrawler(rules)
+		if downloader.MaxConcurrent == 0 {
+			// Do not flood a single server with one connection per discovered link
+			downloader.MaxConcurrent = defaultMaxConcurrent
+		}
+	}
+	cache, err := httpCache(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		downloader.HTTPCache = cache
+		if downloader.Recursive != nil {
+			downloader.Recursive.SetClient(&http.Client{Transport: cache})
+		}
+	}
+	downloader.Stream = download.StreamOptions{
+		FollowStreams: cmd.Bool("follow-stream"),
+		StreamOptions: task.StreamOptions{
+			Concurrency:  cmd.Int("segment-concurrency"),
+			MaxBandwidth: cmd.Int64("stream-bandwidth"),
+			MaxHeight:    cmd.Int("stream-height"),
+		},
+	}
+	return downloader, nil
+}
+
+// downloadAll starts the downloads of the urls passed on the command line. Globs in the urls are expanded lazily,
+// one url at a time, so that large ranges are not kept in memory. Urls that the session already has,
+// unless they failed or were removed, are skipped
+func downloadAll(ctx context.Context, cmd *cli.Command, downloader *download.Downloader, sess *session.Session) error {
+	known := map[download.JobOptions]map[string]bool{}
+	if sess != nil {
+		for _, job := range sess.Jobs() {
+			if job.State != download.JobError && job.State != download.JobRemoved {
+				if known[job.Options] == nil {
+					known[job.Options] = map[string]bool{}
+				}
+				known[job.Options][job.URL] = true
+			}
+		}
+	}
+	start := func(url string, fileName string) {
+		if known[download.JobOptions{FileName: fileName}][url] {
+			slog.Info("skipping url found in session", "url", url)
+			return
+		}
+		downloader.DownloadAs(ctx, url, fileName)
+	}
+
+	output := cmd.String("output")
+	if output == "-" {
+		// The name is only shown in the progress, use the one of the download
+		output = ""
+	}
+	if cmd.Bool("globoff") {
+		for _, url := range cmd.Args().Slice() {
+			start(url, output)
+		}
+		return nil
+	}
+
+	patterns := make([]*glob.Pattern, 0, cmd.Args().Len())
+	for _, url := range cmd.Args().Slice() {
+		pattern, err := glob.Parse(url)
+		if err != nil {
+			return fmt.Errorf("invalid url %q: %w", url, err)
+		}
+		if _, err := pattern.Count(); err != nil {
+			return fmt.Errorf("invalid url %q: %w", url, err)
+		}
+		if pattern.HasGlobs() {
+			downloader.Reconfigure(func(d *download.Downloader) {
+				if d.MaxConcurrent == 0 {
+					d.MaxConcurrent = defaultMaxConcurrent
+				}
+			})
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	for _, pattern := range patterns {
+		for url, matches := range pattern.All() {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fileName := ""
+			if output != "" {
+				fileName = glob.ExpandTemplate(output, matches)
+			}
+			start(url, fileName)
+		}
+	}
+	return nil
+}
+
+// openSession opens the session file given by --session, makes the downloader record its jobs in it and restores
+// the jobs saved in it. If resumePaused is true, paused jobs are restored as waiting. nil is returned if there is
+// no session file
+func openSession(ctx context.Context, cmd *cli.Command, downloader *download.Downloader, resumePaused bool) (*session.Session, error) {
+	path := cmd.String("session")
+	if path == "" {
+		return nil, nil
+	}
+	if downloader.Spider != nil {
+		return nil, errors.New("--session cannot be combined with --spider")
+	}
+	sess, err := session.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening session: %w", err)
+	}
+	downloader.Journal = sess
+	for _, job := range sess.Jobs() {
+		if resumePaused && job.State == download.JobPaused {
+			job.State = download.JobWaiting
+		}
+		if err := downloader.Restore(ctx, job); err != nil {
+			slog.Error("restoring job", "id", job.ID, "url", job.URL, "err", err)
+		}
+	}
+	return sess, nil
+}
+
+// crawlRules builds the rules for recursive downloads from the command line flags
+func crawlRules(cmd *cli.Command) (crawl.Rules, error) {
+	rules := crawl.Rules{
+		MaxDepth:     cmd.Int("level"),
+		SpanHosts:    cmd.Bool("span-hosts"),
+		Domains:      cmd.StringSlice("domains"),
+		Include:      cmd.StringSlice("include"),
+		Exclude:      cmd.StringSlice("exclude"),
+		IgnoreRobots: cmd.Bool("ignore-robots"),
+	}
+	for _, expr := range cmd.StringSlice("include-regex") {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return rules, fmt.Errorf("invalid --include-regex %q: %w", expr, err)
+		}
+		rules.IncludeRegex = append(rules.IncludeRegex, re)
+	}
+	for _, expr := range cmd.StringSlice("exclude-regex") {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return rules, fmt.Errorf("invalid --exclude-regex %q: %w", expr, err)
+		}
+		rules.ExcludeRegex = append(rules.ExcludeRegex, re)
+	}
+	return rules, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/metrics"
+	"github.com/urfave/cli/v3"
+)
+
+// metricsInterval is how often the progress of the active jobs is sampled for the metrics
+const metricsInterval = time.Second
+
+// serveMetrics serves the metrics of the downloader on --metrics-addr, if it is set, and returns their url.
+// The returned function stops the server once the downloads are done
+func serveMetrics(ctx context.Context, cmd *cli.Command, downloader *download.Downloader) (string, func(), error) {
+	addr := cmd.String("metrics-addr")
+	if addr == "" {
+		return "", func() {}, nil
+	}
+	l, err := metrics.Listen(addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("metrics: %w", err)
+	}
+
+	collector := metrics.NewCollector(downloader)
+	stopWatch := collector.Watch(metricsInterval)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := collector.Serve(ctx, l); err != nil {
+			slog.Error("serving metrics", "err", err)
+		}
+	}()
+	return fmt.Sprintf("http://%s%s", l.Addr(), metrics.Path), func() {
+		cancel()
+		<-done
+		stopWatch()
+	}, nil
+}
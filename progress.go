@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/progress"
+	"github.com/urfave/cli/v3"
+)
+
+// showBars reports whether the progress is shown with progress bars
+func showBars(cmd *cli.Command) bool {
+	return cmd.String("progress") == "bar" && !cmd.Bool("quiet")
+}
+
+// watchProgress starts the reporter selected by --progress, unless it shows progress bars or --quiet is set.
+// The returned function stops it once the downloads are done
+func watchProgress(cmd *cli.Command, downloader *download.Downloader) (func(), error) {
+	var w io.Writer
+	switch fd := cmd.Int("progress-fd"); fd {
+	case 1:
+		w = os.Stdout
+		if toStdout(cmd) {
+			w = os.Stderr
+		}
+	case 2:
+		w = os.Stderr
+	default:
+		f := os.NewFile(uintptr(fd), "progress")
+		if f == nil {
+			return nil, fmt.Errorf("invalid --progress-fd %d", fd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("invalid --progress-fd %d: %w", fd, err)
+		}
+		w = f
+	}
+
+	var r progress.Reporter
+	switch mode := cmd.String("progress"); mode {
+	case "bar":
+	case "json":
+		r = progress.NewJSON(w)
+	case "plain":
+		r = progress.NewPlain(w)
+	case "dot":
+		r = progress.NewDot(w)
+	default:
+		return nil, fmt.Errorf("unknown --progress %q", mode)
+	}
+	interval := cmd.Duration("progress-interval")
+	if interval <= 0 {
+		return nil, errors.New("--progress-interval must be positive")
+	}
+	if r == nil || cmd.Bool("quiet") {
+		return func() {}, nil
+	}
+	return progress.Watch(downloader, interval, r), nil
+}
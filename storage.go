@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/glob"
+	"github.com/ananthvk/godown/internal/download/storage"
+	"github.com/urfave/cli/v3"
+)
+
+// writerFactory returns the WriterFactory selected by --s3-bucket, -O -, --pipe-to, --bundle or --store, or nil if
+// the files are saved to the output directory
+func writerFactory(cmd *cli.Command) (storage.WriterFactory, error) {
+	var selected []string
+	if cmd.String("s3-bucket") != "" {
+		selected = append(selected, "--s3-bucket")
+	}
+	if toStdout(cmd) {
+		selected = append(selected, "-O -")
+	}
+	if cmd.String("pipe-to") != "" {
+		selected = append(selected, "--pipe-to")
+	}
+	if cmd.String("bundle") != "" {
+		selected = append(selected, "--bundle")
+	}
+	if cmd.String("store") != "" {
+		selected = append(selected, "--store")
+	}
+	switch {
+	case len(selected) == 0:
+		return nil, nil
+	case len(selected) > 1:
+		return nil, fmt.Errorf("%s cannot be combined with %s", selected[0], selected[1])
+	case cmd.Bool("convert-links"):
+		// Files in a store are shared, so links cannot be converted in place either
+		return nil, fmt.Errorf("--convert-links cannot be combined with %s", selected[0])
+	case cmd.String("store") != "":
+		// Stored files are linked in the output directory, where they are extracted as usual
+		return storeWriterFactory(cmd)
+	case (cmd.Bool("extract") || cmd.Bool("remove-archive")) && !cmd.Bool("extract-stream"):
+		return nil, fmt.Errorf("archives can only be extracted with --extract-stream when using %s", selected[0])
+	}
+	switch {
+	case toStdout(cmd):
+		return stdoutWriterFactory(cmd)
+	case cmd.String("pipe-to") != "":
+		return &storage.PipeWriterFactory{Command: cmd.String("pipe-to"), Dir: cmd.String("output-dir")}, nil
+	case cmd.String("bundle") != "":
+		bundle, err := storage.NewBundleWriterFactory(cmd.String("bundle"))
+		if err != nil {
+			return nil, err
+		}
+		return bundle, nil
+	default:
+		return s3WriterFactory(cmd)
+	}
+}
+
+// storeCompressed returns the decorator compressing the saved files of --store-compressed. Compressed files cannot
+// be extracted or have their links converted once saved, and are not deduplicated by --store
+func storeCompressed(cmd *cli.Command, format string) (storage.Decorator, error) {
+	switch {
+	case (cmd.Bool("extract") || cmd.Bool("remove-archive")) && !cmd.Bool("extract-stream"):
+		return nil, errors.New("archives can only be extracted with --extract-stream when using --store-compressed")
+	case cmd.Bool("convert-links"):
+		return nil, errors.New("--convert-links cannot be combined with --store-compressed")
+	case cmd.String("store") != "":
+		return nil, errors.New("--store cannot be combined with --store-compressed")
+	}
+	compress, err := storage.Compress(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --store-compressed: %w", err)
+	}
+	return compress, nil
+}
+
+// metadataOptions returns an error if the metadata of --metadata, --continue or --update cannot be kept, which is
+// only done for files saved in the output directory as they are
+func metadataOptions(cmd *cli.Command, factory storage.WriterFactory) error {
+	flag := "--metadata"
+	for _, name := range []string{"update", "continue"} {
+		if cmd.Bool(name) {
+			flag = "--" + name
+		}
+	}
+	switch {
+	case factory != nil:
+		return fmt.Errorf("%s only applies to files saved in the output directory, not with --s3-bucket, -O -, --pipe-to, --bundle or --store", flag)
+	case cmd.String("store-compressed") != "":
+		return fmt.Errorf("%s cannot be combined with --store-compressed", flag)
+	}
+	key, err := encryptionKey(cmd)
+	if err == nil && key != nil {
+		return fmt.Errorf("%s cannot be combined with encryption", flag)
+	}
+	return nil
+}
+
+// storeWriterFactory returns the content addressed store of --store
+func storeWriterFactory(cmd *cli.Command) (storage.WriterFactory, error) {
+	switch mode := cmd.String("store-link"); mode {
+	case storage.LinkHard, storage.LinkReflink, storage.LinkSymlink:
+	default:
+		return nil, fmt.Errorf("unknown --store-link %s, use %s, %s or %s", mode, storage.LinkHard, storage.LinkReflink,
+			storage.LinkSymlink)
+	}
+	return &storage.StoreWriterFactory{
+		Dir:      cmd.String("store"),
+		BasePath: cmd.String("output-dir"),
+		LinkMode: cmd.String("store-link"),
+	}, nil
+}
+
+// toStdout reports whether the download is written to stdout, with -O -
+func toStdout(cmd *cli.Command) bool {
+	return cmd.String("output") == "-"
+}
+
+// stdoutWriterFactory writes the only download to stdout. A download retried after it started writing fails
+// instead, as what was written cannot be taken back
+func stdoutWriterFactory(cmd *cli.Command) (storage.WriterFactory, error) {
+	if cmd.Args().Len() != 1 {
+		return nil, errors.New("-O - takes a single url")
+	}
+	if !cmd.Bool("globoff") {
+		pattern, err := glob.Parse(cmd.Args().First())
+		if err == nil {
+			if n, err := pattern.Count(); err == nil && n != 1 {
+				return nil, errors.New("-O - takes a single url, use --globoff to download urls with brackets")
+			}
+		}
+	}
+	for _, flag := range []string{"recursive", "spider", "tui", "session"} {
+		if cmd.IsSet(flag) {
+			return nil, fmt.Errorf("--%s cannot be combined with -O -", flag)
+		}
+	}
+	if cmd.IsSet("progress-fd") && cmd.Int("progress-fd") == 1 {
+		return nil, errors.New("--progress-fd 1 cannot be combined with -O -")
+	}
+	var used atomic.Bool
+	return storage.WriterFunc(func(id, fileName string) (io.Writer, error) {
+		if used.Swap(true) {
+			return nil, errors.New("stdout already holds the start of the download")
+		}
+		// Hide the Close method of stdout
+		return struct{ io.Writer }{os.Stdout}, nil
+	}), nil
+}
+
+// s3WriterFactory returns the factory uploading the downloads to --s3-bucket. Credentials are read from the
+// standard AWS_* environment variables
+func s3WriterFactory(cmd *cli.Command) (storage.WriterFactory, error) {
+	sse := cmd.String("s3-sse")
+	switch sse {
+	case "", storage.S3SSEAES256, storage.S3SSEKMS:
+	default:
+		return nil, fmt.Errorf("unknown --s3-sse %s, use %s or %s", sse, storage.S3SSEAES256, storage.S3SSEKMS)
+	}
+	if cmd.String("s3-sse-kms-key-id") != "" && sse != storage.S3SSEKMS {
+		return nil, errors.New("--s3-sse-kms-key-id requires --s3-sse " + storage.S3SSEKMS)
+	}
+	partSize, err := download.ParseSize(cmd.String("s3-part-size"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --s3-part-size: %w", err)
+	}
+	if partSize < storage.MinS3PartSize {
+		return nil, errors.New("--s3-part-size must be at least 5M")
+	}
+	return &storage.S3WriterFactory{
+		Endpoint:     cmd.String("s3-endpoint"),
+		Region:       cmd.String("s3-region"),
+		Bucket:       cmd.String("s3-bucket"),
+		Prefix:       cmd.String("s3-prefix"),
+		PathStyle:    cmd.Bool("s3-path-style"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		SSE:          sse,
+		SSEKMSKeyID:  cmd.String("s3-sse-kms-key-id"),
+		PartSize:     partSize,
+	}, nil
+}
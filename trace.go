@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ananthvk/godown/internal/download"
+	"github.com/ananthvk/godown/internal/download/trace"
+	"github.com/urfave/cli/v3"
+)
+
+// startTracing traces the downloads to the file given by --trace-file or the collector given by --otlp-endpoint,
+// if either is set. The returned function exports the remaining spans once the downloads are done
+func startTracing(cmd *cli.Command, downloader *download.Downloader) (func(), error) {
+	var exporter trace.Exporter
+	switch file, endpoint := cmd.String("trace-file"), cmd.String("otlp-endpoint"); {
+	case file != "" && endpoint != "":
+		return nil, errors.New("--trace-file cannot be combined with --otlp-endpoint")
+	case file != "":
+		e, err := trace.NewFileExporter(file)
+		if err != nil {
+			return nil, fmt.Errorf("creating trace file: %w", err)
+		}
+		exporter = e
+	case endpoint != "":
+		exporter = trace.NewOTLPExporter(endpoint)
+	default:
+		return func() {}, nil
+	}
+	tracer := trace.NewTracer(exporter)
+	downloader.Tracer = tracer
+	return func() {
+		if err := tracer.Close(); err != nil {
+			slog.Error("closing tracer", "err", err)
+		}
+	}, nil
+}
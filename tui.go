@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ananthvk/godown/internal/download/reporter"
+	"github.com/ananthvk/godown/internal/tui"
+	"github.com/urfave/cli/v3"
+)
+
+// runTUI downloads the urls while ui shows the jobs, until the user quits. Downloads that are still running
+// when the user quits are stopped, with --session they continue on the next run
+func runTUI(ctx context.Context, cmd *cli.Command, ui *tui.UI) error {
+	slog.SetDefault(slog.New(ui.Logs))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	downloader, err := newDownloader(cmd, reporter.NopProgressBarFactory{})
+	if err != nil {
+		ui.Close()
+		return cli.Exit(err.Error(), 1)
+	}
+	stopTracing, err := startTracing(cmd, downloader)
+	if err != nil {
+		ui.Close()
+		return cli.Exit(err.Error(), 1)
+	}
+	stopHooks := startHooks(cmd, downloader, false)
+	metricsURL, stopMetrics, err := serveMetrics(ctx, cmd, downloader)
+	if err != nil {
+		ui.Close()
+		stopHooks()
+		stopTracing()
+		return cli.Exit(err.Error(), 1)
+	}
+	if metricsURL != "" {
+		ui.Notify("metrics on " + metricsURL)
+	}
+	sess, err := openSession(ctx, cmd, downloader, true)
+	if err != nil {
+		ui.Close()
+		stopHooks()
+		stopMetrics()
+		stopTracing()
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// downloadAll blocks while the downloads wait for their turn, so the urls are added in the background
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		if err := downloadAll(ctx, cmd, downloader, sess); err != nil {
+			slog.Error("adding urls", "err", err)
+			ui.Notify(err.Error())
+		}
+	}()
+
+	err = ui.Run(ctx, downloader)
+	cancel()
+	<-added
+	downloader.Wait()
+	closeErr := downloader.Close()
+	stopHooks()
+	stopMetrics()
+	stopTracing()
+	ui.Close()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if closeErr != nil {
+		return cli.Exit("closing storage: "+closeErr.Error(), 1)
+	}
+	if sess != nil {
+		if err := sess.Close(); err != nil {
+			return cli.Exit("saving session: "+err.Error(), 1)
+		}
+	}
+	return finish(cmd, downloader)
+}